@@ -0,0 +1,321 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// BinaryMagic starts every binary program
+const BinaryMagic = "UDON"
+
+// BinaryVersion is the version of the binary format written by Assemble
+const BinaryVersion = 1
+
+// opcodes are the numbers of the instructions in the bytecode of the udon vm
+var opcodes = map[Opcode]uint32{
+	NOP:           0,
+	PUSH:          1,
+	POP:           2,
+	JUMP_IF_FALSE: 4,
+	JUMP:          5,
+	EXTERN:        6,
+	ANNOTATION:    7,
+	JUMP_INDIRECT: 8,
+	COPY:          9,
+}
+
+// Assemble encodes the program as bytecode and a description of its heap.
+// All numbers are big endian uint32 and strings are prefixed with their length. In order:
+//   - BinaryMagic and BinaryVersion
+//   - the heap: its size, then the name, type and initial value of each address. The variables come first,
+//     followed by the strings of the EXTERN, ANNOTATION and quoted PUSH operands, which have no name
+//   - the exported variables: their count, then the heap address of each
+//   - the synced variables: their count, then the heap address and sync mode of each
+//   - the exported events: their count, then the name and code address of each
+//   - the labels of the instructions: their count, then the name and code address of each
+//   - the code: its size in bytes, then the opcode of each instruction, followed by its operand unless it is 4 bytes long
+func (prog *Program) Assemble() ([]byte, error) {
+	heap := []*VarItem{}
+	index := map[VarName]uint32{}
+	for _, item := range prog.Vars {
+		if _, ok := index[item.VarName]; ok {
+			return nil, fmt.Errorf("variable %s declared twice", item.VarName)
+		}
+		index[item.VarName] = uint32(len(heap))
+		heap = append(heap, item)
+	}
+	consts := map[string]uint32{}
+	constant := func(s string) uint32 {
+		addr, ok := consts[s]
+		if !ok {
+			addr = uint32(len(heap))
+			consts[s] = addr
+			heap = append(heap, &VarItem{TypeName: UdonTypeString, InitialValue: s})
+		}
+		return addr
+	}
+	varAddr := func(s string) (uint32, error) {
+		if addr, ok := index[VarName(s)]; ok {
+			return addr, nil
+		}
+		return 0, fmt.Errorf("variable not defined: %s", s)
+	}
+
+	code := []uint32{}
+	for _, inst := range prog.Code {
+		if Addr(len(code)*4) != inst.Addr {
+			return nil, fmt.Errorf("%s at %#x is laid out at %#x", inst, len(code)*4, inst.Addr)
+		}
+		opcode, ok := opcodes[inst.Op]
+		if !ok {
+			return nil, fmt.Errorf("unknown instruction %s", inst.Op)
+		}
+		code = append(code, opcode)
+		if inst.Op.Size() == 4 {
+			continue
+		}
+		var operand uint32
+		var err error
+		switch {
+		case strings.HasPrefix(inst.Arg, `"`):
+			operand = constant(inst.Arg)
+		case strings.HasPrefix(inst.Arg, "###"):
+			err = fmt.Errorf("label %s is not resolved", inst.Arg)
+		case strings.HasPrefix(inst.Arg, "0x"):
+			var n uint64
+			n, err = strconv.ParseUint(inst.Arg[2:], 16, 32)
+			operand = uint32(n)
+		case inst.Op == JUMP || inst.Op == JUMP_IF_FALSE:
+			err = errors.New("bad jump target")
+		default:
+			operand, err = varAddr(inst.Arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%#08x %s: %w", inst.Addr, inst, err)
+		}
+		code = append(code, operand)
+	}
+
+	w := &binaryWriter{}
+	w.buf.WriteString(BinaryMagic)
+	w.uint32(BinaryVersion)
+	w.uint32(uint32(len(heap)))
+	for _, item := range heap {
+		w.string(string(item.VarName))
+		w.string(string(item.TypeName))
+		w.string(item.InitialValue)
+	}
+	w.uint32(uint32(len(prog.Exports)))
+	for _, varName := range prog.Exports {
+		addr, err := varAddr(string(varName))
+		if err != nil {
+			return nil, fmt.Errorf("export: %w", err)
+		}
+		w.uint32(addr)
+	}
+	w.uint32(uint32(len(prog.Syncs)))
+	for _, syncVar := range prog.Syncs {
+		addr, err := varAddr(string(syncVar.VarName))
+		if err != nil {
+			return nil, fmt.Errorf("sync: %w", err)
+		}
+		w.uint32(addr)
+		w.string(string(syncVar.Mode))
+	}
+	w.uint32(uint32(len(prog.Events)))
+	for _, event := range prog.Events {
+		addr, ok := prog.EventAddr(event)
+		if !ok {
+			return nil, fmt.Errorf("exported event %s has no label", event)
+		}
+		w.string(string(event))
+		w.uint32(uint32(addr))
+	}
+	labels := 0
+	for _, inst := range prog.Code {
+		labels += len(inst.Labels)
+	}
+	w.uint32(uint32(labels))
+	for _, inst := range prog.Code {
+		for _, label := range inst.Labels {
+			w.string(string(label))
+			w.uint32(uint32(inst.Addr))
+		}
+	}
+	w.uint32(uint32(len(code) * 4))
+	for _, word := range code {
+		w.uint32(word)
+	}
+	return w.buf.Bytes(), nil
+}
+
+type binaryWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *binaryWriter) uint32(n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	w.buf.Write(b[:])
+}
+
+func (w *binaryWriter) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.buf.WriteString(s)
+}
+
+// IsBinaryProgram reports whether data starts like a program written by Assemble
+func IsBinaryProgram(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(BinaryMagic))
+}
+
+// ReadProgram reads a program written by Assemble or its text assembly
+func ReadProgram(data []byte) (*Program, error) {
+	if IsBinaryProgram(data) {
+		return ReadBinaryProgram(data)
+	}
+	return ParseProgram(string(data))
+}
+
+// ReadBinaryProgram decodes a program written by Assemble.
+// The operands referring to unnamed heap addresses get back the quoted string they hold.
+func ReadBinaryProgram(data []byte) (*Program, error) {
+	if !IsBinaryProgram(data) {
+		return nil, errors.New("not a binary udon program")
+	}
+	r := &binaryReader{data: data[len(BinaryMagic):]}
+	if version := r.uint32(); r.err == nil && version != BinaryVersion {
+		return nil, fmt.Errorf("binary program version %d, want %d", version, BinaryVersion)
+	}
+	prog := &Program{}
+	heap := []*VarItem{}
+	for n := r.count(); n > 0; n-- {
+		item := &VarItem{VarName: VarName(r.string()), TypeName: UdonTypeName(r.string()), InitialValue: r.string()}
+		heap = append(heap, item)
+		if item.VarName == "" {
+			continue
+		}
+		if len(prog.Vars) != len(heap)-1 {
+			return nil, fmt.Errorf("variable %s follows the constants of the heap", item.VarName)
+		}
+		prog.Vars = append(prog.Vars, item)
+	}
+	varName := func(addr uint32) VarName {
+		if int(addr) >= len(prog.Vars) {
+			r.fail(fmt.Errorf("heap address %#x is not a variable", addr))
+			return ""
+		}
+		return prog.Vars[addr].VarName
+	}
+	for n := r.count(); n > 0; n-- {
+		prog.Exports = append(prog.Exports, varName(r.uint32()))
+	}
+	for n := r.count(); n > 0; n-- {
+		prog.Syncs = append(prog.Syncs, SyncVar{VarName: varName(r.uint32()), Mode: SyncMode(r.string())})
+	}
+	heads := map[Addr][]EventName{}
+	for n := r.count(); n > 0; n-- {
+		event := EventName(r.string())
+		prog.Events = append(prog.Events, event)
+		addr := Addr(r.uint32())
+		heads[addr] = append(heads[addr], event)
+	}
+	labels := map[Addr][]LabelName{}
+	for n := r.count(); n > 0; n-- {
+		label := LabelName(r.string())
+		addr := Addr(r.uint32())
+		labels[addr] = append(labels[addr], label)
+	}
+	size := Addr(r.uint32())
+	names := map[uint32]Opcode{}
+	for op, opcode := range opcodes {
+		names[opcode] = op
+	}
+	for addr := Addr(0); addr < size && r.err == nil; {
+		opcode := r.uint32()
+		op, ok := names[opcode]
+		if !ok {
+			r.fail(fmt.Errorf("%#08x: unknown opcode %d", addr, opcode))
+			break
+		}
+		inst := &Instruction{Op: op, Addr: addr, Heads: heads[addr], Labels: labels[addr]}
+		delete(heads, addr)
+		delete(labels, addr)
+		if op.Size() == 8 {
+			operand := r.uint32()
+			switch {
+			case op == JUMP || op == JUMP_IF_FALSE:
+				inst.Arg = fmt.Sprintf("0x%08X", operand)
+			case int(operand) < len(prog.Vars):
+				inst.Arg = string(prog.Vars[operand].VarName)
+			case int(operand) < len(heap):
+				inst.Arg = heap[operand].InitialValue
+			default:
+				r.fail(fmt.Errorf("%#08x %s: heap address %#x out of range", addr, op, operand))
+			}
+		}
+		prog.Code = append(prog.Code, inst)
+		addr += op.Size()
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("read binary program: %w", r.err)
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("read binary program: %d bytes after the code", len(r.data))
+	}
+	for addr := range heads {
+		return nil, fmt.Errorf("read binary program: event %s at %#x is not an instruction", heads[addr][0], addr)
+	}
+	for addr := range labels {
+		return nil, fmt.Errorf("read binary program: label %s at %#x is not an instruction", labels[addr][0], addr)
+	}
+	return prog, nil
+}
+
+// binaryReader reads a binary program, keeping the first error
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *binaryReader) uint32() uint32 {
+	if len(r.data) < 4 {
+		r.fail(io.ErrUnexpectedEOF)
+		r.data = nil
+		return 0
+	}
+	n := binary.BigEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return n
+}
+
+// count reads the length of a table, which can not be larger than what is left to read
+func (r *binaryReader) count() int {
+	n := r.uint32()
+	if int(n) > len(r.data) {
+		r.fail(io.ErrUnexpectedEOF)
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
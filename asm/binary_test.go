@@ -0,0 +1,149 @@
+package asm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"udon-go/asm"
+)
+
+const binaryTestProgram = `.data_start
+
+    .export Count
+    .sync Count, linear
+    Count: %SystemInt32, 0
+    one: %SystemInt32, 1
+    ret_addr: %SystemUInt32, 0xFFFFFFFF
+
+.data_end
+
+.code_start
+
+    .export _interact
+    _interact:
+        PUSH, Count
+        PUSH, one
+        PUSH, Count
+        EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"
+        JUMP, 0x00000028
+        NOP
+        JUMP_INDIRECT, ret_addr
+        JUMP, 0xFFFFFFFF
+
+.code_end
+`
+
+func TestProgram_Assemble(t *testing.T) {
+	prog, err := asm.ParseProgram(binaryTestProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := prog.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &bytes.Buffer{}
+	word := func(words ...uint32) {
+		for _, n := range words {
+			binary.Write(want, binary.BigEndian, n)
+		}
+	}
+	str := func(s string) {
+		word(uint32(len(s)))
+		want.WriteString(s)
+	}
+	want.WriteString("UDON")
+	word(1)
+	word(4)
+	for _, s := range []string{"Count", "SystemInt32", "0", "one", "SystemInt32", "1", "ret_addr", "SystemUInt32", "0xFFFFFFFF",
+		"", "SystemString", `"SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"`} {
+		str(s)
+	}
+	word(1, 0)
+	word(1, 0)
+	str("linear")
+	word(1)
+	str("_interact")
+	word(0)
+	word(0)
+	word(0x3c)
+	word(1, 0, 1, 1, 1, 0, 6, 3, 5, 0x28, 0, 8, 2, 5, 0xFFFFFFFF)
+	if !bytes.Equal(data, want.Bytes()) {
+		t.Errorf("got\n% x\nwant\n% x", data, want.Bytes())
+	}
+
+	got, err := asm.ReadBinaryProgram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, prog) {
+		t.Errorf("read back %+v, want %+v", got, prog)
+	}
+	again, err := got.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("assembling the program read back gives other bytes")
+	}
+}
+
+func TestProgram_AssembleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{"undefined variable", "PUSH, one", "PUSH, two", "variable not defined: two"},
+		{"unresolved label", "JUMP, 0x00000028", "JUMP, ###end###", "label ###end### is not resolved"},
+		{"bad address", "JUMP, 0x00000028", "JUMP, 0x1000000000", "value out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := asm.ParseProgram(strings.Replace(binaryTestProgram, tt.old, tt.new, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = prog.Assemble()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBinaryProgramErrors(t *testing.T) {
+	prog, err := asm.ParseProgram(binaryTestProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := prog.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace the operand of PUSH, one with an address past the heap
+	outOfRange := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(outOfRange[len(data)-12*4:], 9)
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"text", []byte(binaryTestProgram), "not a binary udon program"},
+		{"truncated", data[:len(data)-4], "unexpected EOF"},
+		{"trailing bytes", append(append([]byte{}, data...), 0), "1 bytes after the code"},
+		{"heap address", outOfRange, "heap address 0x9 out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := asm.ReadBinaryProgram(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
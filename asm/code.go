@@ -0,0 +1,197 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Opcode is an udon vm instruction
+type Opcode string
+
+// Opcodes of the udon vm
+const (
+	NOP           Opcode = "NOP"
+	PUSH          Opcode = "PUSH"
+	POP           Opcode = "POP"
+	JUMP_IF_FALSE Opcode = "JUMP_IF_FALSE"
+	JUMP          Opcode = "JUMP"
+	EXTERN        Opcode = "EXTERN"
+	ANNOTATION    Opcode = "ANNOTATION"
+	JUMP_INDIRECT Opcode = "JUMP_INDIRECT"
+	COPY          Opcode = "COPY"
+)
+
+// Size returns the size of the instruction in bytes, 4 for the opcode and 4 for the operand if it has one
+func (op Opcode) Size() Addr {
+	switch op {
+	case NOP, POP, COPY:
+		return 4
+	}
+	return 8
+}
+
+// HaltAddr is the address jumped to to end an event
+const HaltAddr = "0xFFFFFFFF"
+
+// Instruction is an instruction of the code segment
+type Instruction struct {
+	Op Opcode
+	// Arg is the operand as written in the assembly: a heap variable, a quoted extern, a ###label### or an address
+	Arg string
+	// Addr is the address of the instruction, set when the code is laid out
+	Addr Addr
+	// Labels point to the instruction
+	Labels []LabelName
+	// Heads are the event labels written before the instruction
+	Heads []EventName
+	// Comments are the comment lines written before the instruction
+	Comments []string
+}
+
+// String returns the instruction as written in the assembly
+func (inst *Instruction) String() string {
+	if inst.Arg == "" {
+		return string(inst.Op)
+	}
+	return fmt.Sprintf("%s, %s", inst.Op, inst.Arg)
+}
+
+// Label returns the label the operand refers to, if it is a ###label### placeholder
+func (inst *Instruction) Label() (LabelName, bool) {
+	if !strings.HasPrefix(inst.Arg, "###") || !strings.HasSuffix(inst.Arg, "###") || len(inst.Arg) < 6 {
+		return "", false
+	}
+	return LabelName(inst.Arg[3 : len(inst.Arg)-3]), true
+}
+
+// Var returns the heap variable the operand refers to, for PUSH and JUMP_INDIRECT
+func (inst *Instruction) Var() (VarName, bool) {
+	if inst.Op != PUSH && inst.Op != JUMP_INDIRECT {
+		return "", false
+	}
+	if inst.Arg == "" || strings.HasPrefix(inst.Arg, `"`) || strings.HasPrefix(inst.Arg, "0x") || strings.HasPrefix(inst.Arg, "###") {
+		return "", false
+	}
+	return VarName(inst.Arg), true
+}
+
+// Extern returns the extern signature called by an EXTERN instruction
+func (inst *Instruction) Extern() (ExternStr, bool) {
+	if inst.Op != EXTERN {
+		return "", false
+	}
+	return ExternStr(strings.Trim(inst.Arg, `"`)), true
+}
+
+// Code is the code segment as a list of instructions, before the labels are resolved
+type Code struct {
+	Insts []*Instruction
+	// EndLabels point past the last instruction
+	EndLabels []LabelName
+}
+
+// Code parses the code emitted so far into instructions, attaching the labels of LabelDict to them
+func (ua *UdonAssembly) Code() (*Code, error) {
+	labels := map[Addr][]LabelName{}
+	for label, addr := range ua.LabelDict {
+		labels[addr] = append(labels[addr], label)
+	}
+	for _, names := range labels {
+		sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	}
+	code := &Code{}
+	pending := &Instruction{}
+	addr := Addr(0)
+	for _, line := range strings.Split(ua.ASM, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			pending.Comments = append(pending.Comments, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		case strings.HasSuffix(line, ":") && !strings.ContainsAny(line, ", "):
+			pending.Heads = append(pending.Heads, EventName(strings.TrimSuffix(line, ":")))
+		default:
+			inst := pending
+			pending = &Instruction{}
+			fields := strings.SplitN(line, ", ", 2)
+			inst.Op = Opcode(fields[0])
+			if len(fields) == 2 {
+				inst.Arg = fields[1]
+			}
+			inst.Addr = addr
+			inst.Labels = labels[addr]
+			delete(labels, addr)
+			addr += inst.Op.Size()
+			code.Insts = append(code.Insts, inst)
+		}
+	}
+	if addr != ua.ProgramCounter {
+		return nil, fmt.Errorf("code size %d does not match the program counter %d", addr, ua.ProgramCounter)
+	}
+	code.EndLabels = labels[addr]
+	delete(labels, addr)
+	if len(pending.Heads) > 0 {
+		return nil, fmt.Errorf("event %s has no code", pending.Heads[0])
+	}
+	for addr, names := range labels {
+		return nil, fmt.Errorf("label %s points inside an instruction at %#x", names[0], addr)
+	}
+	return code, nil
+}
+
+// SetCode replaces the code emitted so far, laying out the instructions and moving their labels
+func (ua *UdonAssembly) SetCode(code *Code) {
+	ua.ASM = ""
+	ua.ProgramCounter = 0
+	ua.LabelDict = map[LabelName]Addr{}
+	for _, inst := range code.Insts {
+		for _, head := range inst.Heads {
+			ua.EventHead(head)
+		}
+		for _, comment := range inst.Comments {
+			ua.AddComment(comment)
+		}
+		for _, label := range inst.Labels {
+			ua.AddLabelCurrentAddr(label)
+		}
+		inst.Addr = ua.ProgramCounter
+		ua.AddInst(inst.Op.Size(), inst.String())
+	}
+	for _, label := range code.EndLabels {
+		ua.AddLabelCurrentAddr(label)
+	}
+}
+
+// remove deletes instruction i, the labels, event heads and comments written before it move to the next instruction
+func (code *Code) remove(i int) {
+	inst := code.Insts[i]
+	if i+1 < len(code.Insts) {
+		next := code.Insts[i+1]
+		next.Labels = append(inst.Labels, next.Labels...)
+		next.Heads = append(inst.Heads, next.Heads...)
+		next.Comments = append(inst.Comments, next.Comments...)
+	} else {
+		code.EndLabels = append(inst.Labels, code.EndLabels...)
+	}
+	code.Insts = append(code.Insts[:i], code.Insts[i+1:]...)
+}
+
+// labelled reports whether something may jump to instruction i, making it the start of a basic block
+func (code *Code) labelled(i int) bool {
+	return len(code.Insts[i].Labels) > 0 || len(code.Insts[i].Heads) > 0
+}
+
+// labelIndex returns the index of the instruction each label points to, len(Insts) for the end labels
+func (code *Code) labelIndex() map[LabelName]int {
+	index := map[LabelName]int{}
+	for i, inst := range code.Insts {
+		for _, label := range inst.Labels {
+			index[label] = i
+		}
+	}
+	for _, label := range code.EndLabels {
+		index[label] = len(code.Insts)
+	}
+	return index
+}
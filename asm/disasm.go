@@ -0,0 +1,242 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// goTypeNames are the go names of the udon types of go's basic types, the other types keep their udon name
+var goTypeNames = map[UdonTypeName]string{
+	UdonTypeBoolean: "bool",
+	UdonTypeString:  "string",
+	UdonTypeChar:    "rune",
+	UdonTypeByte:    "byte",
+	UdonTypeSByte:   "int8",
+	UdonTypeInt16:   "int16",
+	UdonTypeUInt16:  "uint16",
+	UdonTypeInt32:   "int32",
+	UdonTypeUInt32:  "uint32",
+	UdonTypeInt64:   "int64",
+	UdonTypeUInt64:  "uint64",
+	UdonTypeSingle:  "float32",
+	UdonTypeDouble:  "float64",
+}
+
+// GoTypeName returns the go name of an udon type, or the udon name if it has none
+func GoTypeName(typeName UdonTypeName) string {
+	if name, ok := goTypeNames[typeName]; ok {
+		return name
+	}
+	return string(typeName)
+}
+
+// StubTypeName returns how go source writes an udon type: the go name of basic types,
+// the stub of this package for the other types of UdonTypes, or the udon name
+func StubTypeName(typeName UdonTypeName) string {
+	if name := GoTypeName(typeName); name != string(typeName) {
+		return name
+	}
+	if short := ShortTypeName(typeName); short != typeName {
+		return "asm." + string(short)
+	}
+	return string(typeName)
+}
+
+// LookupTypeName returns the udon type called name by go source, the method table or udon, e.g.
+// int32, asm.Transform, Transform or UnityEngineTransform
+func LookupTypeName(name string) (UdonTypeName, bool) {
+	switch name {
+	case "int":
+		return UdonTypeInt32, true
+	case "uint":
+		return UdonTypeUInt32, true
+	case "interface{}":
+		return UdonTypeObject, true
+	}
+	for typeName, goName := range goTypeNames {
+		if goName == name {
+			return typeName, true
+		}
+	}
+	name = strings.TrimPrefix(name, "asm.")
+	if typeName, ok := UdonTypes[VarName(name)]; ok {
+		return typeName, true
+	}
+	if ShortTypeName(UdonTypeName(name)) != UdonTypeName(name) {
+		return UdonTypeName(name), true
+	}
+	return "", false
+}
+
+// operatorSymbols are the go operators of the op_ methods
+var operatorSymbols = map[UdonMethodName]string{
+	"op_Addition":           "+",
+	"op_Subtraction":        "-",
+	"op_Multiply":           "*",
+	"op_Multiplication":     "*",
+	"op_Division":           "/",
+	"op_Modulus":            "%",
+	"op_Remainder":          "%",
+	"op_Equality":           "==",
+	"op_Inequality":         "!=",
+	"op_LessThan":           "<",
+	"op_GreaterThan":        ">",
+	"op_LessThanOrEqual":    "<=",
+	"op_GreaterThanOrEqual": ">=",
+	"op_LogicalAnd":         "&",
+	"op_LogicalOr":          "|",
+	"op_LogicalXor":         "^",
+	"op_ConditionalAnd":     "&&",
+	"op_ConditionalOr":      "||",
+	"op_ConditionalXor":     "^",
+	"op_LeftShift":          "<<",
+	"op_RightShift":         ">>",
+	"op_UnaryMinus":         "-",
+	"op_UnaryNegation":      "!",
+}
+
+// DemangleExtern writes an extern signature the way go would, e.g.
+// SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32 is int32 + int32 -> int32.
+// Signatures which do not parse are returned as they are.
+func DemangleExtern(externStr ExternStr) string {
+	sig, err := ParseExternStr(externStr)
+	if err != nil {
+		return string(externStr)
+	}
+	args := []string{}
+	for _, argType := range sig.ArgTypes {
+		args = append(args, GoTypeName(argType))
+	}
+	ret := ""
+	if sig.HasRet() {
+		ret = " -> " + GoTypeName(sig.RetType)
+	}
+	symbol, isOperator := operatorSymbols[sig.Method]
+	switch {
+	case isOperator && len(args) == 2:
+		return fmt.Sprintf("%s %s %s%s", args[0], symbol, args[1], ret)
+	case isOperator && len(args) == 1:
+		return fmt.Sprintf("%s%s%s", symbol, args[0], ret)
+	case (sig.Method == "op_Implicit" || sig.Method == "op_Explicit") && len(args) == 1:
+		return fmt.Sprintf("%s %s%s", strings.ToLower(strings.TrimPrefix(string(sig.Method), "op_")), args[0], ret)
+	case sig.Method == "ctor":
+		return fmt.Sprintf("new %s(%s)", GoTypeName(sig.RetType), strings.Join(args, ", "))
+	}
+	return fmt.Sprintf("%s.%s(%s)%s", GoTypeName(sig.Module), sig.Method, strings.Join(args, ", "), ret)
+}
+
+// Disassemble writes an annotated listing of the program: its heap with the type, initial value and symbols
+// of every variable, then its code with the address of every instruction, the events and labels pointing to it,
+// and what its operand refers to. Jump targets without a label are named after their address.
+func (prog *Program) Disassemble(w io.Writer) {
+	labels := map[Addr][]LabelName{}
+	heads := map[Addr]EventName{}
+	for _, inst := range prog.Code {
+		labels[inst.Addr] = inst.Labels
+		if len(inst.Heads) > 0 {
+			heads[inst.Addr] = inst.Heads[0]
+		}
+	}
+	for _, inst := range prog.Code {
+		if inst.Op != JUMP && inst.Op != JUMP_IF_FALSE {
+			continue
+		}
+		target, ok := parseAddr(inst.Arg)
+		if names, exists := labels[target]; ok && exists && len(names) == 0 {
+			labels[target] = []LabelName{LabelName(fmt.Sprintf("L%08X", target))}
+		}
+	}
+	// describe names the instruction at an address
+	describe := func(addr Addr) string {
+		if head, ok := heads[addr]; ok {
+			return string(head)
+		}
+		if names := labels[addr]; len(names) > 0 {
+			return string(names[0])
+		}
+		return ""
+	}
+
+	symbols := map[VarName][]string{}
+	for _, varName := range prog.Exports {
+		symbols[varName] = append(symbols[varName], "export")
+	}
+	for _, syncVar := range prog.Syncs {
+		symbols[syncVar.VarName] = append(symbols[syncVar.VarName], "sync "+string(syncVar.Mode))
+	}
+	vars := map[VarName]*VarItem{}
+	width := 0
+	for _, item := range prog.Vars {
+		vars[item.VarName] = item
+		if len(item.VarName) > width {
+			width = len(item.VarName)
+		}
+	}
+	fmt.Fprintf(w, "heap:\n")
+	for i, item := range prog.Vars {
+		notes := symbols[item.VarName]
+		if item.TypeName == UdonTypeUInt32 {
+			if addr, ok := parseAddr(item.InitialValue); ok {
+				if name := describe(addr); name != "" {
+					notes = append(notes, "address of "+name)
+				}
+			}
+		}
+		line := fmt.Sprintf("    0x%04x  %-*s  %s = %s", i, width, item.VarName, item.TypeName, item.InitialValue)
+		if len(notes) > 0 {
+			line += "  ; " + strings.Join(notes, ", ")
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+
+	fmt.Fprintf(w, "\ncode:\n")
+	for _, inst := range prog.Code {
+		for _, head := range inst.Heads {
+			fmt.Fprintf(w, "%s:  ; event\n", head)
+		}
+		for _, label := range labels[inst.Addr] {
+			// events have a label of the same name
+			if len(inst.Heads) == 0 || LabelName(inst.Heads[0]) != label {
+				fmt.Fprintf(w, "%s:\n", label)
+			}
+		}
+		note := ""
+		switch inst.Op {
+		case PUSH, JUMP_INDIRECT:
+			if item, ok := vars[VarName(inst.Arg)]; ok {
+				note = string(item.TypeName)
+			}
+		case EXTERN:
+			externStr, _ := inst.Extern()
+			note = DemangleExtern(externStr)
+		case JUMP, JUMP_IF_FALSE:
+			if inst.Arg == HaltAddr {
+				note = "end of the event"
+			} else if addr, ok := parseAddr(inst.Arg); ok {
+				note = describe(addr)
+				if note == "" {
+					note = "not an instruction"
+				}
+			}
+		}
+		line := fmt.Sprintf("    0x%08x  %-40s", inst.Addr, inst)
+		if note != "" {
+			line += "  ; " + note
+		}
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
+
+// parseAddr reads an address written 0x..., as in the operands of resolved jumps
+func parseAddr(s string) (Addr, bool) {
+	if !strings.HasPrefix(s, "0x") {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s[2:], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return Addr(n), true
+}
@@ -0,0 +1,73 @@
+package asm_test
+
+import (
+	"strings"
+	"testing"
+	"udon-go/asm"
+)
+
+func TestDemangleExtern(t *testing.T) {
+	tests := []struct {
+		externStr asm.ExternStr
+		want      string
+	}{
+		{"SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32", "int32 + int32 -> int32"},
+		{"SystemBoolean.__op_UnaryNegation__SystemBoolean__SystemBoolean", "!bool -> bool"},
+		{"SystemDouble.__op_Implicit__SystemSingle__SystemDouble", "implicit float32 -> float64"},
+		{"SystemObjectArray.__ctor__SystemInt32__SystemObjectArray", "new SystemObjectArray(int32)"},
+		{"SystemConvert.__ToString__SystemInt32__SystemString", "SystemConvert.ToString(int32) -> string"},
+		{"UnityEngineDebug.__Log__SystemObject__SystemVoid", "UnityEngineDebug.Log(SystemObject)"},
+		{"SystemString.__get_Length__SystemInt32", "string.get_Length() -> int32"},
+		{"not an extern", "not an extern"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.externStr), func(t *testing.T) {
+			if got := asm.DemangleExtern(tt.externStr); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgram_Disassemble(t *testing.T) {
+	prog, err := asm.ParseProgram(binaryTestProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog.SetLabels(map[asm.LabelName]asm.Addr{"_interact": 0, "f": 0x2c})
+	data, err := prog.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the labels are kept by the binary program only
+	binaryProg, err := asm.ReadProgram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		prog *asm.Program
+		want []string
+	}{
+		{"text", prog, []string{"L00000028:", "0x00000020  JUMP, 0x00000028                          ; L00000028"}},
+		{"binary", binaryProg, []string{"f:", "0x00000020  JUMP, 0x00000028                          ; L00000028"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			tt.prog.Disassemble(b)
+			listing := b.String()
+			want := append([]string{
+				"0x0000  Count     SystemInt32 = 0  ; export, sync linear",
+				"0x0002  ret_addr  SystemUInt32 = 0xFFFFFFFF",
+				"_interact:  ; event\n    0x00000000  PUSH, Count",
+				"EXTERN, \"SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32\"  ; int32 + int32 -> int32",
+				"0x00000034  JUMP, 0xFFFFFFFF                          ; end of the event",
+			}, tt.want...)
+			for _, line := range want {
+				if !strings.Contains(listing, line) {
+					t.Errorf("no %q in\n%s", line, listing)
+				}
+			}
+		})
+	}
+}
@@ -3,47 +3,64 @@ package asm
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
-func ParseExterns(rdr io.Reader) (MethodMap, error) {
+// ExternWarning is a line of the method table data which is not a record
+type ExternWarning struct {
+	Line int
+	Text string
+	Err  error
+}
 
-	scan := bufio.NewScanner(rdr)
+func (w *ExternWarning) Error() string {
+	return fmt.Sprintf("line %d: %v: %s", w.Line, w.Err, w.Text)
+}
+
+var (
+	// methodRecord is a method of known kind: ('InstanceFunc', 'Transform', 'GetChild', ('Int32',)): ('Transform', 'extern'),
+	methodRecord = regexp.MustCompile(`^\('(.*?)', '(.*?)', '(.*?)', \((.*?)\).*: \('(.*?)', '(.*?)'`)
+	// externRecord is a member listed with its extern only: ('Unknown', 'VRCPickup', 'get_orientation'): 'extern',
+	externRecord = regexp.MustCompile(`^\('(.*?)', '(.*?)', '(.*?)'\): '(.*?)',?$`)
+)
 
+// ParseExterns reads the method table, one record per line between braces.
+// The lines which are not records are returned as warnings.
+func ParseExterns(rdr io.Reader) (MethodMap, []*ExternWarning, error) {
+	scan := bufio.NewScanner(rdr)
 	result := MethodMap{}
-	bad := 0
+	warnings := []*ExternWarning{}
+	line := 0
 	for scan.Scan() {
-		txt := scan.Text()
-		// fmt.Println("process:", txt)
+		line++
+		txt := strings.TrimSpace(scan.Text())
+		if txt == "" || txt == "{" || txt == "}" {
+			continue
+		}
 		key, value, err := parseRecord(txt)
 		if err != nil {
-			bad++
+			warnings = append(warnings, &ExternWarning{Line: line, Text: txt, Err: err})
 			continue
 		}
 		result[*key] = value
 	}
-	// fmt.Println("Processed records:", len(result))
-	// fmt.Println("Bad records:", bad)
-	return result, nil
+	if err := scan.Err(); err != nil {
+		return nil, nil, err
+	}
+	return result, warnings, nil
 }
 
 func parseRecord(in string) (*MethodKey, *MethodValue, error) {
-	r := regexp.MustCompile(`^\('(.*?)', '(.*?)', '(.*?)', \((.*?)\).*: \('(.*?)', '(.*?)'`)
-	result := r.FindStringSubmatch(in)
-	if len(result) < 5 {
-		return nil, nil, errors.New("Bad length: " + strconv.Itoa(len(result)))
-	}
-	argTypes := result[4]
-	argTypes = strings.ReplaceAll(argTypes, " ", "")
-	argTypes = strings.ReplaceAll(argTypes, "'", "")
-	numCommas := strings.Count(argTypes, ",")
-	if numCommas <= 1 {
-		argTypes = strings.ReplaceAll(argTypes, ",", "")
-	}
-	return &MethodKey{
+	if result := methodRecord.FindStringSubmatch(in); result != nil {
+		argTypes := result[4]
+		argTypes = strings.ReplaceAll(argTypes, " ", "")
+		argTypes = strings.ReplaceAll(argTypes, "'", "")
+		// single element tuples are written with a trailing comma: ('Int32',)
+		argTypes = strings.TrimSuffix(argTypes, ",")
+		return &MethodKey{
 			MethodKind: UdonMethodKind(result[1]),
 			ModuleName: UdonTypeName(result[2]),
 			MethodName: UdonMethodName(result[3]),
@@ -52,4 +69,43 @@ func parseRecord(in string) (*MethodKey, *MethodValue, error) {
 			TypeName:  UdonTypeName(result[5]),
 			ExternStr: result[6],
 		}, nil
+	}
+	if result := externRecord.FindStringSubmatch(in); result != nil {
+		return parseExternRecord(UdonMethodKind(result[1]), UdonTypeName(result[2]), UdonMethodName(result[3]), ExternStr(result[4]))
+	}
+	return nil, nil, errors.New("not a record")
+}
+
+// parseExternRecord reads the types of a record listed with its extern only from the extern.
+// The accessors written 'get' and 'set' with an extern like __get__Name__T are named get_Name and set_Name.
+func parseExternRecord(kind UdonMethodKind, module UdonTypeName, method UdonMethodName, externStr ExternStr) (*MethodKey, *MethodValue, error) {
+	sig, err := ParseExternStr(externStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if method == "get" || method == "set" {
+		if len(sig.ArgTypes) == 0 {
+			return nil, nil, fmt.Errorf("accessor %s of %s names no member", method, module)
+		}
+		// the member name is read as the argument types
+		name := []string{}
+		for _, argType := range sig.ArgTypes {
+			name = append(name, string(argType))
+		}
+		method = method + "_" + UdonMethodName(strings.Join(name, "_"))
+		sig.ArgTypes = nil
+		if method[:4] == "set_" {
+			sig.ArgTypes, sig.RetType = []UdonTypeName{sig.RetType}, UdonTypeVoid
+		}
+	}
+	argTypes := []UdonTypeName{}
+	for _, argType := range sig.ArgTypes {
+		argTypes = append(argTypes, ShortTypeName(argType))
+	}
+	retType := ShortTypeName(sig.RetType)
+	if sig.RetType == UdonTypeVoid {
+		retType = "None"
+	}
+	key := NewMethodKey(kind, module, method, argTypes)
+	return &key, &MethodValue{TypeName: retType, ExternStr: string(externStr)}, nil
 }
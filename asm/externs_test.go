@@ -2,6 +2,7 @@ package asm
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -11,8 +12,77 @@ func TestParseExterns(t *testing.T) {
 		t.Errorf("open file: %s", err)
 	}
 	defer f.Close()
-	_, err = ParseExterns(f)
+	_, warnings, err := ParseExterns(f)
 	if err != nil {
 		t.Errorf("%v", err)
 	}
+	for _, w := range warnings {
+		t.Errorf("%v", w)
+	}
+}
+
+func TestParseExterns_Records(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		key  MethodKey
+		want MethodValue
+	}{
+		{"method", `('InstanceFunc', 'Transform', 'GetChild', ('Int32',)): ('Transform', 'UnityEngineTransform.__GetChild__SystemInt32__UnityEngineTransform'),`,
+			MethodKey{INSTANCE_FUNC, "Transform", "GetChild", "Int32"}, MethodValue{"Transform", "UnityEngineTransform.__GetChild__SystemInt32__UnityEngineTransform"}},
+		{"field setter", `('InstanceFunc', 'Vector3', 'set_x', ()): ('Single', 'UnityEngineVector3.__set_x__SystemSingle'),`,
+			MethodKey{INSTANCE_FUNC, "Vector3", "set_x", ""}, MethodValue{"Single", "UnityEngineVector3.__set_x__SystemSingle"}},
+		{"unknown getter", `('Unknown', 'VRCPickup', 'get_currentHand'): 'VRCSDK3ComponentsVRCPickup.__get_currentHand__VRCSDKBaseVRC_PickupPickupHand',`,
+			MethodKey{UNKNOWN, "VRCPickup", "get_currentHand", ""}, MethodValue{"PickupHand", "VRCSDK3ComponentsVRCPickup.__get_currentHand__VRCSDKBaseVRC_PickupPickupHand"}},
+		{"unknown setter", `('Unknown', 'VRCPickup', 'set_orientation'): 'VRCSDK3ComponentsVRCPickup.__set_orientation__VRCSDKBaseVRC_PickupPickupOrientation',`,
+			MethodKey{UNKNOWN, "VRCPickup", "set_orientation", "VRCSDKBaseVRC_PickupPickupOrientation"}, MethodValue{"None", "VRCSDK3ComponentsVRCPickup.__set_orientation__VRCSDKBaseVRC_PickupPickupOrientation"}},
+		{"unnamed getter", `('Unknown', 'Networking', 'get'): 'VRCSDKBaseNetworking.__get__GetEventDispatcher__SystemFuncVRCSDKBaseVRC_EventDispatcher',`,
+			MethodKey{UNKNOWN, "Networking", "get_GetEventDispatcher", ""}, MethodValue{"SystemFuncVRCSDKBaseVRC_EventDispatcher", "VRCSDKBaseNetworking.__get__GetEventDispatcher__SystemFuncVRCSDKBaseVRC_EventDispatcher"}},
+		{"unnamed setter", `('Unknown', 'Networking', 'set'): 'VRCSDKBaseNetworking.__set__GetEventDispatcher__SystemFuncVRCSDKBaseVRC_EventDispatcher',`,
+			MethodKey{UNKNOWN, "Networking", "set_GetEventDispatcher", "SystemFuncVRCSDKBaseVRC_EventDispatcher"}, MethodValue{"None", "VRCSDKBaseNetworking.__set__GetEventDispatcher__SystemFuncVRCSDKBaseVRC_EventDispatcher"}},
+		{"unknown method", `('Unknown', 'Networking', 'GetEventDispatcher'): 'VRCSDKBaseNetworking.__GetEventDispatcher__VRCSDKBaseVRC_EventDispatcher',`,
+			MethodKey{UNKNOWN, "Networking", "GetEventDispatcher", ""}, MethodValue{"VRC_EventDispatcher", "VRCSDKBaseNetworking.__GetEventDispatcher__VRCSDKBaseVRC_EventDispatcher"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methodMap, warnings, err := ParseExterns(strings.NewReader("{\n" + tt.line + "\n}\n"))
+			if err != nil || len(warnings) != 0 {
+				t.Fatalf("%v, warnings %v", err, warnings)
+			}
+			got, ok := methodMap[tt.key]
+			if !ok || *got != tt.want {
+				t.Errorf("got %v, want %v in %v", got, tt.want, methodMap)
+			}
+		})
+	}
+}
+
+func TestParseExterns_Warnings(t *testing.T) {
+	data := "{\n('StaticFunc', 'Vector3', 'get_zero', ()): ('Vector3', 'UnityEngineVector3.__get_zero__UnityEngineVector3'),\nVector3.zero\n('Unknown', 'Networking', 'get'): 'VRCSDKBaseNetworking.__GetEventDispatcher'\n}\n"
+	methodMap, warnings, err := ParseExterns(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(methodMap) != 1 {
+		t.Errorf("parsed %v", methodMap)
+	}
+	if len(warnings) != 2 || warnings[0].Line != 3 || warnings[0].Text != "Vector3.zero" || warnings[1].Line != 4 {
+		t.Errorf("warnings %v", warnings)
+	}
+}
+
+func TestAddSDKRecords(t *testing.T) {
+	methodMap := MethodMap{}
+	err := AddSDKRecords(methodMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := NewMethodKey(INSTANCE_FUNC, "IUdonEventReceiver", "SendCustomEventDelayedFrames", []UdonTypeName{"String", "Int32", "EventTiming"})
+	want := "VRCUdonCommonInterfacesIUdonEventReceiver.__SendCustomEventDelayedFrames__SystemString_SystemInt32_VRCUdonCommonEnumsEventTiming__SystemVoid"
+	if v, ok := methodMap[key]; !ok || v.ExternStr != want {
+		t.Errorf("got %v, want %s in %v", v, want, methodMap)
+	}
+	if UdonTypes["EventTiming"] != UdonTypeEventTiming {
+		t.Errorf("EventTiming is %s", UdonTypes["EventTiming"])
+	}
 }
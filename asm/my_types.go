@@ -68,3 +68,6 @@ const GoRune UdonTypeName = UdonTypeChar
 
 // GoByte is a convenience alias which maps a go type to a unity type
 const GoByte UdonTypeName = UdonTypeByte
+
+// UdonTypeVoid is the return type of externs which do not return a value
+const UdonTypeVoid UdonTypeName = "SystemVoid"
@@ -0,0 +1,391 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OptLevel selects the passes Optimize runs over the code
+type OptLevel int
+
+const (
+	// O0 keeps the code as emitted
+	O0 OptLevel = iota
+	// O1 threads jumps, removes jumps to the next instruction and code no jump reaches
+	O1
+	// O2 also removes dead stores to temporaries and propagates copies, then the linker reuses their heap variables, see ReuseSlots
+	O2
+)
+
+// Optimize rewrites the code emitted so far, see OptLevel.
+// The passes only rely on how the compiler uses the stack:
+// every jump targets a label, a PUSH followed by COPY is the destination of the copy,
+// and the last PUSH before an EXTERN with a return value is where the result is written.
+// Temporaries are the heap variables handed out by GetNextId, which only the program itself reads.
+func (ua *UdonAssembly) Optimize(level OptLevel) error {
+	if level <= O0 {
+		return nil
+	}
+	code, err := ua.Code()
+	if err != nil {
+		return fmt.Errorf("optimize: %w", err)
+	}
+	o := &optimizer{ua: ua, code: code}
+	for changed := true; changed; {
+		changed = o.threadJumps()
+		changed = o.removeJumpsToNext() || changed
+		changed = o.removeUnreachable() || changed
+		if level >= O2 {
+			changed = o.removeDeadStores() || changed
+			changed = o.forwardStores() || changed
+			changed = o.propagateCopies() || changed
+		}
+	}
+	ua.SetCode(code)
+	if level >= O2 {
+		o.removeUnusedTemps()
+	}
+	return nil
+}
+
+type optimizer struct {
+	ua   *UdonAssembly
+	code *Code
+	// reads and writes count the accesses to each heap variable, see countAccesses
+	reads  map[VarName]int
+	writes map[VarName]int
+}
+
+// threadJumps makes jumps to a jump go to its target directly, jumps going round in a loop are left alone
+func (o *optimizer) threadJumps() bool {
+	changed := false
+	index := o.code.labelIndex()
+	for _, inst := range o.code.Insts {
+		if inst.Op != JUMP && inst.Op != JUMP_IF_FALSE {
+			continue
+		}
+		label, ok := inst.Label()
+		if !ok {
+			continue
+		}
+		target := inst.Arg
+		seen := map[LabelName]bool{label: true}
+		for {
+			i, ok := index[label]
+			if !ok || i >= len(o.code.Insts) || o.code.Insts[i].Op != JUMP {
+				break
+			}
+			next, ok := o.code.Insts[i].Label()
+			if !ok {
+				// a jump to the end of an event ends it as well
+				if inst.Op == JUMP && o.code.Insts[i].Arg == HaltAddr {
+					target = HaltAddr
+				}
+				break
+			}
+			if seen[next] {
+				target = inst.Arg
+				break
+			}
+			seen[next] = true
+			label = next
+			target = o.code.Insts[i].Arg
+		}
+		if target != inst.Arg {
+			inst.Arg = target
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeJumpsToNext removes jumps to the instruction following them, a conditional jump still pops its condition
+func (o *optimizer) removeJumpsToNext() bool {
+	changed := false
+	index := o.code.labelIndex()
+	for i := 0; i < len(o.code.Insts); i++ {
+		inst := o.code.Insts[i]
+		label, ok := inst.Label()
+		if !ok || index[label] != i+1 {
+			continue
+		}
+		switch inst.Op {
+		case JUMP:
+			o.code.remove(i)
+			index = o.code.labelIndex()
+			i--
+			changed = true
+		case JUMP_IF_FALSE:
+			inst.Op, inst.Arg = POP, ""
+			changed = true
+		}
+	}
+	return changed
+}
+
+// removeUnreachable removes the instructions following an unconditional jump up to the next instruction jumped to
+func (o *optimizer) removeUnreachable() bool {
+	changed := false
+	targets := o.jumpTargets()
+	reached := func(inst *Instruction) bool {
+		if len(inst.Heads) > 0 {
+			return true
+		}
+		for _, label := range inst.Labels {
+			if targets[label] {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 1; i < len(o.code.Insts); i++ {
+		prev := o.code.Insts[i-1].Op
+		if prev != JUMP && prev != JUMP_INDIRECT {
+			continue
+		}
+		for i < len(o.code.Insts) && !reached(o.code.Insts[i]) {
+			o.code.remove(i)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// jumpTargets returns the labels code may jump to: the targets of jumps,
+// and the labels whose address is stored in a heap variable for JUMP_INDIRECT
+func (o *optimizer) jumpTargets() map[LabelName]bool {
+	targets := o.storedLabels()
+	for _, inst := range o.code.Insts {
+		if label, ok := inst.Label(); ok {
+			targets[label] = true
+		}
+	}
+	return targets
+}
+
+// storedLabels returns the labels whose address is the initial value of a heap variable, the return addresses of calls
+func (o *optimizer) storedLabels() map[LabelName]bool {
+	labels := map[LabelName]bool{}
+	for _, item := range o.ua.VarTable.VarDict {
+		value := item.InitialValue
+		if strings.HasPrefix(value, "###") && strings.HasSuffix(value, "###") && len(value) >= 6 {
+			labels[LabelName(value[3:len(value)-3])] = true
+		}
+	}
+	return labels
+}
+
+// isWrite reports whether the PUSH at i pushes the heap variable a COPY or EXTERN writes to
+func (o *optimizer) isWrite(i int) bool {
+	if i+1 >= len(o.code.Insts) {
+		return false
+	}
+	next := o.code.Insts[i+1]
+	switch next.Op {
+	case COPY:
+		return true
+	case EXTERN:
+		externStr, _ := next.Extern()
+		sig, err := ParseExternStr(externStr)
+		return err == nil && sig.HasRet()
+	}
+	return false
+}
+
+// countAccesses counts the reads and writes of every heap variable
+func (o *optimizer) countAccesses() {
+	o.reads = map[VarName]int{}
+	o.writes = map[VarName]int{}
+	for i, inst := range o.code.Insts {
+		varName, ok := inst.Var()
+		if !ok {
+			continue
+		}
+		if inst.Op == PUSH && o.isWrite(i) {
+			o.writes[varName]++
+		} else {
+			o.reads[varName]++
+		}
+	}
+}
+
+// isTemp reports whether varName is a temporary, which nothing outside of the code reads
+func (o *optimizer) isTemp(varName VarName) bool {
+	if !strings.HasPrefix(string(varName), "__") {
+		return false
+	}
+	if _, ok := o.ua.VarTable.Find(varName); !ok {
+		return false
+	}
+	for _, global := range o.ua.VarTable.GlobalVarNames {
+		if global == varName {
+			return false
+		}
+	}
+	for _, syncVar := range o.ua.VarTable.SyncVars {
+		if syncVar.VarName == varName {
+			return false
+		}
+	}
+	return true
+}
+
+// sameType reports whether both heap variables have the same udon type, so one can stand for the other
+func (o *optimizer) sameType(a VarName, b VarName) bool {
+	aType, err := o.ua.VarTable.GetVarType(a)
+	if err != nil {
+		return false
+	}
+	bType, err := o.ua.VarTable.GetVarType(b)
+	return err == nil && aType == bType
+}
+
+// removeDeadStores removes the copies to temporaries which are never read:
+// PUSH t; COPY becomes POP, and a PUSH directly popped again is removed
+func (o *optimizer) removeDeadStores() bool {
+	changed := false
+	o.countAccesses()
+	for i := 0; i+1 < len(o.code.Insts); i++ {
+		inst, next := o.code.Insts[i], o.code.Insts[i+1]
+		if inst.Op == PUSH && next.Op == COPY && !o.code.labelled(i+1) {
+			varName, ok := inst.Var()
+			if ok && o.isTemp(varName) && o.reads[varName] == 0 {
+				o.code.remove(i + 1)
+				inst.Op, inst.Arg = POP, ""
+				changed = true
+			}
+		}
+	}
+	for i := 0; i+1 < len(o.code.Insts); i++ {
+		if o.code.Insts[i].Op == PUSH && o.code.Insts[i+1].Op == POP && !o.code.labelled(i+1) {
+			o.code.remove(i + 1)
+			o.code.remove(i)
+			i--
+			changed = true
+		}
+	}
+	return changed
+}
+
+// forwardStores writes results directly to where they are copied:
+// a temporary written once and read once by PUSH t; PUSH x; COPY later in the same block
+// is replaced by x where it is written, as long as x is not accessed in between
+func (o *optimizer) forwardStores() bool {
+	changed := false
+	o.countAccesses()
+	for i := 0; i+1 < len(o.code.Insts); i++ {
+		t, ok := o.code.Insts[i].Var()
+		if !ok || o.code.Insts[i].Op != PUSH || !o.isWrite(i) || !o.isTemp(t) || o.writes[t] != 1 || o.reads[t] != 1 {
+			continue
+		}
+		for k := i + 2; k+2 < len(o.code.Insts); k++ {
+			inst := o.code.Insts[k]
+			if o.code.labelled(k) || inst.Op == JUMP || inst.Op == JUMP_IF_FALSE || inst.Op == JUMP_INDIRECT {
+				break
+			}
+			if inst.Op == EXTERN && k > i+1 {
+				break
+			}
+			varName, ok := inst.Var()
+			if !ok {
+				continue
+			}
+			if varName != t {
+				continue
+			}
+			// the only read of t, it has to be the source of a copy
+			x, ok := o.code.Insts[k+1].Var()
+			if !ok || o.code.Insts[k+2].Op != COPY || o.code.labelled(k+1) || o.code.labelled(k+2) || !o.sameType(t, x) || o.accessed(x, i+2, k) {
+				break
+			}
+			o.code.Insts[i].Arg = string(x)
+			o.code.remove(k + 2)
+			o.code.remove(k + 1)
+			o.code.remove(k)
+			o.countAccesses()
+			changed = true
+			break
+		}
+	}
+	return changed
+}
+
+// accessed reports whether varName is pushed by an instruction in [from, to)
+func (o *optimizer) accessed(varName VarName, from int, to int) bool {
+	for k := from; k < to; k++ {
+		if v, ok := o.code.Insts[k].Var(); ok && v == varName {
+			return true
+		}
+	}
+	return false
+}
+
+// propagateCopies replaces the reads of a temporary holding a copy of a with a:
+// PUSH a; PUSH t; COPY is removed when t is written only there and all its reads follow in the same block
+// before a is written again
+func (o *optimizer) propagateCopies() bool {
+	changed := false
+	o.countAccesses()
+	for i := 0; i+2 < len(o.code.Insts); i++ {
+		a, ok := o.code.Insts[i].Var()
+		if !ok || o.code.Insts[i].Op != PUSH || o.code.Insts[i+2].Op != COPY || o.code.labelled(i+1) || o.code.labelled(i+2) {
+			continue
+		}
+		t, ok := o.code.Insts[i+1].Var()
+		if !ok || t == a || !o.isTemp(t) || o.writes[t] != 1 || !o.sameType(a, t) {
+			continue
+		}
+		uses := []int{}
+		for k := i + 3; k < len(o.code.Insts) && len(uses) < o.reads[t]; k++ {
+			inst := o.code.Insts[k]
+			if o.code.labelled(k) {
+				break
+			}
+			if v, ok := inst.Var(); ok {
+				if v == a && inst.Op == PUSH && o.isWrite(k) {
+					break
+				}
+				if v == t {
+					uses = append(uses, k)
+				}
+			}
+			if inst.Op == JUMP || inst.Op == JUMP_IF_FALSE || inst.Op == JUMP_INDIRECT {
+				break
+			}
+			// externs may send events to this behaviour, which could write a
+			if inst.Op == EXTERN && !(o.isTemp(a) && o.writes[a] == 0) {
+				break
+			}
+		}
+		if len(uses) != o.reads[t] {
+			continue
+		}
+		for _, k := range uses {
+			o.code.Insts[k].Arg = string(a)
+		}
+		o.code.remove(i + 2)
+		o.code.remove(i + 1)
+		o.code.remove(i)
+		o.countAccesses()
+		i--
+		changed = true
+	}
+	return changed
+}
+
+// removeUnusedTemps drops the temporaries the optimized code no longer refers to from the data segment
+func (o *optimizer) removeUnusedTemps() {
+	used := map[VarName]bool{}
+	for _, inst := range o.code.Insts {
+		if varName, ok := inst.Var(); ok {
+			used[varName] = true
+		}
+	}
+	vars := []*VarItem{}
+	for _, item := range o.ua.VarTable.VarDict {
+		if used[item.VarName] || !o.isTemp(item.VarName) {
+			vars = append(vars, item)
+		}
+	}
+	o.ua.VarTable.VarDict = vars
+}
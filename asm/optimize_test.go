@@ -0,0 +1,252 @@
+package asm_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"udon-go/asm"
+)
+
+func TestUdonAssembly_Optimize(t *testing.T) {
+	const negate = asm.ExternStr("SystemInt32.__op_UnaryMinus__SystemInt32__SystemInt32")
+	tests := []struct {
+		name  string
+		level asm.OptLevel
+		emit  func(ua *asm.UdonAssembly)
+		want  []string
+		// vars are the variables left in the data segment
+		vars []asm.VarName
+	}{
+		{"O0 keeps the code", asm.O0, func(ua *asm.UdonAssembly) {
+			ua.JumpLabel("next")
+			ua.AddLabelCurrentAddr("next")
+			ua.End()
+		}, []string{"JUMP, ###next###", "JUMP, 0xFFFFFFFF"}, nil},
+		{"jump threading", asm.O1, func(ua *asm.UdonAssembly) {
+			ua.JumpLabel("a")
+			ua.AddLabelCurrentAddr("b")
+			ua.End()
+			ua.AddLabelCurrentAddr("a")
+			ua.JumpLabel("b")
+		}, []string{"JUMP, 0xFFFFFFFF"}, nil},
+		{"jump cycle", asm.O1, func(ua *asm.UdonAssembly) {
+			ua.AddLabelCurrentAddr("a")
+			ua.JumpLabel("b")
+			ua.AddLabelCurrentAddr("b")
+			ua.JumpLabel("a")
+		}, []string{"JUMP, ###a###"}, nil},
+		{"conditional jump to next", asm.O1, func(ua *asm.UdonAssembly) {
+			ua.PushVar("cond")
+			ua.JumpIfFalseLabel("next")
+			ua.AddLabelCurrentAddr("next")
+			ua.End()
+		}, []string{"PUSH, cond", "POP", "JUMP, 0xFFFFFFFF"}, nil},
+		{"unreachable code", asm.O1, func(ua *asm.UdonAssembly) {
+			ua.JumpIndirect("ret_addr")
+			ua.PushVar("x")
+			ua.PushVar("y")
+			ua.Copy()
+			ua.EventHead("_update")
+			ua.End()
+		}, []string{"JUMP_INDIRECT, ret_addr", "JUMP, 0xFFFFFFFF"}, nil},
+		{"dead store", asm.O2, func(ua *asm.UdonAssembly) {
+			ua.VarTable.AddVar("__t", asm.UdonTypeInt32, "null")
+			ua.PushVar("x")
+			ua.PushVar("__t")
+			ua.Copy()
+			ua.End()
+		}, []string{"JUMP, 0xFFFFFFFF"}, []asm.VarName{}},
+		{"dead store to exported variable", asm.O2, func(ua *asm.UdonAssembly) {
+			ua.VarTable.AddVar("__t", asm.UdonTypeInt32, "null")
+			ua.VarTable.AddVarGlobal("__t")
+			ua.PushVar("x")
+			ua.PushVar("__t")
+			ua.Copy()
+			ua.End()
+		}, []string{"PUSH, x", "PUSH, __t", "COPY", "JUMP, 0xFFFFFFFF"}, []asm.VarName{"__t"}},
+		{"copy propagation and store forwarding", asm.O2, func(ua *asm.UdonAssembly) {
+			for _, varName := range []asm.VarName{"x", "y", "__t", "__r"} {
+				ua.VarTable.AddVar(varName, asm.UdonTypeInt32, "null")
+			}
+			ua.PushVar("x")
+			ua.PushVar("__t")
+			ua.Copy()
+			ua.PushVar("__t")
+			ua.PushVar("__r")
+			ua.Extern(negate)
+			ua.PushVar("__r")
+			ua.PushVar("y")
+			ua.Copy()
+			ua.End()
+		}, []string{"PUSH, x", "PUSH, y", `EXTERN, "` + string(negate) + `"`, "JUMP, 0xFFFFFFFF"}, []asm.VarName{"x", "y"}},
+		{"copy of a variable written again", asm.O2, func(ua *asm.UdonAssembly) {
+			for _, varName := range []asm.VarName{"x", "y", "__t"} {
+				ua.VarTable.AddVar(varName, asm.UdonTypeInt32, "null")
+			}
+			ua.PushVar("x")
+			ua.PushVar("__t")
+			ua.Copy()
+			ua.PushVar("y")
+			ua.PushVar("x")
+			ua.Copy()
+			ua.PushVar("__t")
+			ua.PushVar("y")
+			ua.Copy()
+			ua.End()
+		}, []string{"PUSH, x", "PUSH, __t", "COPY", "PUSH, y", "PUSH, x", "COPY", "PUSH, __t", "PUSH, y", "COPY", "JUMP, 0xFFFFFFFF"},
+			[]asm.VarName{"x", "y", "__t"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ua, err := asm.NewUdonAssembly(strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ua.EventHead("_start")
+			tt.emit(ua)
+			err = ua.Optimize(tt.level)
+			if err != nil {
+				t.Fatalf("optimize: %v", err)
+			}
+			code, err := ua.Code()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, inst := range code.Insts {
+				got = append(got, inst.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if tt.vars == nil {
+				return
+			}
+			vars := []asm.VarName{}
+			for _, item := range ua.VarTable.VarDict {
+				vars = append(vars, item.VarName)
+			}
+			if !reflect.DeepEqual(vars, tt.vars) {
+				t.Errorf("got variables %v, want %v", vars, tt.vars)
+			}
+		})
+	}
+}
+
+func TestParseExternStr(t *testing.T) {
+	tests := []struct {
+		externStr asm.ExternStr
+		want      *asm.ExternSig
+	}{
+		{"SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32",
+			&asm.ExternSig{Module: "SystemInt32", Method: "op_Addition", ArgTypes: []asm.UdonTypeName{"SystemInt32", "SystemInt32"}, RetType: "SystemInt32"}},
+		{"SystemObjectArray.__get_Length__SystemInt32", &asm.ExternSig{Module: "SystemObjectArray", Method: "get_Length", RetType: "SystemInt32"}},
+		{"UnityEngineGameObject.__ctor____UnityEngineGameObject", &asm.ExternSig{Module: "UnityEngineGameObject", Method: "ctor", RetType: "UnityEngineGameObject"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.externStr), func(t *testing.T) {
+			got, err := asm.ParseExternStr(tt.externStr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	_, err := asm.ParseExternStr("SystemInt32.op_Addition")
+	if err == nil {
+		t.Error("no error for a malformed extern")
+	}
+}
+
+func TestUdonAssembly_ReuseSlots(t *testing.T) {
+	// assign emits dst = src
+	assign := func(ua *asm.UdonAssembly, src asm.VarName, dst asm.VarName) {
+		ua.PushVar(src)
+		ua.PushVar(dst)
+		ua.Copy()
+	}
+	tests := []struct {
+		name string
+		emit func(ua *asm.UdonAssembly)
+		// want are the variables left in the data segment
+		want []asm.VarName
+	}{
+		{"sequential lifetimes", func(ua *asm.UdonAssembly) {
+			assign(ua, "x", "__a")
+			assign(ua, "__a", "y")
+			assign(ua, "x", "__b")
+			assign(ua, "__b", "y")
+		}, []asm.VarName{"x", "y", "__a", "__c"}},
+		{"overlapping lifetimes", func(ua *asm.UdonAssembly) {
+			assign(ua, "x", "__a")
+			assign(ua, "x", "__b")
+			assign(ua, "__a", "y")
+			assign(ua, "__b", "y")
+		}, []asm.VarName{"x", "y", "__a", "__b", "__c"}},
+		{"other type", func(ua *asm.UdonAssembly) {
+			assign(ua, "x", "__a")
+			assign(ua, "__a", "y")
+			assign(ua, "x", "__c")
+		}, []asm.VarName{"x", "y", "__a", "__c"}},
+		{"live around a loop", func(ua *asm.UdonAssembly) {
+			assign(ua, "x", "__a")
+			ua.AddLabelCurrentAddr("loop")
+			assign(ua, "x", "__b")
+			assign(ua, "__b", "y")
+			assign(ua, "__a", "y")
+			ua.PushVar("cond")
+			ua.JumpIfFalseLabel("loop")
+		}, []asm.VarName{"x", "y", "__a", "__b", "__c"}},
+		{"read before written", func(ua *asm.UdonAssembly) {
+			assign(ua, "__a", "y")
+			assign(ua, "x", "__b")
+			assign(ua, "__b", "y")
+		}, []asm.VarName{"x", "y", "__a", "__b", "__c"}},
+		{"address left on the stack", func(ua *asm.UdonAssembly) {
+			assign(ua, "x", "__a")
+			ua.PushVar("__a")
+			ua.JumpLabel("f")
+			ua.AddLabelCurrentAddr("f")
+			ua.PushVar("y")
+			ua.Copy()
+			assign(ua, "x", "__b")
+			assign(ua, "__b", "y")
+		}, []asm.VarName{"x", "y", "__a", "__b", "__c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ua, err := asm.NewUdonAssembly(strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ua.VarTable.AddVar("x", asm.UdonTypeInt32, "1")
+			ua.VarTable.AddVar("y", asm.UdonTypeInt32, "null")
+			ua.VarTable.AddVar("__a", asm.UdonTypeInt32, "null")
+			ua.VarTable.AddVar("__b", asm.UdonTypeInt32, "null")
+			ua.VarTable.AddVar("__c", asm.UdonTypeString, "null")
+			ua.EventHead("_start")
+			tt.emit(ua)
+			ua.End()
+			report, err := ua.ReuseSlots()
+			if err != nil {
+				t.Fatalf("reuse slots: %v", err)
+			}
+			vars := []asm.VarName{}
+			for _, item := range ua.VarTable.VarDict {
+				vars = append(vars, item.VarName)
+			}
+			if !reflect.DeepEqual(vars, tt.want) {
+				t.Errorf("got variables %v, want %v", vars, tt.want)
+			}
+			before, after := report.Total()
+			if before != 5 || after != len(tt.want) {
+				t.Errorf("report %d -> %d, want 5 -> %d", before, after, len(tt.want))
+			}
+			if _, err := ua.Code(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
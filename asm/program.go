@@ -0,0 +1,144 @@
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Program is a text assembly read back, with its labels resolved to addresses
+type Program struct {
+	// Vars are the heap variables in declaration order, their index is their heap address
+	Vars    []*VarItem
+	Exports []VarName
+	Syncs   []SyncVar
+	// Events are the exported events, whose addresses are the labels written before their first instruction
+	Events []EventName
+	Code   []*Instruction
+}
+
+// ParseProgram reads the text assembly of an udon program, as written by MakeDataSeg and MakeCodeSeg
+func ParseProgram(text string) (*Program, error) {
+	prog := &Program{}
+	segment := ""
+	pending := &Instruction{}
+	addr := Addr(0)
+	scan := bufio.NewScanner(strings.NewReader(text))
+	scan.Buffer(nil, 1<<20)
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		switch {
+		case line == "":
+		case line == ".data_start", line == ".code_start":
+			segment = line
+		case line == ".data_end", line == ".code_end":
+			segment = ""
+		case strings.HasPrefix(line, "#"):
+			pending.Comments = append(pending.Comments, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+		case segment == ".data_start":
+			err := prog.parseData(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+		case segment == ".code_start" && strings.HasPrefix(line, ".export "):
+			prog.Events = append(prog.Events, EventName(strings.TrimSpace(strings.TrimPrefix(line, ".export "))))
+		case segment == ".code_start" && strings.HasSuffix(line, ":"):
+			pending.Heads = append(pending.Heads, EventName(strings.TrimSuffix(line, ":")))
+		case segment == ".code_start":
+			inst := pending
+			pending = &Instruction{}
+			fields := strings.SplitN(line, ", ", 2)
+			inst.Op = Opcode(fields[0])
+			if len(fields) == 2 {
+				inst.Arg = fields[1]
+			}
+			switch inst.Op {
+			case NOP, PUSH, POP, JUMP_IF_FALSE, JUMP, EXTERN, ANNOTATION, JUMP_INDIRECT, COPY:
+			default:
+				return nil, fmt.Errorf("line %d: unknown instruction %s", n, inst.Op)
+			}
+			if (inst.Arg == "") != (inst.Op.Size() == 4) {
+				return nil, fmt.Errorf("line %d: bad operand for %s", n, inst.Op)
+			}
+			inst.Addr = addr
+			addr += inst.Op.Size()
+			prog.Code = append(prog.Code, inst)
+		default:
+			return nil, fmt.Errorf("line %d: %q outside of a segment", n, line)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	for _, event := range prog.Events {
+		if _, ok := prog.EventAddr(event); !ok {
+			return nil, fmt.Errorf("exported event %s has no label", event)
+		}
+	}
+	return prog, nil
+}
+
+// parseData reads a line of the data segment: .export name, .sync name, mode or name: %Type, value
+func (prog *Program) parseData(line string) error {
+	if strings.HasPrefix(line, ".export ") {
+		prog.Exports = append(prog.Exports, VarName(strings.TrimSpace(strings.TrimPrefix(line, ".export "))))
+		return nil
+	}
+	if strings.HasPrefix(line, ".sync ") {
+		fields := strings.SplitN(strings.TrimPrefix(line, ".sync "), ", ", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("bad sync %q", line)
+		}
+		prog.Syncs = append(prog.Syncs, SyncVar{VarName: VarName(fields[0]), Mode: SyncMode(fields[1])})
+		return nil
+	}
+	colon := strings.Index(line, ": %")
+	if colon < 0 {
+		return fmt.Errorf("bad variable %q", line)
+	}
+	fields := strings.SplitN(line[colon+3:], ", ", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("bad variable %q", line)
+	}
+	typeName := UdonTypeName(fields[0])
+	if typeName == "VRCUdonUdonBehaviour" {
+		typeName = UdonTypeIUdonEventReceiver
+	}
+	prog.Vars = append(prog.Vars, &VarItem{VarName: VarName(line[:colon]), TypeName: typeName, InitialValue: fields[1]})
+	return nil
+}
+
+// EventAddr returns the address of the first instruction of an event
+func (prog *Program) EventAddr(event EventName) (Addr, bool) {
+	for _, inst := range prog.Code {
+		for _, head := range inst.Heads {
+			if head == event {
+				return inst.Addr, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// VarIndex returns the heap address of a variable
+func (prog *Program) VarIndex(varName VarName) (int, bool) {
+	for i, item := range prog.Vars {
+		if item.VarName == varName {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// SetLabels attaches the labels to the instructions they point to, the labels past the last instruction are dropped
+func (prog *Program) SetLabels(labels map[LabelName]Addr) {
+	byAddr := map[Addr][]LabelName{}
+	for label, addr := range labels {
+		byAddr[addr] = append(byAddr[addr], label)
+	}
+	for _, inst := range prog.Code {
+		inst.Labels = byAddr[inst.Addr]
+		sort.Slice(inst.Labels, func(i, j int) bool { return inst.Labels[i] < inst.Labels[j] })
+	}
+}
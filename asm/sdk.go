@@ -0,0 +1,37 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The generated tables, udon_funcs_data.txt, udon_types.go and udon_types_relation.go, were dumped from
+// an SDK which predates the delayed events of IUdonEventReceiver. The entries below are written by hand
+// from the VRChat SDK3 API (VRC.Udon.Common.Interfaces.IUdonEventReceiver.SendCustomEventDelayedSeconds
+// and SendCustomEventDelayedFrames, VRC.Udon.Common.Enums.EventTiming), following the naming of the
+// generated ones. Drop them once the tables are regenerated from an SDK which has them.
+
+// UdonTypeEventTiming is the update loop a delayed event runs in, its zero value is Update
+const UdonTypeEventTiming = "VRCUdonCommonEnumsEventTiming"
+
+// sdkRecords are the method table records missing from udon_funcs_data.txt
+const sdkRecords = `('InstanceFunc', 'IUdonEventReceiver', 'SendCustomEventDelayedSeconds', ('String', 'Single', 'EventTiming')): ('None', 'VRCUdonCommonInterfacesIUdonEventReceiver.__SendCustomEventDelayedSeconds__SystemString_SystemSingle_VRCUdonCommonEnumsEventTiming__SystemVoid'),
+('InstanceFunc', 'IUdonEventReceiver', 'SendCustomEventDelayedFrames', ('String', 'Int32', 'EventTiming')): ('None', 'VRCUdonCommonInterfacesIUdonEventReceiver.__SendCustomEventDelayedFrames__SystemString_SystemInt32_VRCUdonCommonEnumsEventTiming__SystemVoid'),`
+
+func init() {
+	UdonTypes["EventTiming"] = UdonTypeEventTiming
+}
+
+// AddSDKRecords adds the records missing from the generated method table, the table's own records are kept
+func AddSDKRecords(methodMap MethodMap) error {
+	for _, line := range strings.Split(sdkRecords, "\n") {
+		key, value, err := parseRecord(line)
+		if err != nil {
+			return fmt.Errorf("sdk record %s: %w", line, err)
+		}
+		if _, ok := methodMap[*key]; !ok {
+			methodMap[*key] = value
+		}
+	}
+	return nil
+}
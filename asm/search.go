@@ -0,0 +1,222 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ExternQuery selects externs of the method table, its empty fields match every extern
+type ExternQuery struct {
+	// Module is the full udon type declaring the method
+	Module UdonTypeName
+	// Method is a part of the method name, matched ignoring case
+	Method string
+	Kind   UdonMethodKind
+	// ArgTypes are the full udon types of the arguments, nil matches any arguments
+	ArgTypes []UdonTypeName
+}
+
+// Match reports whether an extern is selected by the query
+func (q *ExternQuery) Match(m *ExternMethod) bool {
+	if q.Module != "" && m.Module != q.Module {
+		return false
+	}
+	if q.Kind != "" && m.Kind != q.Kind {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(string(m.Method)), strings.ToLower(q.Method)) {
+		return false
+	}
+	if q.ArgTypes == nil {
+		return true
+	}
+	if len(m.ArgTypes) != len(q.ArgTypes) {
+		return false
+	}
+	for i, argType := range q.ArgTypes {
+		if m.ArgTypes[i] != argType {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the externs of the method table selected by q, by module, method and extern
+func (umm MethodMap) Search(q *ExternQuery) []*ExternMethod {
+	found := []*ExternMethod{}
+	for _, m := range umm.Externs() {
+		if q.Match(m) {
+			found = append(found, m)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Extern < b.Extern
+	})
+	return found
+}
+
+// signatureType writes an udon type in the signatures of the externs: the go name of basic types,
+// the stub of the asm package of the other ones, e.g. asm.Transform or asm.UnityEngineObject
+func signatureType(typeName UdonTypeName) string {
+	if name := GoTypeName(typeName); name != string(typeName) {
+		return name
+	}
+	return "asm." + string(ShortTypeName(typeName))
+}
+
+// GoSignature writes the extern as a go function, e.g. func (asm.Transform) GetChild(int32) asm.Transform.
+// Static methods and constructors are written as functions of their type, e.g. func asm.Vector3.ctor(float32, float32) asm.Vector3.
+// Externs of unknown kind are written like static methods, as the table does not tell whether they take the instance.
+func (m *ExternMethod) GoSignature() string {
+	args := []string{}
+	for _, argType := range m.ArgTypes {
+		args = append(args, signatureType(argType))
+	}
+	ret := ""
+	if m.RetType != UdonTypeVoid {
+		ret = " " + signatureType(m.RetType)
+	}
+	switch m.Kind {
+	case INSTANCE_FUNC:
+		return fmt.Sprintf("func (%s) %s(%s)%s", signatureType(m.Module), m.Method, strings.Join(args, ", "), ret)
+	case UNKNOWN:
+		return fmt.Sprintf("func %s.%s(%s)%s // unknown kind", signatureType(m.Module), m.Method, strings.Join(args, ", "), ret)
+	}
+	return fmt.Sprintf("func %s.%s(%s)%s", signatureType(m.Module), m.Method, strings.Join(args, ", "), ret)
+}
+
+// Write lists the extern, its go signature followed by the extern on the next line, both indented by indent
+func (m *ExternMethod) Write(w io.Writer, indent string) {
+	fmt.Fprintf(w, "%s%s\n%s    %s\n", indent, m.GoSignature(), indent, m.Extern)
+}
+
+// Property is a value of a type read and written by a getter and a setter extern, either of which may be missing
+type Property struct {
+	Name   string
+	Type   UdonTypeName
+	Static bool
+	Get    *ExternMethod
+	Set    *ExternMethod
+}
+
+// Property returns the instance property name of a type, read by its get_ extern and written by its set_ extern.
+// Accessors of unknown kind are left out, the table does not tell whether they take the instance.
+func (umm MethodMap) Property(typeName UdonTypeName, name string) (*Property, bool) {
+	module := ShortTypeName(typeName)
+	p := &Property{Name: name}
+	getKey := NewMethodKey(INSTANCE_FUNC, module, UdonMethodName("get_"+name), nil)
+	if value, ok := umm[getKey]; ok {
+		p.Get = newExternMethod(getKey, value)
+		p.Type = p.Get.RetType
+	}
+	setKeys := []MethodKey{NewMethodKey(INSTANCE_FUNC, module, UdonMethodName("set_"+name), nil)}
+	if p.Get != nil {
+		setKeys = append(setKeys, NewMethodKey(INSTANCE_FUNC, module, UdonMethodName("set_"+name), []UdonTypeName{ShortTypeName(p.Type)}))
+	}
+	for _, setKey := range setKeys {
+		value, ok := umm[setKey]
+		if !ok {
+			continue
+		}
+		set := newExternMethod(setKey, value)
+		if len(set.ArgTypes) == 1 && (p.Get == nil || set.ArgTypes[0] == p.Type) {
+			p.Set, p.Type = set, set.ArgTypes[0]
+			break
+		}
+	}
+	return p, p.Get != nil || p.Set != nil
+}
+
+// TypeDoc lists the externs of the method table declared by a type
+type TypeDoc struct {
+	Type          UdonTypeName
+	Constructors  []*ExternMethod
+	Properties    []*Property
+	StaticMethods []*ExternMethod
+	Methods       []*ExternMethod
+}
+
+// Doc collects the externs declared by a type, the get_ and set_ methods as its properties
+func (umm MethodMap) Doc(typeName UdonTypeName) (*TypeDoc, error) {
+	externs := umm.Search(&ExternQuery{Module: typeName})
+	if len(externs) == 0 {
+		return nil, fmt.Errorf("no externs declared by %s", typeName)
+	}
+	doc := &TypeDoc{Type: typeName}
+	properties := map[string]*Property{}
+	for _, m := range externs {
+		name := string(m.Method)
+		switch {
+		case m.Kind == CONSTRUCTOR:
+			doc.Constructors = append(doc.Constructors, m)
+		case strings.HasPrefix(name, "get_") && len(m.ArgTypes) == 0 || strings.HasPrefix(name, "set_") && len(m.ArgTypes) == 1:
+			p, ok := properties[name[4:]]
+			if !ok {
+				p = &Property{Name: name[4:], Static: m.Kind == STATIC_FUNC}
+				properties[p.Name] = p
+				doc.Properties = append(doc.Properties, p)
+			}
+			if name[:4] == "get_" {
+				p.Get, p.Type = m, m.RetType
+			} else {
+				p.Set, p.Type = m, m.ArgTypes[0]
+			}
+		case m.Kind == STATIC_FUNC:
+			doc.StaticMethods = append(doc.StaticMethods, m)
+		default:
+			doc.Methods = append(doc.Methods, m)
+		}
+	}
+	sort.Slice(doc.Properties, func(i, j int) bool { return doc.Properties[i].Name < doc.Properties[j].Name })
+	return doc, nil
+}
+
+// Write lists the constructors, properties, static methods and methods of the type, with their externs
+func (doc *TypeDoc) Write(w io.Writer) {
+	fmt.Fprintf(w, "type %s // %s\n", signatureType(doc.Type), doc.Type)
+	writeExterns := func(title string, externs []*ExternMethod) {
+		if len(externs) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s\n", title)
+		for _, m := range externs {
+			m.Write(w, "    ")
+		}
+	}
+	writeExterns("constructors", doc.Constructors)
+	if len(doc.Properties) > 0 {
+		fmt.Fprintf(w, "\nproperties\n")
+	}
+	for _, p := range doc.Properties {
+		access := []string{}
+		if p.Static {
+			access = append(access, "static")
+		}
+		if p.Get != nil && p.Get.Kind == UNKNOWN || p.Set != nil && p.Set.Kind == UNKNOWN {
+			access = append(access, "unknown kind")
+		}
+		if p.Get != nil {
+			access = append(access, "get")
+		}
+		if p.Set != nil {
+			access = append(access, "set")
+		}
+		fmt.Fprintf(w, "    %s %s // %s\n", p.Name, signatureType(p.Type), strings.Join(access, " "))
+		for _, m := range []*ExternMethod{p.Get, p.Set} {
+			if m != nil {
+				m.Write(w, "        ")
+			}
+		}
+	}
+	writeExterns("static methods", doc.StaticMethods)
+	writeExterns("methods", doc.Methods)
+}
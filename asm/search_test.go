@@ -0,0 +1,207 @@
+package asm_test
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"udon-go/asm"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const searchTable = `('Constructor', 'Vector3', 'ctor', ('Single', 'Single', 'Single')): ('Vector3', 'UnityEngineVector3.__ctor__SystemSingle_SystemSingle_SystemSingle__UnityEngineVector3'),
+('StaticFunc', 'Vector3', 'get_zero', ()): ('Vector3', 'UnityEngineVector3.__get_zero__UnityEngineVector3'),
+('StaticFunc', 'Vector3', 'Lerp', ('Vector3', 'Vector3', 'Single')): ('Vector3', 'UnityEngineVector3.__Lerp__UnityEngineVector3_UnityEngineVector3_SystemSingle__UnityEngineVector3'),
+('InstanceFunc', 'Vector3', 'get_magnitude', ()): ('Single', 'UnityEngineVector3.__get_magnitude__SystemSingle'),
+('InstanceFunc', 'Vector3', 'get_x', ()): ('Single', 'UnityEngineVector3.__get_x__SystemSingle'),
+('InstanceFunc', 'Vector3', 'set_x', ()): ('Single', 'UnityEngineVector3.__set_x__SystemSingle'),
+('InstanceFunc', 'Transform', 'get_position', ()): ('Vector3', 'UnityEngineTransform.__get_position__UnityEngineVector3'),
+('InstanceFunc', 'Transform', 'set_position', ('Vector3',)): ('None', 'UnityEngineTransform.__set_position__UnityEngineVector3__SystemVoid'),
+('InstanceFunc', 'Transform', 'GetChild', ('Int32',)): ('Transform', 'UnityEngineTransform.__GetChild__SystemInt32__UnityEngineTransform'),
+('InstanceFunc', 'Transform', 'SetParent', ('Transform', 'Boolean')): ('None', 'UnityEngineTransform.__SetParent__UnityEngineTransform_SystemBoolean__SystemVoid'),
+('Unknown', 'VRCPickup', 'get_orientation'): 'VRCSDK3ComponentsVRCPickup.__get_orientation__VRCSDKBaseVRC_PickupPickupOrientation',
+`
+
+// goldenTable adds the records whose signatures are written differently to searchTable:
+// types without a short name, externs of unknown kind and static methods returning nothing
+const goldenTable = searchTable + `('StaticFunc', 'UnityEngineObject', 'DestroyImmediate', ('UnityEngineObject',)): ('None', 'UnityEngineObject.__DestroyImmediate__UnityEngineObject__SystemVoid'),
+('StaticFunc', 'VRCPickup', 'op_Implicit', ('UnityEngineObject',)): ('Boolean', 'VRCSDK3ComponentsVRCPickup.__op_Implicit__UnityEngineObject__SystemBoolean'),
+('Unknown', 'VRCPickup', 'set_orientation'): 'VRCSDK3ComponentsVRCPickup.__set_orientation__VRCSDKBaseVRC_PickupPickupOrientation',
+('Unknown', 'VRCPickup', 'Drop'): 'VRCSDK3ComponentsVRCPickup.__Drop__SystemVoid',
+`
+
+// TestExternsGolden compares the listings of the externs search and doc commands with testdata/externs.golden,
+// go test -update rewrites it
+func TestExternsGolden(t *testing.T) {
+	methodTable, warnings, err := asm.ParseExterns(strings.NewReader(goldenTable))
+	if err != nil || len(warnings) > 0 {
+		t.Fatalf("%v, warnings %v", err, warnings)
+	}
+	b := &strings.Builder{}
+	b.WriteString("# externs search\n")
+	for _, m := range methodTable.Search(&asm.ExternQuery{}) {
+		m.Write(b, "")
+	}
+	for _, typeName := range []asm.UdonTypeName{"UnityEngineTransform", "UnityEngineVector3", "VRCSDK3ComponentsVRCPickup", "UnityEngineObject"} {
+		doc, err := methodTable.Doc(typeName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.WriteString("\n# doc " + string(typeName) + "\n")
+		doc.Write(b)
+	}
+	golden := filepath.Join("testdata", "externs.golden")
+	if *update {
+		err = ioutil.WriteFile(golden, []byte(b.String()), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
+
+func TestMethodMap_Search(t *testing.T) {
+	methodTable, _, err := asm.ParseExterns(strings.NewReader(searchTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		q    asm.ExternQuery
+		want []string
+	}{
+		{"method ignoring case", asm.ExternQuery{Method: "getchild"}, []string{"func (asm.Transform) GetChild(int32) asm.Transform"}},
+		{"module", asm.ExternQuery{Module: asm.UdonTypeName("UnityEngineTransform"), Method: "set"}, []string{
+			"func (asm.Transform) SetParent(asm.Transform, bool)",
+			"func (asm.Transform) set_position(asm.Vector3)",
+		}},
+		{"kind", asm.ExternQuery{Kind: asm.STATIC_FUNC}, []string{
+			"func asm.Vector3.Lerp(asm.Vector3, asm.Vector3, float32) asm.Vector3",
+			"func asm.Vector3.get_zero() asm.Vector3",
+		}},
+		{"argument types", asm.ExternQuery{ArgTypes: []asm.UdonTypeName{asm.UdonTypeSingle, asm.UdonTypeSingle, asm.UdonTypeSingle}}, []string{
+			"func asm.Vector3.ctor(float32, float32, float32) asm.Vector3",
+		}},
+		{"no arguments", asm.ExternQuery{Module: asm.UdonTypeName("UnityEngineVector3"), ArgTypes: []asm.UdonTypeName{}}, []string{
+			"func (asm.Vector3) get_magnitude() float32",
+			"func (asm.Vector3) get_x() float32",
+			"func asm.Vector3.get_zero() asm.Vector3",
+		}},
+		{"field setter", asm.ExternQuery{Method: "set_x"}, []string{"func (asm.Vector3) set_x(float32)"}},
+		{"nothing", asm.ExternQuery{Method: "frobnicate"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, m := range methodTable.Search(&tt.q) {
+				got = append(got, m.GoSignature())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMethodMap_Doc(t *testing.T) {
+	methodTable, _, err := asm.ParseExterns(strings.NewReader(searchTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeName, ok := asm.LookupTypeName("asm.Transform")
+	if !ok || typeName != "UnityEngineTransform" {
+		t.Fatalf("asm.Transform is %q, %v", typeName, ok)
+	}
+	doc, err := methodTable.Doc(typeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Properties) != 1 || doc.Properties[0].Name != "position" || len(doc.Methods) != 2 {
+		t.Errorf("doc of Transform is %+v", doc)
+	}
+	doc, err = methodTable.Doc("UnityEngineVector3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Constructors) != 1 || len(doc.StaticMethods) != 1 || len(doc.Methods) != 0 || len(doc.Properties) != 3 || !doc.Properties[2].Static || doc.Properties[1].Set == nil {
+		t.Errorf("doc of Vector3 is %+v", doc)
+	}
+	if _, err := methodTable.Doc("UnityEngineGameObject"); err == nil {
+		t.Error("doc of a type without externs")
+	}
+}
+
+func TestMethodMap_Property(t *testing.T) {
+	methodTable, _, err := asm.ParseExterns(strings.NewReader(searchTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		typeName asm.UdonTypeName
+		property string
+		// want is the type, getter and setter externs of the property
+		want []string
+		ok   bool
+	}{
+		{"getter and setter", asm.UdonTypeTransform, "position", []string{"UnityEngineVector3",
+			"UnityEngineTransform.__get_position__UnityEngineVector3", "UnityEngineTransform.__set_position__UnityEngineVector3__SystemVoid"}, true},
+		{"field", asm.UdonTypeName("UnityEngineVector3"), "x", []string{"SystemSingle",
+			"UnityEngineVector3.__get_x__SystemSingle", "UnityEngineVector3.__set_x__SystemSingle"}, true},
+		{"read only", asm.UdonTypeName("UnityEngineVector3"), "magnitude", []string{"SystemSingle", "UnityEngineVector3.__get_magnitude__SystemSingle", ""}, true},
+		{"static", asm.UdonTypeName("UnityEngineVector3"), "zero", nil, false},
+		{"unknown kind", asm.UdonTypeName("VRCSDK3ComponentsVRCPickup"), "orientation", nil, false},
+		{"missing", asm.UdonTypeTransform, "frobnicator", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := methodTable.Property(tt.typeName, tt.property)
+			if ok != tt.ok {
+				t.Fatalf("found %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			got := []string{string(p.Type), "", ""}
+			for i, m := range []*asm.ExternMethod{p.Get, p.Set} {
+				if m != nil {
+					got[i+1] = string(m.Extern)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupTypeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want asm.UdonTypeName
+		ok   bool
+	}{
+		{"int", asm.UdonTypeInt32, true},
+		{"float32", asm.UdonTypeSingle, true},
+		{"string", asm.UdonTypeString, true},
+		{"asm.Transform", "UnityEngineTransform", true},
+		{"Transform", "UnityEngineTransform", true},
+		{"UnityEngineTransform", "UnityEngineTransform", true},
+		{"Frobnicator", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := asm.LookupTypeName(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("LookupTypeName(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
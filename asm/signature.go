@@ -0,0 +1,106 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExternSig is an extern signature taken apart, e.g. SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32
+type ExternSig struct {
+	Module   UdonTypeName
+	Method   UdonMethodName
+	ArgTypes []UdonTypeName
+	RetType  UdonTypeName
+}
+
+// ParseExternStr splits an extern signature into its module, method, argument and return types
+func ParseExternStr(externStr ExternStr) (*ExternSig, error) {
+	s := string(externStr)
+	dot := strings.Index(s, ".__")
+	if dot < 0 {
+		return nil, fmt.Errorf("bad extern %s: missing .__", s)
+	}
+	parts := strings.Split(s[dot+3:], "__")
+	sig := &ExternSig{Module: UdonTypeName(s[:dot]), Method: UdonMethodName(parts[0])}
+	switch len(parts) {
+	case 2:
+		sig.RetType = UdonTypeName(parts[1])
+		// setters of fields are written __set_x__T, taking the value
+		if strings.HasPrefix(parts[0], "set_") {
+			sig.ArgTypes, sig.RetType = []UdonTypeName{sig.RetType}, UdonTypeVoid
+		}
+	case 3:
+		// constructors without arguments are written __ctor____T
+		sig.RetType = UdonTypeName(parts[2])
+		if parts[1] != "" {
+			for _, argType := range strings.Split(parts[1], "_") {
+				sig.ArgTypes = append(sig.ArgTypes, UdonTypeName(argType))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("bad extern %s", s)
+	}
+	if sig.Method == "" || sig.RetType == "" {
+		return nil, fmt.Errorf("bad extern %s", s)
+	}
+	return sig, nil
+}
+
+// HasRet reports whether the extern writes a return value, to the last heap address pushed before it
+func (sig *ExternSig) HasRet() bool {
+	return sig.RetType != UdonTypeVoid
+}
+
+// ExternMethod is an extern of the method table, with the full udon type names of its operands
+type ExternMethod struct {
+	Extern   ExternStr
+	Kind     UdonMethodKind
+	Module   UdonTypeName
+	Method   UdonMethodName
+	ArgTypes []UdonTypeName
+	RetType  UdonTypeName
+}
+
+// Operands returns the types of the heap addresses pushed before the extern, in order:
+// the instance of instance methods, the arguments, then the return slot unless it is void
+func (m *ExternMethod) Operands() []UdonTypeName {
+	operands := []UdonTypeName{}
+	if m.Kind == INSTANCE_FUNC {
+		operands = append(operands, m.Module)
+	}
+	operands = append(operands, m.ArgTypes...)
+	if m.RetType != UdonTypeVoid {
+		operands = append(operands, m.RetType)
+	}
+	return operands
+}
+
+// Externs indexes the method table by extern signature
+func (umm MethodMap) Externs() map[ExternStr]*ExternMethod {
+	externs := map[ExternStr]*ExternMethod{}
+	for key, value := range umm {
+		externs[ExternStr(value.ExternStr)] = newExternMethod(key, value)
+	}
+	return externs
+}
+
+// newExternMethod describes a method of the table with full type names.
+// The table lists the setters of fields as returning the value, they take it like the other setters.
+func newExternMethod(key MethodKey, value *MethodValue) *ExternMethod {
+	m := &ExternMethod{
+		Extern:  ExternStr(value.ExternStr),
+		Kind:    key.MethodKind,
+		Module:  FullTypeName(key.ModuleName),
+		Method:  key.MethodName,
+		RetType: FullTypeName(value.TypeName),
+	}
+	if key.ArgTypes != "" {
+		for _, argType := range strings.Split(key.ArgTypes, ",") {
+			m.ArgTypes = append(m.ArgTypes, FullTypeName(UdonTypeName(argType)))
+		}
+	}
+	if strings.HasPrefix(string(m.Method), "set_") && len(m.ArgTypes) == 0 && m.RetType != UdonTypeVoid {
+		m.ArgTypes, m.RetType = []UdonTypeName{m.RetType}, UdonTypeVoid
+	}
+	return m
+}
@@ -0,0 +1,390 @@
+package asm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// SlotReport counts the heap variables of each type before and after ReuseSlots
+type SlotReport struct {
+	Before map[UdonTypeName]int
+	After  map[UdonTypeName]int
+}
+
+// Total returns the number of heap variables before and after
+func (r *SlotReport) Total() (int, int) {
+	before, after := 0, 0
+	for _, n := range r.Before {
+		before += n
+	}
+	for _, n := range r.After {
+		after += n
+	}
+	return before, after
+}
+
+// Write prints the report as a table, one line per type whose count changed
+func (r *SlotReport) Write(w io.Writer) {
+	typeNames := []string{}
+	for typeName := range r.Before {
+		if r.Before[typeName] != r.After[typeName] {
+			typeNames = append(typeNames, string(typeName))
+		}
+	}
+	sort.Strings(typeNames)
+	for _, typeName := range typeNames {
+		fmt.Fprintf(w, "%-40s %5d -> %5d\n", typeName, r.Before[UdonTypeName(typeName)], r.After[UdonTypeName(typeName)])
+	}
+	before, after := r.Total()
+	fmt.Fprintf(w, "%-40s %5d -> %5d\n", "heap slots", before, after)
+}
+
+// countSlots counts the heap variables by type
+func (ua *UdonAssembly) countSlots() map[UdonTypeName]int {
+	count := map[UdonTypeName]int{}
+	for _, item := range ua.VarTable.VarDict {
+		count[item.TypeName]++
+	}
+	return count
+}
+
+// ReuseSlots merges the temporaries of the same type whose lifetimes do not overlap into one heap variable.
+// Lifetimes come from a liveness analysis over the whole code, where a pushed address is read or written
+// by the instruction popping it. Temporaries whose address stays on the stack across a jump,
+// like the arguments and results of functions, or whose value is seen by another event, keep their own variable.
+func (ua *UdonAssembly) ReuseSlots() (*SlotReport, error) {
+	report := &SlotReport{Before: ua.countSlots()}
+	code, err := ua.Code()
+	if err != nil {
+		return nil, fmt.Errorf("reuse slots: %w", err)
+	}
+	o := &optimizer{ua: ua, code: code}
+	s := o.accessesByInstruction()
+	s.liveness(o)
+	renames := s.allocate(o)
+	if len(renames) > 0 {
+		for _, inst := range code.Insts {
+			if varName, ok := inst.Var(); ok {
+				if to, ok := renames[varName]; ok {
+					inst.Arg = string(to)
+				}
+			}
+		}
+		ua.SetCode(code)
+		vars := []*VarItem{}
+		for _, item := range ua.VarTable.VarDict {
+			if _, ok := renames[item.VarName]; !ok {
+				vars = append(vars, item)
+			}
+		}
+		ua.VarTable.VarDict = vars
+	}
+	report.After = ua.countSlots()
+	return report, nil
+}
+
+// slots holds the liveness of the temporaries which may share a heap variable, numbered by candidate
+type slots struct {
+	candidates []VarName
+	index      map[VarName]int
+	// reads and writes are the candidates each instruction reads and writes
+	reads  [][]int
+	writes [][]int
+	// excluded candidates keep their own variable
+	excluded map[VarName]bool
+	liveOut  []bitset
+	liveIn   []bitset
+}
+
+// accessesByInstruction follows the addresses pushed in each basic block to the instruction popping them
+func (o *optimizer) accessesByInstruction() *slots {
+	kinds := o.externKinds()
+	reads := make([][]VarName, len(o.code.Insts))
+	writes := make([][]VarName, len(o.code.Insts))
+	escaping := map[VarName]bool{}
+	// stack holds the variables pushed in the current block, "" for other operands
+	stack := []VarName{}
+	leave := func() {
+		for _, varName := range stack {
+			escaping[varName] = true
+		}
+		stack = stack[:0]
+	}
+	pop := func() VarName {
+		if len(stack) == 0 {
+			// pushed in another block, the variable has been marked as escaping there
+			return ""
+		}
+		varName := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return varName
+	}
+	for i, inst := range o.code.Insts {
+		if o.code.labelled(i) {
+			leave()
+		}
+		switch inst.Op {
+		case PUSH:
+			varName, _ := inst.Var()
+			stack = append(stack, varName)
+		case POP:
+			pop()
+		case COPY:
+			dst, src := pop(), pop()
+			writes[i] = append(writes[i], dst)
+			reads[i] = append(reads[i], src)
+		case JUMP_IF_FALSE:
+			reads[i] = append(reads[i], pop())
+			leave()
+		case JUMP:
+			leave()
+		case JUMP_INDIRECT:
+			varName, _ := inst.Var()
+			reads[i] = append(reads[i], varName)
+			leave()
+		case EXTERN:
+			externStr, _ := inst.Extern()
+			sig, err := ParseExternStr(externStr)
+			kind, known := kinds[externStr]
+			if err != nil || !known {
+				leave()
+				continue
+			}
+			if sig.HasRet() {
+				writes[i] = append(writes[i], pop())
+			}
+			for range sig.ArgTypes {
+				reads[i] = append(reads[i], pop())
+			}
+			if kind == INSTANCE_FUNC {
+				reads[i] = append(reads[i], pop())
+			}
+		}
+	}
+	leave()
+
+	s := &slots{index: map[VarName]int{}, excluded: map[VarName]bool{}}
+	for _, item := range o.ua.VarTable.VarDict {
+		if o.isTemp(item.VarName) && !escaping[item.VarName] {
+			s.index[item.VarName] = len(s.candidates)
+			s.candidates = append(s.candidates, item.VarName)
+		}
+	}
+	number := func(varNames []VarName) []int {
+		numbers := []int{}
+		for _, varName := range varNames {
+			if n, ok := s.index[varName]; ok {
+				numbers = append(numbers, n)
+			}
+		}
+		return numbers
+	}
+	s.reads = make([][]int, len(o.code.Insts))
+	s.writes = make([][]int, len(o.code.Insts))
+	for i := range o.code.Insts {
+		s.reads[i] = number(reads[i])
+		s.writes[i] = number(writes[i])
+	}
+	return s
+}
+
+// externKinds tells instance methods from static ones, to know whether an extern pops an instance
+func (o *optimizer) externKinds() map[ExternStr]UdonMethodKind {
+	kinds := map[ExternStr]UdonMethodKind{}
+	for key, value := range o.ua.MethodTable {
+		kinds[ExternStr(value.ExternStr)] = key.MethodKind
+	}
+	return kinds
+}
+
+// successors returns the instructions control may go to after instruction i
+func (o *optimizer) successors(index map[LabelName]int, returns []int, i int) []int {
+	inst := o.code.Insts[i]
+	target := func() []int {
+		if label, ok := inst.Label(); ok {
+			if j, ok := index[label]; ok && j < len(o.code.Insts) {
+				return []int{j}
+			}
+		}
+		return nil
+	}
+	next := []int{}
+	if i+1 < len(o.code.Insts) {
+		next = append(next, i+1)
+	}
+	switch inst.Op {
+	case JUMP:
+		return target()
+	case JUMP_IF_FALSE:
+		return append(next, target()...)
+	case JUMP_INDIRECT:
+		return returns
+	}
+	return next
+}
+
+// liveness computes the candidates live before and after every instruction
+func (s *slots) liveness(o *optimizer) {
+	index := o.code.labelIndex()
+	returns := []int{}
+	for label := range o.storedLabels() {
+		if i, ok := index[label]; ok && i < len(o.code.Insts) {
+			returns = append(returns, i)
+		}
+	}
+	sort.Ints(returns)
+	n := len(o.code.Insts)
+	succs := make([][]int, n)
+	for i := range o.code.Insts {
+		succs[i] = o.successors(index, returns, i)
+	}
+	s.liveIn = make([]bitset, n)
+	s.liveOut = make([]bitset, n)
+	for i := range o.code.Insts {
+		s.liveIn[i] = newBitset(len(s.candidates))
+		s.liveOut[i] = newBitset(len(s.candidates))
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := n - 1; i >= 0; i-- {
+			for _, j := range succs[i] {
+				s.liveOut[i].union(s.liveIn[j])
+			}
+			in := s.liveOut[i].clone()
+			for _, w := range s.writes[i] {
+				in.remove(w)
+			}
+			for _, r := range s.reads[i] {
+				in.add(r)
+			}
+			if !in.equal(s.liveIn[i]) {
+				s.liveIn[i] = in
+				changed = true
+			}
+		}
+	}
+	for i, inst := range o.code.Insts {
+		// a temporary read before it is written holds its initial value, or one left by an earlier event
+		if len(inst.Heads) > 0 {
+			s.exclude(s.liveIn[i])
+		}
+		// events sent right away run in the middle of this one and could overwrite a shared variable
+		if externStr, ok := inst.Extern(); ok && runsEvents(externStr) {
+			s.exclude(s.liveOut[i])
+		}
+	}
+}
+
+// runsEvents reports whether an extern runs events of udon behaviours before returning
+func runsEvents(externStr ExternStr) bool {
+	return strings.Contains(string(externStr), ".__SendCustomEvent__")
+}
+
+func (s *slots) exclude(live bitset) {
+	for n, varName := range s.candidates {
+		if live.has(n) {
+			s.excluded[varName] = true
+		}
+	}
+}
+
+// allocate assigns the candidates to shared variables, in order of declaration,
+// each going to the first variable of its type none of whose temporaries interferes with it.
+// It returns the candidates renamed to the variable they share.
+func (s *slots) allocate(o *optimizer) map[VarName]VarName {
+	interferes := make([]bitset, len(s.candidates))
+	for n := range s.candidates {
+		interferes[n] = newBitset(len(s.candidates))
+	}
+	for i := range o.code.Insts {
+		// a temporary written while another is live can not share its variable
+		for _, w := range s.writes[i] {
+			interferes[w].union(s.liveOut[i])
+			for n := range s.candidates {
+				if s.liveOut[i].has(n) {
+					interferes[n].add(w)
+				}
+			}
+		}
+	}
+	type slot struct {
+		varName  VarName
+		typeName UdonTypeName
+		members  bitset
+	}
+	shared := []*slot{}
+	renames := map[VarName]VarName{}
+	for n, varName := range s.candidates {
+		if s.excluded[varName] {
+			continue
+		}
+		typeName, err := o.ua.VarTable.GetVarType(varName)
+		if err != nil {
+			continue
+		}
+		var found *slot
+		for _, sl := range shared {
+			if sl.typeName == typeName && !sl.members.intersects(interferes[n]) {
+				found = sl
+				break
+			}
+		}
+		if found == nil {
+			found = &slot{varName: varName, typeName: typeName, members: newBitset(len(s.candidates))}
+			shared = append(shared, found)
+		} else {
+			renames[varName] = found.varName
+		}
+		found.members.add(n)
+	}
+	return renames
+}
+
+// bitset is a set of small integers
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) add(n int) {
+	b[n/64] |= 1 << uint(n%64)
+}
+
+func (b bitset) remove(n int) {
+	b[n/64] &^= 1 << uint(n%64)
+}
+
+func (b bitset) has(n int) bool {
+	return b[n/64]&(1<<uint(n%64)) != 0
+}
+
+func (b bitset) union(other bitset) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+func (b bitset) intersects(other bitset) bool {
+	for i := range b {
+		if b[i]&other[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (b bitset) equal(other bitset) bool {
+	for i := range b {
+		if b[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bitset) clone() bitset {
+	return append(bitset{}, b...)
+}
@@ -0,0 +1,148 @@
+package asm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceMapExt is the extension of the source maps written next to the programs
+const SourceMapExt = ".udonmap.json"
+
+// SourceMap maps a program back to the go source it was compiled from
+type SourceMap struct {
+	// Code are the positions of the instructions in order of address, each covering the instructions up to the next
+	Code []SourcePos `json:"code"`
+	// Vars are the go identifiers of the heap variables declared in the source
+	Vars map[VarName]string `json:"vars"`
+	// Funcs are the events and the functions called by them, in order of address
+	Funcs []SourceFunc `json:"funcs"`
+}
+
+// SourceFunc is an event or a function starting at Addr.
+// Label is the label of its code, which the heap variables it declares are named after.
+type SourceFunc struct {
+	Addr  Addr      `json:"addr"`
+	Name  string    `json:"name"`
+	Label LabelName `json:"label"`
+}
+
+// SourcePos is the position of the go statement the instructions from Addr on are compiled from
+type SourcePos struct {
+	Addr Addr   `json:"addr"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+}
+
+// String returns the position as written by go, file:line:col
+func (pos SourcePos) String() string {
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Col)
+}
+
+// Lookup returns the position of the statement the instruction at addr is compiled from
+func (sm *SourceMap) Lookup(addr Addr) (SourcePos, bool) {
+	i := sort.Search(len(sm.Code), func(i int) bool { return sm.Code[i].Addr > addr })
+	if i == 0 {
+		return SourcePos{}, false
+	}
+	return sm.Code[i-1], true
+}
+
+// Func returns the event or function starting at addr
+func (sm *SourceMap) Func(addr Addr) (SourceFunc, bool) {
+	for _, fn := range sm.Funcs {
+		if fn.Addr == addr {
+			return fn, true
+		}
+	}
+	return SourceFunc{}, false
+}
+
+// sourceComment matches the comments written by SourceComment
+var sourceComment = regexp.MustCompile(`^(\S+):(\d+):(\d+): `)
+
+// SourceComment writes the position and the source of a go statement before the instructions compiled from it,
+// as # file.go:12:5: a := func1(100, 1000). Nothing is written unless Comments is set.
+// The comments move with the instructions through the optimizations, SourceMap reads them back.
+func (ua *UdonAssembly) SourceComment(file string, line int, col int, source string) {
+	if ua.Comments {
+		ua.AddComment(fmt.Sprintf("%s:%d:%d: %s", file, line, col, source))
+	}
+}
+
+// SourceMap returns the positions written by SourceComment for the code emitted so far.
+// An instruction has the position of the last source comment before it.
+func (ua *UdonAssembly) SourceMap() (*SourceMap, error) {
+	code, err := ua.Code()
+	if err != nil {
+		return nil, fmt.Errorf("source map: %w", err)
+	}
+	sm := &SourceMap{Code: []SourcePos{}, Vars: map[VarName]string{}, Funcs: []SourceFunc{}}
+	for _, inst := range code.Insts {
+		for _, comment := range inst.Comments {
+			m := sourceComment.FindStringSubmatch(comment)
+			if m == nil {
+				continue
+			}
+			line, _ := strconv.Atoi(m[2])
+			col, _ := strconv.Atoi(m[3])
+			pos := SourcePos{Addr: inst.Addr, File: m[1], Line: line, Col: col}
+			if n := len(sm.Code); n > 0 && sm.Code[n-1].Addr == inst.Addr {
+				sm.Code[n-1] = pos
+			} else {
+				sm.Code = append(sm.Code, pos)
+			}
+		}
+	}
+	for _, event := range ua.EventNames {
+		if addr, ok := ua.LabelDict[LabelName(event)]; ok {
+			sm.Funcs = append(sm.Funcs, SourceFunc{Addr: addr, Name: string(event), Label: LabelName(event)})
+		}
+	}
+	for key := range ua.FuncTable {
+		argTypes := []UdonTypeName{}
+		if key.ArgTypes != "" {
+			for _, argType := range strings.Split(key.ArgTypes, ",") {
+				argTypes = append(argTypes, UdonTypeName(argType))
+			}
+		}
+		label := ua.FuncTable.GetFunctionID(key.FuncName, argTypes)
+		// the bodies of the functions which are not called are left out
+		if addr, ok := ua.LabelDict[label]; ok && code.jumpsTo(label) {
+			sm.Funcs = append(sm.Funcs, SourceFunc{Addr: addr, Name: string(key.FuncName), Label: label})
+		}
+	}
+	sort.Slice(sm.Funcs, func(i, j int) bool {
+		if sm.Funcs[i].Addr != sm.Funcs[j].Addr {
+			return sm.Funcs[i].Addr < sm.Funcs[j].Addr
+		}
+		return sm.Funcs[i].Name < sm.Funcs[j].Name
+	})
+	return sm, nil
+}
+
+// jumpsTo reports whether an instruction jumps to label
+func (code *Code) jumpsTo(label LabelName) bool {
+	for _, inst := range code.Insts {
+		if target, ok := inst.Label(); ok && target == label {
+			return true
+		}
+	}
+	return false
+}
+
+// StripComments removes the comments from the code emitted so far
+func (ua *UdonAssembly) StripComments() error {
+	code, err := ua.Code()
+	if err != nil {
+		return fmt.Errorf("strip comments: %w", err)
+	}
+	for _, inst := range code.Insts {
+		inst.Comments = nil
+	}
+	ua.SetCode(code)
+	return nil
+}
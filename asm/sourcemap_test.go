@@ -0,0 +1,66 @@
+package asm_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"udon-go/asm"
+)
+
+func TestUdonAssembly_SourceMap(t *testing.T) {
+	ua, err := asm.NewUdonAssembly(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ua.Comments = true
+	ua.VarTable.AddVar("x", asm.UdonTypeInt32, "null")
+	ua.VarTable.AddVar("y", asm.UdonTypeInt32, "null")
+	ua.EventHead("_start")
+	ua.SourceComment("main.go", 3, 1, "func main()")
+	ua.SourceComment("main.go", 4, 2, "y = x")
+	ua.AddInstComment("y = x")
+	ua.Assign("y", "x")
+	ua.SourceComment("main.go", 5, 2, "goto end")
+	ua.JumpLabel("end")
+	ua.SourceComment("main.go", 6, 2, "x = y")
+	ua.Assign("x", "y")
+	ua.AddLabelCurrentAddr("end")
+	ua.SourceComment("main.go", 8, 2, "y = x")
+	ua.Assign("y", "x")
+	ua.End()
+	if !strings.Contains(ua.ASM, "# main.go:4:2: y = x\n") {
+		t.Errorf("no source comment in\n%s", ua.ASM)
+	}
+	// the jump to the next instruction and the code it skips are removed, their positions go
+	err = ua.Optimize(asm.O1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := ua.SourceMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []asm.SourcePos{
+		{Addr: 0, File: "main.go", Line: 4, Col: 2},
+		{Addr: 0x14, File: "main.go", Line: 8, Col: 2},
+	}
+	if !reflect.DeepEqual(sm.Code, want) {
+		t.Errorf("got %v, want %v", sm.Code, want)
+	}
+	for _, tt := range []struct {
+		addr asm.Addr
+		want int
+	}{{0, 4}, {0x10, 4}, {0x14, 8}, {0x100, 8}} {
+		if pos, ok := sm.Lookup(tt.addr); !ok || pos.Line != tt.want {
+			t.Errorf("%#x is at %v, want line %d", tt.addr, pos, tt.want)
+		}
+	}
+
+	err = ua.StripComments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(ua.ASM, "#") {
+		t.Errorf("comments left in\n%s", ua.ASM)
+	}
+}
@@ -13,10 +13,27 @@ type VarItem struct {
 	InitialValue string
 }
 
+// SyncMode is how a synced variable is interpolated on the other clients
+type SyncMode string
+
+// Sync modes of the .sync directive
+const (
+	SyncNone   SyncMode = "none"
+	SyncLinear SyncMode = "linear"
+	SyncSmooth SyncMode = "smooth"
+)
+
+// SyncVar is a variable synced over the network
+type SyncVar struct {
+	VarName VarName
+	Mode    SyncMode
+}
+
 // VarTable holds local, global variables and current function for contextual purposes
 type VarTable struct {
 	VarDict        []*VarItem
 	GlobalVarNames []VarName
+	SyncVars       []SyncVar
 	CurrentFuncID  *LabelName
 }
 
@@ -46,21 +63,6 @@ func NewVarTable() *VarTable {
 	return t
 }
 
-// ResolveVarname returns the VarName for the current function, avoiding naming collisions across functions
-func (vt *VarTable) ResolveVarname(varName VarName) (VarName, error) {
-	tmpVarname := VarName(fmt.Sprintf("%s_%s", *vt.CurrentFuncID, varName))
-	varItem, ok := vt.Find(varName)
-
-	// ignore missing entry, create new var
-	if vt.CurrentFuncID == nil && !ok {
-		return "", errors.New("nil current func ID and var does not exist")
-	}
-	if vt.CurrentFuncID != nil && !ok {
-		return tmpVarname, nil
-	}
-	return varItem.VarName, nil
-}
-
 // SetCurrentFuncID sets the current func ID for contextual execution
 func (vt *VarTable) SetCurrentFuncID(label *LabelName) {
 	if label == nil {
@@ -81,13 +83,29 @@ func (vt *VarTable) AddVarGlobal(varName VarName) error {
 	return nil
 }
 
+// AddVarSync syncs varName over the network
+func (vt *VarTable) AddVarSync(varName VarName, mode SyncMode) error {
+	switch mode {
+	case SyncNone, SyncLinear, SyncSmooth:
+	default:
+		return fmt.Errorf("unknown sync mode %q", mode)
+	}
+	for _, saved := range vt.SyncVars {
+		if varName == saved.VarName {
+			return errors.New("synced variable already registered")
+		}
+	}
+	vt.SyncVars = append(vt.SyncVars, SyncVar{varName, mode})
+	return nil
+}
+
 // AddVar adds varName to the variable table
 func (vt *VarTable) AddVar(varName VarName, typeName UdonTypeName, initValueStr string) error {
 	_, ok := vt.Find(varName)
 	if ok {
 		return errors.New("variable already registered")
 	}
-	if typeName == UdonTypeName("Void") {
+	if typeName == UdonTypeName("Void") || typeName == UdonTypeVoid || typeName == GoNil {
 		return errors.New("variable is void type")
 	}
 	vt.VarDict = append(vt.VarDict, &VarItem{varName, typeName, initValueStr})
@@ -136,6 +154,12 @@ func (vt *VarTable) MakeDataSeg() (string, error) {
 		}
 		dataStr += fmt.Sprintf("    .export %s\n", varName)
 	}
+	for _, syncVar := range vt.SyncVars {
+		if _, ok := vt.Find(syncVar.VarName); !ok {
+			return "", errors.New("synced var does not exist: " + string(syncVar.VarName))
+		}
+		dataStr += fmt.Sprintf("    .sync %s, %s\n", syncVar.VarName, syncVar.Mode)
+	}
 
 	for _, v := range vt.VarDict {
 		if v.TypeName == "VRCUdonCommonInterfacesIUdonEventReceiver" {
@@ -299,9 +323,14 @@ func (umm MethodMap) Get(
 	return v, ok
 }
 
-// NewUdonMethodTable returns a methodmap that is prefilled with external methods
+// NewUdonMethodTable returns a methodmap that is prefilled with external methods and the records of AddSDKRecords,
+// use ParseExterns to get the lines which are not records
 func NewUdonMethodTable(rdr io.Reader) (MethodMap, error) {
-	methodMap, err := ParseExterns(rdr)
+	methodMap, _, err := ParseExterns(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("load method map: %w", err)
+	}
+	err = AddSDKRecords(methodMap)
 	if err != nil {
 		return nil, fmt.Errorf("load method map: %w", err)
 	}
@@ -334,3 +363,54 @@ func (umm MethodMap) GetRetTypeExternStr(
 	}
 	return method, nil
 }
+
+var udonShortTypeNames map[UdonTypeName]UdonTypeName
+
+// ShortTypeName returns the name used by the method table for the full udon type name,
+// e.g. SystemInt32 -> Int32
+func ShortTypeName(typeName UdonTypeName) UdonTypeName {
+	if udonShortTypeNames == nil {
+		udonShortTypeNames = map[UdonTypeName]UdonTypeName{}
+		for short, full := range UdonTypes {
+			udonShortTypeNames[full] = UdonTypeName(short)
+		}
+	}
+	if short, ok := udonShortTypeNames[typeName]; ok {
+		return short
+	}
+	return typeName
+}
+
+// FullTypeName returns the full udon type name of a name used by the method table,
+// e.g. Int32 -> SystemInt32
+func FullTypeName(typeName UdonTypeName) UdonTypeName {
+	if typeName == "None" {
+		return UdonTypeVoid
+	}
+	if full, ok := UdonTypes[VarName(typeName)]; ok {
+		return full
+	}
+	return typeName
+}
+
+// FindExtern returns the full return type and extern string of a method, given the full udon type names
+// of the module and arguments
+func (umm MethodMap) FindExtern(
+	methodKind UdonMethodKind,
+	udonModuleType UdonTypeName,
+	methodName UdonMethodName,
+	argTypes []UdonTypeName) (UdonTypeName, ExternStr, error) {
+	shortArgTypes := []UdonTypeName{}
+	for _, argType := range argTypes {
+		shortArgTypes = append(shortArgTypes, ShortTypeName(argType))
+	}
+	method, ok := umm.Get(methodKind, ShortTypeName(udonModuleType), methodName, shortArgTypes)
+	if !ok {
+		argsStr := []string{}
+		for _, argType := range argTypes {
+			argsStr = append(argsStr, string(argType))
+		}
+		return "", "", fmt.Errorf("method does not exist: %s.%s(%s)", udonModuleType, methodName, strings.Join(argsStr, ", "))
+	}
+	return FullTypeName(method.TypeName), ExternStr(method.ExternStr), nil
+}
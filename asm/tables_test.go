@@ -3,6 +3,7 @@ package asm_test
 import (
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"udon-go/asm"
 )
@@ -35,7 +36,7 @@ func TestVarTable(t *testing.T) {
 
 func TestVarTable_AddVar(t *testing.T) {
 	type fields struct {
-		VarDict        map[asm.VarName]*asm.UdonTypeItem
+		VarDict        []*asm.VarItem
 		GlobalVarNames []asm.VarName
 		CurrentFuncID  *asm.LabelName
 	}
@@ -50,10 +51,10 @@ func TestVarTable_AddVar(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{"pristine", fields{map[asm.VarName]*asm.UdonTypeItem{}, []asm.VarName{}, nil}, args{"foo", asm.GoString, ""}, false},
-		{"existing local var", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoString, ""}}, []asm.VarName{}, nil}, args{"foo", asm.GoString, ""}, true},
-		{"existing global var in var table", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoString, ""}}, []asm.VarName{"foo"}, nil}, args{"foo", asm.GoString, ""}, true},
-		{"existing global var not in var table", fields{map[asm.VarName]*asm.UdonTypeItem{}, []asm.VarName{"foo"}, nil}, args{"foo", asm.GoString, ""}, false},
+		{"pristine", fields{[]*asm.VarItem{}, []asm.VarName{}, nil}, args{"foo", asm.GoString, ""}, false},
+		{"existing local var", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoString}}, []asm.VarName{}, nil}, args{"foo", asm.GoString, ""}, true},
+		{"existing global var in var table", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoString}}, []asm.VarName{"foo"}, nil}, args{"foo", asm.GoString, ""}, true},
+		{"existing global var not in var table", fields{[]*asm.VarItem{}, []asm.VarName{"foo"}, nil}, args{"foo", asm.GoString, ""}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -71,7 +72,7 @@ func TestVarTable_AddVar(t *testing.T) {
 
 func TestVarTable_GetVarType(t *testing.T) {
 	type fields struct {
-		VarDict        map[asm.VarName]*asm.UdonTypeItem
+		VarDict        []*asm.VarItem
 		GlobalVarNames []asm.VarName
 		CurrentFuncID  *asm.LabelName
 	}
@@ -85,9 +86,9 @@ func TestVarTable_GetVarType(t *testing.T) {
 		want    asm.UdonTypeName
 		wantErr bool
 	}{
-		{"pristine", fields{map[asm.VarName]*asm.UdonTypeItem{}, []asm.VarName{}, nil}, args{"foo"}, "", true},
-		{"existing string", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoString, ""}}, []asm.VarName{}, nil}, args{"foo"}, asm.GoString, false},
-		{"existing int", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoInt, ""}}, []asm.VarName{}, nil}, args{"foo"}, asm.GoInt, false},
+		{"pristine", fields{[]*asm.VarItem{}, []asm.VarName{}, nil}, args{"foo"}, "", true},
+		{"existing string", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoString}}, []asm.VarName{}, nil}, args{"foo"}, asm.GoString, false},
+		{"existing int", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoInt}}, []asm.VarName{}, nil}, args{"foo"}, asm.GoInt, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -110,7 +111,7 @@ func TestVarTable_GetVarType(t *testing.T) {
 
 func TestVarTable_AddVarGlobal(t *testing.T) {
 	type fields struct {
-		VarDict        map[asm.VarName]*asm.UdonTypeItem
+		VarDict        []*asm.VarItem
 		GlobalVarNames []asm.VarName
 		CurrentFuncID  *asm.LabelName
 	}
@@ -123,9 +124,9 @@ func TestVarTable_AddVarGlobal(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		{"pristine", fields{map[asm.VarName]*asm.UdonTypeItem{}, []asm.VarName{}, nil}, args{"foo"}, false},
-		{"existing global", fields{map[asm.VarName]*asm.UdonTypeItem{}, []asm.VarName{"foo"}, nil}, args{"foo"}, true},
-		{"existing local", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoString, ""}}, []asm.VarName{}, nil}, args{"foo"}, false},
+		{"pristine", fields{[]*asm.VarItem{}, []asm.VarName{}, nil}, args{"foo"}, false},
+		{"existing global", fields{[]*asm.VarItem{}, []asm.VarName{"foo"}, nil}, args{"foo"}, true},
+		{"existing local", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoString}}, []asm.VarName{}, nil}, args{"foo"}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -141,9 +142,48 @@ func TestVarTable_AddVarGlobal(t *testing.T) {
 	}
 }
 
+func TestVarTable_AddVarSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		synced  []asm.VarName
+		varName asm.VarName
+		mode    asm.SyncMode
+		want    string
+		wantErr bool
+	}{
+		{"none", nil, "foo", asm.SyncNone, "    .sync foo, none\n", false},
+		{"linear", nil, "foo", asm.SyncLinear, "    .sync foo, linear\n", false},
+		{"unknown mode", nil, "foo", "fast", "", true},
+		{"existing", []asm.VarName{"foo"}, "foo", asm.SyncSmooth, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vt := asm.NewVarTable()
+			vt.AddVar("foo", asm.GoInt, "null")
+			for _, varName := range tt.synced {
+				vt.AddVarSync(varName, asm.SyncNone)
+			}
+			err := vt.AddVarSync(tt.varName, tt.mode)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("VarTable.AddVarSync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			data, err := vt.MakeDataSeg()
+			if err != nil {
+				t.Fatalf("VarTable.MakeDataSeg() error = %v", err)
+			}
+			if !strings.Contains(data, tt.want) {
+				t.Errorf("VarTable.MakeDataSeg() = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
 func TestVarTable_ValidVarType(t *testing.T) {
 	type fields struct {
-		VarDict        map[asm.VarName]*asm.UdonTypeItem
+		VarDict        []*asm.VarItem
 		GlobalVarNames []asm.VarName
 		CurrentFuncID  *asm.LabelName
 	}
@@ -158,9 +198,9 @@ func TestVarTable_ValidVarType(t *testing.T) {
 		want    bool
 		wantErr bool
 	}{
-		{"pristine", fields{map[asm.VarName]*asm.UdonTypeItem{}, []asm.VarName{}, nil}, args{"foo", asm.GoString}, false, true},
-		{"existing wrong type", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoInt, ""}}, []asm.VarName{"foo"}, nil}, args{"foo", asm.GoString}, false, false},
-		{"existing matching type", fields{map[asm.VarName]*asm.UdonTypeItem{"foo": {asm.GoString, ""}}, []asm.VarName{}, nil}, args{"foo", asm.GoString}, true, false},
+		{"pristine", fields{[]*asm.VarItem{}, []asm.VarName{}, nil}, args{"foo", asm.GoString}, false, true},
+		{"existing wrong type", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoInt}}, []asm.VarName{"foo"}, nil}, args{"foo", asm.GoString}, false, false},
+		{"existing matching type", fields{[]*asm.VarItem{{VarName: "foo", TypeName: asm.GoString}}, []asm.VarName{}, nil}, args{"foo", asm.GoString}, true, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -180,3 +220,41 @@ func TestVarTable_ValidVarType(t *testing.T) {
 		})
 	}
 }
+
+func TestMethodMap_FindExtern(t *testing.T) {
+	f, err := os.Open("./udon_funcs_data.txt")
+	if err != nil {
+		t.Fatalf("open file: %s", err)
+	}
+	defer f.Close()
+	udonMethodTable, err := asm.NewUdonMethodTable(f)
+	if err != nil {
+		t.Fatalf("load udon method table: %v", err)
+	}
+	tests := []struct {
+		name       string
+		methodKind asm.UdonMethodKind
+		moduleType asm.UdonTypeName
+		methodName asm.UdonMethodName
+		argTypes   []asm.UdonTypeName
+		wantType   asm.UdonTypeName
+		wantExtern asm.ExternStr
+		wantErr    bool
+	}{
+		{"constructor", asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", []asm.UdonTypeName{asm.UdonTypeInt32}, asm.UdonTypeObjectArray, "SystemObjectArray.__ctor__SystemInt32__SystemObjectArray", false},
+		{"two args void", asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Set", []asm.UdonTypeName{asm.UdonTypeInt32, asm.UdonTypeObject}, asm.UdonTypeVoid, "SystemObjectArray.__Set__SystemInt32_SystemObject__SystemVoid", false},
+		{"operator", asm.STATIC_FUNC, asm.UdonTypeInt32, "op_Addition", []asm.UdonTypeName{asm.UdonTypeInt32, asm.UdonTypeInt32}, asm.UdonTypeInt32, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32", false},
+		{"missing", asm.STATIC_FUNC, asm.UdonTypeInt32, "op_Addition", []asm.UdonTypeName{asm.UdonTypeString}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, gotExtern, err := udonMethodTable.FindExtern(tt.methodKind, tt.moduleType, tt.methodName, tt.argTypes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MethodMap.FindExtern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotType != tt.wantType || gotExtern != tt.wantExtern {
+				t.Errorf("MethodMap.FindExtern() = %v, %v, want %v, %v", gotType, gotExtern, tt.wantType, tt.wantExtern)
+			}
+		})
+	}
+}
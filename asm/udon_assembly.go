@@ -19,6 +19,10 @@ type UdonAssembly struct {
 	FuncTable      FuncMap
 	MethodTable    MethodMap
 	EnvVars        []VarName
+	// Comments writes the comments of AddInstComment and SourceComment into the code
+	Comments bool
+	// externs indexes MethodTable by extern signature, see CallExtern
+	externs map[ExternStr]*ExternMethod
 }
 
 func NewUdonAssembly(rdr io.Reader) (*UdonAssembly, error) {
@@ -44,9 +48,17 @@ func NewUdonAssembly(rdr io.Reader) (*UdonAssembly, error) {
 func Log(msg ...string) {
 
 }
+
+// AddInstComment describes the instructions emitted next, when Comments is set
 func (ua *UdonAssembly) AddInstComment(comment string) {
-	// ua.ASM += fmt.Sprintf("        #{%s}\n", comment)
-	return
+	if ua.Comments {
+		ua.AddComment(comment)
+	}
+}
+
+// AddComment writes a comment line to the code segment
+func (ua *UdonAssembly) AddComment(comment string) {
+	ua.ASM += fmt.Sprintf("        # %s\n", comment)
 }
 func (ua *UdonAssembly) AddInst(bcodeSize Addr, inst string) {
 	ua.ASM += fmt.Sprintf("        %s\n", inst)
@@ -163,7 +175,14 @@ func (ua *UdonAssembly) End() {
 	ua.AddInst(Addr(8), "JUMP, 0xFFFFFFFF")
 	return
 }
-func (ua *UdonAssembly) CallExtern(extern_str ExternStr, argVars []VarName) {
+
+// CallExtern pushes argVars and calls the extern, argVars being the instance, the arguments and the return slot.
+// The extern must be in the method table and the types of argVars must be those of its operands.
+func (ua *UdonAssembly) CallExtern(extern_str ExternStr, argVars []VarName) error {
+	err := ua.checkExtern(extern_str, argVars)
+	if err != nil {
+		return err
+	}
 	stringVarNames := []string{}
 	for _, varName := range argVars {
 		stringVarNames = append(stringVarNames, string(varName))
@@ -173,7 +192,32 @@ func (ua *UdonAssembly) CallExtern(extern_str ExternStr, argVars []VarName) {
 		ua.PushVar(arg)
 	}
 	ua.Extern(extern_str)
-	return
+	return nil
+}
+
+// checkExtern compares the variables pushed for an extern with its operands in the method table
+func (ua *UdonAssembly) checkExtern(externStr ExternStr, argVars []VarName) error {
+	if ua.externs == nil {
+		ua.externs = ua.MethodTable.Externs()
+	}
+	method, ok := ua.externs[externStr]
+	if !ok {
+		return fmt.Errorf("extern %s is not in the method table", externStr)
+	}
+	operands := method.Operands()
+	if len(argVars) != len(operands) {
+		return fmt.Errorf("extern %s takes %d operands, %d pushed", externStr, len(operands), len(argVars))
+	}
+	for i, varName := range argVars {
+		typeName, err := ua.VarTable.GetVarType(varName)
+		if err != nil {
+			return fmt.Errorf("extern %s: %w", externStr, err)
+		}
+		if typeName != operands[i] {
+			return fmt.Errorf("extern %s: operand %d is %s of type %s, want %s", externStr, i+1, varName, typeName, operands[i])
+		}
+	}
+	return nil
 }
 func (ua *UdonAssembly) Assign(distVarName VarName, srcVarName VarName) error {
 	// If the variable name on the right side is UdonTypeName,
@@ -221,31 +265,24 @@ func (ua *UdonAssembly) GetAddr(label LabelName) Addr {
 func (ua *UdonAssembly) AddLabel(label LabelName, addr Addr) {
 	ua.LabelDict[label] = addr
 }
-func (ua *UdonAssembly) ReplaceTmpAdrr(code string) string {
-	result := []string{}
-	lines := strings.Split(code, "\n")
-	for _, line := range lines {
-		if !strings.Contains(line, "###") {
-			result = append(result, line)
-			continue
-		}
-		r := regexp.MustCompile(".*###(.*)###.*")
-		matches := r.FindStringSubmatch(line)
-		if len(matches) < 2 {
-			result = append(result, line)
-			continue
-		}
-		// labelName := matches[1]
-		// fmt.Println(line)
-		line = r.ReplaceAllString(line, "hi")
-		// line = strings.Replace(line, "###", "", -1)
-		// addr := ua.GetAddr(LabelName(labelName))
-		// line += strconv.Itoa(int(addr))
-		result = append(result, line)
 
-		// fmt.Sprintf("0x%d", addr))
+// ReplaceTmpAdrr replaces the ###label### placeholders in code with the resolved label addresses
+func (ua *UdonAssembly) ReplaceTmpAdrr(code string) (string, error) {
+	r := regexp.MustCompile("###(.*?)###")
+	var err error
+	result := r.ReplaceAllStringFunc(code, func(match string) string {
+		labelName := LabelName(r.FindStringSubmatch(match)[1])
+		addr, ok := ua.LabelDict[labelName]
+		if !ok {
+			err = fmt.Errorf("label not defined: %s", labelName)
+			return match
+		}
+		return fmt.Sprintf("0x%08X", addr)
+	})
+	if err != nil {
+		return "", err
 	}
-	return strings.Join(result, "\n")
+	return result, nil
 }
 func (ua *UdonAssembly) CallDefFunc(func_name FuncName, arg_var_names []VarName) (*VarName, error) {
 	ua.AddInstComment(fmt.Sprintf("Call DefFunc %s%s", func_name, arg_var_names))
@@ -263,39 +300,41 @@ func (ua *UdonAssembly) CallDefFunc(func_name FuncName, arg_var_names []VarName)
 	}
 	retCallLabel := LabelName(ua.GetNextId("ret_call_label"))
 	constRetAddr := VarName(ua.GetNextId("const_ret_addr"))
+	savedRetAddr := VarName(ua.GetNextId("saved_ret_addr"))
 	retValue := VarName(ua.GetNextId("ret_value"))
 
-	// Save current return address
-	ua.PushVar(VarName("ret_addr"))
+	// Save current return address.
+	// The stack only holds heap addresses, so the value has to be copied out of ret_addr.
+	ua.VarTable.AddVar(savedRetAddr, UdonTypeUInt32, "0xFFFFFFFF")
+	ua.Assign(savedRetAddr, VarName("ret_addr"))
 	// Save environment variables
 	ua.PushVars(ua.EnvVars)
 	// Save return address in order to return
 	ua.VarTable.AddVar(
 		VarName(constRetAddr),
-		UdonTypeName("UInt32"),
+		UdonTypeUInt32,
 		fmt.Sprintf("###%s###", retCallLabel),
 	)
-	// ua.Assign(VarName('ret_addr'), VarName(constRetAddr))
 	ua.PushVar(VarName(constRetAddr))
 	//Push arguments
 	ua.PushVars(arg_var_names)
 	//goto func label
 	ua.JumpLabel(LabelName(ua.FuncTable.GetFunctionID(func_name, arg_var_types)))
 	ua.AddLabelCurrentAddr(retCallLabel)
-	if retTypeName != UdonTypeName("Void") {
+	if retTypeName != GoNil && retTypeName != UdonTypeVoid {
 		// pop ret_var_name
 		ua.VarTable.AddVar(retValue, retTypeName, "null")
 		ua.PopVar(retValue)
 		// restore environment
 		ua.PopVars(ua.EnvVars)
 		// restore current return address
-		ua.PopVar(VarName("ret_addr"))
+		ua.Assign(VarName("ret_addr"), savedRetAddr)
 		return &retValue, nil
 	}
 	// restore environment
 	ua.PopVars(ua.EnvVars)
 	// restore current return address
-	ua.PopVar(VarName("ret_addr"))
+	ua.Assign(VarName("ret_addr"), savedRetAddr)
 	return nil, nil
 }
 func (ua *UdonAssembly) AddEvent(event_name EventName, def_arg_var_names []VarName, def_arg_types []UdonTypeName) error {
@@ -323,4 +362,5 @@ func (ua *UdonAssembly) AddEvent(event_name EventName, def_arg_var_names []VarNa
 }
 func (ua *UdonAssembly) EventHead(event_name EventName) {
 	ua.ASM += fmt.Sprintf("    %s:\n", event_name)
+	ua.AddLabelCurrentAddr(LabelName(event_name))
 }
@@ -0,0 +1,16 @@
+package asm
+
+// Stubs for unity types, so that programs using them are valid go.
+// The compiler resolves asm.Name to the udon type called Name in UdonTypes, see UdonTypes for the full list.
+
+// GameObject is UnityEngineGameObject
+type GameObject interface{}
+
+// Transform is UnityEngineTransform
+type Transform interface{}
+
+// Component is UnityEngineComponent
+type Component interface{}
+
+// UnityEngineObject is UnityEngineObject
+type UnityEngineObject interface{}
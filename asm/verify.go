@@ -0,0 +1,199 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// frame is the function or event an instruction belongs to, with the stack depth its returns leave
+type frame struct {
+	name string
+	// exit is the depth at JUMP_INDIRECT, 1 if the function returns a value, -1 in events, which do not return
+	exit int
+}
+
+// callee is a function of the FuncTable as seen by its callers
+type callee struct {
+	frame *frame
+	// entry is the depth at the function label: the return address and the arguments
+	entry int
+}
+
+// VerifyStack follows every path through the code counting the heap addresses on the stack,
+// using the arity of the externs from the MethodTable and of the functions from the FuncTable.
+// A call is a JUMP to a function label, its path goes on after the jump with the arguments and
+// return address popped and the result pushed. It fails when the stack would underflow,
+// when two paths reach an instruction with different depths or when an event ends with addresses left on the stack.
+func (ua *UdonAssembly) VerifyStack() error {
+	code, err := ua.Code()
+	if err != nil {
+		return fmt.Errorf("verify stack: %w", err)
+	}
+	o := &optimizer{ua: ua, code: code}
+	kinds := o.externKinds()
+	index := code.labelIndex()
+
+	// the optimizer removes the functions nobody calls, leaving their label on the code following them
+	called := map[LabelName]bool{}
+	for _, inst := range code.Insts {
+		if label, ok := inst.Label(); ok && inst.Op == JUMP {
+			called[label] = true
+		}
+	}
+	callees := map[LabelName]*callee{}
+	for key, fn := range ua.FuncTable {
+		argTypes := []UdonTypeName{}
+		if key.ArgTypes != "" {
+			for _, argType := range strings.Split(key.ArgTypes, ",") {
+				argTypes = append(argTypes, UdonTypeName(argType))
+			}
+		}
+		label := ua.FuncTable.GetFunctionID(key.FuncName, argTypes)
+		if !called[label] {
+			continue
+		}
+		exit := 0
+		if fn.ReturnType != GoNil && fn.ReturnType != UdonTypeVoid {
+			exit = 1
+		}
+		callees[label] = &callee{&frame{string(label), exit}, len(argTypes) + 1}
+	}
+
+	n := len(code.Insts)
+	depths := make([]int, n)
+	frames := make([]*frame, n)
+	pending := []int{}
+	errs := []string{}
+	fail := func(i int, format string, args ...interface{}) {
+		inst := code.Insts[i]
+		errs = append(errs, fmt.Sprintf("%#08x %s: %s", inst.Addr, inst, fmt.Sprintf(format, args...)))
+	}
+	reach := func(from int, i int, depth int, f *frame) {
+		if i >= n {
+			fail(from, "runs past the end of the code")
+			return
+		}
+		if frames[i] == nil {
+			depths[i], frames[i] = depth, f
+			pending = append(pending, i)
+			return
+		}
+		if frames[i] != f {
+			fail(i, "reached from both %s and %s", frames[i].name, f.name)
+		} else if depths[i] != depth {
+			fail(i, "reached with %d and %d addresses on the stack", depths[i], depth)
+		}
+	}
+	for i, inst := range code.Insts {
+		for _, head := range inst.Heads {
+			if frames[i] == nil {
+				reach(i, i, 0, &frame{string(head), -1})
+			}
+		}
+		for _, label := range inst.Labels {
+			if fn, ok := callees[label]; ok {
+				reach(i, i, fn.entry, fn.frame)
+			}
+		}
+	}
+
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		inst, depth, f := code.Insts[i], depths[i], frames[i]
+		pop := func(count int) bool {
+			if depth < count {
+				fail(i, "pops %d addresses, %d on the stack", count, depth)
+				return false
+			}
+			depth -= count
+			return true
+		}
+		target := func() (int, bool) {
+			label, ok := inst.Label()
+			if !ok {
+				fail(i, "jumps to an address instead of a label")
+				return 0, false
+			}
+			j, ok := index[label]
+			if !ok {
+				fail(i, "jumps to the undefined label %s", label)
+				return 0, false
+			}
+			return j, true
+		}
+		switch inst.Op {
+		case NOP, ANNOTATION:
+			reach(i, i+1, depth, f)
+		case PUSH:
+			reach(i, i+1, depth+1, f)
+		case POP:
+			if pop(1) {
+				reach(i, i+1, depth, f)
+			}
+		case COPY:
+			if pop(2) {
+				reach(i, i+1, depth, f)
+			}
+		case EXTERN:
+			externStr, _ := inst.Extern()
+			sig, err := ParseExternStr(externStr)
+			kind, known := kinds[externStr]
+			if err != nil || !known {
+				fail(i, "unknown extern, its arity is not in the method table")
+				continue
+			}
+			count := len(sig.ArgTypes)
+			if sig.HasRet() {
+				count++
+			}
+			if kind == INSTANCE_FUNC {
+				count++
+			}
+			if pop(count) {
+				reach(i, i+1, depth, f)
+			}
+		case JUMP_IF_FALSE:
+			if !pop(1) {
+				continue
+			}
+			if j, ok := target(); ok {
+				reach(i, j, depth, f)
+			}
+			reach(i, i+1, depth, f)
+		case JUMP:
+			if inst.Arg == HaltAddr {
+				if depth != 0 {
+					fail(i, "ends %s with %d addresses on the stack", f.name, depth)
+				}
+				continue
+			}
+			j, ok := target()
+			if !ok {
+				continue
+			}
+			label, _ := inst.Label()
+			if fn, ok := callees[label]; ok {
+				if pop(fn.entry) {
+					reach(i, i+1, depth+fn.frame.exit, f)
+				}
+				continue
+			}
+			reach(i, j, depth, f)
+		case JUMP_INDIRECT:
+			if f.exit < 0 {
+				fail(i, "returns from the event %s", f.name)
+			} else if depth != f.exit {
+				fail(i, "returns from %s with %d addresses on the stack, want %d", f.name, depth, f.exit)
+			}
+		default:
+			fail(i, "unknown opcode")
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("verify stack:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
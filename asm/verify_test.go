@@ -0,0 +1,175 @@
+package asm_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"udon-go/asm"
+)
+
+func TestUdonAssembly_VerifyStack(t *testing.T) {
+	f, err := os.Open("./udon_funcs_data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	methodTable, err := asm.NewUdonMethodTable(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const negate = asm.ExternStr("SystemInt32.__op_UnaryMinus__SystemInt32__SystemInt32")
+	const toString = asm.ExternStr("SystemInt32.__ToString__SystemString")
+	tests := []struct {
+		name string
+		emit func(ua *asm.UdonAssembly)
+		// want is part of the error, "" if the code is balanced
+		want string
+	}{
+		{"balanced", func(ua *asm.UdonAssembly) {
+			ua.PushVar("x")
+			ua.PushVar("y")
+			ua.Extern(negate)
+			ua.PushVar("x")
+			ua.PushVar("s")
+			ua.Extern(toString)
+			ua.PushVar("cond")
+			ua.JumpIfFalseLabel("else")
+			ua.PushVar("x")
+			ua.PushVar("y")
+			ua.Copy()
+			ua.AddLabelCurrentAddr("else")
+			ua.End()
+		}, ""},
+		{"call", func(ua *asm.UdonAssembly) {
+			ua.FuncTable.Put("f", []asm.UdonTypeName{asm.UdonTypeInt32}, asm.UdonTypeInt32, []asm.VarName{"f_a"})
+			_, err := ua.CallDefFunc("f", []asm.VarName{"x"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ua.End()
+			ua.AddLabelCurrentAddr(ua.FuncTable.GetFunctionID("f", []asm.UdonTypeName{asm.UdonTypeInt32}))
+			ua.PopVar("f_a")
+			ua.PopVar("ret_addr")
+			ua.PushVar("f_a")
+			ua.JumpRetAddr()
+		}, ""},
+		{"missing return value", func(ua *asm.UdonAssembly) {
+			ua.FuncTable.Put("f", []asm.UdonTypeName{asm.UdonTypeInt32}, asm.UdonTypeInt32, []asm.VarName{"f_a"})
+			_, err := ua.CallDefFunc("f", []asm.VarName{"x"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ua.End()
+			ua.AddLabelCurrentAddr(ua.FuncTable.GetFunctionID("f", []asm.UdonTypeName{asm.UdonTypeInt32}))
+			ua.PopVar("f_a")
+			ua.PopVar("ret_addr")
+			ua.JumpRetAddr()
+		}, "returns from f__SystemInt32 with 0 addresses on the stack, want 1"},
+		{"underflow", func(ua *asm.UdonAssembly) {
+			ua.PushVar("x")
+			ua.Extern(negate)
+			ua.End()
+		}, "pops 2 addresses, 1 on the stack"},
+		{"instance extern", func(ua *asm.UdonAssembly) {
+			ua.PushVar("s")
+			ua.Extern(toString)
+			ua.End()
+		}, "pops 2 addresses, 1 on the stack"},
+		{"unbalanced join", func(ua *asm.UdonAssembly) {
+			ua.PushVar("cond")
+			ua.JumpIfFalseLabel("join")
+			ua.PushVar("x")
+			ua.AddLabelCurrentAddr("join")
+			ua.End()
+		}, "reached with"},
+		{"left on the stack", func(ua *asm.UdonAssembly) {
+			ua.PushVar("x")
+			ua.End()
+		}, "ends _start with 1 addresses on the stack"},
+		{"unknown extern", func(ua *asm.UdonAssembly) {
+			ua.Extern("SystemInt32.__Frobnicate__SystemVoid")
+			ua.End()
+		}, "unknown extern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ua, err := asm.NewUdonAssembly(strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ua.MethodTable = methodTable
+			for _, varName := range []asm.VarName{"x", "y", "f_a"} {
+				ua.VarTable.AddVar(varName, asm.UdonTypeInt32, "null")
+			}
+			ua.VarTable.AddVar("s", asm.UdonTypeString, "null")
+			ua.VarTable.AddVar("cond", asm.UdonTypeBoolean, "null")
+			ua.VarTable.AddVar("ret_addr", asm.UdonTypeUInt32, "0xFFFFFFFF")
+			ua.EventHead("_start")
+			tt.emit(ua)
+			err = ua.VerifyStack()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("verify stack: %v", err)
+			case tt.want != "" && err == nil:
+				t.Errorf("no error, want %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUdonAssembly_CallExtern(t *testing.T) {
+	f, err := os.Open("./udon_funcs_data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	methodTable, err := asm.NewUdonMethodTable(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		externStr asm.ExternStr
+		argVars   []asm.VarName
+		// want is part of the error, "" if the call is valid
+		want string
+	}{
+		{"static", "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32", []asm.VarName{"x", "y", "x"}, ""},
+		{"instance", "SystemInt32.__ToString__SystemString", []asm.VarName{"x", "s"}, ""},
+		{"void", "UnityEngineDebug.__Log__SystemObject__SystemVoid", []asm.VarName{"o"}, ""},
+		{"unknown extern", "SystemInt32.__Frobnicate__SystemVoid", []asm.VarName{}, "not in the method table"},
+		{"missing return slot", "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32", []asm.VarName{"x", "y"}, "takes 3 operands, 2 pushed"},
+		{"missing instance", "SystemInt32.__ToString__SystemString", []asm.VarName{"s"}, "takes 2 operands, 1 pushed"},
+		{"argument type", "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32", []asm.VarName{"x", "s", "x"},
+			"operand 2 is s of type SystemString, want SystemInt32"},
+		{"return type", "SystemInt32.__ToString__SystemString", []asm.VarName{"x", "y"}, "operand 2 is y of type SystemInt32, want SystemString"},
+		{"undefined variable", "UnityEngineDebug.__Log__SystemObject__SystemVoid", []asm.VarName{"z"}, "z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ua, err := asm.NewUdonAssembly(strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ua.MethodTable = methodTable
+			ua.VarTable.AddVar("x", asm.UdonTypeInt32, "null")
+			ua.VarTable.AddVar("y", asm.UdonTypeInt32, "null")
+			ua.VarTable.AddVar("s", asm.UdonTypeString, "null")
+			ua.VarTable.AddVar("o", asm.UdonTypeObject, "null")
+			err = ua.CallExtern(tt.externStr, tt.argVars)
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("call extern: %v", err)
+			case tt.want != "" && err == nil:
+				t.Errorf("no error, want %q", tt.want)
+			case tt.want != "" && !strings.Contains(err.Error(), tt.want):
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+			if err != nil && ua.ProgramCounter != 0 {
+				t.Errorf("code emitted for an invalid call")
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+	"udon-go/asm"
+)
+
+// BehaviourType is a struct type standing for another behaviour of the module, marked with a
+// //udon:behaviour <import path> comment. Its fields are exported variables of the other behaviour
+// and its methods, which must have empty bodies, are its events; both are checked against its manifest.
+// A *T is an UdonBehaviour reference: its fields are read and written with Get/SetProgramVariable
+// and calling its methods sends the custom event.
+type BehaviourType struct {
+	Name string
+	// Path is the import path of the other behaviour
+	Path   string
+	Pos    token.Pos
+	Fields []*BehaviourField
+	// Events are the methods of the type by event name
+	Events map[string]*ast.Ident
+}
+
+// BehaviourField is an exported variable of another behaviour
+type BehaviourField struct {
+	Name     string
+	Pos      token.Pos
+	TypeName asm.UdonTypeName
+	Info     *TypeInfo
+}
+
+// Field returns the field called name
+func (bt *BehaviourType) Field(name string) (*BehaviourField, bool) {
+	for _, field := range bt.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return nil, false
+}
+
+// behaviourDirective returns the import path of the //udon:behaviour comment of a type declaration, if it has one
+func (c *Compiler) behaviourDirective(decl *ast.GenDecl, spec *ast.TypeSpec) (string, bool, error) {
+	docs := []*ast.CommentGroup{spec.Doc}
+	if !decl.Lparen.IsValid() {
+		docs = append(docs, decl.Doc)
+	}
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		for _, comment := range doc.List {
+			fields := strings.Fields(strings.TrimPrefix(comment.Text, "//"))
+			if len(fields) == 0 || fields[0] != "udon:behaviour" {
+				continue
+			}
+			if len(fields) != 2 {
+				return "", true, c.errorf(comment.Pos(), "%s: usage: //udon:behaviour <import path>", spec.Name.Name)
+			}
+			return fields[1], true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// behaviourNamed returns the behaviour type named by expr, T or pkg.T for the behaviour types of an imported helper package
+func (c *Compiler) behaviourNamed(expr ast.Expr) (*BehaviourType, bool) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		bt, ok := c.Behaviours[c.qualify(t.Name)]
+		return bt, ok
+	case *ast.SelectorExpr:
+		pkg, ok := c.importedPackage(t.X)
+		if !ok || !ast.IsExported(t.Sel.Name) {
+			return nil, false
+		}
+		bt, ok := c.Behaviours[pkg.qualify(t.Sel.Name)]
+		return bt, ok
+	}
+	return nil, false
+}
+
+// resolveBehaviourFields resolves the types of the fields of a behaviour type
+func (c *Compiler) resolveBehaviourFields(bt *BehaviourType, spec *ast.TypeSpec) error {
+	for _, field := range spec.Type.(*ast.StructType).Fields.List {
+		typeName, info, err := c.ResolveType(field.Type)
+		if err != nil {
+			return fmt.Errorf("behaviour %s: %w", bt.Name, err)
+		}
+		if len(field.Names) == 0 {
+			return fmt.Errorf("behaviour %s: embedded fields are not supported", bt.Name)
+		}
+		for _, name := range field.Names {
+			bt.Fields = append(bt.Fields, &BehaviourField{Name: name.Name, Pos: name.Pos(), TypeName: typeName, Info: info})
+		}
+	}
+	return nil
+}
+
+// collectBehaviourEvents registers the methods of the behaviour types of the file as their events
+func (c *Compiler) collectBehaviourEvents(f *ast.File) error {
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.FuncDecl)
+		if !ok || decl.Recv == nil || len(decl.Recv.List) != 1 {
+			continue
+		}
+		recv := decl.Recv.List[0].Type
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		}
+		bt, ok := c.behaviourNamed(recv)
+		if !ok {
+			continue
+		}
+		eventName, ok := EventName(decl)
+		if !ok {
+			return c.errorf(decl.Name.Pos(), "method %s of behaviour %s is not an event, its name must start with _", decl.Name.Name, bt.Name)
+		}
+		if decl.Type.Params.NumFields() > 0 || decl.Type.Results.NumFields() > 0 {
+			return c.errorf(decl.Name.Pos(), "event %s of behaviour %s can not take arguments or return values", decl.Name.Name, bt.Name)
+		}
+		if decl.Body == nil || len(decl.Body.List) > 0 {
+			return c.errorf(decl.Name.Pos(), "event %s of behaviour %s runs in the other behaviour, its body must be empty", decl.Name.Name, bt.Name)
+		}
+		bt.Events[string(eventName)] = decl.Name
+	}
+	return nil
+}
+
+// checkBehaviourTypes checks the fields and events of the behaviour types against the manifests of the behaviours they stand for
+func (c *Compiler) checkBehaviourTypes(methodTable asm.MethodMap, prog *Program) error {
+	names := []string{}
+	for name := range c.Behaviours {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		bt := c.Behaviours[name]
+		bm, err := prog.behaviourManifest(methodTable, bt.Path)
+		if err != nil {
+			return c.errorf(bt.Pos, "behaviour %s: %v", bt.Name, err)
+		}
+		for _, field := range bt.Fields {
+			mv, ok := bm.Variable(field.Name)
+			if !ok {
+				return c.errorf(field.Pos, "behaviour %s: %s has no exported variable %s", bt.Name, bt.Path, field.Name)
+			}
+			if mv.Type != string(field.TypeName) {
+				return c.errorf(field.Pos, "behaviour %s: %s.%s is %s, not %s", bt.Name, bt.Path, field.Name, mv.Type, field.TypeName)
+			}
+		}
+		events := []string{}
+		for eventName := range bt.Events {
+			events = append(events, eventName)
+		}
+		sort.Strings(events)
+		for _, eventName := range events {
+			if !bm.HasEvent(eventName) {
+				return c.errorf(bt.Events[eventName].Pos(), "behaviour %s: %s has no event %s", bt.Name, bt.Path, eventName)
+			}
+		}
+	}
+	return nil
+}
+
+// handleProgramVarGet reads a variable of the behaviour ref refers to
+func (c *Compiler) handleProgramVarGet(uasm *asm.UdonAssembly, ref asm.VarName, bt *BehaviourType, sel *ast.Ident) (asm.VarName, error) {
+	field, ok := bt.Field(sel.Name)
+	if !ok {
+		return "", c.errorf(sel.Pos(), "%s has no field %s", bt.Name, sel.Name)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.%s", ref, field.Name))
+	nameVarName, err := c.constString(uasm, field.Name)
+	if err != nil {
+		return "", err
+	}
+	objVarName, err := c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeIUdonEventReceiver, "GetProgramVariable", ref,
+		[]asm.VarName{nameVarName})
+	if err != nil {
+		return "", fmt.Errorf("get %s.%s: %w", bt.Name, field.Name, err)
+	}
+	valueVarName, err := c.castFromObject(uasm, objVarName, field.TypeName)
+	if err != nil {
+		return "", fmt.Errorf("get %s.%s: %w", bt.Name, field.Name, err)
+	}
+	c.SetTypeInfo(valueVarName, field.Info)
+	return valueVarName, nil
+}
+
+// handleProgramVarSet writes valueVarName to a variable of the behaviour ref refers to
+func (c *Compiler) handleProgramVarSet(uasm *asm.UdonAssembly, ref asm.VarName, bt *BehaviourType, sel *ast.Ident, valueVarName asm.VarName) error {
+	field, ok := bt.Field(sel.Name)
+	if !ok {
+		return c.errorf(sel.Pos(), "%s has no field %s", bt.Name, sel.Name)
+	}
+	valueType, err := uasm.VarTable.GetVarType(valueVarName)
+	if err != nil {
+		return err
+	}
+	if valueType != field.TypeName && valueType != asm.UdonTypeObject {
+		return c.errorf(sel.Pos(), "set %s.%s: cannot use %s as %s", bt.Name, field.Name, valueType, field.TypeName)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.%s = %s", ref, field.Name, valueVarName))
+	objVarName, err := c.toObject(uasm, valueVarName)
+	if err != nil {
+		return err
+	}
+	nameVarName, err := c.constString(uasm, field.Name)
+	if err != nil {
+		return err
+	}
+	_, err = c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeIUdonEventReceiver, "SetProgramVariable", ref,
+		[]asm.VarName{nameVarName, objVarName})
+	if err != nil {
+		return fmt.Errorf("set %s.%s: %w", bt.Name, field.Name, err)
+	}
+	return nil
+}
+
+// handleMethodCallExpr compiles x.m(), which sends event m to the behaviour x refers to
+func (c *Compiler) handleMethodCallExpr(uasm *asm.UdonAssembly, out io.Writer, sel *ast.SelectorExpr, args []ast.Expr) (asm.VarName, error) {
+	ref, err := c.handleExpr(uasm, out, sel.X)
+	if err != nil {
+		return "", err
+	}
+	bt, ok := c.BehaviourOf(ref)
+	if !ok {
+		return "", fmt.Errorf("call %s: %w", sel.Sel.Name, ErrNotImplemented)
+	}
+	eventName := sel.Sel.Name
+	if eventName == "main" {
+		eventName = "_start"
+	}
+	if _, ok := bt.Events[eventName]; !ok {
+		return "", c.errorf(sel.Sel.Pos(), "%s has no event %s", bt.Name, sel.Sel.Name)
+	}
+	if len(args) > 0 {
+		return "", c.errorf(sel.Sel.Pos(), "event %s of %s takes no arguments", sel.Sel.Name, bt.Name)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.SendCustomEvent(%s)", ref, eventName))
+	eventVarName, err := c.constString(uasm, eventName)
+	if err != nil {
+		return "", err
+	}
+	_, err = c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeIUdonEventReceiver, "SendCustomEvent", ref,
+		[]asm.VarName{eventVarName})
+	if err != nil {
+		return "", fmt.Errorf("send %s to %s: %w", eventName, bt.Name, err)
+	}
+	return "", nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"udon-go/asm"
+)
+
+// Manifest lists the behaviours of a build, for the unity scripts importing them
+type Manifest struct {
+	Behaviours []*BehaviourManifest `json:"behaviours"`
+}
+
+// BehaviourManifest describes the udon program compiled from a behaviour package
+type BehaviourManifest struct {
+	// Name is the directory of the package in its module
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	// Output is the path of the assembly, relative to the output directory
+	Output string `json:"output"`
+	// Binary is the path of the program assembled by Options.Binary, relative to the output directory
+	Binary string `json:"binary,omitempty"`
+	// SourceMap is the path of the source map written by Options.SourceMap, relative to the output directory
+	SourceMap string         `json:"sourceMap,omitempty"`
+	Variables []ManifestVar  `json:"variables"`
+	Events    []string       `json:"events"`
+	Synced    []ManifestSync `json:"synced"`
+}
+
+// Variable returns the exported variable called name
+func (bm *BehaviourManifest) Variable(name string) (ManifestVar, bool) {
+	for _, mv := range bm.Variables {
+		if mv.Name == name {
+			return mv, true
+		}
+	}
+	return ManifestVar{}, false
+}
+
+// HasEvent reports whether the behaviour has the event
+func (bm *BehaviourManifest) HasEvent(eventName string) bool {
+	for _, name := range bm.Events {
+		if name == eventName {
+			return true
+		}
+	}
+	return false
+}
+
+// ManifestVar is an exported variable of a behaviour
+type ManifestVar struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Behaviour is the import path of the behaviour a reference variable is declared to point to
+	Behaviour string `json:"behaviour,omitempty"`
+}
+
+// ManifestSync is a variable of a behaviour synced over the network
+type ManifestSync struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode"`
+}
+
+// ManifestFile is the name of the manifest in the output directory
+const ManifestFile = "manifest.json"
+
+// Build compiles the behaviours matched by patterns into outDir, one assembly file each, and writes their manifest.
+// A pattern is a package directory, or dir/... for every behaviour below dir.
+// Behaviours of the same module share their helper packages, which are loaded once and linked into every behaviour calling them,
+// as udon programs can not call each other's code.
+func Build(methodTable asm.MethodMap, outDir string, patterns []string, opts Options) (*Manifest, error) {
+	dirs, err := behaviourDirs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	loaders := map[string]*loader{}
+	manifest := &Manifest{Behaviours: []*BehaviourManifest{}}
+	for _, dir := range dirs {
+		modDir, _, err := findModule(dir)
+		if err != nil {
+			return nil, err
+		}
+		l, ok := loaders[modDir]
+		if !ok {
+			l, err = newLoader(dir)
+			if err != nil {
+				return nil, err
+			}
+			loaders[modDir] = l
+		}
+		bm, err := buildBehaviour(methodTable, opts, l, dir, outDir)
+		if err != nil {
+			return nil, fmt.Errorf("build %s: %w", dir, err)
+		}
+		manifest.Behaviours = append(manifest.Behaviours, bm)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(outDir, ManifestFile), append(data, '\n'), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// buildBehaviour links the behaviour in dir and writes its assembly into outDir
+func buildBehaviour(methodTable asm.MethodMap, opts Options, l *loader, dir string, outDir string) (*BehaviourManifest, error) {
+	prog, err := l.program(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	uasm, err := asm.NewUdonAssembly(strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+	uasm.MethodTable = methodTable
+	if opts.HeapReport != nil {
+		fmt.Fprintf(opts.HeapReport, "%s\n", l.behaviourName(dir))
+	}
+	uc := &UdonCompiler{UASM: uasm, Options: opts}
+	code, c, err := uc.link(ioutil.Discard, prog)
+	if err != nil {
+		return nil, err
+	}
+	bm, err := describe(l.behaviourName(dir), prog, uasm, c)
+	if err != nil {
+		return nil, err
+	}
+	outPath := filepath.Join(outDir, filepath.FromSlash(bm.Output))
+	err = os.MkdirAll(filepath.Dir(outPath), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(outPath, []byte(code), 0644)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Binary {
+		data, err := assembleBinary(code, uasm.LabelDict)
+		if err != nil {
+			return nil, err
+		}
+		bm.Binary = strings.TrimSuffix(bm.Output, ".uasm") + BinaryExt
+		err = ioutil.WriteFile(filepath.Join(outDir, filepath.FromSlash(bm.Binary)), data, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.SourceMap {
+		bm.SourceMap = strings.TrimSuffix(bm.Output, ".uasm") + asm.SourceMapExt
+		err = writeSourceMap(filepath.Join(outDir, filepath.FromSlash(bm.SourceMap)), uc.SourceMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bm, nil
+}
+
+// BinaryExt is the extension of the binary programs written next to the assemblies
+const BinaryExt = ".udonbin"
+
+// writeSourceMap writes a source map as json
+func writeSourceMap(path string, sm *asm.SourceMap) error {
+	data, err := json.MarshalIndent(sm, "", "  ")
+	if err != nil {
+		return fmt.Errorf("source map: %w", err)
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// assembleBinary encodes the text assembly of a linked program as bytecode, see asm.Program.Assemble.
+// The labels are kept in the binary for the disassembler.
+func assembleBinary(code string, labels map[asm.LabelName]asm.Addr) ([]byte, error) {
+	prog, err := asm.ParseProgram(code)
+	if err != nil {
+		return nil, fmt.Errorf("assemble: %w", err)
+	}
+	prog.SetLabels(labels)
+	data, err := prog.Assemble()
+	if err != nil {
+		return nil, fmt.Errorf("assemble: %w", err)
+	}
+	return data, nil
+}
+
+// describe returns the manifest of the behaviour of prog, declared or linked by c into uasm
+func describe(name string, prog *Program, uasm *asm.UdonAssembly, c *Compiler) (*BehaviourManifest, error) {
+	bm := &BehaviourManifest{
+		Name:      name,
+		Package:   prog.Main().Path,
+		Output:    name + ".uasm",
+		Variables: []ManifestVar{},
+		Events:    []string{},
+		Synced:    []ManifestSync{},
+	}
+	for _, varName := range uasm.VarTable.GlobalVarNames {
+		typeName, err := uasm.VarTable.GetVarType(varName)
+		if err != nil {
+			return nil, err
+		}
+		mv := ManifestVar{Name: string(varName), Type: string(typeName)}
+		if bt, ok := c.BehaviourOf(varName); ok {
+			mv.Behaviour = bt.Path
+		}
+		bm.Variables = append(bm.Variables, mv)
+	}
+	for _, syncVar := range uasm.VarTable.SyncVars {
+		typeName, err := uasm.VarTable.GetVarType(syncVar.VarName)
+		if err != nil {
+			return nil, err
+		}
+		bm.Synced = append(bm.Synced, ManifestSync{Name: string(syncVar.VarName), Type: string(typeName), Mode: string(syncVar.Mode)})
+	}
+	for _, eventName := range uasm.EventNames {
+		if !c.HiddenEvents[eventName] {
+			bm.Events = append(bm.Events, string(eventName))
+		}
+	}
+	return bm, nil
+}
+
+// behaviourName returns the name of the behaviour in dir, its directory in the module
+func (l *loader) behaviourName(dir string) string {
+	name, err := filepath.Rel(l.modDir, dir)
+	if err != nil || name == "." {
+		name = filepath.Base(l.modDir)
+	}
+	return filepath.ToSlash(name)
+}
+
+// behaviourManifest returns the manifest of the behaviour of the module with the import path, which the program refers to
+func (prog *Program) behaviourManifest(methodTable asm.MethodMap, importPath string) (*BehaviourManifest, error) {
+	if prog.loader == nil {
+		return nil, fmt.Errorf("can not load %s, a single file can not refer to other behaviours", importPath)
+	}
+	return prog.loader.manifest(methodTable, importPath)
+}
+
+// manifest declares the behaviour with the import path and returns its manifest.
+// Only declarations are compiled, so the manifest is known before the behaviour is built.
+func (l *loader) manifest(methodTable asm.MethodMap, importPath string) (*BehaviourManifest, error) {
+	if bm, ok := l.manifests[importPath]; ok {
+		return bm, nil
+	}
+	if importPath != l.modPath && !strings.HasPrefix(importPath, l.modPath+"/") {
+		return nil, fmt.Errorf("%s is not a behaviour of module %s", importPath, l.modPath)
+	}
+	dir := filepath.Join(l.modDir, filepath.FromSlash(strings.TrimPrefix(importPath, l.modPath)))
+	prog, err := l.program(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prog.Main().Name != "main" {
+		return nil, fmt.Errorf("%s is not a behaviour, its package is %s", importPath, prog.Main().Name)
+	}
+	uasm, err := asm.NewUdonAssembly(strings.NewReader(""))
+	if err != nil {
+		return nil, err
+	}
+	uasm.MethodTable = methodTable
+	uc := &UdonCompiler{UASM: uasm}
+	c, _, err := uc.declare(ioutil.Discard, prog)
+	if err != nil {
+		return nil, fmt.Errorf("declare %s: %w", importPath, err)
+	}
+	bm, err := describe(l.behaviourName(dir), prog, uasm, c)
+	if err != nil {
+		return nil, err
+	}
+	l.manifests[importPath] = bm
+	return bm, nil
+}
+
+// behaviourDirs expands patterns to the sorted directories of the behaviours they match
+func behaviourDirs(patterns []string) ([]string, error) {
+	dirs := []string{}
+	seen := map[string]bool{}
+	add := func(dir string) error {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+		return nil
+	}
+	for _, pattern := range patterns {
+		if !strings.HasSuffix(pattern, "...") {
+			err := add(pattern)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		root := filepath.Clean(strings.TrimSuffix(pattern, "..."))
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return nil
+			}
+			base := info.Name()
+			if path != root && (base == "testdata" || strings.HasPrefix(base, ".") || strings.HasPrefix(base, "_")) {
+				return filepath.SkipDir
+			}
+			bp, err := build.Default.ImportDir(path, 0)
+			if err != nil || bp.Name != "main" {
+				return nil
+			}
+			return add(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(dirs)
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no behaviours match %s", strings.Join(patterns, " "))
+	}
+	return dirs, nil
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/printer"
 	"go/token"
+	"go/types"
 	"io"
 	"strconv"
+	"strings"
 	"udon-go/asm"
 )
 
@@ -14,22 +19,124 @@ type Compiler struct {
 	CurrentFuncRetType   *asm.UdonTypeName
 	CurrentBreakLabel    *asm.LabelName
 	CurrentContinueLabel *asm.LabelName
+	CurrentEvent         *asm.EventName
+	Structs              map[string]*StructType
+	VarTypes             map[asm.VarName]*TypeInfo
+	FuncRetTypes         map[asm.FuncName]*TypeInfo
+	// Fset positions the errors reporting source locations
+	Fset *token.FileSet
+	// Scope is the innermost scope, the package scope outside of functions
+	Scope *Scope
+	// HeapNames are the heap variables handed out to declarations
+	HeapNames      map[asm.VarName]bool
+	ScopedVarCount int
+	// UntypedConsts holds the value of numeric literals, which take the type of the other operand
+	UntypedConsts map[asm.VarName]string
+	// UsesMaps is set once a map is created or used, even a nil map, the map runtime is only emitted when it is needed
+	UsesMaps bool
+	// UsesDelayedSlots is set once a delayed call taking arguments or capturing variables is scheduled,
+	// the delayed call runtime is only emitted when it is needed
+	UsesDelayedSlots bool
+	// DelayedCalls are the scheduled calls whose events are compiled after the current function
+	DelayedCalls []*DelayedCall
+	// Pkg is the package being compiled
+	Pkg *Package
+	// HiddenEvents are the events generated for delayed calls, which are not part of the behaviour's API
+	HiddenEvents map[asm.EventName]bool
+	// Behaviours are the types standing for other behaviours, by qualified name
+	Behaviours map[string]*BehaviourType
+	// Funcs are the functions of the program which are not events, by qualified name
+	Funcs map[asm.FuncName]*FuncDecl
+	// InlineThreshold is the size of the largest function body inlined at its call sites, 0 inlines only the //udon:inline ones
+	InlineThreshold int
+	// Inline is the call whose function body is being compiled in its place, nil outside of inlined bodies
+	Inline      *inlineSite
+	InlineCount int
+	// Pos is the position of the statement or expression being compiled, for errors raised below the syntax tree
+	Pos token.Pos
+	// VarIdents are the go identifiers of the heap variables handed out to declarations, for the source map
+	VarIdents map[asm.VarName]string
+	// Expr is the innermost expression being compiled
+	Expr ast.Expr
+	// Exprs receives what is learned of the expressions compiled, when it is not nil
+	Exprs map[ast.Expr]*ExprInfo
 }
 
-func (c *Compiler) handleDecls(uasm *asm.UdonAssembly, out io.Writer, d *ast.File) error {
-	// fmt.Println("run: handleDecls")
-	var err error
+// ExprInfo is what the compiler learned of an expression, for the language server
+type ExprInfo struct {
+	// Type is the udon type of its value, empty for calls without result
+	Type asm.UdonTypeName
+	// Extern is the last extern called by the expression itself, not by its operands
+	Extern asm.ExternStr
+	// Info is the go type behind Type, if any
+	Info *TypeInfo
+}
+
+// NewCompiler returns a compiler with empty type tables
+func NewCompiler() *Compiler {
+	return &Compiler{
+		Structs:       map[string]*StructType{},
+		VarTypes:      map[asm.VarName]*TypeInfo{},
+		FuncRetTypes:  map[asm.FuncName]*TypeInfo{},
+		UntypedConsts: map[asm.VarName]string{},
+		Scope:         &Scope{Kind: PackageScope, Vars: map[string]*ScopeVar{}},
+		HeapNames:     map[asm.VarName]bool{},
+		VarIdents:     map[asm.VarName]string{},
+		HiddenEvents:  map[asm.EventName]bool{},
+		Behaviours:    map[string]*BehaviourType{},
+		Funcs:         map[asm.FuncName]*FuncDecl{},
+	}
+}
+
+// EventName returns the udon event a function declaration compiles to, if any
+func EventName(decl *ast.FuncDecl) (asm.EventName, bool) {
+	if decl.Name.Name == "main" {
+		return asm.EventName("_start"), true
+	}
+	if strings.HasPrefix(decl.Name.Name, "_") {
+		return asm.EventName(decl.Name.Name), true
+	}
+	return "", false
+}
+
+// eventName returns the udon event a function declaration of the current package compiles to,
+// functions of helper packages are never events
+func (c *Compiler) eventName(decl *ast.FuncDecl) (asm.EventName, bool) {
+	if c.Pkg != nil && c.Pkg.Prefix != "" {
+		return "", false
+	}
+	return EventName(decl)
+}
+
+// handleGenDecls declares the package variables of the file
+func (c *Compiler) handleGenDecls(uasm *asm.UdonAssembly, out io.Writer, d *ast.File) error {
+	for _, decl := range d.Decls {
+		if decl, ok := decl.(*ast.GenDecl); ok {
+			err := c.handleGenDecl(uasm, out, decl)
+			if err != nil {
+				return fmt.Errorf("handle generic declaration: %w", c.at(decl.Pos(), err))
+			}
+		}
+	}
+	return nil
+}
+
+// handleFuncDecls compiles the reachable functions of the file, followed by the events of the calls they delay
+func (c *Compiler) handleFuncDecls(uasm *asm.UdonAssembly, out io.Writer, d *ast.File, reachable map[*ast.FuncDecl]bool) error {
 	for _, decl := range d.Decls {
 		switch decl := decl.(type) {
 		case *ast.GenDecl:
-			err = c.handleGenDecl(uasm, out, decl)
+		case *ast.FuncDecl:
+			if !reachable[decl] {
+				continue
+			}
+			err := c.handleFuncDecl(uasm, out, decl)
 			if err != nil {
-				return fmt.Errorf("handle generic declaration: %w", err)
+				return fmt.Errorf("handle func declaration %s: %w", decl.Name.Name, err)
 			}
-		case *ast.FuncDecl:
-			err = c.handleFuncDecl(uasm, out, decl)
+			err = c.emitDelayedCalls(uasm, out)
 			if err != nil {
-				return fmt.Errorf("handle func declaration: %w", err)
+				return fmt.Errorf("handle func declaration %s: %w", decl.Name.Name, err)
 			}
 		default:
 			fmt.Printf("unsupported decl: %#v", d)
@@ -43,7 +150,7 @@ func (c *Compiler) handleGenDecl(uasm *asm.UdonAssembly, out io.Writer, decl *as
 	for _, s := range decl.Specs {
 		switch spec := s.(type) {
 		case *ast.TypeSpec:
-
+			// structs are collected before compilation, see collectStructs
 		case *ast.ValueSpec:
 			if len(spec.Names) > 1 {
 				return fmt.Errorf("unsupported # of value names: %v", spec.Names)
@@ -52,81 +159,234 @@ func (c *Compiler) handleGenDecl(uasm *asm.UdonAssembly, out io.Writer, decl *as
 				return fmt.Errorf("unsupported # of values: %v", spec.Names)
 			}
 
-			switch l := spec.Values[0].(type) {
-			case *ast.BasicLit:
-				varName := asm.VarName(spec.Names[0].Name)
-				switch l.Kind {
-				case token.INT:
-					val, err := strconv.Atoi(l.Value)
-					if err != nil {
-						return fmt.Errorf("parse int: %w", err)
-					}
-					uasm.SetUint32(varName, val)
-					uasm.VarTable.AddVar(varName, asm.UdonTypeInt32, l.Value)
-				case token.FLOAT:
-					return fmt.Errorf("unsupported token: %s", l.Kind.String())
-				case token.IMAG:
-					return fmt.Errorf("unsupported token: %s", l.Kind.String())
-				case token.CHAR:
-					return fmt.Errorf("unsupported token: %s", l.Kind.String())
-				case token.STRING:
-					return fmt.Errorf("unsupported token: %s", l.Kind.String())
-				default:
-					return fmt.Errorf("unsupported token: %s", l.Kind.String())
+			if len(spec.Values) == 0 {
+				if spec.Type == nil {
+					return fmt.Errorf("missing type: %v", spec.Names)
 				}
+				typeName, info, err := c.ResolveType(spec.Type)
+				if err != nil {
+					return fmt.Errorf("resolve type: %w", err)
+				}
+				varName, err := c.declarePackageVar(uasm, spec.Names[0])
+				if err != nil {
+					return err
+				}
+				err = uasm.VarTable.AddVar(varName, typeName, zeroLiteral(typeName))
+				if err != nil {
+					return fmt.Errorf("add var %s: %w", varName, err)
+				}
+				c.SetTypeInfo(varName, info)
+				err = c.packageVarDirectives(uasm, decl, spec, varName)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 
+			typeName, info, value, err := c.packageVarValue(spec)
+			if err != nil {
+				return err
+			}
+			varName, err := c.declarePackageVar(uasm, spec.Names[0])
+			if err != nil {
+				return err
+			}
+			err = uasm.VarTable.AddVar(varName, typeName, value)
+			if err != nil {
+				return fmt.Errorf("add var %s: %w", varName, err)
+			}
+			c.SetTypeInfo(varName, info)
+			if c.inBehaviour() {
 				uasm.VarTable.AddVarGlobal(varName)
 			}
+			err = c.packageVarDirectives(uasm, decl, spec, varName)
+			if err != nil {
+				return err
+			}
+		}
+
+	}
+	return nil
+}
+
+// packageVarValue returns the type and the initial value of a package variable initialized with a constant, e.g. var N = -1.
+// Other initializers would have to run before the events and are not supported.
+func (c *Compiler) packageVarValue(spec *ast.ValueSpec) (asm.UdonTypeName, *TypeInfo, string, error) {
+	var typeName asm.UdonTypeName
+	var info *TypeInfo
+	if spec.Type != nil {
+		var err error
+		typeName, info, err = c.ResolveType(spec.Type)
+		if err != nil {
+			return "", nil, "", fmt.Errorf("resolve type: %w", err)
+		}
+	}
+	expr := spec.Values[0]
+	// without a package the expression may only refer to the universe, e.g. true or int64
+	checked := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	tv := types.TypeAndValue{}
+	if types.CheckExpr(token.NewFileSet(), nil, token.NoPos, expr, checked) == nil {
+		tv = checked.Types[expr]
+	}
+	if tv.IsNil() && spec.Type != nil {
+		return typeName, info, "null", nil
+	}
+	if tv.Value == nil {
+		return "", nil, "", c.errorf(expr.Pos(), "unsupported initializer %s, package variables can only be initialized with constants", types.ExprString(expr))
+	}
+	if spec.Type == nil {
+		basic, ok := types.Default(tv.Type).(*types.Basic)
+		if !ok {
+			return "", nil, "", c.errorf(expr.Pos(), "unsupported initializer %s", types.ExprString(expr))
+		}
+		var err error
+		typeName, err = IdentToUnity(ast.NewIdent(basic.Name()))
+		if err != nil {
+			return "", nil, "", c.errorf(expr.Pos(), "%v", err)
+		}
+	}
+	value, err := constLiteral(tv.Value, typeName)
+	if err != nil {
+		return "", nil, "", c.errorf(expr.Pos(), "%v", err)
+	}
+	return typeName, info, value, nil
+}
+
+// constLiteral writes the constant v as the initial value of a heap variable of typeName
+func constLiteral(v constant.Value, typeName asm.UdonTypeName) (string, error) {
+	switch {
+	case v.Kind() == constant.Bool && typeName == asm.UdonTypeBoolean:
+		return v.String(), nil
+	case v.Kind() == constant.String && typeName == asm.UdonTypeString:
+		return strconv.Quote(constant.StringVal(v)), nil
+	case (v.Kind() == constant.Int || v.Kind() == constant.Float) && isInteger(typeName):
+		i := constant.ToInt(v)
+		if i.Kind() != constant.Int {
+			return "", fmt.Errorf("constant %s truncated to %s", v, typeName)
 		}
+		return i.String(), nil
+	case (v.Kind() == constant.Int || v.Kind() == constant.Float) && isFloat(typeName):
+		f, _ := constant.Float64Val(constant.ToFloat(v))
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	}
+	return "", fmt.Errorf("cannot use constant %s as %s", v, typeName)
+}
+
+// inBehaviour reports whether the current package is the behaviour rather than a helper package
+func (c *Compiler) inBehaviour() bool {
+	return c.Pkg == nil || c.Pkg.Prefix == ""
+}
 
+// packageVarDirectives exports the exported package variables of the behaviour
+// and syncs the ones marked with a //udon:sync [none|linear|smooth] comment
+func (c *Compiler) packageVarDirectives(uasm *asm.UdonAssembly, decl *ast.GenDecl, spec *ast.ValueSpec, varName asm.VarName) error {
+	name := spec.Names[0]
+	docs := []*ast.CommentGroup{spec.Doc}
+	if !decl.Lparen.IsValid() {
+		docs = append(docs, decl.Doc)
+	}
+	for _, doc := range docs {
+		if doc == nil {
+			continue
+		}
+		for _, comment := range doc.List {
+			fields := strings.Fields(strings.TrimPrefix(comment.Text, "//"))
+			if len(fields) == 0 || fields[0] != "udon:sync" {
+				continue
+			}
+			if !c.inBehaviour() {
+				return c.errorf(comment.Pos(), "%s: only variables of a behaviour can be synced", name.Name)
+			}
+			if len(fields) > 2 {
+				return c.errorf(comment.Pos(), "%s: usage: //udon:sync [none|linear|smooth]", name.Name)
+			}
+			mode := asm.SyncNone
+			if len(fields) == 2 {
+				mode = asm.SyncMode(fields[1])
+			}
+			typeName, err := uasm.VarTable.GetVarType(varName)
+			if err != nil {
+				return err
+			}
+			if !isValueType(typeName) || c.VarTypes[varName] != nil {
+				return c.errorf(name.Pos(), "cannot sync %s of type %s", name.Name, typeName)
+			}
+			err = uasm.VarTable.AddVarSync(varName, mode)
+			if err != nil {
+				return c.errorf(comment.Pos(), "sync %s: %v", name.Name, err)
+			}
+		}
+	}
+	if c.inBehaviour() && name.IsExported() && !isGlobal(uasm, varName) {
+		return uasm.VarTable.AddVarGlobal(varName)
 	}
 	return nil
 }
 
+func isGlobal(uasm *asm.UdonAssembly, varName asm.VarName) bool {
+	for _, global := range uasm.VarTable.GlobalVarNames {
+		if global == varName {
+			return true
+		}
+	}
+	return false
+}
+
+// declarePackageVar declares a package variable, which counts as used like in go
+func (c *Compiler) declarePackageVar(uasm *asm.UdonAssembly, ident *ast.Ident) (asm.VarName, error) {
+	varName, err := c.declare(uasm, ident)
+	if err != nil {
+		return "", err
+	}
+	c.Scope.Vars[ident.Name].Used = true
+	return varName, nil
+}
+
 func (c *Compiler) handleFuncDecl(uasm *asm.UdonAssembly, out io.Writer, decl *ast.FuncDecl) error {
 	// fmt.Println("run: handleFuncDecl")
-	funcName := asm.FuncName(decl.Name.Name)
+	if decl.Recv != nil {
+		return fmt.Errorf("methods: %w", ErrNotImplemented)
+	}
+	if eventName, ok := c.eventName(decl); ok {
+		return c.handleEventDecl(uasm, out, decl, eventName)
+	}
+	funcName := asm.FuncName(c.qualify(decl.Name.Name))
 	argTypes := []asm.UdonTypeName{}
+	argInfos := []*TypeInfo{}
 
-	retTypes := []asm.UdonTypeName{}
 	argNames := []asm.VarName{}
 	uasm.EnvVars = argNames
 
 	for _, arg := range decl.Type.Params.List {
-		argTypes = append(argTypes, IdentToUnity(arg.Type.(*ast.Ident)))
+		typeName, info, err := c.ResolveType(arg.Type)
+		if err != nil {
+			return fmt.Errorf("resolve arg type: %w", err)
+		}
+		for range arg.Names {
+			argTypes = append(argTypes, typeName)
+			argInfos = append(argInfos, info)
+		}
 	}
 
 	funcLabel := uasm.FuncTable.GetFunctionID(funcName, argTypes)
 	uasm.VarTable.SetCurrentFuncID(&funcLabel)
 	uasm.AddLabelCurrentAddr(funcLabel)
+	c.sourceComment(uasm, decl)
 
+	c.pushScope(FuncScope)
 	for _, arg := range decl.Type.Params.List {
-		if len(arg.Names) > 1 {
-			return errors.New("multiple args to type not supported")
-		}
-
-		resolvedVarName, err := uasm.VarTable.ResolveVarname(asm.VarName(arg.Names[0].Name))
-		if err != nil {
-			return fmt.Errorf("resolve var name: %w", err)
-		}
-		argNames = append(argNames, resolvedVarName)
-	}
-
-	if decl.Type.Results != nil && len(decl.Type.Results.List) > 0 {
-		for _, ret := range decl.Type.Results.List {
-			if ret.Tag == nil {
-				break
+		for _, name := range arg.Names {
+			varName, err := c.declareParam(uasm, name)
+			if err != nil {
+				return err
 			}
-			retTypes = append(retTypes, asm.UdonTypeName(fmt.Sprintf("%s", ret.Type)))
+			argNames = append(argNames, varName)
 		}
 	}
-	if len(retTypes) > 1 {
-		return errors.New("multiple returns not supported")
-	}
-	udonReturnType := asm.GoNil
 
-	if len(retTypes) > 0 {
-		udonReturnType = retTypes[0]
+	udonReturnType, _, err := c.funcReturnType(decl)
+	if err != nil {
+		return err
 	}
 
 	if udonReturnType != asm.GoNil {
@@ -138,24 +398,26 @@ func (c *Compiler) handleFuncDecl(uasm *asm.UdonAssembly, out io.Writer, decl *a
 		if err != nil {
 			return fmt.Errorf("add var: %w", err)
 		}
+		c.SetTypeInfo(varName, argInfos[i])
 	}
-	err := uasm.PopVars(argNames)
+	err = uasm.PopVars(argNames)
 	if err != nil {
 		return fmt.Errorf("pop vars: %w", err)
 	}
 	uasm.FuncTable.Put(funcName, argTypes, udonReturnType, argNames)
 
-	uasm.VarTable.SetCurrentFuncID(nil)
-	c.CurrentFuncRetType = nil
-
 	err = uasm.PopVar(asm.VarName("ret_addr"))
 	if err != nil {
 		return fmt.Errorf("pop vars: %w", err)
 	}
-	// err := c.handleBlockStmt(uasm, out, decl.Body)
-	// if err != nil {
-	// 	return fmt.Errorf("handle block: %w", err)
-	// }
+	err = c.handleBlockStmt(uasm, out, decl.Body)
+	if err != nil {
+		return fmt.Errorf("handle block: %w", err)
+	}
+	err = c.popScope()
+	if err != nil {
+		return err
+	}
 	uasm.JumpRetAddr()
 	uasm.EnvVars = []asm.VarName{}
 	uasm.VarTable.SetCurrentFuncID(nil)
@@ -163,133 +425,889 @@ func (c *Compiler) handleFuncDecl(uasm *asm.UdonAssembly, out io.Writer, decl *a
 
 	return nil
 }
+
+// declareParam declares a function parameter, unused parameters are not an error
+func (c *Compiler) declareParam(uasm *asm.UdonAssembly, ident *ast.Ident) (asm.VarName, error) {
+	if ident.Name == "_" {
+		ident = ast.NewIdent(fmt.Sprintf("_%d", len(c.Scope.Vars)))
+	}
+	varName, err := c.declare(uasm, ident)
+	if err != nil {
+		return "", err
+	}
+	c.Scope.Vars[ident.Name].Used = true
+	return varName, nil
+}
+
+// handleEventDecl compiles the body of an exported event, which halts instead of returning
+func (c *Compiler) handleEventDecl(uasm *asm.UdonAssembly, out io.Writer, decl *ast.FuncDecl, eventName asm.EventName) error {
+	eventLabel := asm.LabelName(eventName)
+	uasm.VarTable.SetCurrentFuncID(&eventLabel)
+	c.CurrentEvent = &eventName
+	uasm.EventHead(eventName)
+	c.sourceComment(uasm, decl)
+
+	c.pushScope(FuncScope)
+	err := c.handleBlockStmt(uasm, out, decl.Body)
+	if err != nil {
+		return fmt.Errorf("handle block: %w", err)
+	}
+	err = c.popScope()
+	if err != nil {
+		return err
+	}
+	uasm.End()
+	uasm.VarTable.SetCurrentFuncID(nil)
+	c.CurrentEvent = nil
+	return nil
+}
+
+// sourceComment writes the position and the first line of a statement or function before the instructions compiled from it,
+// see asm.UdonAssembly.SourceComment
+func (c *Compiler) sourceComment(uasm *asm.UdonAssembly, node ast.Node) {
+	if !uasm.Comments || c.Fset == nil || !node.Pos().IsValid() {
+		return
+	}
+	switch node.(type) {
+	case *ast.BlockStmt, *ast.EmptyStmt:
+		return
+	}
+	b := &bytes.Buffer{}
+	if decl, ok := node.(*ast.FuncDecl); ok {
+		// only the signature, func(a int) int written as func f(a int) int
+		printer.Fprint(b, c.Fset, decl.Type)
+		signature := strings.TrimPrefix(b.String(), "func")
+		b.Reset()
+		b.WriteString("func " + decl.Name.Name + signature)
+	} else {
+		printer.Fprint(b, c.Fset, node)
+	}
+	source := strings.TrimSuffix(strings.SplitN(b.String(), "\n", 2)[0], " {")
+	pos := c.Fset.Position(node.Pos())
+	uasm.SourceComment(pos.Filename, pos.Line, pos.Column, source)
+}
+
+// funcReturnType returns the udon return type of a function declaration, asm.GoNil if it returns nothing
+func (c *Compiler) funcReturnType(decl *ast.FuncDecl) (asm.UdonTypeName, *TypeInfo, error) {
+	if decl.Type.Results == nil || len(decl.Type.Results.List) == 0 {
+		return asm.GoNil, nil, nil
+	}
+	if len(decl.Type.Results.List) > 1 || len(decl.Type.Results.List[0].Names) > 1 {
+		return "", nil, errors.New("multiple returns not supported")
+	}
+	typeName, info, err := c.ResolveType(decl.Type.Results.List[0].Type)
+	if err != nil {
+		return "", nil, fmt.Errorf("resolve return type: %w", err)
+	}
+	return typeName, info, nil
+}
+
+// handleScopedBlock compiles a nested block, whose declarations are not visible after it
+func (c *Compiler) handleScopedBlock(uasm *asm.UdonAssembly, out io.Writer, bs *ast.BlockStmt) error {
+	return c.inBlockScope(func() error {
+		return c.handleBlockStmt(uasm, out, bs)
+	})
+}
+
+// inBlockScope runs compile in a new block scope
+func (c *Compiler) inBlockScope(compile func() error) error {
+	c.pushScope(BlockScope)
+	err := compile()
+	if err != nil {
+		return err
+	}
+	return c.popScope()
+}
+
+// handleBlockStmt compiles the statements of a block, leaving out the unreachable ones
 func (c *Compiler) handleBlockStmt(uasm *asm.UdonAssembly, out io.Writer, bs *ast.BlockStmt) error {
+	live, _ := liveStmts(bs.List)
+	for _, s := range live {
+		err := c.handleStmt(uasm, out, s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	for _, s := range bs.List {
-		switch st := s.(type) {
-		case *ast.ExprStmt:
-			// fmt.Println("handle ast.ExprStmt")
-			_, err := c.handleExpr(uasm, out, st.X)
-			if err != nil {
-				return fmt.Errorf("error handling expr: %v", err)
-			}
-		case *ast.AssignStmt:
-			// fmt.Println("handle ast.AssignStmt")
-			if len(st.Lhs) > 1 {
-				return fmt.Errorf("assign: unsupported # of lhs exprs: %v", st.Lhs)
-			}
-			if len(st.Rhs) > 1 {
-				return fmt.Errorf("assign: unsupported # of rhs exprs: %v", st.Rhs)
+func (c *Compiler) handleStmt(uasm *asm.UdonAssembly, out io.Writer, s ast.Stmt) (err error) {
+	defer func(pos token.Pos) { c.Pos = pos }(c.Pos)
+	c.Pos = s.Pos()
+	// errors without a position of their own are reported at the statement
+	defer func() { err = c.at(s.Pos(), err) }()
+	c.sourceComment(uasm, s)
+	switch st := s.(type) {
+	case *ast.ExprStmt:
+		// fmt.Println("handle ast.ExprStmt")
+		_, err := c.handleExpr(uasm, out, st.X)
+		if err != nil {
+			return fmt.Errorf("error handling expr: %w", err)
+		}
+	case *ast.DeclStmt:
+		return c.handleDeclStmt(uasm, out, st)
+	case *ast.AssignStmt:
+		// fmt.Println("handle ast.AssignStmt")
+		if index, ok := commaOk(st); ok {
+			return c.handleCommaOkAssign(uasm, out, st, index)
+		}
+		if op, ok := AssignOpTokens[st.Tok]; ok {
+			if len(st.Lhs) != 1 || len(st.Rhs) != 1 {
+				return fmt.Errorf("assign %s: expected one operand on each side", st.Tok)
 			}
+			return c.handleOpAssign(uasm, out, st.Lhs[0], op, st.Rhs[0])
+		}
+		if len(st.Lhs) > 1 {
+			return fmt.Errorf("assign: unsupported # of lhs exprs: %v", st.Lhs)
+		}
+		if len(st.Rhs) > 1 {
+			return fmt.Errorf("assign: unsupported # of rhs exprs: %v", st.Rhs)
+		}
 
-			lhs := st.Lhs[0]
-			rhs := st.Rhs[0]
+		lhs := st.Lhs[0]
+		rhs := st.Rhs[0]
 
-			lhsVarName, err := c.handleExpr(uasm, out, lhs)
-			if err != nil {
-				return fmt.Errorf("assign: left expr %v: %v", lhs, err)
+		rhsVarName, err := c.handleExpr(uasm, out, rhs)
+		if err != nil {
+			return fmt.Errorf("assign: right expr %s: %w", types.ExprString(rhs), err)
+		}
+		if rhsVarName == "" {
+			return fmt.Errorf("assign: right expr %v has no value", rhs)
+		}
+		switch st.Tok {
+		case token.DEFINE:
+			return c.defineVars(uasm, out, st.Pos(), st.Lhs, []asm.VarName{rhsVarName})
+		case token.ASSIGN:
+			return c.assignTo(uasm, out, lhs, rhsVarName)
+		default:
+			return fmt.Errorf("assign %s: %w", st.Tok, ErrNotImplemented)
+		}
+	case *ast.ReturnStmt:
+		if c.Inline != nil {
+			return c.inlineReturn(uasm, out, st)
+		}
+		if c.CurrentEvent != nil {
+			if len(st.Results) > 0 {
+				return c.errorf(st.Pos(), "return stmt: events can not return values")
 			}
-			rhsVarName, err := c.handleExpr(uasm, out, rhs)
+			uasm.End()
+			return nil
+		}
+
+		if len(st.Results) > 1 {
+			return c.errorf(st.Pos(), "return stmt: multiple returns not supported")
+		} else if len(st.Results) == 1 {
+			// standard return
+			// TODO: Add checks for return type
+			if c.CurrentFuncRetType == nil {
+				return c.errorf(st.Pos(), "too many return values")
+			}
+			retVarName, err := c.handleExpr(uasm, out, st.Results[0])
 			if err != nil {
-				return fmt.Errorf("assign: right expr %v: %v", rhs, err)
+				return fmt.Errorf("handle expr: %w", err)
 			}
-			uasm.VarTable.AddVar(lhsVarName, asm.UdonTypeInt32, "0")
-			uasm.Assign(lhsVarName, rhsVarName)
-		case *ast.ReturnStmt:
-			err := uasm.PopVar(asm.VarName("ret_addr"))
+			uasm.PushVar(retVarName)
+		} else if c.CurrentFuncRetType != nil {
+			return c.errorf(st.Pos(), "not enough return values")
+		}
+		uasm.JumpRetAddr()
+	case *ast.IfStmt:
+		// fmt.Println("handle ast.IfStmt")
+		return c.handleIfStmt(uasm, out, st)
+	case *ast.BlockStmt:
+		return c.handleScopedBlock(uasm, out, st)
+	case *ast.IncDecStmt:
+		op := token.ADD
+		if st.Tok == token.DEC {
+			op = token.SUB
+		}
+		return c.handleOpAssign(uasm, out, st.X, op, nil)
+	case *ast.ForStmt:
+		return c.handleForStmt(uasm, out, st)
+	case *ast.RangeStmt:
+		return c.handleRangeStmt(uasm, out, st)
+	case *ast.BranchStmt:
+		return c.handleBranchStmt(uasm, st)
+	case *ast.GoStmt:
+		return c.handleGoStmt(uasm, out, st)
+	case *ast.EmptyStmt:
+	default:
+		return fmt.Errorf("statement %T: %w", st, ErrNotImplemented)
+	}
+	return nil
+}
+
+// commaOk reports whether st is v, ok = m[k]
+func commaOk(st *ast.AssignStmt) (*ast.IndexExpr, bool) {
+	if len(st.Lhs) != 2 || len(st.Rhs) != 1 {
+		return nil, false
+	}
+	index, ok := st.Rhs[0].(*ast.IndexExpr)
+	return index, ok
+}
+
+// handleCommaOkAssign compiles v, ok := m[k] and v, ok = m[k]
+func (c *Compiler) handleCommaOkAssign(uasm *asm.UdonAssembly, out io.Writer, st *ast.AssignStmt, index *ast.IndexExpr) error {
+	mapVarName, err := c.handleExpr(uasm, out, index.X)
+	if err != nil {
+		return fmt.Errorf("assign: %w", err)
+	}
+	keyVarName, err := c.handleExpr(uasm, out, index.Index)
+	if err != nil {
+		return fmt.Errorf("assign: %w", err)
+	}
+	valueVarName, okVarName, err := c.handleMapIndex(uasm, mapVarName, keyVarName)
+	if err != nil {
+		return fmt.Errorf("assign: %w", err)
+	}
+	srcVarNames := []asm.VarName{valueVarName, okVarName}
+	switch st.Tok {
+	case token.DEFINE:
+		return c.defineVars(uasm, out, st.Pos(), st.Lhs, srcVarNames)
+	case token.ASSIGN:
+		for i, srcVarName := range srcVarNames {
+			err = c.assignTo(uasm, out, st.Lhs[i], srcVarName)
 			if err != nil {
-				return fmt.Errorf("return stmt: %w", err)
+				return err
 			}
+		}
+		return nil
+	}
+	return fmt.Errorf("assign %s: %w", st.Tok, ErrNotImplemented)
+}
+
+// handleIfStmt compiles if, if/else and else if chains.
+// The init statement is scoped to the whole chain, each branch body has its own scope.
+func (c *Compiler) handleIfStmt(uasm *asm.UdonAssembly, out io.Writer, st *ast.IfStmt) error {
+	return c.inBlockScope(func() error {
+		return c.handleIfChain(uasm, out, st)
+	})
+}
+
+func (c *Compiler) handleIfChain(uasm *asm.UdonAssembly, out io.Writer, st *ast.IfStmt) error {
+	if st.Init != nil {
+		err := c.handleStmt(uasm, out, st.Init)
+		if err != nil {
+			return fmt.Errorf("error handling if init: %w", err)
+		}
+	}
+	ifEndLabel := asm.LabelName(uasm.GetNextId("if_end_label"))
+	elseLabel := ifEndLabel
+	if st.Else != nil {
+		elseLabel = asm.LabelName(uasm.GetNextId("else_label"))
+	}
+
+	// if (!test) goto else
+	err := c.handleCond(uasm, out, st.Cond, elseLabel)
+	if err != nil {
+		return fmt.Errorf("error handling if cond: %w", err)
+	}
+	// {}
+	err = c.handleScopedBlock(uasm, out, st.Body)
+	if err != nil {
+		return fmt.Errorf("error handling if body: %w", err)
+	}
+	if st.Else != nil {
+		// goto if_end, unless the body already left
+		if !terminates(st.Body) {
+			uasm.JumpLabel(ifEndLabel)
+		}
+		// else:
+		uasm.AddLabelCurrentAddr(elseLabel)
+		switch e := st.Else.(type) {
+		case *ast.BlockStmt:
+			err = c.handleScopedBlock(uasm, out, e)
+		case *ast.IfStmt:
+			err = c.handleIfStmt(uasm, out, e)
+		default:
+			err = fmt.Errorf("unexpected else %T", st.Else)
+		}
+		if err != nil {
+			return fmt.Errorf("error handling else body: %w", err)
+		}
+	}
+	// if_end:
+	uasm.AddLabelCurrentAddr(ifEndLabel)
+	return nil
+}
+
+// terminates reports whether control never goes from st to the statement after it
+func terminates(st ast.Stmt) bool {
+	switch st := st.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return st.Tok == token.BREAK || st.Tok == token.CONTINUE || st.Tok == token.GOTO
+	case *ast.BlockStmt:
+		live, _ := liveStmts(st.List)
+		return len(live) > 0 && terminates(live[len(live)-1])
+	case *ast.IfStmt:
+		return st.Else != nil && terminates(st.Body) && terminates(st.Else)
+	}
+	return false
+}
 
-			if len(st.Results) > 1 {
-				// tuple return
-				// TODO: Add checks for return type
-				for _, result := range st.Results {
-					retVarName, err := c.handleExpr(uasm, out, result)
+// handleForStmt compiles a for loop, the init statement is scoped to the loop
+func (c *Compiler) handleForStmt(uasm *asm.UdonAssembly, out io.Writer, st *ast.ForStmt) error {
+	err := c.inBlockScope(func() error {
+		return c.handleForLoop(uasm, out, st)
+	})
+	if err != nil {
+		return err
+	}
+	return c.checkLoopCaptures(st)
+}
+
+func (c *Compiler) handleForLoop(uasm *asm.UdonAssembly, out io.Writer, st *ast.ForStmt) error {
+	loopLabel := asm.LabelName(uasm.GetNextId("for_label"))
+	continueLabel := asm.LabelName(uasm.GetNextId("for_continue_label"))
+	endLabel := asm.LabelName(uasm.GetNextId("for_end_label"))
+	if st.Init != nil {
+		err := c.handleStmt(uasm, out, st.Init)
+		if err != nil {
+			return fmt.Errorf("for init: %w", err)
+		}
+	}
+	uasm.AddLabelCurrentAddr(loopLabel)
+	if st.Cond != nil {
+		err := c.handleCond(uasm, out, st.Cond, endLabel)
+		if err != nil {
+			return fmt.Errorf("for cond: %w", err)
+		}
+	}
+	err := c.handleLoopBody(uasm, out, st.Body, endLabel, continueLabel)
+	if err != nil {
+		return err
+	}
+	uasm.AddLabelCurrentAddr(continueLabel)
+	// every iteration has its own copy of the variables of the init statement, as in go 1.22,
+	// which the post statement assigns before the next iteration
+	for _, v := range c.Scope.Vars {
+		v.Scheduled = token.NoPos
+	}
+	if st.Post != nil {
+		err := c.handleStmt(uasm, out, st.Post)
+		if err != nil {
+			return fmt.Errorf("for post: %w", err)
+		}
+	}
+	uasm.JumpLabel(loopLabel)
+	uasm.AddLabelCurrentAddr(endLabel)
+	return nil
+}
+
+func (c *Compiler) handleRangeStmt(uasm *asm.UdonAssembly, out io.Writer, st *ast.RangeStmt) error {
+	xVarName, err := c.handleExpr(uasm, out, st.X)
+	if err != nil {
+		return fmt.Errorf("range: %w", err)
+	}
+	if mt, ok := c.MapOf(xVarName); ok {
+		// the iteration variables are scoped to the loop
+		err := c.inBlockScope(func() error {
+			return c.handleMapRange(uasm, out, st, xVarName, mt)
+		})
+		if err != nil {
+			return err
+		}
+		return c.checkLoopCaptures(st)
+	}
+	return fmt.Errorf("range over %s: %w", xVarName, ErrNotImplemented)
+}
+
+// rangeAssign assigns an iteration variable of a range statement
+func (c *Compiler) rangeAssign(uasm *asm.UdonAssembly, out io.Writer, tok token.Token, lhs ast.Expr, srcVarName asm.VarName) error {
+	if tok == token.DEFINE {
+		return c.defineVar(uasm, lhs, srcVarName)
+	}
+	return c.assignTo(uasm, out, lhs, srcVarName)
+}
+
+// handleLoopBody compiles the body of a loop, with break and continue jumping to the given labels
+func (c *Compiler) handleLoopBody(uasm *asm.UdonAssembly, out io.Writer, body *ast.BlockStmt, breakLabel asm.LabelName, continueLabel asm.LabelName) error {
+	outerBreakLabel, outerContinueLabel := c.CurrentBreakLabel, c.CurrentContinueLabel
+	c.CurrentBreakLabel, c.CurrentContinueLabel = &breakLabel, &continueLabel
+	err := c.handleScopedBlock(uasm, out, body)
+	c.CurrentBreakLabel, c.CurrentContinueLabel = outerBreakLabel, outerContinueLabel
+	return err
+}
+
+func (c *Compiler) handleBranchStmt(uasm *asm.UdonAssembly, st *ast.BranchStmt) error {
+	if st.Label != nil {
+		return fmt.Errorf("labeled %s: %w", st.Tok, ErrNotImplemented)
+	}
+	switch st.Tok {
+	case token.BREAK:
+		if c.CurrentBreakLabel == nil {
+			return errors.New("break is not in a loop")
+		}
+		uasm.JumpLabel(*c.CurrentBreakLabel)
+	case token.CONTINUE:
+		if c.CurrentContinueLabel == nil {
+			return errors.New("continue is not in a loop")
+		}
+		uasm.JumpLabel(*c.CurrentContinueLabel)
+	default:
+		return fmt.Errorf("%s: %w", st.Tok, ErrNotImplemented)
+	}
+	return nil
+}
+
+// handleDeclStmt declares function local variables, e.g. var p *Node
+func (c *Compiler) handleDeclStmt(uasm *asm.UdonAssembly, out io.Writer, st *ast.DeclStmt) error {
+	decl, ok := st.Decl.(*ast.GenDecl)
+	if !ok || decl.Tok != token.VAR {
+		return fmt.Errorf("declaration %T: %w", st.Decl, ErrNotImplemented)
+	}
+	for _, s := range decl.Specs {
+		spec := s.(*ast.ValueSpec)
+		if len(spec.Values) != 0 && len(spec.Values) != len(spec.Names) {
+			return fmt.Errorf("unsupported # of values: %v", spec.Names)
+		}
+		var typeName asm.UdonTypeName
+		var info *TypeInfo
+		if spec.Type != nil {
+			var err error
+			typeName, info, err = c.ResolveType(spec.Type)
+			if err != nil {
+				return fmt.Errorf("var %s: %w", spec.Names[0].Name, err)
+			}
+		}
+		for i, name := range spec.Names {
+			var srcVarName asm.VarName
+			var err error
+			if len(spec.Values) > 0 {
+				srcVarName, err = c.handleExpr(uasm, out, spec.Values[i])
+				if err != nil {
+					return fmt.Errorf("var %s: %w", name.Name, err)
+				}
+				if spec.Type != nil {
+					srcVarName, err = c.declaredValue(uasm, spec.Values[i].Pos(), srcVarName, typeName, info)
 					if err != nil {
-						return fmt.Errorf("handle expr: %w", err)
+						return err
 					}
-					uasm.PushVar(retVarName)
 				}
-			} else if len(st.Results) == 1 {
-				// standard return
-				// TODO: Add checks for return type
-				retVarName, err := c.handleExpr(uasm, out, st.Results[0])
+			} else {
+				srcVarName, err = c.zeroValue(uasm, typeName)
 				if err != nil {
-					return fmt.Errorf("handle expr: %w", err)
+					return fmt.Errorf("var %s: %w", name.Name, err)
 				}
-				uasm.PushVar(retVarName)
-			} else {
-				// void
-				// TODO: Add checks for return type
+				c.SetTypeInfo(srcVarName, info)
 			}
-			uasm.JumpRetAddr()
-		case *ast.IfStmt:
-			// fmt.Println("handle ast.IfStmt")
-			elseLabel := asm.LabelName(uasm.GetNextId("else_label"))
-			ifEndLabel := asm.LabelName(uasm.GetNextId("if_end_label"))
-
-			condVarName, err := c.handleExpr(uasm, out, st.Cond)
+			err = c.defineVar(uasm, name, srcVarName)
 			if err != nil {
-				return fmt.Errorf("error handling if cond: %v", err)
+				return err
 			}
+		}
+	}
+	return nil
+}
 
-			uasm.PushVar(condVarName)
-			// if (!test) goto else
-			uasm.JumpIfFalseLabel(elseLabel)
-			// {}
-			err = c.handleBlockStmt(uasm, out, st.Body)
-			if err != nil {
-				return fmt.Errorf("error handling if body: %v", err)
-			}
-			// goto if_end
-			uasm.JumpLabel(ifEndLabel)
-			// else:
-			uasm.AddLabelCurrentAddr(elseLabel)
-			err = c.handleBlockStmt(uasm, out, st.Else.(*ast.BlockStmt))
+// zeroLiteral returns the initial value of a heap variable holding the zero value of typeName,
+// the types which are not go basic types start as null
+func zeroLiteral(typeName asm.UdonTypeName) string {
+	switch {
+	case typeName == asm.UdonTypeString:
+		return `""`
+	case typeName == asm.UdonTypeBoolean:
+		return "false"
+	case numericTypes[typeName]:
+		return "0"
+	}
+	return "null"
+}
+
+// zeroValue returns a constant holding the zero value of typeName
+func (c *Compiler) zeroValue(uasm *asm.UdonAssembly, typeName asm.UdonTypeName) (asm.VarName, error) {
+	constNextID := uasm.GetNextId("const_zero")
+	err := uasm.VarTable.AddVar(constNextID, typeName, zeroLiteral(typeName))
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	return constNextID, nil
+}
+
+// declaredValue returns srcVarName as the value of a variable declared with typeName,
+// a value of another udon type such as nil for a pointer is copied to a variable of the type
+func (c *Compiler) declaredValue(uasm *asm.UdonAssembly, pos token.Pos, srcVarName asm.VarName, typeName asm.UdonTypeName, info *TypeInfo) (asm.VarName, error) {
+	srcVarName, err := c.assignable(uasm, pos, srcVarName, typeName, "variable declaration")
+	if err != nil {
+		return "", err
+	}
+	srcType, err := uasm.VarTable.GetVarType(srcVarName)
+	if err != nil || srcType == typeName {
+		return srcVarName, err
+	}
+	varName, err := c.zeroValue(uasm, typeName)
+	if err != nil {
+		return "", err
+	}
+	c.SetTypeInfo(varName, info)
+	return varName, uasm.Assign(varName, srcVarName)
+}
+
+// assignable returns srcVarName as a value of typeName for an assignment, context tells which one:
+// untyped constants take the type and values of another basic type are an error at pos
+func (c *Compiler) assignable(uasm *asm.UdonAssembly, pos token.Pos, srcVarName asm.VarName, typeName asm.UdonTypeName, context string) (asm.VarName, error) {
+	srcVarName, err := c.untypedAs(uasm, srcVarName, typeName)
+	if err != nil {
+		return "", c.errorf(pos, "%v", err)
+	}
+	srcType, err := uasm.VarTable.GetVarType(srcVarName)
+	if err != nil {
+		return "", err
+	}
+	if srcType != typeName && (isValueType(srcType) || isValueType(typeName)) {
+		return "", c.errorf(pos, "cannot use %s as %s in %s", srcType, typeName, context)
+	}
+	return srcVarName, nil
+}
+
+// defineVar declares the identifier lhs in the current scope and assigns src to it
+func (c *Compiler) defineVar(uasm *asm.UdonAssembly, lhs ast.Expr, srcVarName asm.VarName) error {
+	ident, ok := lhs.(*ast.Ident)
+	if !ok {
+		return fmt.Errorf("define: unsupported lhs: %T", lhs)
+	}
+	if ident.Name == "_" {
+		return nil
+	}
+	dstVarName, err := c.declare(uasm, ident)
+	if err != nil {
+		return err
+	}
+	c.SetTypeInfo(dstVarName, c.VarTypes[srcVarName])
+	return uasm.Assign(dstVarName, srcVarName)
+}
+
+// defineVars compiles a := with several variables on the left, which redeclares the ones
+// already declared in the same scope as long as at least one of them is new
+func (c *Compiler) defineVars(uasm *asm.UdonAssembly, out io.Writer, pos token.Pos, lhs []ast.Expr, srcVarNames []asm.VarName) error {
+	isNew := make([]bool, len(lhs))
+	anyNew := false
+	for i, l := range lhs {
+		ident, ok := l.(*ast.Ident)
+		if !ok {
+			return c.errorf(l.Pos(), "non-name on left side of :=")
+		}
+		if _, declared := c.Scope.Vars[ident.Name]; !declared && ident.Name != "_" {
+			isNew[i] = true
+			anyNew = true
+		}
+	}
+	if !anyNew {
+		return c.errorf(pos, "no new variables on left side of :=")
+	}
+	for i, l := range lhs {
+		var err error
+		if isNew[i] {
+			err = c.defineVar(uasm, l, srcVarNames[i])
+		} else {
+			err = c.assignTo(uasm, out, l, srcVarNames[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assignTo stores src in an existing variable, struct field or map entry
+func (c *Compiler) assignTo(uasm *asm.UdonAssembly, out io.Writer, lhs ast.Expr, srcVarName asm.VarName) error {
+	switch l := lhs.(type) {
+	case *ast.Ident:
+		if l.Name == "_" {
+			return nil
+		}
+		dstVarName, err := c.lookupVar(l, false)
+		if err != nil {
+			return err
+		}
+		return c.assignVar(uasm, l.Pos(), dstVarName, srcVarName)
+	case *ast.SelectorExpr:
+		if dstVarName, ok, err := c.importedVar(l, false); ok {
 			if err != nil {
-				return fmt.Errorf("error handling else body: %v", err)
+				return err
 			}
-			// if_end:
-			uasm.AddLabelCurrentAddr(ifEndLabel)
+			return c.assignVar(uasm, l.Pos(), dstVarName, srcVarName)
+		}
+		ptrVarName, err := c.handleExpr(uasm, out, l.X)
+		if err != nil {
+			return fmt.Errorf("assign: %w", err)
+		}
+		return c.assignSelector(uasm, out, l, ptrVarName, srcVarName)
+	case *ast.IndexExpr:
+		mapVarName, err := c.handleExpr(uasm, out, l.X)
+		if err != nil {
+			return fmt.Errorf("assign: %w", err)
+		}
+		keyVarName, err := c.handleExpr(uasm, out, l.Index)
+		if err != nil {
+			return fmt.Errorf("assign: %w", err)
+		}
+		return c.handleMapSet(uasm, mapVarName, keyVarName, srcVarName)
+	}
+	return fmt.Errorf("assign: unsupported lhs: %T", lhs)
+}
 
-		case *ast.ForStmt:
-		default:
-			fmt.Printf("unsupported statement: %v", s)
-			return nil
+// assignSelector stores src in the field, behaviour variable or property sel of the value ptrVarName sel.X evaluated to
+func (c *Compiler) assignSelector(uasm *asm.UdonAssembly, out io.Writer, sel *ast.SelectorExpr, ptrVarName asm.VarName, srcVarName asm.VarName) error {
+	if bt, ok := c.BehaviourOf(ptrVarName); ok {
+		return c.handleProgramVarSet(uasm, ptrVarName, bt, sel.Sel, srcVarName)
+	}
+	st, ok := c.StructOf(ptrVarName)
+	if !ok {
+		err := c.handlePropertySet(uasm, ptrVarName, sel.Sel, srcVarName)
+		if err != nil {
+			return err
 		}
+		return c.writeBack(uasm, out, sel.X, ptrVarName)
 	}
-	return nil
+	return c.handleFieldSet(uasm, out, ptrVarName, st, sel.Sel.Name, srcVarName)
 }
 
-func (c *Compiler) handleCallExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr) (asm.VarName, error) {
-	id := expr.Fun.(*ast.Ident)
+// assignVar copies src to the heap variable of a go variable, checking the type of src against it
+func (c *Compiler) assignVar(uasm *asm.UdonAssembly, pos token.Pos, dstVarName asm.VarName, srcVarName asm.VarName) error {
+	dstType, err := uasm.VarTable.GetVarType(dstVarName)
+	if err != nil {
+		return err
+	}
+	srcVarName, err = c.assignable(uasm, pos, srcVarName, dstType, "assignment")
+	if err != nil {
+		return err
+	}
+	return uasm.Assign(dstVarName, srcVarName)
+}
+
+// callExtern emits a call to the extern method and returns the variable holding its result.
+// instance is empty for static functions and constructors; the result is empty for void externs.
+func (c *Compiler) callExtern(
+	uasm *asm.UdonAssembly,
+	methodKind asm.UdonMethodKind,
+	moduleType asm.UdonTypeName,
+	methodName asm.UdonMethodName,
+	instance asm.VarName,
+	args []asm.VarName,
+) (asm.VarName, error) {
 	argTypes := []asm.UdonTypeName{}
+	for _, arg := range args {
+		argType, err := uasm.VarTable.GetVarType(arg)
+		if err != nil {
+			return "", err
+		}
+		argTypes = append(argTypes, argType)
+	}
+	retType, externStr, err := uasm.MethodTable.FindExtern(methodKind, moduleType, methodName, argTypes)
+	if err != nil {
+		return "", c.errorf(c.Pos, "%v", err)
+	}
+	pushVars := []asm.VarName{}
+	if instance != "" {
+		pushVars = append(pushVars, instance)
+	}
+	pushVars = append(pushVars, args...)
+	return c.emitExtern(uasm, externStr, retType, pushVars)
+}
+
+// emitExtern pushes the operands and a return slot of retType unless it is void, then calls the extern
+func (c *Compiler) emitExtern(uasm *asm.UdonAssembly, externStr asm.ExternStr, retType asm.UdonTypeName, pushVars []asm.VarName) (asm.VarName, error) {
+	var retVarName asm.VarName
+	if retType != asm.UdonTypeVoid {
+		retVarName = uasm.GetNextId("extern_ret")
+		err := uasm.VarTable.AddVar(retVarName, retType, "null")
+		if err != nil {
+			return "", fmt.Errorf("add var: %w", err)
+		}
+		pushVars = append(pushVars, retVarName)
+	}
+	err := uasm.CallExtern(externStr, pushVars)
+	if err != nil {
+		return "", c.errorf(c.Pos, "%v", err)
+	}
+	if c.Exprs != nil && c.Expr != nil {
+		c.exprInfo(c.Expr).Extern = externStr
+	}
+	return retVarName, nil
+}
+
+// exprInfo returns what is recorded of an expression, recording it
+func (c *Compiler) exprInfo(e ast.Expr) *ExprInfo {
+	info, ok := c.Exprs[e]
+	if !ok {
+		info = &ExprInfo{}
+		c.Exprs[e] = info
+	}
+	return info
+}
+
+// recordExpr records the type of the value an expression was compiled to
+func (c *Compiler) recordExpr(uasm *asm.UdonAssembly, e ast.Expr, varName asm.VarName) {
+	info := c.exprInfo(e)
+	if varName == "" {
+		return
+	}
+	if typeName, err := uasm.VarTable.GetVarType(varName); err == nil {
+		info.Type = typeName
+	}
+	info.Info = c.VarTypes[varName]
+}
+
+// toObject copies varName into a SystemObject variable, for externs taking a SystemObject
+func (c *Compiler) toObject(uasm *asm.UdonAssembly, varName asm.VarName) (asm.VarName, error) {
+	typeName, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	if typeName == asm.UdonTypeObject {
+		return varName, nil
+	}
+	objVarName := uasm.GetNextId("obj")
+	err = uasm.VarTable.AddVar(objVarName, asm.UdonTypeObject, "null")
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	uasm.Assign(objVarName, varName)
+	return objVarName, nil
+}
+
+func (c *Compiler) handleCallExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr) (asm.VarName, error) {
+	typeName, ok, err := c.conversionType(expr.Fun)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return c.handleConversion(uasm, out, expr, typeName)
+	}
+	if sel, ok := expr.Fun.(*ast.SelectorExpr); ok {
+		return c.handlePkgCallExpr(uasm, out, sel, expr.Args)
+	}
+	id, ok := expr.Fun.(*ast.Ident)
+	if !ok {
+		return "", fmt.Errorf("call %T: %w", expr.Fun, ErrNotImplemented)
+	}
+	switch id.Name {
+	case "new":
+		return c.handleNewExpr(uasm, out, expr)
+	case "make":
+		return c.handleMakeExpr(uasm, out, expr)
+	case "len":
+		return c.handleLenExpr(uasm, out, expr)
+	case "delete":
+		return c.handleDeleteExpr(uasm, out, expr)
+	}
+	argVarNames := []asm.VarName{}
 	for _, arg := range expr.Args {
-		switch a := arg.(type) {
-		case *ast.Ident:
-			typeName, err := uasm.VarTable.GetVarType(asm.VarName(a.Obj.Name))
-			if err != nil {
-				return "", err
-			}
-			argTypes = append(argTypes, typeName)
-		case *ast.BasicLit:
-			switch a.Kind {
-			case token.INT:
-				argTypes = append(argTypes, asm.UdonTypeInt32)
-			}
+		argVarName, err := c.handleExpr(uasm, out, arg)
+		if err != nil {
+			return "", fmt.Errorf("call %s: %w", id.Name, err)
+		}
+		argVarNames = append(argVarNames, argVarName)
+	}
+	return c.callFunc(uasm, out, id.Pos(), asm.FuncName(c.qualify(id.Name)), id.Name, argVarNames)
+}
+
+// callFunc calls a function of the program at pos, name is how the source refers to it.
+// Small functions are inlined instead.
+func (c *Compiler) callFunc(uasm *asm.UdonAssembly, out io.Writer, pos token.Pos, funcName asm.FuncName, name string, argVarNames []asm.VarName) (asm.VarName, error) {
+	fn, err := c.inlinable(funcName)
+	if err != nil {
+		return "", err
+	}
+	if fn != nil {
+		return c.inlineCall(uasm, out, fn, funcName, name, argVarNames)
+	}
+	if c.recursive(funcName) {
+		// the parameters and locals of a function have one heap variable each, a nested call would overwrite them
+		return "", c.errorf(pos, "call %s: recursive functions are not supported", name)
+	}
+	retVarName, err := uasm.CallDefFunc(funcName, argVarNames)
+	if err != nil {
+		return "", fmt.Errorf("call %s: %w", name, err)
+	}
+	if retVarName == nil {
+		return "", nil
+	}
+	c.SetTypeInfo(*retVarName, c.FuncRetTypes[funcName])
+	return *retVarName, nil
+}
+
+// handleImportedCallExpr compiles a call to a function of an imported helper package
+func (c *Compiler) handleImportedCallExpr(uasm *asm.UdonAssembly, out io.Writer, pkg *Package, sel *ast.SelectorExpr, args []ast.Expr) (asm.VarName, error) {
+	name := fmt.Sprintf("%s.%s", sel.X.(*ast.Ident).Name, sel.Sel.Name)
+	argVarNames := []asm.VarName{}
+	for _, arg := range args {
+		argVarName, err := c.handleExpr(uasm, out, arg)
+		if err != nil {
+			return "", fmt.Errorf("call %s: %w", name, err)
 		}
+		argVarNames = append(argVarNames, argVarName)
 	}
-	uasm.FuncTable.GetFunctionID(asm.FuncName(id.Name), argTypes)
-	return "", nil
+	return c.callFunc(uasm, out, sel.Pos(), asm.FuncName(pkg.qualify(sel.Sel.Name)), name, argVarNames)
 }
 
-func (c *Compiler) handleBinaryExpr(uasm *asm.UdonAssembly, out io.Writer, be *ast.BinaryExpr) (asm.VarName, error) {
-	return "", fmt.Errorf("%s: %w", "binaryExpr", ErrNotImplemented)
+// handlePkgCallExpr compiles calls to the stub functions of the asm package and the supported standard library
+func (c *Compiler) handlePkgCallExpr(uasm *asm.UdonAssembly, out io.Writer, sel *ast.SelectorExpr, args []ast.Expr) (asm.VarName, error) {
+	if imported, ok, err := c.importedName(sel); ok {
+		if err != nil {
+			return "", err
+		}
+		return c.handleImportedCallExpr(uasm, out, imported, sel, args)
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return c.handleMethodCallExpr(uasm, out, sel, args)
+	}
+	if _, isVar := c.Scope.Lookup(pkg.Name); isVar {
+		return c.handleMethodCallExpr(uasm, out, sel, args)
+	}
+	switch pkg.Name {
+	case "asm":
+	case "strconv":
+		return c.handleStrconvCallExpr(uasm, out, sel.Sel.Name, args)
+	case "fmt":
+		return c.handleFmtCallExpr(uasm, out, sel.Sel.Name, args)
+	case "udon":
+		return c.handleUdonCallExpr(uasm, out, sel.Sel.Name, args)
+	default:
+		return "", fmt.Errorf("call %s.%s: %w", pkg.Name, sel.Sel.Name, ErrNotImplemented)
+	}
+	switch sel.Sel.Name {
+	case "Log":
+		if len(args) != 1 {
+			return "", fmt.Errorf("asm.Log: unsupported # of args: %d", len(args))
+		}
+		msgVarName, err := c.handleExpr(uasm, out, args[0])
+		if err != nil {
+			return "", fmt.Errorf("asm.Log: %w", err)
+		}
+		objVarName, err := c.toObject(uasm, msgVarName)
+		if err != nil {
+			return "", err
+		}
+		return c.callExtern(uasm, asm.STATIC_FUNC, asm.UdonTypeDebug, "Log", "", []asm.VarName{objVarName})
+	}
+	return "", fmt.Errorf("call asm.%s: %w", sel.Sel.Name, ErrNotImplemented)
 }
 
 func (c *Compiler) handleUnaryExpr(uasm *asm.UdonAssembly, out io.Writer, ue *ast.UnaryExpr) (asm.VarName, error) {
-	return "", fmt.Errorf("%s: %w", "UnaryExpr", ErrNotImplemented)
+	switch ue.Op {
+	case token.AND:
+		if lit, ok := ue.X.(*ast.CompositeLit); ok {
+			return c.handleStructLit(uasm, out, lit)
+		}
+		return "", fmt.Errorf("taking the address of %T: %w", ue.X, ErrNotImplemented)
+	case token.SUB:
+		// negative literals are constants
+		if lit, ok := ue.X.(*ast.BasicLit); ok && (lit.Kind == token.INT || lit.Kind == token.FLOAT) {
+			return c.handleBasicLit(uasm, out, &ast.BasicLit{ValuePos: ue.OpPos, Kind: lit.Kind, Value: "-" + lit.Value})
+		}
+	case token.ARROW:
+		return "", fmt.Errorf("channels: %w", ErrNotImplemented)
+	}
+	xVarName, err := c.handleExpr(uasm, out, ue.X)
+	if err != nil {
+		return "", err
+	}
+	return c.unaryOp(uasm, ue.Op, xVarName)
 }
 func (c *Compiler) handleFuncType(uasm *asm.UdonAssembly, out io.Writer, lit *ast.FuncType) (asm.VarName, error) {
 	return "", fmt.Errorf("%s: %w", "FuncType", ErrNotImplemented)
@@ -298,19 +1316,46 @@ func (c *Compiler) handleFuncLit(uasm *asm.UdonAssembly, out io.Writer, lit *ast
 	return "", fmt.Errorf("%s: %w", "FuncLit", ErrNotImplemented)
 }
 func (c *Compiler) handleIdent(uasm *asm.UdonAssembly, out io.Writer, ident *ast.Ident) (asm.VarName, error) {
-	constNextID := uasm.GetNextId(ident.Name)
-	return constNextID, nil
+	if _, ok := c.Scope.Lookup(ident.Name); ok {
+		return c.lookupVar(ident, true)
+	}
+	switch ident.Name {
+	case "nil":
+		constNextID := uasm.GetNextId("const_nil")
+		uasm.VarTable.AddVar(constNextID, asm.UdonTypeObject, "null")
+		return constNextID, nil
+	case "true", "false":
+		constNextID := uasm.GetNextId("const_bool")
+		uasm.VarTable.AddVar(constNextID, asm.UdonTypeBoolean, ident.Name)
+		return constNextID, nil
+	}
+	return c.lookupVar(ident, true)
 }
 
 func (c *Compiler) handleBasicLit(uasm *asm.UdonAssembly, out io.Writer, lit *ast.BasicLit) (asm.VarName, error) {
 	constNextID := uasm.GetNextId("const")
-	if lit.Kind == token.INT {
+	switch lit.Kind {
+	case token.INT:
 		uasm.VarTable.AddVar(constNextID, asm.UdonTypeInt32, lit.Value)
+	case token.FLOAT:
+		uasm.VarTable.AddVar(constNextID, asm.UdonTypeSingle, lit.Value)
+	case token.STRING:
+		uasm.VarTable.AddVar(constNextID, asm.UdonTypeString, lit.Value)
+	default:
+		return "", fmt.Errorf("unsupported literal: %s", lit.Kind)
+	}
+	if lit.Kind != token.STRING {
+		c.UntypedConsts[constNextID] = lit.Value
 	}
 	return constNextID, nil
 }
 
-func (c *Compiler) handleExpr(uasm *asm.UdonAssembly, out io.Writer, e ast.Expr) (asm.VarName, error) {
+func (c *Compiler) handleExpr(uasm *asm.UdonAssembly, out io.Writer, e ast.Expr) (varName asm.VarName, err error) {
+	defer func(pos token.Pos, expr ast.Expr) { c.Pos, c.Expr = pos, expr }(c.Pos, c.Expr)
+	c.Pos, c.Expr = e.Pos(), e
+	if c.Exprs != nil {
+		defer func() { c.recordExpr(uasm, e, varName) }()
+	}
 	switch expr := e.(type) {
 	case *ast.Ident:
 		// fmt.Println("expr: Ident")
@@ -326,19 +1371,37 @@ func (c *Compiler) handleExpr(uasm *asm.UdonAssembly, out io.Writer, e ast.Expr)
 		return c.handleCallExpr(uasm, out, expr)
 	case *ast.BinaryExpr:
 		// fmt.Println("expr: BinaryExpr")
-		c.handleExpr(uasm, out, expr.X)
-		return c.handleExpr(uasm, out, expr.Y)
+		return c.handleBinaryExpr(uasm, out, expr)
+	case *ast.ParenExpr:
+		return c.handleExpr(uasm, out, expr.X)
+	case *ast.SelectorExpr:
+		return c.handleSelectorExpr(uasm, out, expr)
 	case *ast.CompositeLit:
 		// fmt.Println("expr: CompositeLit")
+		if mapType, ok := expr.Type.(*ast.MapType); ok {
+			return c.handleMapLit(uasm, out, expr, mapType)
+		}
+		return "", errors.New("struct values are not supported, use a pointer")
+	case *ast.IndexExpr:
+		mapVarName, err := c.handleExpr(uasm, out, expr.X)
+		if err != nil {
+			return "", err
+		}
+		keyVarName, err := c.handleExpr(uasm, out, expr.Index)
+		if err != nil {
+			return "", err
+		}
+		valueVarName, _, err := c.handleMapIndex(uasm, mapVarName, keyVarName)
+		return valueVarName, err
+	case *ast.StarExpr:
+		return "", errors.New("dereferencing struct pointers is not supported")
 	case *ast.UnaryExpr:
 		// fmt.Println("expr: UnaryExpr")
-		return "", fmt.Errorf("UnaryExpr: %w", ErrNotImplemented)
+		return c.handleUnaryExpr(uasm, out, expr)
 	case *ast.BasicLit:
 		// fmt.Println("expr: BasicLit")
 		return c.handleBasicLit(uasm, out, expr)
 	default:
-		fmt.Printf("unsupported expression: %v", expr)
-		return "", nil
+		return "", fmt.Errorf("unsupported expression: %T", expr)
 	}
-	return "", nil
 }
@@ -0,0 +1,2147 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"udon-go/asm"
+	"udon-go/vm"
+)
+
+var methodTable struct {
+	once  sync.Once
+	table asm.MethodMap
+	err   error
+}
+
+func compileSource(t *testing.T, src string) (string, error) {
+	t.Helper()
+	return newTestCompiler(t).MakeUASMCode(ioutil.Discard, strings.NewReader(src))
+}
+
+// newTestCompiler returns a compiler sharing the method table of the tests
+func newTestCompiler(t *testing.T) *UdonCompiler {
+	t.Helper()
+	// the method table is read only, parse it once for all tests
+	methodTable.once.Do(func() {
+		f, err := os.Open("./asm/udon_funcs_data.txt")
+		if err != nil {
+			methodTable.err = err
+			return
+		}
+		defer f.Close()
+		methodTable.table, methodTable.err = asm.NewUdonMethodTable(f)
+	})
+	if methodTable.err != nil {
+		t.Fatalf("method table: %s", methodTable.err)
+	}
+	uasm, err := asm.NewUdonAssembly(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("new udon assembly: %s", err)
+	}
+	uasm.MethodTable = methodTable.table
+	return &UdonCompiler{UASM: uasm}
+}
+
+func TestFunctions(t *testing.T) {
+	src := `package main
+
+import "udon-go/asm"
+
+func add(x int, y int) int {
+	return x + y
+}
+
+func main() {
+	a := add(100, 1000)
+	asm.Log(a)
+}
+`
+	code, err := compileSource(t, src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	for _, want := range []string{
+		`add__SystemInt32_SystemInt32_x: %SystemInt32, null`,
+		`EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"`,
+		`EXTERN, "UnityEngineDebug.__Log__SystemObject__SystemVoid"`,
+		`JUMP_INDIRECT, ret_addr`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %s in:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "###") {
+		t.Errorf("unresolved label in:\n%s", code)
+	}
+}
+
+func TestStructPointers(t *testing.T) {
+	src := `package main
+
+type Node struct {
+	Value int
+	Next  *Node
+}
+
+func main() {
+	n := new(Node)
+	n.Value = 1
+	n.Value -= 2
+	m := &Node{Value: 2, Next: n}
+	v := m.Next.Value
+	if m.Next == nil {
+		v = 0
+	} else {
+		v = 1
+	}
+	_ = v
+}
+`
+	code, err := compileSource(t, src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	for _, want := range []string{
+		`EXTERN, "SystemObjectArray.__ctor__SystemInt32__SystemObjectArray"`,
+		`EXTERN, "SystemObjectArray.__Set__SystemInt32_SystemObject__SystemVoid"`,
+		`EXTERN, "SystemObjectArray.__Get__SystemInt32__SystemObject"`,
+		`EXTERN, "SystemConvert.__ToInt32__SystemObject__SystemInt32"`,
+		`EXTERN, "SystemInt32.__op_Subtraction__SystemInt32_SystemInt32__SystemInt32"`,
+		`EXTERN, "SystemObject.__op_Equality__SystemObject_SystemObject__SystemBoolean"`,
+		`_start_n: %SystemObjectArray, null`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %s in:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "###") {
+		t.Errorf("unresolved label in:\n%s", code)
+	}
+}
+
+func TestStructFieldConstants(t *testing.T) {
+	src := `package main
+type P struct {
+	F float64
+}
+var X float64
+func main() {
+	p := &P{F: 1}
+	X = p.F
+	p.F = 2
+	p.F += 0.5
+	X += p.F
+}
+`
+	_, vars, _ := emulate(t, src, Options{}, []asm.EventName{"_start"}, 0)
+	if x := vars["X"]; x != float64(3.5) {
+		t.Errorf("got X %v, want 3.5", x)
+	}
+}
+
+func TestStructPointerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"struct value", "package main\ntype T struct{ A int }\nfunc f(t T) {}\nfunc main() { f(nil) }\n", "struct values are not supported"},
+		{"unknown field", "package main\ntype T struct{ A int }\nfunc main() { t := new(T); t.B = 1 }\n", "T has no field B"},
+		{"field type", "package main\ntype T struct{ A int }\nfunc main() { t := new(T); t.A = \"a\" }\n", "cannot use SystemString as SystemInt32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestProperties(t *testing.T) {
+	src := `package main
+
+import "udon-go/asm"
+
+var T asm.Transform
+
+func main() {
+	p := T.position
+	p.x += 1
+	p.y = 2
+	T.position = p
+	n := T.childCount
+	_ = n
+}
+`
+	code, err := compileSource(t, src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	for _, want := range []string{
+		`EXTERN, "UnityEngineTransform.__get_position__UnityEngineVector3"`,
+		`EXTERN, "UnityEngineVector3.__get_x__SystemSingle"`,
+		`EXTERN, "UnityEngineVector3.__set_x__SystemSingle"`,
+		`EXTERN, "UnityEngineVector3.__set_y__SystemSingle"`,
+		`%SystemSingle, 2`,
+		`EXTERN, "UnityEngineTransform.__set_position__UnityEngineVector3__SystemVoid"`,
+		`EXTERN, "UnityEngineTransform.__get_childCount__SystemInt32"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %s in:\n%s", want, code)
+		}
+	}
+}
+
+func TestPropertyWriteBack(t *testing.T) {
+	src := `package main
+
+import "udon-go/asm"
+
+var T asm.Transform
+
+func main() {
+	T.position.x = 2.5
+	T.root.position = T.position
+}
+`
+	code, err := compileSource(t, src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	// the x of a copy of the position is set, then the copy is written back
+	at := 0
+	for _, want := range []string{
+		`EXTERN, "UnityEngineTransform.__get_position__UnityEngineVector3"`,
+		`EXTERN, "UnityEngineVector3.__set_x__SystemSingle"`,
+		`EXTERN, "UnityEngineTransform.__set_position__UnityEngineVector3__SystemVoid"`,
+		`EXTERN, "UnityEngineTransform.__get_root__UnityEngineTransform"`,
+		`EXTERN, "UnityEngineTransform.__set_position__UnityEngineVector3__SystemVoid"`,
+	} {
+		i := strings.Index(code[at:], want)
+		if i < 0 {
+			t.Fatalf("missing %s after %d in:\n%s", want, at, code)
+		}
+		at += i + len(want)
+	}
+	// the root has no setter, it is a reference
+	if strings.Contains(code[at:], "EXTERN") {
+		t.Errorf("unexpected externs after the root position is set:\n%s", code[at:])
+	}
+}
+
+func TestPropertyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown property", "_ = T.frobnicator", "UnityEngineTransform has no field or property frobnicator"},
+		{"read only", "T.childCount = 1", "property childCount of UnityEngineTransform cannot be written"},
+		{"value type", "T.position = 1", "cannot use SystemInt32 as UnityEngineVector3 in assignment to property position"},
+		{"not a udon object", "x := 1; _ = x.y", "SystemInt32 has no field or property y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package main\nimport \"udon-go/asm\"\nvar T asm.Transform\nfunc main() {\n" + tt.body + "\n}\n"
+			_, err := compileSource(t, src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaps(t *testing.T) {
+	src := `package main
+
+func main() {
+	m := map[string]int{"a": 1}
+	m["b"] = 2
+	v, ok := m["a"]
+	delete(m, "a")
+	for k, v := range m {
+		_ = k
+		_ = v
+	}
+	_ = v
+	_ = ok
+	_ = len(m)
+}
+`
+	code, err := compileSource(t, src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	for _, want := range []string{
+		`EXTERN, "SystemObject.__GetHashCode__SystemInt32"`,
+		`EXTERN, "SystemObject.__Equals__SystemObject_SystemObject__SystemBoolean"`,
+		`EXTERN, "SystemObject.__ReferenceEquals__SystemObject_SystemObject__SystemBoolean"`,
+		`EXTERN, "SystemConvert.__ToInt32__SystemObject__SystemInt32"`,
+		`# map runtime`,
+		`_start_m: %SystemObjectArray, null`,
+		`_start_ok: %SystemBoolean, null`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %s in:\n%s", want, code)
+		}
+	}
+	if strings.Contains(code, "###") {
+		t.Errorf("unresolved label in:\n%s", code)
+	}
+}
+
+func TestMapRuntimeOnlyWhenUsed(t *testing.T) {
+	code, err := compileSource(t, "package main\nfunc main() {}\n")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if strings.Contains(code, "map runtime") {
+		t.Errorf("map runtime emitted without maps:\n%s", code)
+	}
+}
+
+func TestNilMap(t *testing.T) {
+	src := `package main
+
+import "udon-go/asm"
+import "strconv"
+
+var M map[string]int
+
+func main() {
+	n := M["a"]
+	_, ok := M["a"]
+	delete(M, "a")
+	for k := range M {
+		asm.Log(k)
+	}
+	asm.Log(strconv.Itoa(n) + " " + strconv.Itoa(len(M)))
+	if !ok {
+		asm.Log("missing")
+	}
+}
+`
+	log, _, _ := emulate(t, src, Options{}, []asm.EventName{"_start"}, 0)
+	want := []string{"0 0", "missing"}
+	if !reflect.DeepEqual(log, want) {
+		t.Errorf("got log %q, want %q", log, want)
+	}
+
+	code, err := compileSource(t, "package main\nvar M map[string]int\nfunc main() {\nM[\"a\"]++\n}\n")
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if _, err := asm.ParseProgram(code); err != nil {
+		t.Errorf("parse: %v", err)
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"key type", "package main\nfunc main() { m := make(map[string]int); m[1] = 1 }\n", "cannot use SystemInt32 as map key of type SystemString"},
+		{"value type", "package main\nfunc main() { m := make(map[string]int); m[\"a\"] = \"b\" }\n", "cannot use SystemString as map value of type SystemInt32"},
+		{"map key", "package main\nfunc main() { m := make(map[map[int]int]int); _ = m }\n", "maps are not comparable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"int to string", "b := 1\ns := string(b)\n_ = s", []string{`EXTERN, "SystemConvert.__ToString__SystemInt32__SystemString"`}},
+		{"strconv.Itoa", "s := strconv.Itoa(1)\n_ = s", []string{`EXTERN, "SystemConvert.__ToString__SystemInt32__SystemString"`}},
+		{"widening", "var b byte\ni := int(b)\n_ = i", []string{`EXTERN, "SystemConvert.__ToInt32__SystemByte__SystemInt32"`}},
+		{"truncation", "f := 1.5\ni := int(f)\n_ = i", []string{
+			`EXTERN, "SystemConvert.__ToInt32__SystemSingle__SystemInt32"`,
+			`EXTERN, "SystemConvert.__ToSingle__SystemInt32__SystemSingle"`,
+			`EXTERN, "SystemSingle.__op_Subtraction__SystemSingle_SystemSingle__SystemSingle"`,
+		}},
+		{"fmt.Sprint", "s := fmt.Sprint(1, 2, \"a\")\n_ = s", []string{
+			`EXTERN, "SystemString.__op_Addition__SystemString_SystemString__SystemString"`,
+			`: %SystemString, " "`,
+		}},
+		{"unity cast", "var o asm.Component\nt := asm.Transform(o)\n_ = t", []string{`: %UnityEngineTransform, null`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := compileSource(t, "package main\nfunc main() {\n"+tt.body+"\n}\n")
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(code, want) {
+					t.Errorf("missing %s in:\n%s", want, code)
+				}
+			}
+		})
+	}
+}
+
+func TestIntegerWrap(t *testing.T) {
+	src := `package main
+var B byte
+var S int16
+var H uint16
+var U uint32
+var W uint64
+var I int64
+var N int32
+func main() {
+	n := 300
+	m := 40000
+	k := -1
+	var wide int64 = 1048576
+	wide = wide*wide + 5
+	B = byte(n)
+	S = int16(m)
+	H = uint16(k)
+	U = uint32(k)
+	W = uint64(k)
+	I = int64(W)
+	N = int32(wide)
+}
+`
+	for _, level := range []asm.OptLevel{asm.O0, asm.O2} {
+		_, vars, _ := emulate(t, src, Options{OptLevel: level}, []asm.EventName{"_start"}, 0)
+		want := map[asm.VarName]interface{}{
+			"B": uint8(44), "S": int16(-25536), "H": uint16(65535), "U": uint32(4294967295),
+			"W": uint64(18446744073709551615), "I": int64(-1), "N": int32(5),
+		}
+		if !reflect.DeepEqual(vars, want) {
+			t.Errorf("O%d: got variables %#v, want %#v", level, vars, want)
+		}
+	}
+}
+
+// TestConversionSample compiles sample/func.go, which formats an int for asm.Log
+func TestConversionSample(t *testing.T) {
+	src, err := ioutil.ReadFile("sample/func.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := compileSource(t, string(src))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	want := `EXTERN, "SystemConvert.__ToString__SystemInt32__SystemString"`
+	if !strings.Contains(code, want) {
+		t.Errorf("missing %s in:\n%s", want, code)
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"string to int", "s := \"1\"\ni := int(s)\n_ = i", "cannot convert _start_s (SystemString) to SystemInt32"},
+		{"int to unity", "t := asm.Transform(1)\n_ = t", "to UnityEngineTransform"},
+		{"strconv.Itoa", "s := strconv.Itoa(\"a\")\n_ = s", "strconv.Itoa: cannot use SystemString as SystemInt32"},
+		{"strconv.Quote", "s := strconv.Quote(\"a\")\n_ = s", "call strconv.Quote: not implemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, "package main\nfunc main() {\n"+tt.body+"\n}\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"add assign", "x := 1\nx += 2\n_ = x", []string{`EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"`}},
+		{"remainder", "x := 7\nx %= 3\n_ = x", []string{
+			`EXTERN, "SystemInt32.__op_Division__SystemInt32_SystemInt32__SystemInt32"`,
+			`EXTERN, "SystemInt32.__op_Multiplication__SystemInt32_SystemInt32__SystemInt32"`,
+			`EXTERN, "SystemInt32.__op_Subtraction__SystemInt32_SystemInt32__SystemInt32"`,
+		}},
+		{"shift assign", "x := 1\nx <<= 3\n_ = x", []string{`EXTERN, "SystemInt32.__op_LeftShift__SystemInt32_SystemInt32__SystemInt32"`}},
+		{"increment", "x := 1.5\nx++\n_ = x", []string{`: %SystemSingle, 1`, `EXTERN, "SystemSingle.__op_Addition__SystemSingle_SystemSingle__SystemSingle"`}},
+		{"untyped constant", "f := 1.5\ng := f * 2\n_ = g", []string{`EXTERN, "SystemSingle.__op_Multiplication__SystemSingle_SystemSingle__SystemSingle"`}},
+		{"negation", "x := 1\ny := -x\n_ = y", []string{`EXTERN, "SystemInt32.__op_UnaryMinus__SystemInt32__SystemInt32"`}},
+		{"negative literal", "x := -1\n_ = x", []string{`: %SystemInt32, -1`}},
+		{"not", "b := true\nb = !b", []string{`EXTERN, "SystemBoolean.__op_UnaryNegation__SystemBoolean__SystemBoolean"`}},
+		{"complement", "x := 1\ny := ^x\n_ = y", []string{`EXTERN, "SystemInt32.__op_LogicalXor__SystemInt32_SystemInt32__SystemInt32"`}},
+		{"byte complement", "var b byte\nb = ^b", []string{`: %SystemByte, 255`, `EXTERN, "SystemConvert.__ToByte__SystemInt32__SystemByte"`}},
+		{"map entry", "m := make(map[string]int)\nm[\"a\"]++", []string{`EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := compileSource(t, "package main\nfunc main() {\n"+tt.body+"\n}\n")
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(code, want) {
+					t.Errorf("missing %s in:\n%s", want, code)
+				}
+			}
+		})
+	}
+}
+
+func TestOperatorErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"float remainder", "f := 1.5\nf %= 2", "operator % not defined on SystemSingle"},
+		{"not int", "x := 1\nb := !x\n_ = b", "operator ! not defined on SystemInt32"},
+		{"truncated constant", "x := 1\ny := x + 1.5\n_ = y", "constant 1.5 truncated to SystemInt32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, "package main\nfunc main() {\n"+tt.body+"\n}\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallExternErrors(t *testing.T) {
+	uasm := newTestCompiler(t).UASM
+	c := NewCompiler()
+	c.Fset = token.NewFileSet()
+	c.Pos = c.Fset.AddFile("main.go", -1, 10).Pos(4)
+	uasm.VarTable.AddVar("x", asm.UdonTypeInt32, "null")
+	uasm.VarTable.AddVar("s", asm.UdonTypeString, "null")
+	tests := []struct {
+		name       string
+		methodName asm.UdonMethodName
+		args       []asm.VarName
+		want       string
+	}{
+		{"unknown method", "op_Frobnicate", []asm.VarName{"x"}, "main.go:1:5: method does not exist: SystemInt32.op_Frobnicate(SystemInt32)"},
+		{"argument type", "op_Addition", []asm.VarName{"x", "s"}, "main.go:1:5: method does not exist: SystemInt32.op_Addition(SystemInt32, SystemString)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.callExtern(uasm, asm.STATIC_FUNC, asm.UdonTypeInt32, tt.methodName, "", tt.args)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"if condition", `package main
+type T struct{ N int }
+func main() {
+	var p *T
+	if p != nil && p.N > 0 {
+		p.N = 1
+	} else {
+		p = nil
+	}
+}
+`},
+		{"value", `package main
+type T struct{ N int }
+func main() {
+	var p *T
+	ok := p == nil || p.N > 0
+	_ = ok
+}
+`},
+		{"for condition", `package main
+type T struct{ N int }
+func main() {
+	var p *T
+	for i := 0; p != nil && p.N > i; i++ {
+		p.N = 0
+	}
+}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := compileSource(t, tt.src)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			if strings.Contains(code, "op_Conditional") {
+				t.Errorf("both operands evaluated in:\n%s", code)
+			}
+			// the field is only loaded once the nil check has branched
+			jump := strings.Index(code, "JUMP_IF_FALSE")
+			get := strings.Index(code, "SystemObjectArray.__Get__")
+			if jump < 0 || get < 0 || get < jump {
+				t.Errorf("field loaded before the nil check in:\n%s", code)
+			}
+		})
+	}
+}
+
+func TestLoops(t *testing.T) {
+	src := `package main
+func main() {
+	n := 0
+	for i := 0; i < 10; i++ {
+		if i == 3 {
+			continue
+		} else {
+			n += i
+		}
+		if n > 20 {
+			break
+		} else {
+			n++
+		}
+	}
+	for {
+		break
+	}
+}
+`
+	code, err := compileSource(t, src)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if strings.Contains(code, "###") {
+		t.Errorf("unresolved label in:\n%s", code)
+	}
+	_, err = compileSource(t, "package main\nfunc main() {\nbreak\n}\n")
+	if err == nil || !strings.Contains(err.Error(), "break is not in a loop") {
+		t.Errorf("got error %v, want break outside of a loop", err)
+	}
+}
+
+func TestIfStmt(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  []string
+		jumps int
+	}{
+		{"no else", "b := true\nif b {\nb = false\n}", nil, 0},
+		{"else", "b := true\nif b {\nb = false\n} else {\nb = true\n}", nil, 1},
+		{"else if", "n := 1\nif n == 0 {\nn = 1\n} else if n == 1 {\nn = 2\n} else {\nn = 3\n}", nil, 2},
+		{"else if without else", "n := 1\nif n == 0 {\nn = 1\n} else if n == 1 {\nn = 2\n}", nil, 1},
+		{"return", "b := true\nif b {\nreturn\n} else {\nb = false\n}", nil, 0},
+		{"init", "if x := 1; x > 0 {\nx = 2\n} else {\nx = 3\n}\nx := \"a\"\n_ = x", []string{
+			`_start_x_1: %SystemInt32, null`,
+			`_start_x: %SystemString, null`,
+		}, 1},
+		{"branch scope", "b := true\nif b {\nx := 1\n_ = x\n} else {\nx := \"a\"\n_ = x\n}", []string{
+			`_start_x_1: %SystemInt32, null`,
+			`_start_x_2: %SystemString, null`,
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := compileSource(t, "package main\nfunc main() {\n"+tt.body+"\n}\n")
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(code, want) {
+					t.Errorf("missing %s in:\n%s", want, code)
+				}
+			}
+			// jumps other than the ones halting the event
+			jumps := strings.Count(code, "JUMP, 0x") - strings.Count(code, "JUMP, 0xFFFFFFFF")
+			if jumps != tt.jumps {
+				t.Errorf("got %d jumps, want %d in:\n%s", jumps, tt.jumps, code)
+			}
+		})
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"shadowing", "func main() {\nx := 1\n{\nx := \"a\"\n_ = x\n}\n_ = x\n}", []string{
+			`_start_x: %SystemInt32, null`,
+			`_start_x_1: %SystemString, null`,
+		}},
+		{"numbered names stay unique", "func main() {\n{\nx := 1\n_ = x\n}\nx_1 := \"a\"\n_ = x_1\n}", []string{
+			`_start_x_1: %SystemInt32, null`,
+			`_start_x_1_2: %SystemString, null`,
+		}},
+		{"loop variables", "func main() {\nfor i := 0; i < 3; i++ {\n}\ni := \"a\"\n_ = i\n}", []string{
+			`_start_i_1: %SystemInt32, null`,
+			`_start_i: %SystemString, null`,
+		}},
+		{"comma ok redeclares", "func main() {\nm := map[string]int{}\nv, ok := m[\"a\"]\nw, ok := m[\"b\"]\n_ = v\n_ = w\n_ = ok\n}", nil},
+		{"package variable declared later", "func main() {\nx = 2\n}\nvar x = 1", []string{
+			`x: %SystemInt32, 1`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := compileSource(t, "package main\n"+tt.src+"\n")
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(code, want) {
+					t.Errorf("missing %s in:\n%s", want, code)
+				}
+			}
+		})
+	}
+}
+
+func TestScopeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"undefined", "x := y\n_ = x", "main.go:3:6: undefined: y"},
+		{"out of scope", "{\nx := 1\n_ = x\n}\nx = 2", "main.go:7:1: undefined: x"},
+		{"unused", "x := 1", "main.go:3:1: declared and not used: x"},
+		{"assigned but unused", "x := 1\nx = 2\nx++", "main.go:3:1: declared and not used: x"},
+		{"unused in block", "x := 1\n_ = x\nif x > 0 {\ny := 2\n}", "main.go:6:1: declared and not used: y"},
+		{"redeclared", "x := 1\nx := 2\n_ = x", "main.go:4:1: no new variables on left side of :="},
+		{"redeclared var", "var x int\nvar x int\n_ = x", "main.go:4:5: x redeclared in this block"},
+		{"declared type", "var s string = 1\n_ = s", "main.go:3:16: cannot use SystemInt32 as SystemString in variable declaration"},
+		{"truncated constant", "var n int = 2.5\n_ = n", "main.go:3:13: constant 2.5 truncated to SystemInt32"},
+		{"assigned type", "x := 3\nx = \"hello\"\n_ = x", "main.go:4:1: cannot use SystemString as SystemInt32 in assignment"},
+		{"redeclared type", "m := map[string]int{}\nx := \"a\"\nx, ok := m[\"b\"]\n_ = x\n_ = ok", "main.go:5:1: cannot use SystemInt32 as SystemString in assignment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, "package main\nfunc main() {\n"+tt.body+"\n}\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVarDecls(t *testing.T) {
+	src := `package main
+var N int64
+var F float32
+func main() {
+	var n int64 = 5
+	var f float32 = 2
+	n = n * 2
+	N = n
+	F = f / 4
+}
+`
+	_, vars, _ := emulate(t, src, Options{}, []asm.EventName{"_start"}, 0)
+	want := map[asm.VarName]interface{}{"N": int64(10), "F": float64(0.5)}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("got variables %#v, want %#v", vars, want)
+	}
+}
+
+func TestZeroValues(t *testing.T) {
+	src := `package main
+import "udon-go/asm"
+var S string
+var B bool
+func main() {
+	var s string
+	var n int
+	n++
+	if s == "" && S == "" && !B && n == 1 {
+		asm.Log("zero")
+	}
+}
+`
+	log, _, _ := emulate(t, src, Options{}, []asm.EventName{"_start"}, 0)
+	if !reflect.DeepEqual(log, []string{"zero"}) {
+		t.Errorf("got log %q, want zero", log)
+	}
+}
+
+func TestPackageVars(t *testing.T) {
+	src := `package main
+var N = -1
+var M = 2 * 3
+var S = "hi"
+var F = 2.5
+var L int64 = 5
+var D float64 = 1
+var B = !false
+func main() {
+}
+`
+	_, vars, _ := emulate(t, src, Options{}, []asm.EventName{"_start"}, 0)
+	want := map[asm.VarName]interface{}{
+		"N": int32(-1), "M": int32(6), "S": "hi", "F": float64(2.5), "L": int64(5), "D": float64(1), "B": true,
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Errorf("got variables %#v, want %#v", vars, want)
+	}
+}
+
+func TestPackageVarErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"call", "func f() int {\nreturn 1\n}\nvar N = f()", "main.go:5:9: unsupported initializer f()"},
+		{"variable", "var M = 1\nvar N = M", "main.go:3:9: unsupported initializer M"},
+		{"truncated", "var N int = 2.5", "main.go:2:13: constant 2.5 truncated to SystemInt32"},
+		{"mismatched", "var S string = 1", "main.go:2:16: cannot use constant 1 as SystemString"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, "package main\n"+tt.src+"\nfunc main() {\n}\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDelayedCalls(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"go statement", "func greet(s string) {\nasm.Log(s)\n}\nfunc main() {\nname := \"a\"\ngo greet(name)\n}", []string{
+			`.export __delayed_`,
+			`__SendCustomEventDelayedFrames__SystemString_SystemInt32_VRCUdonCommonEnumsEventTiming__SystemVoid"`,
+			`this_udon: %VRCUdonUdonBehaviour, this`,
+			`_arg0: %SystemString, null`,
+		}},
+		{"after", "func main() {\nudon.After(2*time.Second, func() {\nasm.Log(\"a\")\n})\n}", []string{
+			`: %SystemInt64, 1000000000`,
+			`"SystemConvert.__ToSingle__SystemDouble__SystemSingle"`,
+			`__SendCustomEventDelayedSeconds__SystemString_SystemSingle_VRCUdonCommonEnumsEventTiming__SystemVoid"`,
+		}},
+		{"captured variable", "func main() {\nn := 3\nudon.NextFrame(func() {\nm := n + 1\n_ = m\n})\n}", []string{
+			`_n: %SystemInt32, null`,
+			`_m_1: %SystemInt32, null`,
+		}},
+		{"function literal arguments", "func main() {\ngo func(s string, n int) {\nasm.Log(s)\n_ = n\n}(\"a\", 1)\n}", []string{
+			`_s: %SystemString, null`,
+			`_n: %SystemInt32, null`,
+		}},
+		{"nested", "func main() {\nx := 1\nudon.NextFrame(func() {\nudon.NextFrame(func() {\n_ = x\n})\n})\n}", nil},
+		{"in a loop without captures", "func tick() {\n}\nfunc main() {\nfor i := 0; i < 3; i++ {\ngo tick()\n}\n}", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := compileSource(t, "package main\n"+tt.src+"\n")
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(code, want) {
+					t.Errorf("missing %s in:\n%s", want, code)
+				}
+			}
+		})
+	}
+}
+
+func TestDelayedCallsPending(t *testing.T) {
+	src := `package main
+
+import (
+	"strconv"
+	"time"
+	"udon-go/asm"
+	"udon-go/udon"
+)
+
+func show(s string) {
+	asm.Log(s)
+}
+
+func later(d time.Duration, s string) {
+	udon.After(d, func() {
+		asm.Log(s)
+	})
+}
+
+func main() {
+	for i := 0; i < 3; i++ {
+		go show(strconv.Itoa(i))
+	}
+	for i := 5; i < 7; i++ {
+		go func() {
+			asm.Log(strconv.Itoa(i))
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		j := i * 10
+		udon.NextFrame(func() {
+			asm.Log(strconv.Itoa(j))
+		})
+	}
+	later(2*time.Second, "slow")
+	later(time.Second, "fast")
+}
+`
+	for _, level := range []asm.OptLevel{asm.O0, asm.O2} {
+		log, _, _ := emulate(t, src, Options{OptLevel: level}, []asm.EventName{"_start"}, 4)
+		want := []string{"0", "1", "2", "5", "6", "0", "10", "20", "30", "40", "fast", "slow"}
+		if !reflect.DeepEqual(log, want) {
+			t.Errorf("O%d: got log %q, want %q", level, log, want)
+		}
+	}
+}
+
+func TestDelayedCallErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"assign captured", "n := 1\nudon.NextFrame(func() {\nn = 2\n})", "main.go:5:1: cannot assign to n"},
+		{"assign after scheduled", "n := 5\ngo func() {\nasm.Log(strconv.Itoa(n))\n}()\nn = 6", "main.go:7:1: cannot assign to n after a function run later captures it"},
+		{"increment after scheduled", "n := 5\nudon.NextFrame(func() {\n_ = n\n})\nn++", "main.go:7:1: cannot assign to n after a function run later captures it"},
+		{"assign in loop", "n := 0\nfor i := 0; i < 3; i++ {\nn += i\ngo func() {\n_ = n\n}()\n}", "main.go:5:1: cannot assign to n in a loop running a function later which captures it"},
+		{"extern", "go asm.Log(\"a\")", "only functions of the program and function literals can run later"},
+		{"function value", "f := 1\n_ = f\nudon.NextFrame(f)", "function values are not supported"},
+		{"results", "go func() int {\nreturn 1\n}()", "functions run later can not return values"},
+		{"duration type", "udon.After(\"a\", f0)", "cannot use"},
+		{"nil", "udon.After(1, nil)", "main.go:3:15: cannot run nil later, a function is required"},
+		{"not a function", "udon.NextFrame(T)", "main.go:3:16: cannot run T later, it is not a function"},
+		{"literal", "udon.NextFrame(1)", "main.go:3:16: only functions of the program and function literals can run later"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package main\nfunc main() {\n" + tt.body + "\n}\nfunc f(n int) {\n}\nfunc f0() {\n}\ntype T struct {\n}\n"
+			_, err := compileSource(t, src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// writeModule writes files, by path relative to the module root, into a temporary module called example.com/world
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "udon-go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	files["go.mod"] = "module example.com/world\n\ngo 1.14\n"
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(src), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func linkModule(t *testing.T, files map[string]string, behaviour string) (string, error) {
+	t.Helper()
+	dir := writeModule(t, files)
+	prog, err := LoadProgram(filepath.Join(dir, behaviour))
+	if err != nil {
+		return "", err
+	}
+	return newTestCompiler(t).Link(ioutil.Discard, prog)
+}
+
+var libFiles = map[string]string{
+	"lib/lib.go": `package lib
+
+var Calls int
+
+type Counter struct {
+	Value int
+}
+
+func Twice(n int) int {
+	Calls++
+	return add(n, n)
+}
+
+func add(a int, b int) int {
+	return a + b
+}
+
+func Incr(c *Counter) {
+	c.Value = Twice(c.Value)
+}
+`,
+	"lib/unused.go": `package lib
+
+func Unused() int {
+	return Twice(3)
+}
+`,
+}
+
+func TestLinkPackages(t *testing.T) {
+	files := map[string]string{
+		"door/a.go": `package main
+
+import "example.com/world/lib"
+
+func main() {
+	n := lib.Twice(2)
+	_ = n
+	helper()
+}
+`,
+		"door/b.go": `package main
+
+import (
+	"udon-go/asm"
+	l "example.com/world/lib"
+)
+
+func helper() {
+	c := &l.Counter{Value: 1}
+	l.Incr(c)
+	l.Calls = 0
+	asm.Log("done")
+}
+
+func unused() {
+}
+`,
+	}
+	for name, src := range libFiles {
+		files[name] = src
+	}
+	code, err := linkModule(t, files, "door")
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	for _, want := range []string{
+		`lib__Calls: %SystemInt32, 0`,
+		`lib__Twice__SystemInt32_n: %SystemInt32, null`,
+		`lib__add__SystemInt32_SystemInt32_a: %SystemInt32, null`,
+		`lib__Incr__SystemObjectArray_c: %SystemObjectArray, null`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %s in:\n%s", want, code)
+		}
+	}
+	for _, unwanted := range []string{"Unused", "unused", ".export lib__Calls"} {
+		if strings.Contains(code, unwanted) {
+			t.Errorf("%s should not be linked:\n%s", unwanted, code)
+		}
+	}
+	if strings.Count(code, "lib__Twice__SystemInt32_n: ") != 1 {
+		t.Errorf("lib.Twice should be compiled once:\n%s", code)
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unsupported import", "package main\nimport \"os\"\nfunc main() {\n_ = os.Args\n}\n", `unsupported import "os"`},
+		{"unexported", "package main\nimport \"example.com/world/lib\"\nfunc main() {\n_ = lib.add(1, 2)\n}\n", "name add not exported by package lib"},
+		{"undefined", "package main\nimport \"example.com/world/lib\"\nfunc main() {\nlib.Missing = 1\n}\n", "undefined: lib.Missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"door/main.go": tt.src}
+			for name, src := range libFiles {
+				files[name] = src
+			}
+			_, err := linkModule(t, files, "door")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadMethodTable(t *testing.T) {
+	path, err := filepath.Abs(defaultFuncsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	methodTable, err := loadMethodTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = methodTable.FindExtern(asm.INSTANCE_FUNC, asm.UdonTypeIUdonEventReceiver, "SendCustomEventDelayedFrames",
+		[]asm.UdonTypeName{asm.UdonTypeString, asm.UdonTypeInt32, asm.UdonTypeEventTiming})
+	if err != nil {
+		t.Errorf("the sdk records are missing: %v", err)
+	}
+	_, err = loadMethodTable(filepath.Join("testdata", "missing", "udon_funcs_data.txt"))
+	if err == nil || !strings.Contains(err.Error(), "-funcs") {
+		t.Errorf("got error %v, want a hint at -funcs", err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	files := map[string]string{
+		"door/main.go": `package main
+
+import "example.com/world/lib"
+
+// Open is synced
+//udon:sync
+var Open bool
+
+var Speed = 2
+
+func _interact() {
+	Open = lib.Twice(Speed) > 0
+}
+`,
+		"scoreboard/main.go": `package main
+
+import "example.com/world/lib"
+
+var (
+	//udon:sync linear
+	score int
+	Name  string
+)
+
+func main() {
+	score = lib.Twice(score)
+	Name = "board"
+}
+`,
+		"scoreboard/testdata/main.go": "package main\n",
+	}
+	for name, src := range libFiles {
+		files[name] = src
+	}
+	dir := writeModule(t, files)
+	outDir := filepath.Join(dir, "out")
+	_, err := Build(methodTable.table, outDir, []string{filepath.Join(dir, "...")}, Options{})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(outDir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &Manifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Manifest{Behaviours: []*BehaviourManifest{
+		{
+			Name:      "door",
+			Package:   "example.com/world/door",
+			Output:    "door.uasm",
+			Variables: []ManifestVar{{Name: "Open", Type: "SystemBoolean"}, {Name: "Speed", Type: "SystemInt32"}},
+			Events:    []string{"_interact"},
+			Synced:    []ManifestSync{{Name: "Open", Type: "SystemBoolean", Mode: "none"}},
+		},
+		{
+			Name:      "scoreboard",
+			Package:   "example.com/world/scoreboard",
+			Output:    "scoreboard.uasm",
+			Variables: []ManifestVar{{Name: "Name", Type: "SystemString"}},
+			Events:    []string{"_start"},
+			Synced:    []ManifestSync{{Name: "score", Type: "SystemInt32", Mode: "linear"}},
+		},
+	}}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("manifest:\n%s", data)
+	}
+	for _, bm := range want.Behaviours {
+		code, err := ioutil.ReadFile(filepath.Join(outDir, bm.Output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(code), "lib__Twice__SystemInt32_n: %SystemInt32, null") {
+			t.Errorf("%s does not link lib.Twice:\n%s", bm.Output, code)
+		}
+	}
+}
+
+func TestSyncErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"unknown mode", "//udon:sync fast\nvar x int", `unknown sync mode "fast"`},
+		{"too many fields", "//udon:sync none linear\nvar x int", "usage: //udon:sync [none|linear|smooth]"},
+		{"struct pointer", "type T struct{}\n//udon:sync\nvar x *T", "cannot sync x of type SystemObjectArray"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, "package main\n"+tt.src+"\nfunc main() {\n}\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildBinary(t *testing.T) {
+	newTestCompiler(t)
+	dir := writeModule(t, doorFiles)
+	outDir := filepath.Join(dir, "out")
+	manifest, err := Build(methodTable.table, outDir, []string{filepath.Join(dir, "door")}, Options{Binary: true})
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	bm := manifest.Behaviours[0]
+	if bm.Binary != "door.udonbin" {
+		t.Fatalf("binary %q, want door.udonbin", bm.Binary)
+	}
+	text, err := ioutil.ReadFile(filepath.Join(outDir, bm.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(outDir, bm.Binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := asm.ReadProgram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := vm.New(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Run("_interact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := m.Var("Open"); open != true {
+		t.Errorf("Open = %v after _interact", open)
+	}
+	labels := map[asm.LabelName]asm.Addr{}
+	for _, inst := range prog.Code {
+		for _, label := range inst.Labels {
+			labels[label] = inst.Addr
+		}
+	}
+	want, err := assembleBinary(string(text), labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Error("the binary program is not the assembly assembled")
+	}
+}
+
+var doorFiles = map[string]string{
+	"door/main.go": `package main
+
+//udon:sync
+var Open bool
+
+var Opened int
+
+func _interact() {
+	Open = !Open
+}
+
+func _close() {
+	Open = false
+}
+`,
+}
+
+func TestBehaviourReferences(t *testing.T) {
+	files := map[string]string{
+		"switch/main.go": `package main
+
+// Door is the door the switch opens
+//
+//udon:behaviour example.com/world/door
+type Door struct {
+	Open   bool
+	Opened int
+}
+
+func (*Door) _close() {}
+
+var Target *Door
+
+func _interact() {
+	if Target.Open {
+		Target._close()
+		return
+	}
+	Target.Open = true
+	Target.Opened++
+}
+`,
+	}
+	for name, src := range doorFiles {
+		files[name] = src
+	}
+	code, err := linkModule(t, files, "switch")
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	for _, want := range []string{
+		".export Target",
+		"Target: %VRCUdonUdonBehaviour, null",
+		`"VRCUdonCommonInterfacesIUdonEventReceiver.__GetProgramVariable__SystemString__SystemObject"`,
+		`"SystemConvert.__ToBoolean__SystemObject__SystemBoolean"`,
+		`"VRCUdonCommonInterfacesIUdonEventReceiver.__SetProgramVariable__SystemString_SystemObject__SystemVoid"`,
+		`"VRCUdonCommonInterfacesIUdonEventReceiver.__SendCustomEvent__SystemString__SystemVoid"`,
+		`%SystemString, "_close"`,
+		`%SystemString, "Opened"`,
+	} {
+		if !strings.Contains(code, want) {
+			t.Errorf("missing %q in:\n%s", want, code)
+		}
+	}
+}
+
+func TestBehaviourReferenceErrors(t *testing.T) {
+	decl := "package main\n//udon:behaviour example.com/world/door\ntype Door struct {\n%s\n}\n%s\nvar Target *Door\nfunc _interact() {\n%s\n}\n"
+	tests := []struct {
+		name    string
+		fields  string
+		methods string
+		body    string
+		want    string
+	}{
+		{"missing variable", "Closed bool", "", "", "example.com/world/door has no exported variable Closed"},
+		{"unexported variable", "secret int", "", "", "example.com/world/door has no exported variable secret"},
+		{"variable type", "Open int", "", "", "example.com/world/door.Open is SystemBoolean, not SystemInt32"},
+		{"missing event", "", "func (*Door) _lock() {}", "", "example.com/world/door has no event _lock"},
+		{"not an event", "", "func (*Door) Lock() {}", "", "method Lock of behaviour Door is not an event"},
+		{"event body", "", "func (*Door) _close() {\nOpen = false\n}\nvar Open bool", "", "its body must be empty"},
+		{"event args", "", "func (*Door) _close(n int) {}", "", "can not take arguments or return values"},
+		{"undeclared field", "Open bool", "", "Target.Opened = 1", "Door has no field Opened"},
+		{"undeclared event", "", "", "Target._close()", "Door has no event _close"},
+		{"field type", "Open bool", "", "Target.Open = 1", "cannot use SystemInt32 as SystemBoolean"},
+		{"value type", "", "", "var d Door\n_ = d", "behaviour values are not supported, use *Door"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"switch/main.go": fmt.Sprintf(decl, tt.fields, tt.methods, tt.body)}
+			for name, src := range doorFiles {
+				files[name] = src
+			}
+			_, err := linkModule(t, files, "switch")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+	t.Run("not a behaviour", func(t *testing.T) {
+		files := map[string]string{"switch/main.go": "package main\n//udon:behaviour example.com/world/lib\ntype Lib struct{}\nvar L *Lib\nfunc main() {\n}\n"}
+		for name, src := range libFiles {
+			files[name] = src
+		}
+		_, err := linkModule(t, files, "switch")
+		want := "example.com/world/lib is not a behaviour, its package is lib"
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	})
+	t.Run("single file", func(t *testing.T) {
+		_, err := compileSource(t, "package main\n//udon:behaviour example.com/world/door\ntype Door struct{}\nvar D *Door\nfunc main() {\n}\n")
+		want := "a single file can not refer to other behaviours"
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got error %v, want %q", err, want)
+		}
+	})
+}
+
+// emulate compiles src at level and runs its events in the emulator, then the delayed events of frames later frames.
+// It returns the log, the exported variables and the number of instructions.
+func emulate(t *testing.T, src string, opts Options, events []asm.EventName, frames int) ([]string, map[asm.VarName]interface{}, int) {
+	t.Helper()
+	uc := newTestCompiler(t)
+	uc.Options = opts
+	level := opts.OptLevel
+	code, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("O%d: compile: %v", level, err)
+	}
+	prog, err := asm.ParseProgram(code)
+	if err != nil {
+		t.Fatalf("O%d: parse: %v", level, err)
+	}
+	m, err := vm.New(prog)
+	if err != nil {
+		t.Fatalf("O%d: load: %v", level, err)
+	}
+	for _, event := range events {
+		err = m.Run(event)
+		if err != nil {
+			t.Fatalf("O%d: run %s: %v\n%s", level, event, err, code)
+		}
+	}
+	for i := 0; i < frames; i++ {
+		err = m.NextFrame(0.5)
+		if err != nil {
+			t.Fatalf("O%d: frame %d: %v\n%s", level, i, err, code)
+		}
+	}
+	vars := map[asm.VarName]interface{}{}
+	for _, varName := range prog.Exports {
+		vars[varName], _ = m.Var(varName)
+	}
+	return m.Log, vars, len(prog.Code)
+}
+
+func TestOptimizeEquivalence(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		events []asm.EventName
+		frames int
+		want   []string
+	}{
+		{"functions", readSample(t, "sample/func.go"), nil, 0, []string{"120"}},
+		{"maps", readSample(t, "sample/maps/main.go"), nil, 0, []string{"udon twice", "udon", "one word"}},
+		{"linked list", readSample(t, "sample/linkedlist/main.go"), nil, 0, []string{"first"}},
+		{"delayed calls", readSample(t, "sample/timer/main.go"), nil, 3, []string{
+			"tick 1", "hello world", "half a second later, world", "tick 2", "and one more frame, world",
+		}},
+		{"loops", `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+var Total int
+func fib(n int) int {
+	a := 0
+	b := 1
+	for ; n > 0; n-- {
+		c := a + b
+		a = b
+		b = c
+	}
+	return a
+}
+func main() {
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			continue
+		}
+		if i > 7 {
+			break
+		}
+		Total += fib(i)
+	}
+	s := ""
+	for i := 3; i > 0; i-- {
+		s = s + strconv.Itoa(i)
+	}
+	asm.Log(s)
+	asm.Log(strconv.Itoa(Total))
+}
+`, nil, 0, []string{"321", "21"}},
+		{"operators", `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+var Bits uint32
+var Ratio float64
+func main() {
+	x := 7
+	x <<= 3
+	x ^= 5
+	x &^= 1
+	y := -x / 3
+	Bits = uint32(x) >> 2
+	Ratio = float64(y) / 4
+	ok := x > 10 && !(y > 0) || x == 0
+	asm.Log(strconv.Itoa(x) + " " + strconv.Itoa(y) + " " + strconv.FormatBool(ok))
+	f := 2.75
+	asm.Log(strconv.Itoa(int(f)) + " " + strconv.Itoa(int(-f)))
+}
+`, nil, 0, []string{"60 -20 True", "2 -2"}},
+		{"structs and events", `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+type Point struct {
+	X int
+	Y int
+}
+var Clicks int
+func move(p *Point, dx int) {
+	p.X += dx
+	p.Y = p.X * 2
+}
+var origin *Point
+func main() {
+	origin = &Point{X: 1}
+}
+func _interact() {
+	Clicks++
+	move(origin, Clicks)
+	asm.Log(strconv.Itoa(origin.X) + "," + strconv.Itoa(origin.Y))
+}
+`, []asm.EventName{"_interact", "_interact"}, 0, []string{"2,4", "4,8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := append([]asm.EventName{"_start"}, tt.events...)
+			wantLog, wantVars, size := emulate(t, tt.src, Options{OptLevel: asm.O0}, events, tt.frames)
+			if !reflect.DeepEqual(wantLog, tt.want) {
+				t.Fatalf("O0 log %q, want %q", wantLog, tt.want)
+			}
+			for _, level := range []asm.OptLevel{asm.O1, asm.O2} {
+				log, vars, optSize := emulate(t, tt.src, Options{OptLevel: level}, events, tt.frames)
+				if !reflect.DeepEqual(log, wantLog) {
+					t.Errorf("O%d log %q, want %q", level, log, wantLog)
+				}
+				if !reflect.DeepEqual(vars, wantVars) {
+					t.Errorf("O%d variables %v, want %v", level, vars, wantVars)
+				}
+				if optSize > size {
+					t.Errorf("O%d has %d instructions, more than the %d of O0", level, optSize, size)
+				}
+				size = optSize
+			}
+		})
+	}
+}
+
+func TestInline(t *testing.T) {
+	src := `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+var Total int
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+func dist(a int, b int) int {
+	x := abs(a - b)
+	return x
+}
+//udon:inline
+func sum(n int) int {
+	s := 0
+	for i := 1; i <= n; i++ {
+		s += i
+	}
+	return s
+}
+//udon:noinline
+func twice(x int) int {
+	return 2 * x
+}
+func add(x int) {
+	Total += x
+}
+func main() {
+	x := 3
+	s := 0
+	add(dist(x, 10))
+	add(sum(4))
+	s = twice(x)
+	asm.Log(strconv.Itoa(Total) + " " + strconv.Itoa(s) + " " + strconv.Itoa(x))
+}
+`
+	want := []string{"17 6 3"}
+	for _, opts := range []Options{{InlineThreshold: -1}, {InlineThreshold: 100}, {OptLevel: asm.O2, InlineThreshold: 100}} {
+		log, _, _ := emulate(t, src, opts, []asm.EventName{"_start"}, 0)
+		if !reflect.DeepEqual(log, want) {
+			t.Errorf("inline threshold %d at O%d: log %q, want %q", opts.InlineThreshold, opts.OptLevel, log, want)
+		}
+	}
+
+	// calls is the number of calls left in the code of src compiled with opts
+	calls := func(src string, opts Options) int {
+		uc := newTestCompiler(t)
+		uc.Options = opts
+		code, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// every call pushes the address it returns to
+		return strings.Count(code, "PUSH, __const_ret_addr_")
+	}
+	tests := []struct {
+		name string
+		opts Options
+		want int
+	}{
+		{"only marked functions", Options{InlineThreshold: -1}, 5},
+		{"default threshold", Options{OptLevel: asm.O1}, 2},
+		{"large threshold", Options{InlineThreshold: 100}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := calls(src, tt.opts); got != tt.want {
+				t.Errorf("%d calls left, want %d", got, tt.want)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"recursive", "//udon:inline\nfunc f(n int) int {\nif n == 0 {\nreturn 0\n}\nreturn f(n - 1)\n}\nfunc main() {\nf(1)\n}", "cannot inline f: it is recursive"},
+		{"mutually recursive", "func g(n int) int {\nreturn f(n)\n}\n//udon:inline\nfunc f(n int) int {\nreturn g(n)\n}\nfunc main() {\nf(1)\n}", "cannot inline f: it is recursive"},
+		{"event", "//udon:inline\nfunc _interact() {\n}\nfunc main() {\n}", "events are not called"},
+		{"usage", "//udon:noinline always\nfunc f() {\n}\nfunc main() {\nf()\n}", "usage: //udon:noinline"},
+		{"both", "//udon:inline\n//udon:noinline\nfunc f() {\n}\nfunc main() {\nf()\n}", "both //udon:inline and //udon:noinline"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileSource(t, "package main\n"+tt.src+"\n")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecursionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantAST string
+		wantSSA string
+	}{
+		{"recursive", "func fact(n int) int {\nif n == 0 {\nreturn 1\n}\nreturn n * fact(n-1)\n}\nfunc main() {\nfact(4)\n}",
+			"main.go:6:12: call fact: recursive functions are not supported",
+			"main.go:9:5: call fact: recursive functions are not supported"},
+		{"mutually recursive", "func even(n int) bool {\nif n == 0 {\nreturn true\n}\nreturn odd(n - 1)\n}\nfunc odd(n int) bool {\nreturn !even(n)\n}\nfunc main() {\neven(4)\n}",
+			"main.go:6:8: call odd: recursive functions are not supported",
+			"main.go:12:5: call even: recursive functions are not supported"},
+	}
+	for _, tt := range tests {
+		for backend, want := range map[string]string{BackendAST: tt.wantAST, BackendSSA: tt.wantSSA} {
+			t.Run(tt.name+" "+backend, func(t *testing.T) {
+				uc := newTestCompiler(t)
+				uc.Options.Backend = backend
+				_, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader("package main\n"+tt.src+"\n"))
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want %q", err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestReturnErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantAST string
+		wantSSA string
+	}{
+		{"missing value", "func f() int {\nreturn\n}\nfunc main() {\nf()\n}",
+			"main.go:3:1: not enough return values", "main.go:3:1: not enough return values"},
+		{"extra value", "func f() {\nreturn 1\n}\nfunc main() {\nf()\n}",
+			"main.go:3:1: too many return values", "main.go:3:8: too many return values"},
+		{"inlined missing value", "//udon:inline\nfunc f() int {\nreturn\n}\nfunc main() {\nf()\n}",
+			"main.go:4:1: not enough return values", "main.go:4:1: not enough return values"},
+		{"event value", "func _update() int {\nreturn 1\n}\nfunc main() {\n}",
+			"main.go:3:1: return stmt: events can not return values", "main.go:3:1: return: events can not return values"},
+	}
+	for _, tt := range tests {
+		for backend, want := range map[string]string{BackendAST: tt.wantAST, BackendSSA: tt.wantSSA} {
+			t.Run(tt.name+" "+backend, func(t *testing.T) {
+				uc := newTestCompiler(t)
+				uc.Options.Backend = backend
+				_, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader("package main\n"+tt.src+"\n"))
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("got error %v, want %q", err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDeadCode(t *testing.T) {
+	src := `package main
+import "udon-go/asm"
+func helper() {
+	asm.Log("helper")
+}
+func onlyDead() {
+	asm.Log("only dead")
+}
+func sign(x int) string {
+	if x < 0 {
+		return "-"
+	} else {
+		return "+"
+	}
+	asm.Log("after if")
+	return ""
+}
+func main() {
+	for i := 0; i < 3; i++ {
+		break
+		asm.Log("after break")
+	}
+	asm.Log(sign(1))
+	return
+	onlyDead()
+}
+`
+	var report strings.Builder
+	uc := newTestCompiler(t)
+	uc.Options.DeadCode = &report
+	code, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `main.go:3:1: removed helper, it is never called
+main.go:6:1: removed onlyDead, it is never called
+main.go:15:2: removed unreachable code
+main.go:21:3: removed unreachable code
+main.go:25:2: removed unreachable code
+`
+	if report.String() != want {
+		t.Errorf("got report\n%s\nwant\n%s", report.String(), want)
+	}
+	for _, text := range []string{"helper", "only dead", "after if", "after break"} {
+		if strings.Contains(code, strconv.Quote(text)) {
+			t.Errorf("%q is compiled", text)
+		}
+	}
+	log, _, _ := emulate(t, src, Options{}, []asm.EventName{"_start"}, 0)
+	if !reflect.DeepEqual(log, []string{"+"}) {
+		t.Errorf("log %q", log)
+	}
+}
+
+func TestSSABackend(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// ast is set when the ast backend compiles src as well
+		ast  bool
+		want []string
+	}{
+		{"functions", readSample(t, "sample/func.go"), true, []string{"120"}},
+		{"loops and conditions", `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+var Total = 5
+func classify(n int) string {
+	switch {
+	case n < 0:
+		return "negative"
+	case n == 0 || n > 100:
+		return "edge"
+	}
+	return "small"
+}
+func main() {
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			continue
+		}
+		if i > 7 {
+			break
+		}
+		Total += i
+	}
+	asm.Log(strconv.Itoa(Total))
+	asm.Log(classify(-3) + " " + classify(0) + " " + classify(7))
+}
+`, false, []string{"21", "negative edge small"}},
+		{"swapping phis", `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+func main() {
+	a, b := 0, 1
+	for i := 0; i < 10; i++ {
+		a, b = b, a+b
+	}
+	asm.Log(strconv.Itoa(a) + " " + strconv.Itoa(b))
+}
+`, false, []string{"55 89"}},
+		{"multiple results", `package main
+import (
+	"strconv"
+	"udon-go/asm"
+)
+func divmod(a int, b int) (int, int) {
+	if b == 0 {
+		return 0, a
+	}
+	return a / b, a % b
+}
+func main() {
+	q, r := divmod(17, 5)
+	_, z := divmod(3, 0)
+	asm.Log(strconv.Itoa(q) + " " + strconv.Itoa(r) + " " + strconv.Itoa(z))
+}
+`, false, []string{"3 2 3"}},
+		{"zero values", `package main
+import "udon-go/asm"
+var S string
+var N int
+func main() {
+	N++
+	if S == "" && N == 1 {
+		asm.Log("zero")
+	}
+}
+`, true, []string{"zero"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := []string{BackendSSA}
+			if tt.ast {
+				backends = append(backends, BackendAST)
+			}
+			for _, backend := range backends {
+				for _, level := range []asm.OptLevel{asm.O0, asm.O2} {
+					log, _, _ := emulate(t, tt.src, Options{Backend: backend, OptLevel: level}, []asm.EventName{"_start"}, 0)
+					if !reflect.DeepEqual(log, tt.want) {
+						t.Errorf("%s backend at O%d: log %q, want %q", backend, level, log, tt.want)
+					}
+				}
+			}
+		})
+	}
+
+	errTests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"struct", "type T struct{ X int }\nvar P *T", "type *main.T is not supported by the ssa backend"},
+		{"closure", "func main() {\nf := func() {}\nf()\n}", "function literals are not supported by the ssa backend"},
+		{"type error", "func main() {\nx := 1 + \"a\"\n}", "type check main"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newTestCompiler(t)
+			uc.Options.Backend = BackendSSA
+			_, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader("package main\n"+tt.src+"\n"))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func readSample(t *testing.T, path string) string {
+	t.Helper()
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+func TestHeapReport(t *testing.T) {
+	var report strings.Builder
+	uc := newTestCompiler(t)
+	uc.Options = Options{OptLevel: asm.O2, HeapReport: &report}
+	code, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader(readSample(t, "sample/maps/main.go")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before, after int
+	for _, line := range strings.Split(report.String(), "\n") {
+		if strings.HasPrefix(line, "heap slots") {
+			fmt.Sscanf(strings.TrimPrefix(line, "heap slots"), "%d -> %d", &before, &after)
+		}
+	}
+	if after == 0 || after >= before {
+		t.Fatalf("no heap slot reused:\n%s", report.String())
+	}
+	prog, err := asm.ParseProgram(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prog.Vars) != after {
+		t.Errorf("%d variables in the data segment, the report says %d", len(prog.Vars), after)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	src := `package main
+
+func add(a int, b int) int {
+	return a + b
+}
+
+func main() {
+	x := add(1, 2)
+	if x > 2 {
+		x = 0
+	}
+	_ = x
+}
+`
+	for _, comments := range []bool{true, false} {
+		t.Run(fmt.Sprintf("comments %v", comments), func(t *testing.T) {
+			uc := newTestCompiler(t)
+			uc.Options = Options{OptLevel: asm.O2, Comments: comments, SourceMap: true}
+			code, err := uc.MakeUASMCode(ioutil.Discard, strings.NewReader(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, comment := range []string{"# main.go:3:1: func add(a int, b int) int", "# main.go:8:2: x := add(1, 2)", "# main.go:9:2: if x > 2"} {
+				if strings.Contains(code, comment) != comments {
+					t.Errorf("comment %q written: %v, want %v", comment, !comments, comments)
+				}
+			}
+			prog, err := asm.ParseProgram(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, _ := prog.EventAddr("_start")
+			lines := map[int]bool{}
+			for _, pos := range uc.SourceMap.Code {
+				if pos.File != "main.go" {
+					t.Errorf("position %s in another file", pos)
+				}
+				lines[pos.Line] = true
+			}
+			for _, line := range []int{3, 4, 8, 9, 10} {
+				if !lines[line] {
+					t.Errorf("no instruction of line %d in %v", line, uc.SourceMap.Code)
+				}
+			}
+			// the first statement of an event starts at its first instruction
+			if pos, ok := uc.SourceMap.Lookup(start); !ok || pos.Line != 8 {
+				t.Errorf("_start at %#x is at %v, want line 8", start, pos)
+			}
+			if ident := uc.SourceMap.Vars["_start_x"]; ident != "x" {
+				t.Errorf("_start_x is %q, want x", ident)
+			}
+		})
+	}
+}
+
+// lspSession frames the messages of a client
+func lspSession(msgs ...string) io.Reader {
+	b := &bytes.Buffer{}
+	for _, msg := range msgs {
+		msg = `{"jsonrpc":"2.0",` + msg + `}`
+		fmt.Fprintf(b, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return b
+}
+
+// lspReply is a response or notification of the server
+type lspReply struct {
+	ID     int             `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *lspError       `json:"error"`
+}
+
+func readLSP(t *testing.T, data []byte) []*lspReply {
+	t.Helper()
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	replies := []*lspReply{}
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err == io.EOF {
+			return replies
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, n)
+		_, err = io.ReadFull(r.R, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := &lspReply{}
+		err = json.Unmarshal(body, reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply)
+	}
+}
+
+func TestServeLSP(t *testing.T) {
+	src := `package main
+
+import (
+	"strconv"
+	"udon-go/asm"
+)
+
+var T asm.Transform
+
+func main() {
+	x := 1
+	y := x + 2
+	asm.Log(strconv.Itoa(y))
+}
+`
+	dir := writeModule(t, map[string]string{"main.go": src})
+	uri := pathURI(filepath.Join(dir, "main.go"))
+	// the documents are checked as edited, not as saved
+	bad := strings.Replace(strings.Replace(src, `x + 2`, `x + "a"`, 1), "asm.Transform", "asm.Transform = x", 1)
+	member := strings.Replace(src, "asm.Log(strconv.Itoa(y))", "asm.Log(strconv.Itoa(y))\n\tT.Get", 1)
+	doc := func(text string) string {
+		data, _ := json.Marshal(text)
+		return string(data)
+	}
+	at := func(id int, method string, line int, char int) string {
+		return fmt.Sprintf(`"id":%d,"method":"textDocument/%s","params":{"textDocument":{"uri":%q},"position":{"line":%d,"character":%d}}`, id, method, uri, line, char)
+	}
+	in := lspSession(
+		`"id":1,"method":"initialize","params":{}`,
+		`"method":"initialized","params":{}`,
+		fmt.Sprintf(`"method":"textDocument/didOpen","params":{"textDocument":{"uri":%q,"text":%s}}`, uri, doc(bad)),
+		fmt.Sprintf(`"method":"textDocument/didChange","params":{"textDocument":{"uri":%q},"contentChanges":[{"text":%s}]}`, uri, doc(src)),
+		at(2, "hover", 11, 8),
+		at(3, "hover", 6, 0),
+		at(4, "completion", 12, 6),
+		fmt.Sprintf(`"method":"textDocument/didChange","params":{"textDocument":{"uri":%q},"contentChanges":[{"text":%s}]}`, uri, doc(member)),
+		at(5, "completion", 13, 6),
+		`"id":6,"method":"textDocument/definition","params":{}`,
+		`"id":7,"method":"shutdown"`,
+		`"method":"exit"`,
+	)
+	out := &bytes.Buffer{}
+	err := ServeLSP(newTestCompiler(t).UASM.MethodTable, in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := readLSP(t, out.Bytes())
+	got := []string{}
+	for _, reply := range replies {
+		if reply.Method != "" {
+			got = append(got, reply.Method)
+		} else {
+			got = append(got, strconv.Itoa(reply.ID))
+		}
+	}
+	want := []string{"1", "textDocument/publishDiagnostics", "textDocument/publishDiagnostics", "2", "3", "4",
+		"textDocument/publishDiagnostics", "5", "6", "7"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got messages %v, want %v", got, want)
+	}
+
+	type diagnostics struct {
+		URI         string
+		Diagnostics []lspDiagnostic
+	}
+	var diags diagnostics
+	json.Unmarshal(replies[1].Params, &diags)
+	// every type error is published, not only the first one
+	wantDiags := []lspDiagnostic{{
+		Range:    lspRange{Start: lspPosition{Line: 7, Character: 22}, End: lspPosition{Line: 7, Character: 23}},
+		Severity: 1,
+		Source:   "udon-go",
+		Message:  "undefined: x",
+	}, {
+		Range:    lspRange{Start: lspPosition{Line: 11, Character: 6}, End: lspPosition{Line: 11, Character: 7}},
+		Severity: 1,
+		Source:   "udon-go",
+		Message:  `invalid operation: x + "a" (mismatched types int and untyped string)`,
+	}}
+	if diags.URI != uri || !reflect.DeepEqual(diags.Diagnostics, wantDiags) {
+		t.Errorf("diagnostics of the type mismatches are %+v", diags)
+	}
+	diags = diagnostics{}
+	json.Unmarshal(replies[2].Params, &diags)
+	if len(diags.Diagnostics) != 0 {
+		t.Errorf("diagnostics %+v left once fixed", diags)
+	}
+	diags = diagnostics{}
+	json.Unmarshal(replies[6].Params, &diags)
+	if len(diags.Diagnostics) != 1 || diags.Diagnostics[0].Range.Start != (lspPosition{Line: 13, Character: 3}) {
+		t.Errorf("diagnostics of the member being typed are %+v", diags)
+	}
+
+	var hover struct {
+		Contents struct{ Value string }
+		Range    lspRange
+	}
+	json.Unmarshal(replies[3].Result, &hover)
+	for _, s := range []string{"x + 2 int32", "`SystemInt32`", "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"} {
+		if !strings.Contains(hover.Contents.Value, s) {
+			t.Errorf("no %q in hover %q", s, hover.Contents.Value)
+		}
+	}
+	if hover.Range.Start.Character != 6 || hover.Range.End.Character != 11 {
+		t.Errorf("hover range %+v, want x + 2", hover.Range)
+	}
+	if string(replies[4].Result) != "null" {
+		t.Errorf("hover outside of expressions is %s", replies[4].Result)
+	}
+
+	var list struct{ Items []lspCompletionItem }
+	json.Unmarshal(replies[5].Result, &list)
+	if len(list.Items) == 0 || list.Items[0].Label != "AINavMeshAgentRef" || list.Items[0].Kind != lspClass {
+		t.Errorf("completion of asm. starts with %+v", list.Items[:1])
+	}
+	list.Items = nil
+	json.Unmarshal(replies[7].Result, &list)
+	labels := map[string]int{}
+	for _, item := range list.Items {
+		labels[item.Label] = item.Kind
+	}
+	if labels["GetChild"] != lspMethod || labels["position"] != lspProperty {
+		t.Errorf("completion of T. is %v, want the method GetChild and the property position", labels)
+	}
+	if replies[8].Error == nil || replies[8].Error.Code != lspMethodNotFound {
+		t.Errorf("unsupported request answered with %+v", replies[8])
+	}
+}
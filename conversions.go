@@ -0,0 +1,413 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"strconv"
+	"udon-go/asm"
+)
+
+// numericTypes are the udon types of go numbers, converted between each other with SystemConvert
+var numericTypes = map[asm.UdonTypeName]bool{
+	asm.UdonTypeByte:   true,
+	asm.UdonTypeSByte:  true,
+	asm.UdonTypeInt16:  true,
+	asm.UdonTypeUInt16: true,
+	asm.UdonTypeInt32:  true,
+	asm.UdonTypeUInt32: true,
+	asm.UdonTypeInt64:  true,
+	asm.UdonTypeUInt64: true,
+	asm.UdonTypeSingle: true,
+	asm.UdonTypeDouble: true,
+}
+
+// integerKind is the size and signedness of an integer type
+type integerKind struct {
+	bits   uint
+	signed bool
+}
+
+var integerKinds = map[asm.UdonTypeName]integerKind{
+	asm.UdonTypeByte:   {8, false},
+	asm.UdonTypeSByte:  {8, true},
+	asm.UdonTypeInt16:  {16, true},
+	asm.UdonTypeUInt16: {16, false},
+	asm.UdonTypeInt32:  {32, true},
+	asm.UdonTypeUInt32: {32, false},
+	asm.UdonTypeInt64:  {64, true},
+	asm.UdonTypeUInt64: {64, false},
+}
+
+// widens reports whether the integer type typeName holds every value of srcType
+func widens(srcType asm.UdonTypeName, typeName asm.UdonTypeName) bool {
+	src, dst := integerKinds[srcType], integerKinds[typeName]
+	switch {
+	case src.signed == dst.signed:
+		return dst.bits >= src.bits
+	case dst.signed:
+		return dst.bits > src.bits
+	}
+	return false
+}
+
+func isFloat(typeName asm.UdonTypeName) bool {
+	return typeName == asm.UdonTypeSingle || typeName == asm.UdonTypeDouble
+}
+
+func isInteger(typeName asm.UdonTypeName) bool {
+	return numericTypes[typeName] && !isFloat(typeName)
+}
+
+// isValueType reports whether typeName is a go basic type, everything else is converted as a reference
+func isValueType(typeName asm.UdonTypeName) bool {
+	return numericTypes[typeName] || typeName == asm.UdonTypeChar || typeName == asm.UdonTypeBoolean || typeName == asm.UdonTypeString
+}
+
+// unityType returns the udon type named by asm.Name, e.g. asm.Transform
+func unityType(sel *ast.SelectorExpr) (asm.UdonTypeName, bool) {
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok || pkg.Name != "asm" {
+		return "", false
+	}
+	typeName, ok := asm.UdonTypes[asm.VarName(sel.Sel.Name)]
+	return typeName, ok
+}
+
+// conversionType returns the target type if fun is a type, making the call a conversion T(x)
+func (c *Compiler) conversionType(fun ast.Expr) (asm.UdonTypeName, bool, error) {
+	switch t := fun.(type) {
+	case *ast.ParenExpr:
+		return c.conversionType(t.X)
+	case *ast.Ident:
+		if _, ok := c.structNamed(t); ok {
+			return "", true, fmt.Errorf("cannot convert to struct %s", t.Name)
+		}
+		typeName, err := IdentToUnity(t)
+		if err != nil {
+			return "", false, nil
+		}
+		return typeName, true, nil
+	case *ast.SelectorExpr:
+		if st, ok := c.structNamed(t); ok {
+			return "", true, fmt.Errorf("cannot convert to struct %s", st.Name)
+		}
+		typeName, ok := unityType(t)
+		if !ok {
+			typeName, ok = c.timeType(t)
+		}
+		return typeName, ok, nil
+	case *ast.StarExpr, *ast.MapType, *ast.ArrayType:
+		return "", true, fmt.Errorf("conversion to %T: %w", t, ErrNotImplemented)
+	}
+	return "", false, nil
+}
+
+// handleConversion compiles T(x)
+func (c *Compiler) handleConversion(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr, typeName asm.UdonTypeName) (asm.VarName, error) {
+	if len(expr.Args) != 1 {
+		return "", fmt.Errorf("conversion to %s: expected one argument", typeName)
+	}
+	varName, err := c.handleExpr(uasm, out, expr.Args[0])
+	if err != nil {
+		return "", err
+	}
+	return c.convert(uasm, varName, typeName)
+}
+
+// convert converts varName to typeName.
+// Numbers go through SystemConvert, which checks for overflow, integers narrowed wrap around like in go.
+// Converting a number to a string formats it like strconv.Itoa, not as a rune.
+// Other types are reference casts, which udon checks when the value is used.
+func (c *Compiler) convert(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	srcType, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	if srcType == typeName {
+		return varName, nil
+	}
+	if _, ok := c.VarTypes[varName]; ok {
+		return "", fmt.Errorf("cannot convert %s to %s", varName, typeName)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s(%s)", typeName, varName))
+	switch {
+	case srcType == asm.UdonTypeObject:
+		return c.castFromObject(uasm, varName, typeName)
+	case typeName == asm.UdonTypeString:
+		if isValueType(srcType) {
+			return c.toString(uasm, varName)
+		}
+	case isInteger(typeName) && isFloat(srcType):
+		return c.truncate(uasm, varName, typeName)
+	case isInteger(typeName) && isInteger(srcType) && !widens(srcType, typeName):
+		return c.wrapInteger(uasm, varName, typeName)
+	case numericTypes[typeName] || typeName == asm.UdonTypeChar:
+		if numericTypes[srcType] || srcType == asm.UdonTypeChar {
+			return c.convertExtern(uasm, varName, typeName)
+		}
+	case !isValueType(typeName):
+		if !isValueType(srcType) {
+			return c.copyAs(uasm, varName, typeName)
+		}
+	}
+	return "", fmt.Errorf("cannot convert %s (%s) to %s", varName, srcType, typeName)
+}
+
+// convertExtern calls SystemConvert.To<T>(x)
+func (c *Compiler) convertExtern(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	methodName := asm.UdonMethodName("To" + asm.ShortTypeName(typeName))
+	return c.callExtern(uasm, asm.STATIC_FUNC, asm.UdonTypeConvert, methodName, "", []asm.VarName{varName})
+}
+
+// toString formats a basic value with SystemConvert.ToString
+func (c *Compiler) toString(uasm *asm.UdonAssembly, varName asm.VarName) (asm.VarName, error) {
+	typeName, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	if typeName == asm.UdonTypeString {
+		return varName, nil
+	}
+	if !isValueType(typeName) {
+		varName, err = c.toObject(uasm, varName)
+		if err != nil {
+			return "", err
+		}
+	}
+	return c.callExtern(uasm, asm.STATIC_FUNC, asm.UdonTypeConvert, "ToString", "", []asm.VarName{varName})
+}
+
+// truncate converts a float to an integer rounding toward zero like go does,
+// SystemConvert rounds to the nearest even integer so the result is corrected by one when it overshoots
+func (c *Compiler) truncate(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	srcType, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	r := &routine{c: c, uasm: uasm}
+	zero := r.constOf(srcType, "0")
+	one := r.constOf(srcType, "1")
+	rounded := uasm.GetNextId("rounded")
+	r.fail(uasm.VarTable.AddVar(rounded, srcType, "null"))
+	negativeLabel := r.newLabel("trunc_negative")
+	doneLabel := r.newLabel("trunc_done")
+
+	r.assign(rounded, r.convert(r.convert(varName, typeName), srcType))
+	r.jumpIfFalse(r.op(token.GEQ, varName, zero), negativeLabel)
+	r.jumpIfFalse(r.op(token.GTR, rounded, varName), doneLabel)
+	r.assign(rounded, r.op(token.SUB, rounded, one))
+	r.jump(doneLabel)
+	r.mark(negativeLabel)
+	r.jumpIfFalse(r.op(token.LSS, rounded, varName), doneLabel)
+	r.assign(rounded, r.op(token.ADD, rounded, one))
+	r.mark(doneLabel)
+	result := r.convert(rounded, typeName)
+	if r.err != nil {
+		return "", r.err
+	}
+	return result, nil
+}
+
+// wrapInteger converts an integer to a type not holding all of its values, wrapping around like go does
+// where SystemConvert throws. The value is masked to the size of typeName in a type holding the mask,
+// then taken down by the size of typeName if the sign bit of a signed typeName is set.
+func (c *Compiler) wrapInteger(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	srcType, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	src, dst := integerKinds[srcType], integerKinds[typeName]
+	if dst.bits == 64 {
+		return c.wrapInteger64(uasm, varName, typeName)
+	}
+	r := &routine{c: c, uasm: uasm}
+	mask := strconv.FormatUint(1<<dst.bits-1, 10)
+	workType := asm.UdonTypeName(asm.UdonTypeInt64)
+	if dst.bits < 32 && (src.bits < 32 || srcType == asm.UdonTypeInt32) {
+		workType = asm.UdonTypeInt32
+	}
+	masked := uasm.GetNextId("wrapped")
+	r.fail(uasm.VarTable.AddVar(masked, workType, "null"))
+	value := varName
+	if src.bits == 64 {
+		// masked first, which makes a SystemUInt64 value fit in a SystemInt64
+		value = r.op(token.AND, value, r.constOf(srcType, mask))
+	}
+	if srcType != workType {
+		value = r.convert(value, workType)
+	}
+	if src.bits < 64 {
+		value = r.op(token.AND, value, r.constOf(workType, mask))
+	}
+	r.assign(masked, value)
+	if dst.signed {
+		positiveLabel := r.newLabel("wrap_positive")
+		r.jumpIfFalse(r.op(token.GTR, masked, r.constOf(workType, strconv.FormatUint(1<<(dst.bits-1)-1, 10))), positiveLabel)
+		r.assign(masked, r.op(token.SUB, masked, r.constOf(workType, strconv.FormatUint(1<<dst.bits, 10))))
+		r.mark(positiveLabel)
+	}
+	result := r.convert(masked, typeName)
+	if r.err != nil {
+		return "", r.err
+	}
+	return result, nil
+}
+
+// wrapInteger64 converts between SystemInt64 and SystemUInt64, and from the smaller signed integers to SystemUInt64.
+// The values out of range of typeName are moved by 2^63 into range, converted, then moved by 2^63 again.
+func (c *Compiler) wrapInteger64(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	srcType, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	r := &routine{c: c, uasm: uasm}
+	if typeName == asm.UdonTypeUInt64 && srcType != asm.UdonTypeInt64 {
+		varName = r.convert(varName, asm.UdonTypeInt64)
+	}
+	result := uasm.GetNextId("wrapped")
+	r.fail(uasm.VarTable.AddVar(result, typeName, "null"))
+	outOfRangeLabel := r.newLabel("wrap_out_of_range")
+	doneLabel := r.newLabel("wrap_done")
+	if typeName == asm.UdonTypeUInt64 {
+		r.jumpIfFalse(r.op(token.GEQ, varName, r.constOf(asm.UdonTypeInt64, "0")), outOfRangeLabel)
+	} else {
+		r.jumpIfFalse(r.op(token.LEQ, varName, r.constOf(asm.UdonTypeUInt64, "9223372036854775807")), outOfRangeLabel)
+	}
+	r.assign(result, r.convert(varName, typeName))
+	r.jump(doneLabel)
+	r.mark(outOfRangeLabel)
+	if typeName == asm.UdonTypeUInt64 {
+		// x - MinInt64 is x + 2^63, which is positive for a negative x
+		shifted := r.op(token.SUB, varName, r.constOf(asm.UdonTypeInt64, "-9223372036854775808"))
+		r.assign(result, r.op(token.ADD, r.convert(shifted, typeName), r.constOf(asm.UdonTypeUInt64, "9223372036854775808")))
+	} else {
+		shifted := r.op(token.SUB, varName, r.constOf(asm.UdonTypeUInt64, "9223372036854775808"))
+		r.assign(result, r.op(token.ADD, r.convert(shifted, typeName), r.constOf(asm.UdonTypeInt64, "-9223372036854775808")))
+	}
+	r.mark(doneLabel)
+	if r.err != nil {
+		return "", r.err
+	}
+	return result, nil
+}
+
+// copyAs copies varName into a new variable of typeName
+func (c *Compiler) copyAs(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	castVarName := uasm.GetNextId("cast")
+	err := uasm.VarTable.AddVar(castVarName, typeName, "null")
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	uasm.AddInstComment(fmt.Sprintf("cast %s to %s", varName, typeName))
+	uasm.PushVar(varName)
+	uasm.PushVar(castVarName)
+	uasm.Copy()
+	return castVarName, nil
+}
+
+func (r *routine) constOf(typeName asm.UdonTypeName, value string) asm.VarName {
+	constNextID := r.uasm.GetNextId("const")
+	r.fail(r.uasm.VarTable.AddVar(constNextID, typeName, value))
+	return constNextID
+}
+
+func (r *routine) convert(varName asm.VarName, typeName asm.UdonTypeName) asm.VarName {
+	if r.err != nil {
+		return ""
+	}
+	retVarName, err := r.c.convertExtern(r.uasm, varName, typeName)
+	r.fail(err)
+	return retVarName
+}
+
+// handleStrconvCallExpr compiles the formatting functions of strconv
+func (c *Compiler) handleStrconvCallExpr(uasm *asm.UdonAssembly, out io.Writer, name string, args []ast.Expr) (asm.VarName, error) {
+	var argType asm.UdonTypeName
+	switch name {
+	case "Itoa":
+		argType = asm.UdonTypeInt32
+	case "FormatBool":
+		argType = asm.UdonTypeBoolean
+	default:
+		return "", fmt.Errorf("call strconv.%s: %w", name, ErrNotImplemented)
+	}
+	if len(args) != 1 {
+		return "", fmt.Errorf("strconv.%s: unsupported # of args: %d", name, len(args))
+	}
+	varName, err := c.handleExpr(uasm, out, args[0])
+	if err != nil {
+		return "", fmt.Errorf("strconv.%s: %w", name, err)
+	}
+	typeName, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	if typeName != argType {
+		return "", fmt.Errorf("strconv.%s: cannot use %s as %s", name, typeName, argType)
+	}
+	return c.toString(uasm, varName)
+}
+
+// handleFmtCallExpr compiles fmt.Sprint, formatting every operand with ToString
+func (c *Compiler) handleFmtCallExpr(uasm *asm.UdonAssembly, out io.Writer, name string, args []ast.Expr) (asm.VarName, error) {
+	if name != "Sprint" {
+		return "", fmt.Errorf("call fmt.%s: %w", name, ErrNotImplemented)
+	}
+	result := asm.VarName("")
+	prevIsString := false
+	for i, arg := range args {
+		varName, err := c.handleExpr(uasm, out, arg)
+		if err != nil {
+			return "", fmt.Errorf("fmt.Sprint: %w", err)
+		}
+		if varName == "" {
+			return "", errors.New("fmt.Sprint: argument has no value")
+		}
+		typeName, err := uasm.VarTable.GetVarType(varName)
+		if err != nil {
+			return "", err
+		}
+		isString := typeName == asm.UdonTypeString
+		str, err := c.toString(uasm, varName)
+		if err != nil {
+			return "", fmt.Errorf("fmt.Sprint: %w", err)
+		}
+		if i == 0 {
+			result = str
+		} else {
+			// like fmt, operands are separated by a space when neither is a string
+			if !isString && !prevIsString {
+				space, err := c.constString(uasm, " ")
+				if err != nil {
+					return "", err
+				}
+				result, err = c.binaryOp(uasm, token.ADD, result, space)
+				if err != nil {
+					return "", err
+				}
+			}
+			result, err = c.binaryOp(uasm, token.ADD, result, str)
+			if err != nil {
+				return "", err
+			}
+		}
+		prevIsString = isString
+	}
+	if result == "" {
+		return c.constString(uasm, "")
+	}
+	return result, nil
+}
+
+// constString adds a string constant holding value
+func (c *Compiler) constString(uasm *asm.UdonAssembly, value string) (asm.VarName, error) {
+	constNextID := uasm.GetNextId("const")
+	err := uasm.VarTable.AddVar(constNextID, asm.UdonTypeString, fmt.Sprintf("%q", value))
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	return constNextID, nil
+}
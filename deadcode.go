@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"sort"
+)
+
+// liveStmts splits a statement list after the first statement control does not go past.
+// The statements after it are unreachable, unless a label makes them a jump target again.
+func liveStmts(list []ast.Stmt) (live []ast.Stmt, dead []ast.Stmt) {
+	for i, st := range list {
+		if !terminates(st) {
+			continue
+		}
+		rest := list[i+1:]
+		for j, st := range rest {
+			if _, ok := st.(*ast.LabeledStmt); ok {
+				more, moreDead := liveStmts(rest[j:])
+				return append(list[:i+1:i+1], more...), append(rest[:j:j], moreDead...)
+			}
+		}
+		return list[:i+1], rest
+	}
+	return list, nil
+}
+
+// inspectLive is ast.Inspect skipping the unreachable statements of blocks
+func inspectLive(node ast.Node, f func(ast.Node) bool) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		block, ok := n.(*ast.BlockStmt)
+		if !ok {
+			return f(n)
+		}
+		if f(block) {
+			live, _ := liveStmts(block.List)
+			for _, st := range live {
+				inspectLive(st, f)
+			}
+		}
+		return false
+	})
+}
+
+// reportDeadCode lists the functions of the behaviour which are never called and the unreachable statements
+// of the linked functions, none of which are compiled
+func reportDeadCode(w io.Writer, prog *Program, reachable map[*ast.FuncDecl]bool) {
+	for i, pkg := range prog.Packages {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				decl, ok := decl.(*ast.FuncDecl)
+				if !ok || decl.Body == nil {
+					continue
+				}
+				if !reachable[decl] {
+					// helper packages may hold many functions a behaviour never calls, only the behaviour's are listed
+					if i == 0 && decl.Recv == nil {
+						fmt.Fprintf(w, "%s: removed %s, it is never called\n", prog.Fset.Position(decl.Pos()), decl.Name.Name)
+					}
+					continue
+				}
+				unreachable := []token.Pos{}
+				inspectLive(decl.Body, func(n ast.Node) bool {
+					if block, ok := n.(*ast.BlockStmt); ok {
+						if _, dead := liveStmts(block.List); len(dead) > 0 {
+							unreachable = append(unreachable, dead[0].Pos())
+						}
+					}
+					return true
+				})
+				sort.Slice(unreachable, func(i, j int) bool { return unreachable[i] < unreachable[j] })
+				for _, pos := range unreachable {
+					fmt.Fprintf(w, "%s: removed unreachable code\n", prog.Fset.Position(pos))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package debug
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"udon-go/asm"
+	"udon-go/vm"
+)
+
+// consoleHelp lists the commands of Console
+const consoleHelp = `run [event]          start an event, _start by default, and run it to a breakpoint
+break file:line      stop before the code of a source line
+break 0xaddr         stop before an instruction
+clear                remove the breakpoints
+continue, c          run to the next breakpoint or the end of the event
+next, n              run to the next line, stepping over the calls
+step, s              run to the next line, stepping into the calls
+stepi, si            run one instruction
+out                  run until the current function returns
+print, p name        print a variable by go name or heap name
+locals               print the variables of the current function
+globals              print the package variables
+bt                   print the calls being run
+stack                print the variables on the vm stack
+quit, q              leave the debugger
+`
+
+// Console runs the debugger commands read from r, one per line, until quit or the end of the input.
+// The errors of the commands are written to w, only failing to write ends the console early.
+func (d *Debugger) Console(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for {
+		_, err := fmt.Fprint(w, "(udon) ")
+		if err != nil {
+			return err
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "q" {
+			return nil
+		}
+		err = d.command(w, fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+		}
+	}
+}
+
+// command runs one console command
+func (d *Debugger) command(w io.Writer, name string, args []string) error {
+	stepping := map[string]func() (StopReason, error){
+		"continue": d.Continue, "c": d.Continue,
+		"next": d.Next, "n": d.Next,
+		"step": d.StepIn, "s": d.StepIn,
+		"out": d.StepOut,
+	}
+	if step, ok := stepping[name]; ok {
+		if !d.Running() {
+			return errors.New("no event running")
+		}
+		reason, err := step()
+		if err != nil {
+			return err
+		}
+		d.printStop(w, reason)
+		return nil
+	}
+	switch name {
+	case "help", "h":
+		fmt.Fprint(w, consoleHelp)
+	case "run", "r":
+		event := asm.EventName("_start")
+		if len(args) > 0 {
+			event = asm.EventName(args[0])
+		}
+		err := d.Start(event)
+		if err != nil {
+			return err
+		}
+		reason := StopBreakpoint
+		if !d.breakpoints[asm.Addr(d.VM.PC)] {
+			reason, err = d.Continue()
+			if err != nil {
+				return err
+			}
+		}
+		d.printStop(w, reason)
+	case "stepi", "si":
+		if !d.Running() {
+			return errors.New("no event running")
+		}
+		err := d.StepInstruction()
+		if err != nil {
+			return err
+		}
+		reason := StopStep
+		if !d.Running() {
+			reason = StopEnd
+		}
+		d.printStop(w, reason)
+	case "break", "b":
+		if len(args) != 1 {
+			return errors.New("usage: break file:line or break 0xaddr")
+		}
+		return d.breakAt(w, args[0])
+	case "clear":
+		d.breakpoints = map[asm.Addr]bool{}
+	case "print", "p":
+		if len(args) != 1 {
+			return errors.New("usage: print name")
+		}
+		v, err := d.Var(args[0])
+		if err != nil {
+			return err
+		}
+		printVars(w, []*Variable{v})
+	case "locals":
+		if len(d.frames) == 0 {
+			return errors.New("no event running")
+		}
+		printVars(w, d.Locals(d.frames[len(d.frames)-1]))
+	case "globals":
+		printVars(w, d.Globals())
+	case "bt":
+		for i, f := range d.Frames() {
+			fmt.Fprintf(w, "#%d %s at %s\n", i, f.Name, d.where(f.PC))
+		}
+	case "stack":
+		printVars(w, d.Stack())
+	default:
+		return fmt.Errorf("unknown command %s, try help", name)
+	}
+	return nil
+}
+
+// breakAt sets the breakpoint of a break command
+func (d *Debugger) breakAt(w io.Writer, at string) error {
+	if strings.HasPrefix(at, "0x") {
+		addr, err := strconv.ParseUint(at[2:], 16, 32)
+		if err != nil {
+			return fmt.Errorf("bad address %s", at)
+		}
+		err = d.SetBreakpoint(asm.Addr(addr))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "breakpoint at %s\n", d.where(asm.Addr(addr)))
+		return nil
+	}
+	i := strings.LastIndex(at, ":")
+	line, err := strconv.Atoi(at[i+1:])
+	if i < 0 || err != nil {
+		return fmt.Errorf("bad line %s, want file:line", at)
+	}
+	addrs, err := d.SetLineBreakpoint(at[:i], line)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		fmt.Fprintf(w, "breakpoint at %s\n", d.where(addr))
+	}
+	return nil
+}
+
+// printStop tells where the event stopped
+func (d *Debugger) printStop(w io.Writer, reason StopReason) {
+	if reason == StopEnd {
+		fmt.Fprintln(w, "event ended")
+		return
+	}
+	fmt.Fprintf(w, "%s at %s\n", reason, d.where(asm.Addr(d.VM.PC)))
+	if inst := d.VM.Instruction(); inst != nil {
+		fmt.Fprintf(w, "    0x%08x  %s\n", inst.Addr, inst)
+	}
+}
+
+// where names an address and its source position
+func (d *Debugger) where(addr asm.Addr) string {
+	s := fmt.Sprintf("0x%08x", addr)
+	if d.SourceMap != nil {
+		if pos, ok := d.SourceMap.Lookup(addr); ok {
+			s = fmt.Sprintf("%s (%s)", pos, s)
+		}
+	}
+	return s
+}
+
+func printVars(w io.Writer, vars []*Variable) {
+	for _, v := range vars {
+		fmt.Fprintf(w, "%s %s = %s\n", v.Name, asm.GoTypeName(v.Type), FormatValue(v.Value))
+	}
+}
+
+// FormatValue writes a heap value the way go would, quoting strings and characters
+func FormatValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return strconv.Quote(value)
+	case vm.Char:
+		return strconv.QuoteRune(rune(value))
+	case *vm.Object:
+		return fmt.Sprintf("%s(%s)", value.Type, value.Name)
+	case *vm.Array:
+		elems := []string{}
+		for _, elem := range value.Elems {
+			elems = append(elems, FormatValue(elem))
+		}
+		return fmt.Sprintf("%s{%s}", value.Type, strings.Join(elems, ", "))
+	}
+	return fmt.Sprintf("%v", value)
+}
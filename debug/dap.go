@@ -0,0 +1,460 @@
+package debug
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"udon-go/asm"
+	"udon-go/vm"
+)
+
+// dapMessage is a request, response or event of the debug adapter protocol
+type dapMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	RequestSeq int             `json:"request_seq,omitempty"`
+	Success    *bool           `json:"success,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	Event      string          `json:"event,omitempty"`
+	Body       interface{}     `json:"body,omitempty"`
+}
+
+// LaunchArgs are the arguments of the launch request
+type LaunchArgs struct {
+	// Program is the path of the assembly or binary program
+	Program string `json:"program"`
+	// SourceMap is the path of its source map, the program path with asm.SourceMapExt when empty
+	SourceMap string `json:"sourceMap"`
+	// Event is the event run, _start when empty
+	Event       asm.EventName `json:"event"`
+	StopOnEntry bool          `json:"stopOnEntry"`
+}
+
+// dapThread is the only thread, the event being run
+const dapThread = 1
+
+// dapServer debugs one program for an editor speaking the debug adapter protocol
+type dapServer struct {
+	r   *textproto.Reader
+	w   *bufio.Writer
+	seq int
+	d   *Debugger
+	// event is the event launched
+	event       asm.EventName
+	stopOnEntry bool
+	// vars are the variables of the scopes handed out since the last stop, by reference
+	vars map[int][]*Variable
+}
+
+// ServeDAP reads debug adapter protocol requests from r and writes the responses and events to w,
+// until the client disconnects or r ends
+func ServeDAP(r io.Reader, w io.Writer) error {
+	s := &dapServer{r: textproto.NewReader(bufio.NewReader(r)), w: bufio.NewWriter(w), vars: map[int][]*Variable{}}
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done, err := s.handle(req)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// read reads the next message, framed by a Content-Length header
+func (s *dapServer) read() (*dapMessage, error) {
+	header, err := s.r.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("dap header: %w", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("dap header: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(s.r.R, data)
+	if err != nil {
+		return nil, fmt.Errorf("dap message: %w", err)
+	}
+	msg := &dapMessage{}
+	err = json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, fmt.Errorf("dap message: %w", err)
+	}
+	return msg, nil
+}
+
+// send writes a message, numbering it
+func (s *dapServer) send(msg *dapMessage) error {
+	s.seq++
+	msg.Seq = s.seq
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(data))
+	s.w.Write(data)
+	return s.w.Flush()
+}
+
+func (s *dapServer) respond(req *dapMessage, body interface{}, err error) error {
+	success := err == nil
+	msg := &dapMessage{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: &success, Body: body}
+	if err != nil {
+		msg.Message = err.Error()
+	}
+	return s.send(msg)
+}
+
+func (s *dapServer) sendEvent(event string, body interface{}) error {
+	return s.send(&dapMessage{Type: "event", Event: event, Body: body})
+}
+
+// handle answers a request, reporting whether the client disconnected.
+// The errors of requests are sent back to the client, only failing to write is returned.
+func (s *dapServer) handle(req *dapMessage) (bool, error) {
+	if req.Type != "request" {
+		return false, nil
+	}
+	body, after, err := s.dispatch(req)
+	if err != nil {
+		return false, s.respond(req, nil, err)
+	}
+	err = s.respond(req, body, nil)
+	if err != nil {
+		return false, err
+	}
+	if after != nil {
+		err = after()
+		if err != nil {
+			return false, err
+		}
+	}
+	return req.Command == "disconnect", nil
+}
+
+// dispatch runs a request, returning the body of its response and what to do once it is sent
+func (s *dapServer) dispatch(req *dapMessage) (interface{}, func() error, error) {
+	if s.d == nil {
+		switch req.Command {
+		case "initialize", "launch", "disconnect":
+		default:
+			return nil, nil, fmt.Errorf("%s before launch", req.Command)
+		}
+	}
+	switch req.Command {
+	case "initialize":
+		body := map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsSteppingGranularity":      true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+		}
+		return body, func() error { return s.sendEvent("initialized", nil) }, nil
+	case "launch":
+		args := &LaunchArgs{}
+		err := json.Unmarshal(req.Arguments, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, s.launch(args)
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setInstructionBreakpoints":
+		return s.setInstructionBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{"breakpoints": []interface{}{}}, nil, nil
+	case "configurationDone":
+		err := s.d.Start(s.event)
+		if err != nil {
+			return nil, nil, err
+		}
+		if s.stopOnEntry {
+			return nil, func() error { return s.stopped("entry") }, nil
+		}
+		return nil, func() error { return s.resume(s.d.Continue) }, nil
+	case "threads":
+		threads := []map[string]interface{}{{"id": dapThread, "name": string(s.event)}}
+		return map[string]interface{}{"threads": threads}, nil, nil
+	case "stackTrace":
+		return s.stackTrace(), nil, nil
+	case "scopes":
+		return s.scopes(req.Arguments)
+	case "variables":
+		return s.variables(req.Arguments)
+	case "evaluate":
+		args := &struct {
+			Expression string `json:"expression"`
+		}{}
+		err := json.Unmarshal(req.Arguments, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := s.d.Var(strings.TrimSpace(args.Expression))
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{"result": FormatValue(v.Value), "type": asm.GoTypeName(v.Type), "variablesReference": 0}, nil, nil
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, func() error { return s.resume(s.d.Continue) }, nil
+	case "next", "stepIn", "stepOut":
+		steps := map[string]func() (StopReason, error){"next": s.d.Next, "stepIn": s.d.StepIn, "stepOut": s.d.StepOut}
+		step := steps[req.Command]
+		args := &struct {
+			Granularity string `json:"granularity"`
+		}{}
+		json.Unmarshal(req.Arguments, args)
+		if args.Granularity == "instruction" && req.Command != "stepOut" {
+			step = s.stepInstruction
+		}
+		return nil, func() error { return s.resume(step) }, nil
+	case "pause":
+		// the event runs to its stop within the request which started it
+		return nil, nil, nil
+	case "disconnect":
+		return nil, nil, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+// launch loads the program and its source map
+func (s *dapServer) launch(args *LaunchArgs) error {
+	data, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	m, err := vm.Load(string(data))
+	if err != nil {
+		return err
+	}
+	smPath := args.SourceMap
+	if smPath == "" {
+		smPath = strings.TrimSuffix(args.Program, filepath.Ext(args.Program)) + asm.SourceMapExt
+	}
+	sm, err := ReadSourceMap(smPath)
+	if err != nil && (args.SourceMap != "" || !os.IsNotExist(errors.Unwrap(err))) {
+		return err
+	}
+	m.SourceMap = sm
+	s.d = New(m, sm)
+	s.event = args.Event
+	if s.event == "" {
+		s.event = "_start"
+	}
+	s.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+// ReadSourceMap reads a source map written by the compiler
+func ReadSourceMap(path string) (*asm.SourceMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("source map: %w", err)
+	}
+	sm := &asm.SourceMap{}
+	err = json.Unmarshal(data, sm)
+	if err != nil {
+		return nil, fmt.Errorf("source map %s: %w", path, err)
+	}
+	return sm, nil
+}
+
+// stepInstruction runs one instruction as a step
+func (s *dapServer) stepInstruction() (StopReason, error) {
+	err := s.d.StepInstruction()
+	if err != nil || !s.d.Running() {
+		return StopEnd, err
+	}
+	if s.d.breakpoints[asm.Addr(s.d.VM.PC)] {
+		return StopBreakpoint, nil
+	}
+	return StopStep, nil
+}
+
+// resume runs the event and tells the client where it stopped
+func (s *dapServer) resume(run func() (StopReason, error)) error {
+	if !s.d.Running() {
+		return s.sendEvent("terminated", nil)
+	}
+	reason, err := run()
+	if err != nil {
+		err = s.sendEvent("output", map[string]interface{}{"category": "stderr", "output": err.Error() + "\n"})
+		if err != nil {
+			return err
+		}
+		return s.sendEvent("terminated", nil)
+	}
+	for _, line := range s.d.VM.Log {
+		err = s.sendEvent("output", map[string]interface{}{"category": "stdout", "output": line + "\n"})
+		if err != nil {
+			return err
+		}
+	}
+	s.d.VM.Log = nil
+	switch reason {
+	case StopEnd:
+		err = s.sendEvent("exited", map[string]interface{}{"exitCode": 0})
+		if err != nil {
+			return err
+		}
+		return s.sendEvent("terminated", nil)
+	case StopBreakpoint:
+		return s.stopped("breakpoint")
+	}
+	return s.stopped("step")
+}
+
+func (s *dapServer) stopped(reason string) error {
+	s.vars = map[int][]*Variable{}
+	return s.sendEvent("stopped", map[string]interface{}{"reason": reason, "threadId": dapThread, "allThreadsStopped": true})
+}
+
+func (s *dapServer) setBreakpoints(arguments json.RawMessage) (interface{}, func() error, error) {
+	args := &struct {
+		Source struct {
+			Path string `json:"path"`
+		} `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}{}
+	err := json.Unmarshal(arguments, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.d.ClearLineBreakpoints(args.Source.Path)
+	breakpoints := []map[string]interface{}{}
+	for _, bp := range args.Breakpoints {
+		_, err := s.d.SetLineBreakpoint(args.Source.Path, bp.Line)
+		breakpoint := map[string]interface{}{"verified": err == nil, "line": bp.Line}
+		if err != nil {
+			breakpoint["message"] = err.Error()
+		}
+		breakpoints = append(breakpoints, breakpoint)
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil, nil
+}
+
+// setInstructionBreakpoints replaces the breakpoints set by address
+func (s *dapServer) setInstructionBreakpoints(arguments json.RawMessage) (interface{}, func() error, error) {
+	args := &struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+		} `json:"breakpoints"`
+	}{}
+	err := json.Unmarshal(arguments, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	lines := map[asm.Addr]bool{}
+	if s.d.SourceMap != nil {
+		for _, pos := range s.d.SourceMap.Code {
+			lines[pos.Addr] = true
+		}
+	}
+	for addr := range s.d.breakpoints {
+		if !lines[addr] {
+			s.d.ClearBreakpoint(addr)
+		}
+	}
+	breakpoints := []map[string]interface{}{}
+	for _, bp := range args.Breakpoints {
+		addr, err := strconv.ParseUint(bp.InstructionReference, 0, 32)
+		if err == nil {
+			err = s.d.SetBreakpoint(asm.Addr(int(addr) + bp.Offset))
+		}
+		breakpoint := map[string]interface{}{"verified": err == nil}
+		if err != nil {
+			breakpoint["message"] = err.Error()
+		}
+		breakpoints = append(breakpoints, breakpoint)
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}, nil, nil
+}
+
+func (s *dapServer) stackTrace() interface{} {
+	frames := []map[string]interface{}{}
+	for i, f := range s.d.Frames() {
+		frame := map[string]interface{}{
+			"id":                          i,
+			"name":                        f.Name,
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%08x", f.PC),
+		}
+		if pos, ok := s.d.FramePosition(f); ok {
+			frame["source"] = map[string]interface{}{"name": filepath.Base(pos.File), "path": pos.File}
+			frame["line"], frame["column"] = pos.Line, pos.Col
+		}
+		frames = append(frames, frame)
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// scopes hands out the locals of a frame, the globals and the vm stack.
+// Their references are 1 + 3 * the frame id, then 2 + and 3 +.
+func (s *dapServer) scopes(arguments json.RawMessage) (interface{}, func() error, error) {
+	args := &struct {
+		FrameID int `json:"frameId"`
+	}{}
+	err := json.Unmarshal(arguments, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	frames := s.d.Frames()
+	if args.FrameID < 0 || args.FrameID >= len(frames) {
+		return nil, nil, fmt.Errorf("no frame %d", args.FrameID)
+	}
+	ref := 1 + 3*args.FrameID
+	s.vars[ref] = s.d.Locals(frames[args.FrameID])
+	s.vars[ref+1] = s.d.Globals()
+	s.vars[ref+2] = s.d.Stack()
+	scopes := []map[string]interface{}{
+		{"name": "Locals", "presentationHint": "locals", "variablesReference": ref, "expensive": false},
+		{"name": "Globals", "variablesReference": ref + 1, "expensive": false},
+		{"name": "VM Stack", "presentationHint": "registers", "variablesReference": ref + 2, "expensive": false},
+	}
+	return map[string]interface{}{"scopes": scopes}, nil, nil
+}
+
+func (s *dapServer) variables(arguments json.RawMessage) (interface{}, func() error, error) {
+	args := &struct {
+		VariablesReference int `json:"variablesReference"`
+	}{}
+	err := json.Unmarshal(arguments, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	vars := []map[string]interface{}{}
+	for _, v := range s.vars[args.VariablesReference] {
+		vars = append(vars, map[string]interface{}{
+			"name":               v.Name,
+			"value":              FormatValue(v.Value),
+			"type":               asm.GoTypeName(v.Type),
+			"evaluateName":       v.Name,
+			"variablesReference": 0,
+		})
+	}
+	return map[string]interface{}{"variables": vars}, nil, nil
+}
@@ -0,0 +1,154 @@
+package debug_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"udon-go/asm"
+	"udon-go/debug"
+)
+
+// dapSession frames the requests of a client
+func dapSession(requests ...string) io.Reader {
+	b := &bytes.Buffer{}
+	for i, req := range requests {
+		msg := fmt.Sprintf(`{"seq":%d,"type":"request",%s}`, i+1, req)
+		fmt.Fprintf(b, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return b
+}
+
+// readDAP splits the output of the server into messages
+func readDAP(t *testing.T, data []byte) []map[string]interface{} {
+	t.Helper()
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	msgs := []map[string]interface{}{}
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := make([]byte, n)
+		_, err = io.ReadFull(r.R, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := map[string]interface{}{}
+		err = json.Unmarshal(body, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestServeDAP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := filepath.Join(dir, "main.uasm")
+	err = ioutil.WriteFile(program, []byte(doubleProgram), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := json.Marshal(&asm.SourceMap{
+		Code: []asm.SourcePos{
+			{Addr: 0x00, File: "/src/main.go", Line: 11, Col: 2},
+			{Addr: 0x40, File: "/src/main.go", Line: 3, Col: 1},
+			{Addr: 0x58, File: "/src/main.go", Line: 4, Col: 2},
+		},
+		Vars:  map[asm.VarName]string{"Result": "Result", "_start_x": "x", "double__SystemInt32_n": "n"},
+		Funcs: []asm.SourceFunc{{Addr: 0, Name: "_start", Label: "_start"}, {Addr: 0x40, Name: "double", Label: "double__SystemInt32"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "main"+asm.SourceMapExt), sm, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := dapSession(
+		`"command":"initialize","arguments":{"adapterID":"udon"}`,
+		fmt.Sprintf(`"command":"launch","arguments":{"program":%q}`, program),
+		`"command":"setBreakpoints","arguments":{"source":{"path":"/src/main.go"},"breakpoints":[{"line":4},{"line":6}]}`,
+		`"command":"configurationDone"`,
+		`"command":"stackTrace","arguments":{"threadId":1}`,
+		`"command":"scopes","arguments":{"frameId":0}`,
+		`"command":"variables","arguments":{"variablesReference":1}`,
+		`"command":"evaluate","arguments":{"expression":"y"}`,
+		`"command":"stepOut","arguments":{"threadId":1}`,
+		`"command":"continue","arguments":{"threadId":1}`,
+		`"command":"disconnect"`,
+		`"command":"threads"`,
+	)
+	out := &bytes.Buffer{}
+	err = debug.ServeDAP(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := readDAP(t, out.Bytes())
+	// the messages in order, a response as its command, an event as event:name
+	got := []string{}
+	for _, msg := range msgs {
+		switch msg["type"] {
+		case "response":
+			s := msg["command"].(string)
+			if msg["success"] != true {
+				s += " failed: " + msg["message"].(string)
+			}
+			got = append(got, s)
+		case "event":
+			got = append(got, "event:"+msg["event"].(string))
+		}
+	}
+	want := []string{
+		"initialize", "event:initialized", "launch", "setBreakpoints", "configurationDone", "event:stopped",
+		"stackTrace", "scopes", "variables", "evaluate failed: undefined: y",
+		"stepOut", "event:stopped", "continue", "event:exited", "event:terminated", "disconnect",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	body := func(i int) map[string]interface{} {
+		return msgs[i]["body"].(map[string]interface{})
+	}
+	breakpoints := body(3)["breakpoints"].([]interface{})
+	if breakpoints[0].(map[string]interface{})["verified"] != true || breakpoints[1].(map[string]interface{})["verified"] != false {
+		t.Errorf("breakpoints %v, want the one of line 4 verified", breakpoints)
+	}
+	if reason := body(5)["reason"]; reason != "breakpoint" {
+		t.Errorf("stopped for %v, want breakpoint", reason)
+	}
+	frames := body(6)["stackFrames"].([]interface{})
+	top := frames[0].(map[string]interface{})
+	if len(frames) != 2 || top["name"] != "double" || top["line"] != 4.0 || top["source"].(map[string]interface{})["path"] != "/src/main.go" {
+		t.Errorf("stack frames %v", frames)
+	}
+	vars := body(8)["variables"].([]interface{})
+	if len(vars) != 1 || vars[0].(map[string]interface{})["name"] != "n" || vars[0].(map[string]interface{})["value"] != "3" {
+		t.Errorf("locals %v, want n = 3", vars)
+	}
+	if reason := body(11)["reason"]; reason != "step" {
+		t.Errorf("stopped for %v, want step", reason)
+	}
+}
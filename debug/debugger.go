@@ -0,0 +1,387 @@
+// Package debug steps through the events run by the vm in terms of the go source they are compiled from.
+// Calls and returns are recognized by the convention of asm.UdonAssembly.CallDefFunc:
+// the caller pushes a variable holding the address after its jump, the callee returns with JUMP_INDIRECT.
+package debug
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"udon-go/asm"
+	"udon-go/vm"
+)
+
+// StopReason tells why the debugger stopped running the event
+type StopReason int
+
+const (
+	// StopStep is the end of a step
+	StopStep StopReason = iota
+	// StopBreakpoint is a breakpoint reached
+	StopBreakpoint
+	// StopEnd is the end of the event
+	StopEnd
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopStep:
+		return "step"
+	case StopBreakpoint:
+		return "breakpoint"
+	}
+	return "end"
+}
+
+// Debugger runs the events of a vm one step at a time
+type Debugger struct {
+	VM *vm.VM
+	// SourceMap maps the program to its source, nil to debug the assembly
+	SourceMap *asm.SourceMap
+	// frames are the calls being run, innermost last
+	frames      []*Frame
+	breakpoints map[asm.Addr]bool
+}
+
+// Frame is an event or a function being run
+type Frame struct {
+	// Name is the go name of the function, its label or its address without a source map
+	Name string
+	// Label prefixes the heap variables of the function, "" if it is not known
+	Label asm.LabelName
+	// Entry is the address the function starts at, Return the address it returns to
+	Entry  asm.Addr
+	Return asm.Addr
+	// PC is the address of the next instruction run in the frame
+	PC asm.Addr
+}
+
+// Variable is a heap variable as seen from the source
+type Variable struct {
+	// Name is the go identifier of the variable, its heap name if it has none
+	Name    string
+	VarName asm.VarName
+	Type    asm.UdonTypeName
+	Value   interface{}
+}
+
+// New returns a debugger for the vm, sm may be nil
+func New(m *vm.VM, sm *asm.SourceMap) *Debugger {
+	return &Debugger{VM: m, SourceMap: sm, breakpoints: map[asm.Addr]bool{}}
+}
+
+// Start makes the event the next code to run, stopping before its first instruction
+func (d *Debugger) Start(event asm.EventName) error {
+	err := d.VM.Start(event)
+	if err != nil {
+		return err
+	}
+	d.frames = []*Frame{d.frame(asm.Addr(d.VM.PC), asm.Addr(0xFFFFFFFF))}
+	return nil
+}
+
+// frame names the function starting at entry
+func (d *Debugger) frame(entry asm.Addr, ret asm.Addr) *Frame {
+	f := &Frame{Name: fmt.Sprintf("0x%08x", entry), Entry: entry, Return: ret, PC: entry}
+	if d.SourceMap != nil {
+		if fn, ok := d.SourceMap.Func(entry); ok {
+			f.Name, f.Label = fn.Name, fn.Label
+			return f
+		}
+	}
+	if inst := d.VM.Instruction(); inst != nil && inst.Addr == entry {
+		for _, head := range inst.Heads {
+			f.Name, f.Label = string(head), asm.LabelName(head)
+			return f
+		}
+		for _, label := range inst.Labels {
+			f.Name, f.Label = string(label), label
+			return f
+		}
+	}
+	return f
+}
+
+// Running reports whether the event has not ended yet
+func (d *Debugger) Running() bool {
+	return d.VM.Running()
+}
+
+// SetBreakpoint stops the event before the instruction at addr
+func (d *Debugger) SetBreakpoint(addr asm.Addr) error {
+	for _, inst := range d.VM.Program.Code {
+		if inst.Addr == addr {
+			d.breakpoints[addr] = true
+			return nil
+		}
+	}
+	return fmt.Errorf("no instruction at %#x", addr)
+}
+
+// SetLineBreakpoint stops the event before the code of a line of the source, returning its addresses.
+// A file without directory matches the files of that name.
+func (d *Debugger) SetLineBreakpoint(file string, line int) ([]asm.Addr, error) {
+	if d.SourceMap == nil {
+		return nil, errors.New("no source map")
+	}
+	addrs := []asm.Addr{}
+	for _, pos := range d.SourceMap.Code {
+		if pos.Line == line && sameFile(pos.File, file) {
+			d.breakpoints[pos.Addr] = true
+			addrs = append(addrs, pos.Addr)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no code at %s:%d", file, line)
+	}
+	return addrs, nil
+}
+
+// sameFile reports whether two paths name the same file, comparing only the base name when one of them is one
+func sameFile(a string, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	if a == b {
+		return true
+	}
+	if filepath.Base(a) == a || filepath.Base(b) == b {
+		return filepath.Base(a) == filepath.Base(b)
+	}
+	return false
+}
+
+// ClearBreakpoint removes the breakpoint at addr
+func (d *Debugger) ClearBreakpoint(addr asm.Addr) {
+	delete(d.breakpoints, addr)
+}
+
+// ClearLineBreakpoints removes the breakpoints of the lines of a file
+func (d *Debugger) ClearLineBreakpoints(file string) {
+	if d.SourceMap == nil {
+		return
+	}
+	for _, pos := range d.SourceMap.Code {
+		if sameFile(pos.File, file) {
+			delete(d.breakpoints, pos.Addr)
+		}
+	}
+}
+
+// Breakpoints returns the addresses of the breakpoints in order
+func (d *Debugger) Breakpoints() []asm.Addr {
+	addrs := []asm.Addr{}
+	for addr := range d.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// StepInstruction runs the next instruction, following the calls and returns
+func (d *Debugger) StepInstruction() error {
+	inst := d.VM.Instruction()
+	if inst == nil {
+		return errors.New("the event has ended")
+	}
+	ret := inst.Addr + inst.Op.Size()
+	call := inst.Op == asm.JUMP && inst.Arg != asm.HaltAddr && d.pushedAddr(ret)
+	err := d.VM.Step()
+	if err != nil {
+		return err
+	}
+	pc := asm.Addr(d.VM.PC)
+	switch {
+	case call:
+		d.frames = append(d.frames, d.frame(pc, ret))
+	case inst.Op == asm.JUMP_INDIRECT && len(d.frames) > 1 && d.frames[len(d.frames)-1].Return == pc:
+		d.frames = d.frames[:len(d.frames)-1]
+	}
+	d.frames[len(d.frames)-1].PC = pc
+	return nil
+}
+
+// pushedAddr reports whether a variable on the stack holds addr, the return address of a call
+func (d *Debugger) pushedAddr(addr asm.Addr) bool {
+	for _, i := range d.VM.Stack {
+		if value, ok := d.VM.Heap[i].(uint32); ok && asm.Addr(value) == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Continue runs the event until a breakpoint or its end
+func (d *Debugger) Continue() (StopReason, error) {
+	return d.run(func() bool { return false })
+}
+
+// StepIn runs the event until the next line of the source, entering the functions called
+func (d *Debugger) StepIn() (StopReason, error) {
+	line := d.line()
+	return d.run(func() bool { return d.line() != line })
+}
+
+// Next runs the event until the next line of the source in the current function or its callers
+func (d *Debugger) Next() (StopReason, error) {
+	line, depth := d.line(), len(d.frames)
+	return d.run(func() bool { return len(d.frames) < depth || len(d.frames) == depth && d.line() != line })
+}
+
+// StepOut runs the event until the current function returns
+func (d *Debugger) StepOut() (StopReason, error) {
+	depth := len(d.frames)
+	return d.run(func() bool { return len(d.frames) < depth })
+}
+
+// run steps until done or a breakpoint, at least one instruction
+func (d *Debugger) run(done func() bool) (StopReason, error) {
+	for {
+		err := d.StepInstruction()
+		if err != nil {
+			return StopEnd, err
+		}
+		if !d.Running() {
+			return StopEnd, nil
+		}
+		if d.breakpoints[asm.Addr(d.VM.PC)] {
+			return StopBreakpoint, nil
+		}
+		if done() {
+			return StopStep, nil
+		}
+	}
+}
+
+// line names the source line of the next instruction, its address without a source map.
+// Instructions without a position count as part of the line before them.
+func (d *Debugger) line() string {
+	if pos, ok := d.Position(); ok {
+		return fmt.Sprintf("%s:%d", pos.File, pos.Line)
+	}
+	return fmt.Sprintf("%#x", d.VM.PC)
+}
+
+// Position returns the source position of the next instruction
+func (d *Debugger) Position() (asm.SourcePos, bool) {
+	if d.SourceMap == nil || !d.Running() {
+		return asm.SourcePos{}, false
+	}
+	return d.SourceMap.Lookup(asm.Addr(d.VM.PC))
+}
+
+// Frames returns the calls being run, innermost first
+func (d *Debugger) Frames() []*Frame {
+	frames := []*Frame{}
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		frames = append(frames, d.frames[i])
+	}
+	return frames
+}
+
+// FramePosition returns the source position of the next instruction run by a frame
+func (d *Debugger) FramePosition(f *Frame) (asm.SourcePos, bool) {
+	if d.SourceMap == nil {
+		return asm.SourcePos{}, false
+	}
+	return d.SourceMap.Lookup(f.PC)
+}
+
+// Locals returns the variables declared by the function of a frame, by go name
+func (d *Debugger) Locals(f *Frame) []*Variable {
+	if f.Label == "" {
+		return []*Variable{}
+	}
+	return d.variables(func(varName asm.VarName) bool { return d.owner(varName) == f.Label })
+}
+
+// Globals returns the variables declared outside of the functions
+func (d *Debugger) Globals() []*Variable {
+	return d.variables(func(varName asm.VarName) bool { return d.owner(varName) == "" })
+}
+
+// owner returns the label of the function declaring a heap variable, the longest label prefixing its name
+func (d *Debugger) owner(varName asm.VarName) asm.LabelName {
+	owner := asm.LabelName("")
+	for _, fn := range d.SourceMap.Funcs {
+		if strings.HasPrefix(string(varName), string(fn.Label)+"_") && len(fn.Label) > len(owner) {
+			owner = fn.Label
+		}
+	}
+	return owner
+}
+
+// variables returns the variables of the source map kept by filter, in order of name
+func (d *Debugger) variables(keep func(varName asm.VarName) bool) []*Variable {
+	vars := []*Variable{}
+	if d.SourceMap == nil {
+		return vars
+	}
+	for varName, name := range d.SourceMap.Vars {
+		if !keep(varName) {
+			continue
+		}
+		if v, ok := d.heapVar(varName); ok {
+			v.Name = name
+			vars = append(vars, v)
+		}
+	}
+	sort.Slice(vars, func(i, j int) bool {
+		if vars[i].Name != vars[j].Name {
+			return vars[i].Name < vars[j].Name
+		}
+		return vars[i].VarName < vars[j].VarName
+	})
+	return vars
+}
+
+// heapVar returns a heap variable by its heap name
+func (d *Debugger) heapVar(varName asm.VarName) (*Variable, bool) {
+	i, ok := d.VM.Program.VarIndex(varName)
+	if !ok {
+		return nil, false
+	}
+	item := d.VM.Program.Vars[i]
+	return &Variable{Name: string(varName), VarName: varName, Type: item.TypeName, Value: d.VM.Heap[i]}, true
+}
+
+// Var finds a variable as the innermost frame sees it: its locals by go name, then the globals, then any heap variable.
+// A shadowed name refers to the variable declared last.
+func (d *Debugger) Var(name string) (*Variable, error) {
+	scopes := [][]*Variable{}
+	if len(d.frames) > 0 {
+		scopes = append(scopes, d.Locals(d.frames[len(d.frames)-1]))
+	}
+	scopes = append(scopes, d.Globals())
+	for _, vars := range scopes {
+		var found *Variable
+		for _, v := range vars {
+			if v.Name == name {
+				found = v
+			}
+		}
+		if found != nil {
+			return found, nil
+		}
+	}
+	if v, ok := d.heapVar(asm.VarName(name)); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("undefined: %s", name)
+}
+
+// Stack returns the variables whose addresses are on the vm stack, the top last
+func (d *Debugger) Stack() []*Variable {
+	vars := []*Variable{}
+	for _, i := range d.VM.Stack {
+		item := d.VM.Program.Vars[i]
+		v, _ := d.heapVar(item.VarName)
+		if d.SourceMap != nil {
+			if name, ok := d.SourceMap.Vars[item.VarName]; ok {
+				v.Name = name
+			}
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
@@ -0,0 +1,247 @@
+package debug_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"udon-go/asm"
+	"udon-go/debug"
+	"udon-go/vm"
+)
+
+// doubleProgram is compiled from doubleSource
+const doubleProgram = `.data_start
+
+    .export Result
+    Result: %SystemInt32, 0
+    _start_x: %SystemInt32, 3
+    double__SystemInt32_n: %SystemInt32, null
+    ret_addr: %SystemUInt32, 0xFFFFFFFF
+    __const_ret_addr_0: %SystemUInt32, 0x00000018
+    __ret_value_1: %SystemInt32, null
+    __extern_ret_2: %SystemInt32, null
+
+.data_end
+
+.code_start
+
+    .export _start
+    _start:
+        PUSH, __const_ret_addr_0
+        PUSH, _start_x
+        JUMP, 0x00000040
+        PUSH, __ret_value_1
+        COPY
+        PUSH, __ret_value_1
+        PUSH, Result
+        COPY
+        JUMP, 0xFFFFFFFF
+        PUSH, double__SystemInt32_n
+        COPY
+        PUSH, ret_addr
+        COPY
+        PUSH, double__SystemInt32_n
+        PUSH, double__SystemInt32_n
+        PUSH, __extern_ret_2
+        EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"
+        PUSH, __extern_ret_2
+        JUMP_INDIRECT, ret_addr
+
+.code_end
+`
+
+// doubleSource is the go source of doubleProgram
+const doubleSource = `package main
+
+func double(n int) int {
+	return n + n
+}
+
+var Result int
+
+func main() {
+	x := 3
+	Result = double(x)
+}
+`
+
+func newDebugger(t *testing.T) *debug.Debugger {
+	t.Helper()
+	m, err := vm.Load(doubleProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := &asm.SourceMap{
+		Code: []asm.SourcePos{
+			{Addr: 0x00, File: "/src/main.go", Line: 11, Col: 2},
+			{Addr: 0x40, File: "/src/main.go", Line: 3, Col: 1},
+			{Addr: 0x58, File: "/src/main.go", Line: 4, Col: 2},
+		},
+		Vars:  map[asm.VarName]string{"Result": "Result", "_start_x": "x", "double__SystemInt32_n": "n"},
+		Funcs: []asm.SourceFunc{{Addr: 0, Name: "_start", Label: "_start"}, {Addr: 0x40, Name: "double", Label: "double__SystemInt32"}},
+	}
+	d := debug.New(m, sm)
+	err = d.Start("_start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// frameNames returns the functions being run, innermost first
+func frameNames(d *debug.Debugger) []string {
+	names := []string{}
+	for _, f := range d.Frames() {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestDebugger_Steps(t *testing.T) {
+	type stop struct {
+		reason debug.StopReason
+		line   int
+		frames []string
+	}
+	tests := []struct {
+		name string
+		// breakpoint is the line of a breakpoint, 0 for none
+		breakpoint int
+		steps      []func(d *debug.Debugger) (debug.StopReason, error)
+		want       []stop
+	}{
+		{"continue", 0, []func(d *debug.Debugger) (debug.StopReason, error){(*debug.Debugger).Continue},
+			[]stop{{debug.StopEnd, 0, []string{"_start"}}}},
+		{"breakpoint", 4, []func(d *debug.Debugger) (debug.StopReason, error){(*debug.Debugger).Continue, (*debug.Debugger).Continue},
+			[]stop{{debug.StopBreakpoint, 4, []string{"double", "_start"}}, {debug.StopEnd, 0, []string{"_start"}}}},
+		{"step in and out", 0, []func(d *debug.Debugger) (debug.StopReason, error){(*debug.Debugger).StepIn, (*debug.Debugger).StepIn, (*debug.Debugger).StepOut},
+			[]stop{{debug.StopStep, 3, []string{"double", "_start"}}, {debug.StopStep, 4, []string{"double", "_start"}}, {debug.StopStep, 11, []string{"_start"}}}},
+		{"next over the call", 0, []func(d *debug.Debugger) (debug.StopReason, error){(*debug.Debugger).Next},
+			[]stop{{debug.StopEnd, 0, []string{"_start"}}}},
+		{"next stops at a breakpoint in the call", 4, []func(d *debug.Debugger) (debug.StopReason, error){(*debug.Debugger).Next, (*debug.Debugger).Next},
+			[]stop{{debug.StopBreakpoint, 4, []string{"double", "_start"}}, {debug.StopStep, 11, []string{"_start"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDebugger(t)
+			if tt.breakpoint != 0 {
+				_, err := d.SetLineBreakpoint("main.go", tt.breakpoint)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, step := range tt.steps {
+				reason, err := step(d)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := stop{reason, 0, frameNames(d)}
+				if pos, ok := d.Position(); ok {
+					got.line = pos.Line
+				}
+				if !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("step %d stopped at %+v, want %+v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDebugger_Vars(t *testing.T) {
+	d := newDebugger(t)
+	_, err := d.SetLineBreakpoint("/src/main.go", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reason, err := d.Continue()
+	if err != nil || reason != debug.StopBreakpoint {
+		t.Fatalf("stopped with %v, %v", reason, err)
+	}
+	n, err := d.Var("n")
+	if err != nil || n.VarName != "double__SystemInt32_n" || n.Value != int32(3) {
+		t.Errorf("n is %+v, %v", n, err)
+	}
+	// x is a local of the caller
+	if _, err := d.Var("x"); err == nil {
+		t.Error("x found in double")
+	}
+	frames := d.Frames()
+	locals := d.Locals(frames[1])
+	if len(locals) != 1 || locals[0].Name != "x" || locals[0].Value != int32(3) {
+		t.Errorf("locals of _start are %+v", locals)
+	}
+	if pos, ok := d.FramePosition(frames[1]); !ok || pos.Line != 11 {
+		t.Errorf("_start is at %v", pos)
+	}
+	globals := d.Globals()
+	if len(globals) != 1 || globals[0].Name != "Result" {
+		t.Errorf("globals are %+v", globals)
+	}
+	err = d.StepInstruction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := d.Stack()
+	if len(stack) != 1 || stack[0].Name != "n" || stack[0].VarName != "double__SystemInt32_n" {
+		t.Errorf("stack is %+v", stack)
+	}
+	_, err = d.StepOut()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.Var("Result")
+	if err != nil || result.Value != int32(6) {
+		t.Errorf("Result is %+v, %v", result, err)
+	}
+}
+
+func TestDebugger_Errors(t *testing.T) {
+	d := newDebugger(t)
+	if _, err := d.SetLineBreakpoint("main.go", 6); err == nil {
+		t.Error("breakpoint set on a line without code")
+	}
+	if err := d.SetBreakpoint(0x44); err == nil {
+		t.Error("breakpoint set inside an instruction")
+	}
+	if _, err := d.Continue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StepInstruction(); err == nil {
+		t.Error("step after the end of the event")
+	}
+}
+
+func TestDebugger_Console(t *testing.T) {
+	m, err := vm.Load(doubleProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without source map, the debugger works on the assembly
+	d := debug.New(m, nil)
+	input := "break 0x58\nrun\nbt\np double__SystemInt32_n\nsi\nstack\nfrob\nc\np Result\nq\n"
+	b := &strings.Builder{}
+	err = d.Console(strings.NewReader(input), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"breakpoint at 0x00000058",
+		"breakpoint at 0x00000058\n    0x00000058  PUSH, double__SystemInt32_n",
+		"#0 0x00000040 at 0x00000058\n#1 _start at 0x00000010",
+		"double__SystemInt32_n int32 = 3",
+		"step at 0x00000060",
+		"double__SystemInt32_n int32 = 3",
+		"error: unknown command frob, try help",
+		"event ended",
+		"Result int32 = 6",
+	}
+	for _, s := range want {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("no %q in\n%s", s, b.String())
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"udon-go/asm"
+)
+
+// thisUdonVarName holds the behaviour itself, which delayed calls send their events to
+const thisUdonVarName = asm.VarName("this_udon")
+
+// DelayedCall is a function run later by a go statement, udon.After or udon.NextFrame.
+// Udon has no threads, so it is compiled into a hidden custom event which the behaviour
+// sends to itself with SendCustomEventDelayedSeconds or SendCustomEventDelayedFrames.
+// The values the function needs are queued when it is scheduled, as the call site may be
+// scheduled again before the event runs, and the event copies them into its heap slots.
+// The enclosing function can not assign the captured variables once the call is scheduled,
+// the event would not see the new values.
+type DelayedCall struct {
+	Event asm.EventName
+	// Captures are the local variables the function refers to, bound to their slots
+	Captures []*ScopeVar
+	// Params are the parameters of a function literal, bound to the slots holding the arguments
+	Params []*ScopeVar
+	// Slots are the heap slots of the event, Values the variables queued for them when it is scheduled
+	Slots  []asm.VarName
+	Values []asm.VarName
+	// compile emits the body of the event
+	compile func() error
+}
+
+// The pending calls of a call site taking arguments or capturing variables are queued in a SystemObjectArray,
+// which holds an entry per pending call followed by nulls. An entry is a SystemObjectArray of the time the
+// call is due, as a SystemSingle number of seconds or frames, followed by the values of the slots.
+// Every pending call sends the same event, so the event takes the entry due first, and the first
+// queued of those due at the same time, as udon sends them in order.
+var (
+	delayedPushFunc = RuntimeFunc{
+		Name:     "__delayed_push",
+		ArgNames: []asm.VarName{"queue", "entry"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray, asm.UdonTypeObjectArray},
+		RetType:  asm.UdonTypeObjectArray,
+	}
+	delayedPopFunc = RuntimeFunc{
+		Name:     "__delayed_pop",
+		ArgNames: []asm.VarName{"queue"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray},
+		RetType:  asm.UdonTypeObjectArray,
+	}
+	delayedRuntimeFuncs = []RuntimeFunc{delayedPushFunc, delayedPopFunc}
+)
+
+const delayedInitialCapacity = 4
+
+// durations are the time constants in nanoseconds, the value of a time.Duration
+var durations = map[string]string{
+	"Nanosecond":  "1",
+	"Microsecond": "1000",
+	"Millisecond": "1000000",
+	"Second":      "1000000000",
+	"Minute":      "60000000000",
+	"Hour":        "3600000000000",
+}
+
+// timeType returns the udon type of time.Duration, which is a number of nanoseconds
+func (c *Compiler) timeType(sel *ast.SelectorExpr) (asm.UdonTypeName, bool) {
+	if !c.isPkg(sel.X, "time") || sel.Sel.Name != "Duration" {
+		return "", false
+	}
+	return asm.UdonTypeInt64, true
+}
+
+// durationConst returns the value of time.Second and the other time constants
+func (c *Compiler) durationConst(sel *ast.SelectorExpr) (string, bool) {
+	if !c.isPkg(sel.X, "time") {
+		return "", false
+	}
+	value, ok := durations[sel.Sel.Name]
+	return value, ok
+}
+
+// isPkg reports whether x names the imported package pkg, rather than a variable shadowing it
+func (c *Compiler) isPkg(x ast.Expr, pkg string) bool {
+	ident, ok := x.(*ast.Ident)
+	if !ok || ident.Name != pkg {
+		return false
+	}
+	_, ok = c.Scope.Lookup(pkg)
+	return !ok
+}
+
+// handleGoStmt compiles go f(x), which runs f once the current event has returned.
+// Like in go the arguments are evaluated by the go statement.
+func (c *Compiler) handleGoStmt(uasm *asm.UdonAssembly, out io.Writer, st *ast.GoStmt) error {
+	call, err := c.delayedCall(uasm, out, st.Call.Fun, st.Call.Args)
+	if err != nil {
+		return fmt.Errorf("go: %w", err)
+	}
+	frames, err := c.typedConst(uasm, "0", asm.UdonTypeInt32)
+	if err != nil {
+		return err
+	}
+	return c.schedule(uasm, call, frames)
+}
+
+// handleUdonCallExpr compiles the scheduling functions of the udon package
+func (c *Compiler) handleUdonCallExpr(uasm *asm.UdonAssembly, out io.Writer, name string, args []ast.Expr) (asm.VarName, error) {
+	switch name {
+	case "After":
+		if len(args) != 2 {
+			return "", fmt.Errorf("udon.After: unsupported # of args: %d", len(args))
+		}
+		dVarName, err := c.handleExpr(uasm, out, args[0])
+		if err != nil {
+			return "", fmt.Errorf("udon.After: %w", err)
+		}
+		seconds, err := c.seconds(uasm, dVarName)
+		if err != nil {
+			return "", fmt.Errorf("udon.After: %w", err)
+		}
+		call, err := c.delayedCall(uasm, out, args[1], nil)
+		if err != nil {
+			return "", fmt.Errorf("udon.After: %w", err)
+		}
+		return "", c.schedule(uasm, call, seconds)
+	case "NextFrame":
+		if len(args) != 1 {
+			return "", fmt.Errorf("udon.NextFrame: unsupported # of args: %d", len(args))
+		}
+		call, err := c.delayedCall(uasm, out, args[0], nil)
+		if err != nil {
+			return "", fmt.Errorf("udon.NextFrame: %w", err)
+		}
+		frames, err := c.typedConst(uasm, "1", asm.UdonTypeInt32)
+		if err != nil {
+			return "", err
+		}
+		return "", c.schedule(uasm, call, frames)
+	}
+	return "", fmt.Errorf("call udon.%s: %w", name, ErrNotImplemented)
+}
+
+// seconds converts a time.Duration to the SystemSingle number of seconds taken by SendCustomEventDelayedSeconds
+func (c *Compiler) seconds(uasm *asm.UdonAssembly, dVarName asm.VarName) (asm.VarName, error) {
+	typeName, err := uasm.VarTable.GetVarType(dVarName)
+	if err != nil {
+		return "", err
+	}
+	if value, ok := c.UntypedConsts[dVarName]; ok {
+		dVarName, err = c.typedConst(uasm, value, asm.UdonTypeInt64)
+		if err != nil {
+			return "", err
+		}
+	} else if typeName != asm.UdonTypeInt64 {
+		return "", fmt.Errorf("cannot use %s (%s) as time.Duration", dVarName, typeName)
+	}
+	r := &routine{c: c, uasm: uasm}
+	seconds := r.op(token.QUO, r.convert(dVarName, asm.UdonTypeDouble), r.constOf(asm.UdonTypeDouble, "1000000000"))
+	result := r.convert(seconds, asm.UdonTypeSingle)
+	if r.err != nil {
+		return "", r.err
+	}
+	return result, nil
+}
+
+// delayedCall prepares the hidden event running fun(args...), copying the arguments and captured variables into its slots
+func (c *Compiler) delayedCall(uasm *asm.UdonAssembly, out io.Writer, fun ast.Expr, args []ast.Expr) (*DelayedCall, error) {
+	call := &DelayedCall{Event: asm.EventName(uasm.GetNextId("delayed"))}
+	switch f := fun.(type) {
+	case *ast.ParenExpr:
+		return c.delayedCall(uasm, out, f.X, args)
+	case *ast.FuncLit:
+		return call, c.delayedFuncLit(uasm, out, call, f, args)
+	case *ast.Ident:
+		if _, ok := c.Scope.Lookup(f.Name); ok {
+			return nil, c.errorf(f.Pos(), "cannot run %s later, function values are not supported", f.Name)
+		}
+		if f.Name == "nil" {
+			return nil, c.errorf(f.Pos(), "cannot run nil later, a function is required")
+		}
+		funcName := asm.FuncName(c.qualify(f.Name))
+		if _, ok := c.Funcs[funcName]; !ok {
+			return nil, c.errorf(f.Pos(), "cannot run %s later, it is not a function", f.Name)
+		}
+		return call, c.delayedFunc(uasm, out, call, f, funcName, args)
+	case *ast.SelectorExpr:
+		pkg, ok, err := c.importedName(f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			funcName := asm.FuncName(pkg.qualify(f.Sel.Name))
+			if _, ok := c.Funcs[funcName]; !ok {
+				return nil, c.errorf(f.Pos(), "cannot run %s later, it is not a function", types.ExprString(f))
+			}
+			return call, c.delayedFunc(uasm, out, call, f, funcName, args)
+		}
+	}
+	return nil, c.errorf(fun.Pos(), "only functions of the program and function literals can run later")
+}
+
+// delayedFunc copies the arguments of a call to a function of the program into slots of the call
+func (c *Compiler) delayedFunc(uasm *asm.UdonAssembly, out io.Writer, call *DelayedCall, fun ast.Expr, funcName asm.FuncName, args []ast.Expr) error {
+	name := types.ExprString(fun)
+	slots := []asm.VarName{}
+	for i, arg := range args {
+		argVarName, err := c.handleExpr(uasm, out, arg)
+		if err != nil {
+			return err
+		}
+		slot, err := c.delayedSlot(uasm, call, fmt.Sprintf("arg%d", i), argVarName)
+		if err != nil {
+			return err
+		}
+		slots = append(slots, slot)
+	}
+	call.compile = func() error {
+		_, err := c.callFunc(uasm, out, fun.Pos(), funcName, name, slots)
+		return err
+	}
+	return nil
+}
+
+// delayedFuncLit binds the parameters and captured variables of a function literal to slots of the call
+func (c *Compiler) delayedFuncLit(uasm *asm.UdonAssembly, out io.Writer, call *DelayedCall, lit *ast.FuncLit, args []ast.Expr) error {
+	if lit.Type.Results != nil && len(lit.Type.Results.List) > 0 {
+		return c.errorf(lit.Pos(), "functions run later can not return values")
+	}
+	params := map[string]bool{}
+	i := 0
+	for _, field := range lit.Type.Params.List {
+		typeName, info, err := c.ResolveType(field.Type)
+		if err != nil {
+			return err
+		}
+		for _, name := range field.Names {
+			if i >= len(args) {
+				return c.errorf(lit.Pos(), "not enough arguments in call to function literal")
+			}
+			argVarName, err := c.handleExpr(uasm, out, args[i])
+			if err != nil {
+				return err
+			}
+			argVarName, err = c.argAs(uasm, argVarName, typeName)
+			if err != nil {
+				return err
+			}
+			i++
+			if name.Name == "_" {
+				continue
+			}
+			slot, err := c.delayedSlot(uasm, call, name.Name, argVarName)
+			if err != nil {
+				return err
+			}
+			c.SetTypeInfo(slot, info)
+			params[name.Name] = true
+			call.Params = append(call.Params, &ScopeVar{Name: name.Name, VarName: slot, Pos: name.Pos(), Used: true})
+		}
+	}
+	if i != len(args) {
+		return c.errorf(lit.Pos(), "too many arguments in call to function literal")
+	}
+	captures := c.capturedVars(lit, params)
+	for _, v := range captures {
+		v.Used = true
+		if !v.Scheduled.IsValid() {
+			v.Scheduled = lit.Pos()
+		}
+		slot, err := c.delayedSlot(uasm, call, v.Name, v.VarName)
+		if err != nil {
+			return err
+		}
+		call.Captures = append(call.Captures, &ScopeVar{Name: v.Name, VarName: slot, Pos: v.Pos, Used: true, Captured: true})
+	}
+	call.compile = func() error {
+		return c.handleBlockStmt(uasm, out, lit.Body)
+	}
+	return nil
+}
+
+// argAs checks that an argument has the type of its parameter, typing untyped constants
+func (c *Compiler) argAs(uasm *asm.UdonAssembly, argVarName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	argType, err := uasm.VarTable.GetVarType(argVarName)
+	if err != nil {
+		return "", err
+	}
+	if argType == typeName {
+		return argVarName, nil
+	}
+	if value, ok := c.UntypedConsts[argVarName]; ok && numericTypes[typeName] {
+		return c.typedConst(uasm, value, typeName)
+	}
+	return "", fmt.Errorf("cannot use %s (%s) as %s", argVarName, argType, typeName)
+}
+
+// capturedVars returns the local variables of the enclosing functions lit refers to, in order of appearance
+func (c *Compiler) capturedVars(lit *ast.FuncLit, params map[string]bool) []*ScopeVar {
+	seen := map[*ScopeVar]bool{}
+	vars := []*ScopeVar{}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr:
+			// the selected name is a field, not a variable
+			ast.Inspect(n.X, visit)
+			return false
+		case *ast.Ident:
+			if params[n.Name] {
+				return false
+			}
+			v, ok := c.Scope.lookupLocal(n.Name)
+			if ok && !seen[v] {
+				seen[v] = true
+				vars = append(vars, v)
+			}
+		}
+		return true
+	}
+	ast.Inspect(lit.Body, visit)
+	return vars
+}
+
+// delayedSlot adds a heap slot to the call, which receives the value srcVarName has when the call is scheduled
+func (c *Compiler) delayedSlot(uasm *asm.UdonAssembly, call *DelayedCall, name string, srcVarName asm.VarName) (asm.VarName, error) {
+	typeName, err := uasm.VarTable.GetVarType(srcVarName)
+	if err != nil {
+		return "", err
+	}
+	slot := asm.VarName(fmt.Sprintf("%s_%s", call.Event, name))
+	err = uasm.VarTable.AddVar(slot, typeName, "null")
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	c.HeapNames[slot] = true
+	c.SetTypeInfo(slot, c.VarTypes[srcVarName])
+	call.Slots = append(call.Slots, slot)
+	call.Values = append(call.Values, srcVarName)
+	return slot, nil
+}
+
+// queueName is the heap variable holding the pending calls of a call site
+func (call *DelayedCall) queueName() asm.VarName {
+	return asm.VarName(fmt.Sprintf("%s_queue", call.Event))
+}
+
+// enqueue adds the values of the slots of call to its queue, with the time the call is due
+func (c *Compiler) enqueue(uasm *asm.UdonAssembly, call *DelayedCall, delay asm.VarName, delayType asm.UdonTypeName) error {
+	c.UsesDelayedSlots = true
+	queue := call.queueName()
+	err := uasm.VarTable.AddVar(queue, asm.UdonTypeObjectArray, "null")
+	if err != nil {
+		return fmt.Errorf("add var: %w", err)
+	}
+	r := &routine{c: c, uasm: uasm}
+	var due asm.VarName
+	if delayType == asm.UdonTypeInt32 {
+		frame := r.extern(asm.STATIC_FUNC, asm.UdonTypeTime, "get_frameCount", "")
+		due = r.convert(r.op(token.ADD, frame, delay), asm.UdonTypeSingle)
+	} else {
+		due = r.op(token.ADD, r.extern(asm.STATIC_FUNC, asm.UdonTypeTime, "get_time", ""), delay)
+	}
+	entry := r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", r.constInt(len(call.Values)+1))
+	r.set(entry, r.constInt(0), due)
+	for i, value := range call.Values {
+		r.set(entry, r.constInt(i+1), value)
+	}
+	r.assign(queue, r.call(delayedPushFunc.Name, queue, entry))
+	return r.err
+}
+
+// dequeue copies the values of the pending call due first into the slots of call
+func (c *Compiler) dequeue(uasm *asm.UdonAssembly, call *DelayedCall) error {
+	r := &routine{c: c, uasm: uasm}
+	entry := r.call(delayedPopFunc.Name, call.queueName())
+	for i, slot := range call.Slots {
+		typeName, err := uasm.VarTable.GetVarType(slot)
+		if err != nil {
+			return err
+		}
+		r.assign(slot, r.get(entry, r.constInt(i+1), typeName))
+	}
+	return r.err
+}
+
+// schedule sends the event of call to this behaviour after delay,
+// a SystemSingle number of seconds or a SystemInt32 number of frames
+func (c *Compiler) schedule(uasm *asm.UdonAssembly, call *DelayedCall, delay asm.VarName) error {
+	delayType, err := uasm.VarTable.GetVarType(delay)
+	if err != nil {
+		return err
+	}
+	methodName := asm.UdonMethodName("SendCustomEventDelayedSeconds")
+	if delayType == asm.UdonTypeInt32 {
+		methodName = "SendCustomEventDelayedFrames"
+	}
+	if len(call.Slots) > 0 {
+		err = c.enqueue(uasm, call, delay, delayType)
+		if err != nil {
+			return fmt.Errorf("schedule %s: %w", call.Event, err)
+		}
+	}
+	if _, ok := uasm.VarTable.Find(thisUdonVarName); !ok {
+		err = uasm.VarTable.AddVar(thisUdonVarName, asm.UdonTypeIUdonEventReceiver, "this")
+		if err != nil {
+			return fmt.Errorf("add var: %w", err)
+		}
+	}
+	err = uasm.AddEvent(call.Event, nil, nil)
+	if err != nil {
+		return err
+	}
+	c.HiddenEvents[call.Event] = true
+	// the zero EventTiming is Update
+	timing, err := c.zeroValue(uasm, asm.UdonTypeEventTiming)
+	if err != nil {
+		return err
+	}
+	eventVarName, err := c.constString(uasm, string(call.Event))
+	if err != nil {
+		return err
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s %s", methodName, call.Event))
+	_, err = c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeIUdonEventReceiver, methodName, thisUdonVarName,
+		[]asm.VarName{eventVarName, delay, timing})
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", call.Event, err)
+	}
+	c.DelayedCalls = append(c.DelayedCalls, call)
+	return nil
+}
+
+// emitDelayedCalls compiles the hidden events of the calls scheduled so far, including the ones they schedule themselves
+func (c *Compiler) emitDelayedCalls(uasm *asm.UdonAssembly, out io.Writer) error {
+	for len(c.DelayedCalls) > 0 {
+		call := c.DelayedCalls[0]
+		c.DelayedCalls = c.DelayedCalls[1:]
+		err := c.emitDelayedCall(uasm, out, call)
+		if err != nil {
+			return fmt.Errorf("delayed call %s: %w", call.Event, err)
+		}
+	}
+	return nil
+}
+
+// emitDelayedCall compiles the event of call, which reads the captured variables from their slots
+func (c *Compiler) emitDelayedCall(uasm *asm.UdonAssembly, out io.Writer, call *DelayedCall) error {
+	eventLabel := asm.LabelName(call.Event)
+	uasm.VarTable.SetCurrentFuncID(&eventLabel)
+	c.CurrentEvent = &call.Event
+	uasm.EventHead(call.Event)
+	if len(call.Slots) > 0 {
+		err := c.dequeue(uasm, call)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.pushScope(FuncScope)
+	for _, v := range call.Captures {
+		c.Scope.Vars[v.Name] = v
+	}
+	err := c.inBlockScope(func() error {
+		for _, v := range call.Params {
+			c.Scope.Vars[v.Name] = v
+		}
+		return call.compile()
+	})
+	if err != nil {
+		return err
+	}
+	err = c.popScope()
+	if err != nil {
+		return err
+	}
+	uasm.End()
+	uasm.VarTable.SetCurrentFuncID(nil)
+	c.CurrentEvent = nil
+	return nil
+}
+
+// registerDelayedRuntime makes the delayed call runtime callable, it is only emitted when c.UsesDelayedSlots is set
+func registerDelayedRuntime(uasm *asm.UdonAssembly) {
+	for _, f := range delayedRuntimeFuncs {
+		f.register(uasm)
+	}
+}
+
+// emitDelayedRuntime emits the runtime functions queueing the pending calls of call sites
+func (c *Compiler) emitDelayedRuntime(uasm *asm.UdonAssembly) error {
+	uasm.AddComment("delayed call runtime: the pending calls of a call site are queued in a SystemObjectArray,")
+	uasm.AddComment("each a SystemObjectArray [due, slot values...], followed by nulls.")
+	for _, emit := range []func(*asm.UdonAssembly) error{
+		c.emitDelayedPush,
+		c.emitDelayedPop,
+	} {
+		err := emit(uasm)
+		if err != nil {
+			return fmt.Errorf("delayed call runtime: %w", err)
+		}
+	}
+	return nil
+}
+
+// __delayed_push stores entry in the first free slot of queue, growing it when it is full, and returns the queue
+func (c *Compiler) emitDelayedPush(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, delayedPushFunc)
+	queue, entry := args[0], args[1]
+	i := r.local("i", asm.UdonTypeInt32)
+	j := r.local("j", asm.UdonTypeInt32)
+	allocatedLabel := r.newLabel("delayed_push_allocated")
+	findLabel := r.newLabel("delayed_push_find")
+	growLabel := r.newLabel("delayed_push_grow")
+	copyLabel := r.newLabel("delayed_push_copy")
+	copiedLabel := r.newLabel("delayed_push_copied")
+	placeLabel := r.newLabel("delayed_push_place")
+
+	r.jumpIfFalse(r.op(token.EQL, queue, r.constNil()), allocatedLabel)
+	r.assign(queue, r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", r.constInt(delayedInitialCapacity)))
+	r.mark(allocatedLabel)
+	capacity := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", queue)
+	r.assign(i, r.constInt(0))
+
+	r.mark(findLabel)
+	r.jumpIfFalse(r.op(token.LSS, i, capacity), growLabel)
+	r.jumpIfFalse(r.op(token.NEQ, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", queue, i), r.constNil()), placeLabel)
+	r.assign(i, r.op(token.ADD, i, r.constInt(1)))
+	r.jump(findLabel)
+
+	// the queue is full, i is its capacity
+	r.mark(growLabel)
+	grown := r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", r.op(token.MUL, capacity, r.constInt(2)))
+	r.assign(j, r.constInt(0))
+	r.mark(copyLabel)
+	r.jumpIfFalse(r.op(token.LSS, j, capacity), copiedLabel)
+	r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Set", grown, j, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", queue, j))
+	r.assign(j, r.op(token.ADD, j, r.constInt(1)))
+	r.jump(copyLabel)
+	r.mark(copiedLabel)
+	r.assign(queue, grown)
+
+	r.mark(placeLabel)
+	r.set(queue, i, entry)
+	r.ret(queue)
+	return r.end()
+}
+
+// __delayed_pop removes the entry due first from queue, the first queued of those due at the same time, and returns it
+func (c *Compiler) emitDelayedPop(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, delayedPopFunc)
+	queue := args[0]
+	i := r.local("i", asm.UdonTypeInt32)
+	best := r.local("best", asm.UdonTypeInt32)
+	bestDue := r.local("best_due", asm.UdonTypeSingle)
+	result := r.local("result", asm.UdonTypeObjectArray)
+	findLabel := r.newLabel("delayed_pop_find")
+	nextLabel := r.newLabel("delayed_pop_next")
+	foundLabel := r.newLabel("delayed_pop_found")
+	shiftLabel := r.newLabel("delayed_pop_shift")
+	doneLabel := r.newLabel("delayed_pop_done")
+
+	capacity := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", queue)
+	r.assign(best, r.constInt(0))
+	r.assign(bestDue, r.get(r.get(queue, r.constInt(0), asm.UdonTypeObjectArray), r.constInt(0), asm.UdonTypeSingle))
+	r.assign(i, r.constInt(1))
+
+	r.mark(findLabel)
+	r.jumpIfFalse(r.op(token.LSS, i, capacity), foundLabel)
+	entry := r.get(queue, i, asm.UdonTypeObjectArray)
+	r.jumpIfFalse(r.op(token.NEQ, entry, r.constNil()), foundLabel)
+	due := r.get(entry, r.constInt(0), asm.UdonTypeSingle)
+	r.jumpIfFalse(r.op(token.LSS, due, bestDue), nextLabel)
+	r.assign(best, i)
+	r.assign(bestDue, due)
+	r.mark(nextLabel)
+	r.assign(i, r.op(token.ADD, i, r.constInt(1)))
+	r.jump(findLabel)
+
+	// the entries after it move down one slot, keeping the queue in order
+	r.mark(foundLabel)
+	r.assign(result, r.get(queue, best, asm.UdonTypeObjectArray))
+	r.assign(i, best)
+	last := r.op(token.SUB, capacity, r.constInt(1))
+	r.mark(shiftLabel)
+	r.jumpIfFalse(r.op(token.LSS, i, last), doneLabel)
+	next := r.op(token.ADD, i, r.constInt(1))
+	r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Set", queue, i, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", queue, next))
+	r.assign(i, next)
+	r.jump(shiftLabel)
+	r.mark(doneLabel)
+	r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Set", queue, last, r.constNil())
+	r.ret(result)
+	return r.end()
+}
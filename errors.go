@@ -1,5 +1,23 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"go/token"
+)
 
 var ErrNotImplemented = errors.New("not implemented")
+
+// PosError is an error at a position of the source
+type PosError struct {
+	Pos token.Position
+	Err error
+}
+
+func (e *PosError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Pos, e.Err)
+}
+
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
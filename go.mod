@@ -2,4 +2,4 @@ module udon-go
 
 go 1.14
 
-require github.com/davecgh/go-spew v1.1.1 // indirect
+require golang.org/x/tools v0.1.12
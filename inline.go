@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"strings"
+	"udon-go/asm"
+)
+
+// DefaultInlineThreshold is the size of the largest function body inlined at -O 1 and above, in syntax nodes.
+// Bodies this small, like return 2*x + y, take about as many instructions as saving and restoring the caller's state.
+const DefaultInlineThreshold = 10
+
+// FuncDecl is a function of the program which may be inlined at its call sites
+type FuncDecl struct {
+	Decl *ast.FuncDecl
+	Pkg  *Package
+	// Inline and NoInline are set by the //udon:inline and //udon:noinline comments
+	Inline   bool
+	NoInline bool
+}
+
+// inlineSite is a call whose function body is being compiled in place of the call
+type inlineSite struct {
+	// Result receives the returned value, it is empty for functions returning nothing
+	Result asm.VarName
+	// End follows the inlined body, returns jump to it
+	End asm.LabelName
+}
+
+// inlineThreshold returns the size of the largest function inlined without a //udon:inline comment
+func (o Options) inlineThreshold() int {
+	switch {
+	case o.InlineThreshold < 0:
+		return 0
+	case o.InlineThreshold > 0:
+		return o.InlineThreshold
+	case o.OptLevel >= asm.O1:
+		return DefaultInlineThreshold
+	}
+	return 0
+}
+
+// inlineDirectives reads the //udon:inline and //udon:noinline comments of a function declaration
+func (c *Compiler) inlineDirectives(decl *ast.FuncDecl) (inline bool, noInline bool, err error) {
+	if decl.Doc == nil {
+		return false, false, nil
+	}
+	for _, comment := range decl.Doc.List {
+		fields := strings.Fields(strings.TrimPrefix(comment.Text, "//"))
+		if len(fields) == 0 || (fields[0] != "udon:inline" && fields[0] != "udon:noinline") {
+			continue
+		}
+		if len(fields) > 1 {
+			return false, false, c.errorf(comment.Pos(), "%s: usage: //%s", decl.Name.Name, fields[0])
+		}
+		if _, ok := c.eventName(decl); ok {
+			return false, false, c.errorf(comment.Pos(), "%s: events are not called, //%s does not apply", decl.Name.Name, fields[0])
+		}
+		if fields[0] == "udon:inline" {
+			inline = true
+		} else {
+			noInline = true
+		}
+	}
+	if inline && noInline {
+		return false, false, c.errorf(decl.Pos(), "%s: both //udon:inline and //udon:noinline", decl.Name.Name)
+	}
+	return inline, noInline, nil
+}
+
+// callees returns the functions of the program the body of fn calls or refers to.
+// The functions run later by go statements, udon.After and udon.NextFrame are left out,
+// they are called once fn has returned.
+func (c *Compiler) callees(fn *FuncDecl) []asm.FuncName {
+	funcNames := []asm.FuncName{}
+	if fn == nil {
+		return funcNames
+	}
+	add := func(funcName asm.FuncName) {
+		if _, ok := c.Funcs[funcName]; ok {
+			funcNames = append(funcNames, funcName)
+		}
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GoStmt:
+			for _, arg := range n.Call.Args {
+				ast.Inspect(arg, visit)
+			}
+			return false
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok && len(n.Args) > 0 {
+				if x, ok := sel.X.(*ast.Ident); ok && x.Name == "udon" && (sel.Sel.Name == "After" || sel.Sel.Name == "NextFrame") {
+					for _, arg := range n.Args[:len(n.Args)-1] {
+						ast.Inspect(arg, visit)
+					}
+					return false
+				}
+			}
+		case *ast.SelectorExpr:
+			if x, ok := n.X.(*ast.Ident); ok {
+				if imported, ok := fn.Pkg.Imports[x.Name]; ok {
+					add(asm.FuncName(imported.qualify(n.Sel.Name)))
+					return false
+				}
+			}
+		case *ast.Ident:
+			add(asm.FuncName(fn.Pkg.qualify(n.Name)))
+		}
+		return true
+	}
+	ast.Inspect(fn.Decl.Body, visit)
+	return funcNames
+}
+
+// recursive reports whether funcName may call itself, directly or through other functions
+func (c *Compiler) recursive(funcName asm.FuncName) bool {
+	seen := map[asm.FuncName]bool{}
+	pending := c.callees(c.Funcs[funcName])
+	for len(pending) > 0 {
+		callee := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if callee == funcName {
+			return true
+		}
+		if !seen[callee] {
+			seen[callee] = true
+			pending = append(pending, c.callees(c.Funcs[callee])...)
+		}
+	}
+	return false
+}
+
+// notInlinable returns why the body of fn can not be compiled at its call sites, "" if it can
+func (c *Compiler) notInlinable(funcName asm.FuncName, fn *FuncDecl) string {
+	if c.recursive(funcName) {
+		return "it is recursive"
+	}
+	reason := ""
+	ast.Inspect(fn.Decl.Body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.FuncLit, *ast.GoStmt, *ast.DeferStmt:
+			// the calls run later refer to the variables of the function
+			reason = "it runs code later"
+		}
+		return reason == ""
+	})
+	return reason
+}
+
+// funcSize measures a function body in syntax nodes
+func funcSize(decl *ast.FuncDecl) int {
+	size := 0
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if n != nil {
+			size++
+		}
+		return true
+	})
+	return size
+}
+
+// inlinable returns the declaration of funcName if its calls are replaced by its body
+func (c *Compiler) inlinable(funcName asm.FuncName) (*FuncDecl, error) {
+	fn, ok := c.Funcs[funcName]
+	if !ok || fn.NoInline || (!fn.Inline && (c.InlineThreshold == 0 || funcSize(fn.Decl) > c.InlineThreshold)) {
+		return nil, nil
+	}
+	if reason := c.notInlinable(funcName, fn); reason != "" {
+		if fn.Inline {
+			return nil, c.errorf(fn.Decl.Pos(), "cannot inline %s: %s", fn.Decl.Name.Name, reason)
+		}
+		return nil, nil
+	}
+	return fn, nil
+}
+
+// inlineCall compiles the body of fn in place of a call, with its own copy of the parameters and local variables.
+// Its returns assign the result and jump past the body.
+func (c *Compiler) inlineCall(uasm *asm.UdonAssembly, out io.Writer, fn *FuncDecl, funcName asm.FuncName, name string, argVarNames []asm.VarName) (asm.VarName, error) {
+	argTypes := []asm.UdonTypeName{}
+	for _, argVarName := range argVarNames {
+		typeName, err := uasm.VarTable.GetVarType(argVarName)
+		if err != nil {
+			return "", fmt.Errorf("call %s: %w", name, err)
+		}
+		argTypes = append(argTypes, typeName)
+	}
+	retTypeName, err := uasm.FuncTable.GetRetType(funcName, argTypes)
+	if err != nil {
+		return "", fmt.Errorf("call %s: %w", name, err)
+	}
+	uasm.AddInstComment(fmt.Sprintf("Inline %s%s", funcName, argVarNames))
+	site := &inlineSite{End: asm.LabelName(uasm.GetNextId("inline_end"))}
+	if retTypeName != asm.GoNil && retTypeName != asm.UdonTypeVoid {
+		site.Result = uasm.GetNextId("inline_ret")
+		err = uasm.VarTable.AddVar(site.Result, retTypeName, "null")
+		if err != nil {
+			return "", fmt.Errorf("add var: %w", err)
+		}
+		c.SetTypeInfo(site.Result, c.FuncRetTypes[funcName])
+	}
+
+	// the body sees the package of the function, not the variables of the caller
+	scope, pkg, funcID := c.Scope, c.Pkg, uasm.VarTable.CurrentFuncID
+	event, retType, outer := c.CurrentEvent, c.CurrentFuncRetType, c.Inline
+	defer func() {
+		c.Scope, c.Pkg = scope, pkg
+		uasm.VarTable.SetCurrentFuncID(funcID)
+		c.CurrentEvent, c.CurrentFuncRetType, c.Inline = event, retType, outer
+	}()
+	c.enterPackage(fn.Pkg)
+	c.InlineCount++
+	inlineID := asm.LabelName(fmt.Sprintf("%s_inline%d", uasm.FuncTable.GetFunctionID(funcName, argTypes), c.InlineCount))
+	uasm.VarTable.SetCurrentFuncID(&inlineID)
+	c.CurrentEvent = nil
+	c.CurrentFuncRetType = nil
+	if site.Result != "" {
+		c.CurrentFuncRetType = &retTypeName
+	}
+	c.Inline = site
+
+	c.pushScope(FuncScope)
+	i := 0
+	for _, field := range fn.Decl.Type.Params.List {
+		_, info, err := c.ResolveType(field.Type)
+		if err != nil {
+			return "", fmt.Errorf("resolve arg type: %w", err)
+		}
+		for _, ident := range field.Names {
+			varName, err := c.declareParam(uasm, ident)
+			if err != nil {
+				return "", err
+			}
+			err = uasm.VarTable.AddVar(varName, argTypes[i], "null")
+			if err != nil {
+				return "", fmt.Errorf("add var: %w", err)
+			}
+			c.SetTypeInfo(varName, info)
+			uasm.Assign(varName, argVarNames[i])
+			i++
+		}
+	}
+	err = c.handleBlockStmt(uasm, out, fn.Decl.Body)
+	if err != nil {
+		return "", fmt.Errorf("inline %s: %w", name, err)
+	}
+	err = c.popScope()
+	if err != nil {
+		return "", err
+	}
+	uasm.AddLabelCurrentAddr(site.End)
+	return site.Result, nil
+}
+
+// inlineReturn compiles a return of an inlined body
+func (c *Compiler) inlineReturn(uasm *asm.UdonAssembly, out io.Writer, st *ast.ReturnStmt) error {
+	if len(st.Results) > 1 {
+		return c.errorf(st.Pos(), "return stmt: multiple returns not supported")
+	}
+	if len(st.Results) == 1 {
+		retVarName, err := c.handleExpr(uasm, out, st.Results[0])
+		if err != nil {
+			return fmt.Errorf("handle expr: %w", err)
+		}
+		if c.Inline.Result == "" {
+			return c.errorf(st.Pos(), "too many return values")
+		}
+		uasm.Assign(c.Inline.Result, retVarName)
+	} else if c.Inline.Result != "" {
+		return c.errorf(st.Pos(), "not enough return values")
+	}
+	uasm.JumpLabel(c.Inline.End)
+	return nil
+}
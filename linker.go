@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"udon-go/asm"
+)
+
+// funcKey names a function declared in a package of the program
+type funcKey struct {
+	pkg  *Package
+	name string
+}
+
+// reachableFuncs returns the function declarations reachable from the events of the behaviour.
+// Only those are linked, helper packages may hold many functions a behaviour never calls.
+// A function counts as reached wherever its name is referred to outside of unreachable statements,
+// so the result may hold more than is called.
+func reachableFuncs(prog *Program) map[*ast.FuncDecl]bool {
+	decls := map[funcKey]*ast.FuncDecl{}
+	for _, pkg := range prog.Packages {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv == nil {
+					decls[funcKey{pkg, decl.Name.Name}] = decl
+				}
+			}
+		}
+	}
+	reachable := map[*ast.FuncDecl]bool{}
+	pending := []funcKey{}
+	reach := func(key funcKey) {
+		if decl, ok := decls[key]; ok && !reachable[decl] {
+			reachable[decl] = true
+			pending = append(pending, key)
+		}
+	}
+	main := prog.Main()
+	for key, decl := range decls {
+		if _, ok := EventName(decl); ok && key.pkg == main {
+			reach(key)
+		}
+	}
+	for len(pending) > 0 {
+		key := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		var visit func(n ast.Node) bool
+		visit = func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.SelectorExpr:
+				if x, ok := n.X.(*ast.Ident); ok {
+					if imported, ok := key.pkg.Imports[x.Name]; ok {
+						reach(funcKey{imported, n.Sel.Name})
+						return false
+					}
+				}
+				inspectLive(n.X, visit)
+				return false
+			case *ast.Ident:
+				reach(funcKey{key.pkg, n.Name})
+			}
+			return true
+		}
+		inspectLive(decls[key].Body, visit)
+	}
+	return reachable
+}
+
+// Link compiles the behaviour of prog and the helper functions it reaches into one udon program
+func (uc *UdonCompiler) Link(w io.Writer, prog *Program) (string, error) {
+	code, _, err := uc.link(w, prog)
+	return code, err
+}
+
+// link links prog, returning the compiler so that the declarations of the behaviour can be inspected
+func (uc *UdonCompiler) link(w io.Writer, prog *Program) (string, *Compiler, error) {
+	// the source map is read from the source comments
+	uc.UASM.Comments = uc.Options.Comments || uc.Options.SourceMap
+	if uc.Options.Backend == BackendSSA {
+		return uc.linkSSA(prog)
+	}
+	c, reachable, err := uc.declare(w, prog)
+	if err != nil {
+		return "", nil, err
+	}
+	if uc.Options.DeadCode != nil {
+		reportDeadCode(uc.Options.DeadCode, prog, reachable)
+	}
+	err = c.checkBehaviourTypes(uc.UASM.MethodTable, prog)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, pkg := range prog.Packages {
+		c.enterPackage(pkg)
+		for _, f := range pkg.Files {
+			err = c.handleFuncDecls(uc.UASM, w, f, reachable)
+			if err != nil {
+				return "", nil, fmt.Errorf("handle decls: %w", err)
+			}
+		}
+	}
+	c.enterPackage(prog.Main())
+	if c.UsesMaps {
+		err = c.emitMapRuntime(uc.UASM)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if c.UsesDelayedSlots {
+		err = c.emitDelayedRuntime(uc.UASM)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	code, err := uc.assemble()
+	if err != nil {
+		return "", nil, err
+	}
+	if uc.SourceMap != nil {
+		for varName, ident := range c.VarIdents {
+			if _, ok := uc.UASM.VarTable.Find(varName); ok {
+				uc.SourceMap.Vars[varName] = ident
+			}
+		}
+	}
+	return code, c, nil
+}
+
+// assemble optimizes the compiled code and writes out the program
+func (uc *UdonCompiler) assemble() (string, error) {
+	err := uc.UASM.Optimize(uc.Options.OptLevel)
+	if err != nil {
+		return "", err
+	}
+	if uc.Options.OptLevel >= asm.O2 {
+		report, err := uc.UASM.ReuseSlots()
+		if err != nil {
+			return "", err
+		}
+		if uc.Options.HeapReport != nil {
+			report.Write(uc.Options.HeapReport)
+		}
+	}
+	err = uc.UASM.VerifyStack()
+	if err != nil {
+		return "", err
+	}
+	if uc.Options.SourceMap {
+		uc.SourceMap, err = uc.UASM.SourceMap()
+		if err != nil {
+			return "", err
+		}
+	}
+	if uc.UASM.Comments && !uc.Options.Comments {
+		err = uc.UASM.StripComments()
+		if err != nil {
+			return "", err
+		}
+	}
+	retCode := ""
+	dataSegment, err := uc.UASM.VarTable.MakeDataSeg()
+	if err != nil {
+		return "", fmt.Errorf("make data seg: %w", err)
+	}
+	retCode += dataSegment
+	retCode += uc.UASM.MakeCodeSeg()
+	retCode, err = uc.UASM.ReplaceTmpAdrr(retCode)
+	if err != nil {
+		return "", fmt.Errorf("resolve labels: %w", err)
+	}
+	return retCode, nil
+}
+
+// declare registers the types, functions, events and package variables of prog without compiling any function.
+// It is all the manifest of a behaviour needs, so behaviours referring to each other can be declared in any order.
+func (uc *UdonCompiler) declare(w io.Writer, prog *Program) (*Compiler, map[*ast.FuncDecl]bool, error) {
+	c := NewCompiler()
+	c.Fset = prog.Fset
+	c.InlineThreshold = uc.Options.inlineThreshold()
+	c.Exprs = uc.Options.Exprs
+	for i, pkg := range prog.Packages {
+		pkg.Scope = c.Scope
+		if i > 0 {
+			pkg.Scope = &Scope{Kind: PackageScope, Vars: map[string]*ScopeVar{}}
+		}
+	}
+	for _, pkg := range prog.Packages {
+		c.enterPackage(pkg)
+		for _, f := range pkg.Files {
+			err := c.collectStructs(f)
+			if err != nil {
+				return nil, nil, fmt.Errorf("collect structs: %w", err)
+			}
+		}
+	}
+	for _, pkg := range prog.Packages {
+		c.enterPackage(pkg)
+		for _, f := range pkg.Files {
+			err := c.collectBehaviourEvents(f)
+			if err != nil {
+				return nil, nil, fmt.Errorf("collect behaviour events: %w", err)
+			}
+		}
+	}
+	registerMapRuntime(uc.UASM)
+	registerDelayedRuntime(uc.UASM)
+	reachable := reachableFuncs(prog)
+	for _, pkg := range prog.Packages {
+		c.enterPackage(pkg)
+		for _, f := range pkg.Files {
+			err := collectFuncs(c, uc.UASM, f, reachable)
+			if err != nil {
+				return nil, nil, fmt.Errorf("collect funcs: %w", err)
+			}
+		}
+	}
+	err := addInitVars(uc.UASM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// package variables are declared first, functions may refer to the ones declared after them
+	for _, pkg := range prog.Packages {
+		c.enterPackage(pkg)
+		for _, f := range pkg.Files {
+			err = c.handleGenDecls(uc.UASM, w, f)
+			if err != nil {
+				return nil, nil, fmt.Errorf("handle decls: %w", err)
+			}
+		}
+	}
+	return c, reachable, nil
+}
+
+// addInitVars declares the variables every program has, the return address of functions and the objects of the behaviour
+func addInitVars(uasm *asm.UdonAssembly) error {
+	err := uasm.VarTable.AddVar(asm.VarName("ret_addr"), asm.UdonTypeUInt32, "0xFFFFFFFF")
+	if err != nil {
+		return fmt.Errorf("add init vars: %w", err)
+	}
+	err = uasm.VarTable.AddVar(asm.VarName("this_trans"), asm.UdonTypeTransform, "this")
+	if err != nil {
+		return fmt.Errorf("add init vars: %w", err)
+	}
+	err = uasm.VarTable.AddVar(asm.VarName("this_gameObj"), asm.UdonTypeGameObject, "this")
+	if err != nil {
+		return fmt.Errorf("add init vars: %w", err)
+	}
+	return nil
+}
+
+// enterPackage makes pkg the package whose declarations are compiled
+func (c *Compiler) enterPackage(pkg *Package) {
+	c.Pkg = pkg
+	c.Scope = pkg.Scope
+}
+
+// qualify returns the program wide name of a function, variable or type declared in the current package
+func (c *Compiler) qualify(name string) string {
+	return c.Pkg.qualify(name)
+}
+
+// importedPackage returns the helper package x refers to, unless a variable shadows its name
+func (c *Compiler) importedPackage(x ast.Expr) (*Package, bool) {
+	ident, ok := x.(*ast.Ident)
+	if !ok || c.Pkg == nil {
+		return nil, false
+	}
+	pkg, ok := c.Pkg.Imports[ident.Name]
+	if !ok {
+		return nil, false
+	}
+	if _, shadowed := c.Scope.Lookup(ident.Name); shadowed {
+		return nil, false
+	}
+	return pkg, true
+}
+
+// importedName checks that sel refers to an exported name of an imported helper package and returns the package
+func (c *Compiler) importedName(sel *ast.SelectorExpr) (*Package, bool, error) {
+	pkg, ok := c.importedPackage(sel.X)
+	if !ok {
+		return nil, false, nil
+	}
+	if !ast.IsExported(sel.Sel.Name) {
+		return nil, true, c.errorf(sel.Sel.Pos(), "name %s not exported by package %s", sel.Sel.Name, pkg.Name)
+	}
+	return pkg, true, nil
+}
+
+// importedVar returns the heap variable of a package variable of an imported helper package, e.g. lib.Count
+func (c *Compiler) importedVar(sel *ast.SelectorExpr, use bool) (asm.VarName, bool, error) {
+	pkg, ok, err := c.importedName(sel)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+	v, ok := pkg.Scope.Vars[sel.Sel.Name]
+	if !ok {
+		return "", true, c.errorf(sel.Sel.Pos(), "undefined: %s.%s", sel.X.(*ast.Ident).Name, sel.Sel.Name)
+	}
+	if use {
+		v.Used = true
+	}
+	return v.VarName, true, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stubPackages are the packages of the compiler declaring udon types and functions,
+// calls to them are compiled directly instead of being linked
+var stubPackages = map[string]bool{
+	"udon-go/asm":  true,
+	"udon-go/udon": true,
+}
+
+// stdPackages are the standard library packages the compiler knows how to compile calls to
+var stdPackages = map[string]bool{
+	"fmt":     true,
+	"strconv": true,
+	"time":    true,
+}
+
+// Program is a behaviour and the helper packages of its module it imports, linked into one udon program
+type Program struct {
+	Fset *token.FileSet
+	// Packages holds the behaviour first, then the helper packages it imports, depth first
+	Packages []*Package
+	// loader loads the other behaviours of the module the program refers to, it is nil for single files
+	loader *loader
+}
+
+// Main returns the package of the behaviour
+func (prog *Program) Main() *Package {
+	return prog.Packages[0]
+}
+
+// Package is a parsed go package of the program
+type Package struct {
+	Path  string
+	Name  string
+	Dir   string
+	Files []*ast.File
+	// Prefix qualifies the functions, variables and types of helper packages, it is empty for the behaviour
+	Prefix string
+	// Imports are the helper packages imported by the files of the package, by the name they are imported as
+	Imports map[string]*Package
+	// Scope holds the package variables
+	Scope *Scope
+}
+
+// qualify returns the program wide name of a function, variable or type declared in pkg
+func (pkg *Package) qualify(name string) string {
+	if pkg == nil {
+		return name
+	}
+	return pkg.Prefix + name
+}
+
+// loader reads the packages of a go module.
+// Helper packages are parsed once and shared by the programs of every behaviour loaded with it.
+type loader struct {
+	fset    *token.FileSet
+	modPath string
+	modDir  string
+	pkgs    map[string]*Package
+	// manifests are the declared manifests of the behaviours referred to by //udon:behaviour types
+	manifests map[string]*BehaviourManifest
+	// overlay holds the files being edited by absolute path, they are read instead of the files on disk
+	overlay map[string][]byte
+}
+
+// newLoader returns a loader for the module containing dir
+func newLoader(dir string) (*loader, error) {
+	modDir, modPath, err := findModule(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &loader{fset: token.NewFileSet(), modPath: modPath, modDir: modDir, pkgs: map[string]*Package{}, manifests: map[string]*BehaviourManifest{}}, nil
+}
+
+// LoadProgram loads the behaviour in path, a package directory or a single go file,
+// and the helper packages of the same module it imports
+func LoadProgram(path string) (*Program, error) {
+	return loadProgram(path, nil)
+}
+
+// loadProgram loads the behaviour in path, reading the files of overlay instead of those on disk
+func loadProgram(path string, overlay map[string][]byte) (*Program, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fileNames := []string{}
+	if !info.IsDir() {
+		dir, fileNames = filepath.Dir(dir), []string{filepath.Base(dir)}
+	}
+	l, err := newLoader(dir)
+	if err != nil {
+		return nil, err
+	}
+	l.overlay = overlay
+	return l.program(dir, fileNames)
+}
+
+// program loads the behaviour in dir, restricted to fileNames unless it is empty, with the helper packages it imports
+func (l *loader) program(dir string, fileNames []string) (*Program, error) {
+	main, err := l.load(l.importPath(dir), dir, fileNames, true)
+	if err != nil {
+		return nil, err
+	}
+	prog := &Program{Fset: l.fset, loader: l}
+	seen := map[*Package]bool{}
+	var add func(pkg *Package)
+	add = func(pkg *Package) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		prog.Packages = append(prog.Packages, pkg)
+		names := []string{}
+		for name := range pkg.Imports {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(pkg.Imports[name])
+		}
+	}
+	add(main)
+	return prog, nil
+}
+
+// importPath returns the import path of the package in dir
+func (l *loader) importPath(dir string) string {
+	rel, err := filepath.Rel(l.modDir, dir)
+	if err != nil || rel == "." {
+		return l.modPath
+	}
+	return l.modPath + "/" + filepath.ToSlash(rel)
+}
+
+// findModule returns the directory and path of the module containing dir
+func findModule(dir string) (string, string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		f, err := os.Open(filepath.Join(d, "go.mod"))
+		if err == nil {
+			defer f.Close()
+			scan := bufio.NewScanner(f)
+			for scan.Scan() {
+				fields := strings.Fields(scan.Text())
+				if len(fields) == 2 && fields[0] == "module" {
+					modPath, err := strconv.Unquote(fields[1])
+					if err != nil {
+						modPath = fields[1]
+					}
+					return d, modPath, nil
+				}
+			}
+			return "", "", fmt.Errorf("%s: missing module path", filepath.Join(d, "go.mod"))
+		}
+		if filepath.Dir(d) == d {
+			return "", "", fmt.Errorf("%s is not in a go module", dir)
+		}
+	}
+}
+
+// load parses the package in dir, restricted to fileNames unless it is empty, and the packages it imports.
+// The behaviour is not cached, its names are not prefixed.
+func (l *loader) load(importPath string, dir string, fileNames []string, behaviour bool) (*Package, error) {
+	if len(fileNames) == 0 {
+		bp, err := build.Default.ImportDir(dir, 0)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", dir, err)
+		}
+		fileNames = bp.GoFiles
+	}
+	pkg := &Package{Path: importPath, Dir: dir, Imports: map[string]*Package{}}
+	if !behaviour {
+		pkg.Prefix = prefixOf(strings.TrimPrefix(strings.TrimPrefix(importPath, l.modPath), "/"))
+		l.pkgs[importPath] = pkg
+	}
+	for _, name := range fileNames {
+		path := filepath.Join(dir, name)
+		var src interface{}
+		if data, ok := l.overlay[path]; ok {
+			src = data
+		}
+		f, err := parser.ParseFile(l.fset, path, src, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("parse file: %w", err)
+		}
+		if pkg.Name == "" {
+			pkg.Name = f.Name.Name
+		} else if pkg.Name != f.Name.Name {
+			return nil, fmt.Errorf("%s: found packages %s and %s", dir, pkg.Name, f.Name.Name)
+		}
+		pkg.Files = append(pkg.Files, f)
+	}
+	for _, f := range pkg.Files {
+		for _, spec := range f.Imports {
+			err := l.resolveImport(pkg, spec)
+			if err != nil {
+				return nil, &PosError{Pos: l.fset.Position(spec.Pos()), Err: err}
+			}
+		}
+	}
+	return pkg, nil
+}
+
+// resolveImport loads the helper package imported by spec
+func (l *loader) resolveImport(pkg *Package, spec *ast.ImportSpec) error {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return err
+	}
+	if stubPackages[path] || stdPackages[path] {
+		return nil
+	}
+	if path != l.modPath && !strings.HasPrefix(path, l.modPath+"/") {
+		return fmt.Errorf("unsupported import %q, only packages of module %s can be linked", path, l.modPath)
+	}
+	imported, ok := l.pkgs[path]
+	if !ok {
+		dir := filepath.Join(l.modDir, filepath.FromSlash(strings.TrimPrefix(path, l.modPath)))
+		imported, err = l.load(path, dir, nil, false)
+		if err != nil {
+			return err
+		}
+	}
+	if imported.Name == "main" {
+		return fmt.Errorf("import %q is a program, not a helper package", path)
+	}
+	name := imported.Name
+	if spec.Name != nil {
+		name = spec.Name.Name
+	}
+	if name == "_" || name == "." {
+		return errors.New("blank and dot imports are not supported")
+	}
+	pkg.Imports[name] = imported
+	return nil
+}
+
+// prefixOf turns the path of a helper package in its module into the prefix of its names, e.g. lib/mathx -> lib_mathx__
+func prefixOf(relPath string) string {
+	if relPath == "" {
+		relPath = "root"
+	}
+	prefix := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, relPath)
+	return prefix + "__"
+}
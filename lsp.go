@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"udon-go/asm"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// lspMessage is a request, response or notification of the language server protocol
+type lspMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+// lspError is the error of a response, with a JSON-RPC error code
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return e.Message
+}
+
+// the JSON-RPC error codes of the responses
+const (
+	lspMethodNotFound = -32601
+	lspInternalError  = -32603
+)
+
+// lspPosition is a position in a document, zero based, the character counted in UTF-16 code units
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// lspDocumentPosition are the parameters of the requests about a position of a document
+type lspDocumentPosition struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label         string `json:"label"`
+	Kind          int    `json:"kind"`
+	Detail        string `json:"detail,omitempty"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// the kinds of completion items
+const (
+	lspMethod   = 2
+	lspFunction = 3
+	lspField    = 5
+	lspClass    = 7
+	lspProperty = 10
+)
+
+// lspServer checks the behaviours being edited for an editor speaking the language server protocol
+type lspServer struct {
+	r           *textproto.Reader
+	w           *bufio.Writer
+	methodTable asm.MethodMap
+	// docs are the contents of the open documents, by path
+	docs map[string][]byte
+	// checks are the last checks of the open documents, by path
+	checks map[string]*lspCheck
+	// diagnosed are the files with diagnostics published, by path
+	diagnosed map[string]bool
+}
+
+// lspCheck is what compiling the behaviour of a document found
+type lspCheck struct {
+	fset  *token.FileSet
+	exprs map[ast.Expr]*ExprInfo
+	errs  []error
+}
+
+// ServeLSP reads language server protocol messages from r and writes the responses and notifications to w,
+// until the client exits or r ends
+func ServeLSP(methodTable asm.MethodMap, r io.Reader, w io.Writer) error {
+	s := &lspServer{
+		r:           textproto.NewReader(bufio.NewReader(r)),
+		w:           bufio.NewWriter(w),
+		methodTable: methodTable,
+		docs:        map[string][]byte{},
+		checks:      map[string]*lspCheck{},
+		diagnosed:   map[string]bool{},
+	}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		err = s.handle(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read reads the next message, framed by a Content-Length header
+func (s *lspServer) read() (*lspMessage, error) {
+	header, err := s.r.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("lsp header: %w", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("lsp header: bad Content-Length %q", header.Get("Content-Length"))
+	}
+	data := make([]byte, n)
+	_, err = io.ReadFull(s.r.R, data)
+	if err != nil {
+		return nil, fmt.Errorf("lsp message: %w", err)
+	}
+	msg := &lspMessage{}
+	err = json.Unmarshal(data, msg)
+	if err != nil {
+		return nil, fmt.Errorf("lsp message: %w", err)
+	}
+	return msg, nil
+}
+
+// send writes a JSON-RPC message
+func (s *lspServer) send(msg map[string]interface{}) error {
+	msg["jsonrpc"] = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(data))
+	s.w.Write(data)
+	return s.w.Flush()
+}
+
+func (s *lspServer) notify(method string, params interface{}) error {
+	return s.send(map[string]interface{}{"method": method, "params": params})
+}
+
+// handle answers a request or runs a notification.
+// The errors of requests are sent back to the client, those of notifications are logged to it,
+// only failing to write is returned.
+func (s *lspServer) handle(msg *lspMessage) error {
+	result, err := s.dispatch(msg)
+	if msg.ID == nil {
+		if err != nil {
+			return s.notify("window/logMessage", map[string]interface{}{"type": 1, "message": fmt.Sprintf("%s: %v", msg.Method, err)})
+		}
+		return nil
+	}
+	if err != nil {
+		lspErr, ok := err.(*lspError)
+		if !ok {
+			lspErr = &lspError{Code: lspInternalError, Message: err.Error()}
+		}
+		return s.send(map[string]interface{}{"id": msg.ID, "error": lspErr})
+	}
+	return s.send(map[string]interface{}{"id": msg.ID, "result": result})
+}
+
+// dispatch runs a message, returning the result of requests
+func (s *lspServer) dispatch(msg *lspMessage) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		capabilities := map[string]interface{}{
+			// the documents are sent whole on every change
+			"textDocumentSync":   1,
+			"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+			"hoverProvider":      true,
+		}
+		return map[string]interface{}{"capabilities": capabilities, "serverInfo": map[string]string{"name": "udon-go"}}, nil
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	case "shutdown":
+		return nil, nil
+	case "textDocument/didOpen":
+		params := &struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}{}
+		err := json.Unmarshal(msg.Params, params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		params := &struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}{}
+		err := json.Unmarshal(msg.Params, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didSave":
+		path, err := s.documentPath(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.publish(path)
+	case "textDocument/didClose":
+		path, err := s.documentPath(msg.Params)
+		if err != nil {
+			return nil, err
+		}
+		delete(s.docs, path)
+		delete(s.checks, path)
+		return nil, nil
+	case "textDocument/completion":
+		params := &lspDocumentPosition{}
+		err := json.Unmarshal(msg.Params, params)
+		if err != nil {
+			return nil, err
+		}
+		path, err := uriPath(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"isIncomplete": false, "items": s.complete(path, params.Position)}, nil
+	case "textDocument/hover":
+		params := &lspDocumentPosition{}
+		err := json.Unmarshal(msg.Params, params)
+		if err != nil {
+			return nil, err
+		}
+		path, err := uriPath(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		return s.hover(path, params.Position), nil
+	}
+	if strings.HasPrefix(msg.Method, "$/") || msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &lspError{Code: lspMethodNotFound, Message: "unsupported method " + msg.Method}
+}
+
+// documentPath returns the path of the document of the parameters of a notification
+func (s *lspServer) documentPath(data json.RawMessage) (string, error) {
+	params := &struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+	}{}
+	err := json.Unmarshal(data, params)
+	if err != nil {
+		return "", err
+	}
+	return uriPath(params.TextDocument.URI)
+}
+
+// uriPath returns the path of a file URI
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported document %s, only files are checked", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathURI returns the file URI of a path
+func pathURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// update replaces the contents of a document and checks it
+func (s *lspServer) update(uri string, text string) error {
+	path, err := uriPath(uri)
+	if err != nil {
+		return err
+	}
+	s.docs[path] = []byte(text)
+	return s.publish(path)
+}
+
+// source returns the contents of a file, as edited if it is open
+func (s *lspServer) source(path string) []byte {
+	if src, ok := s.docs[path]; ok {
+		return src
+	}
+	src, _ := ioutil.ReadFile(path)
+	return src
+}
+
+// load loads the behaviour the document at path belongs to, reading the documents of docs instead of the files on disk.
+// A document outside of a module is a behaviour on its own.
+func (s *lspServer) load(path string, docs map[string][]byte) (*Program, error) {
+	dir := filepath.Dir(path)
+	if _, _, err := findModule(dir); err == nil {
+		return loadProgram(dir, docs)
+	}
+	fset := token.NewFileSet()
+	var src interface{}
+	if data, ok := docs[path]; ok {
+		src = data
+	}
+	f, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parse file: %w", err)
+	}
+	pkg := &Package{Name: f.Name.Name, Files: []*ast.File{f}, Imports: map[string]*Package{}}
+	return &Program{Fset: fset, Packages: []*Package{pkg}}, nil
+}
+
+// check type checks and compiles the behaviour the document at path belongs to
+func (s *lspServer) check(path string, docs map[string][]byte) *lspCheck {
+	check := &lspCheck{exprs: map[ast.Expr]*ExprInfo{}}
+	prog, err := s.load(path, docs)
+	if err != nil {
+		check.errs = []error{err}
+		return check
+	}
+	check.fset = prog.Fset
+	typeCheck(prog, func(err error) {
+		check.errs = append(check.errs, err)
+	})
+	uasm, err := asm.NewUdonAssembly(strings.NewReader(""))
+	if err != nil {
+		check.errs = []error{err}
+		return check
+	}
+	uasm.MethodTable = s.methodTable
+	uc := &UdonCompiler{UASM: uasm, Options: Options{Exprs: check.exprs}}
+	// Type errors explain a failed link better than its first error does
+	_, err = uc.Link(ioutil.Discard, prog)
+	if err != nil && len(check.errs) == 0 {
+		check.errs = []error{err}
+	}
+	return check
+}
+
+// publish checks the document at path and sends the diagnostics of its behaviour,
+// clearing those of the files which no longer have any
+func (s *lspServer) publish(path string) error {
+	check := s.check(path, s.docs)
+	s.checks[path] = check
+	diags := s.diagnostics(path, check.errs)
+	for diagnosed := range s.diagnosed {
+		if _, ok := diags[diagnosed]; !ok {
+			diags[diagnosed] = []lspDiagnostic{}
+		}
+	}
+	paths := []string{}
+	for p := range diags {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		err := s.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": pathURI(p), "diagnostics": diags[p]})
+		if err != nil {
+			return err
+		}
+		if len(diags[p]) > 0 {
+			s.diagnosed[p] = true
+		} else {
+			delete(s.diagnosed, p)
+		}
+	}
+	return nil
+}
+
+// diagnostics turns the errors of checking the document at path into diagnostics, by file.
+// Syntax and type errors are all reported, the compiler stops at its first error.
+// Errors without a position are reported at the start of the document.
+func (s *lspServer) diagnostics(path string, errs []error) map[string][]lspDiagnostic {
+	diags := map[string][]lspDiagnostic{}
+	add := func(pos token.Position, msg string) {
+		file := pos.Filename
+		if file == "" {
+			file = path
+		}
+		src := s.source(file)
+		start := pos.Offset
+		if !pos.IsValid() || start > len(src) {
+			start = 0
+		}
+		diags[file] = append(diags[file], lspDiagnostic{
+			Range:    lspRange{Start: toLSP(src, start), End: toLSP(src, wordEnd(src, start))},
+			Severity: 1,
+			Source:   "udon-go",
+			Message:  msg,
+		})
+	}
+	for _, err := range errs {
+		var syntaxErrs scanner.ErrorList
+		var typeErr types.Error
+		var posErr *PosError
+		switch {
+		case errors.As(err, &syntaxErrs):
+			for _, e := range syntaxErrs {
+				add(e.Pos, e.Msg)
+			}
+		case errors.As(err, &typeErr):
+			add(typeErr.Fset.Position(typeErr.Pos), typeErr.Msg)
+		case errors.As(err, &posErr):
+			add(posErr.Pos, posErr.Err.Error())
+		default:
+			add(token.Position{}, err.Error())
+		}
+	}
+	return diags
+}
+
+// toLSP returns the position of a byte offset of src
+func toLSP(src []byte, offset int) lspPosition {
+	line := strings.Count(string(src[:offset]), "\n")
+	lineStart := strings.LastIndexByte(string(src[:offset]), '\n') + 1
+	return lspPosition{Line: line, Character: len(utf16.Encode([]rune(string(src[lineStart:offset]))))}
+}
+
+// fromLSP returns the byte offset of a position of src, clamped to its line
+func fromLSP(src []byte, pos lspPosition) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(string(src[offset:]), '\n')
+		if i < 0 {
+			return len(src)
+		}
+		offset += i + 1
+	}
+	for units := 0; units < pos.Character && offset < len(src) && src[offset] != '\n'; {
+		r, size := utf8.DecodeRune(src[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+// isIdentByte reports whether b may be part of an identifier, counting all non ASCII bytes
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= utf8.RuneSelf
+}
+
+// wordEnd returns the end of the identifier starting at offset, or of its character if it is none
+func wordEnd(src []byte, offset int) int {
+	end := offset
+	for end < len(src) && isIdentByte(src[end]) {
+		end++
+	}
+	if end == offset && end < len(src) && src[end] != '\n' {
+		_, size := utf8.DecodeRune(src[end:])
+		end += size
+	}
+	return end
+}
+
+// exprsOf returns the expressions of a check in the file at path, with their offsets
+func (check *lspCheck) exprsOf(path string) []ast.Expr {
+	exprs := []ast.Expr{}
+	if check.fset == nil {
+		return exprs
+	}
+	for e := range check.exprs {
+		if check.fset.Position(e.Pos()).Filename == path {
+			exprs = append(exprs, e)
+		}
+	}
+	return exprs
+}
+
+// hover describes the innermost expression compiled at a position: its go and udon type and the extern it calls
+func (s *lspServer) hover(path string, pos lspPosition) interface{} {
+	check, ok := s.checks[path]
+	if !ok {
+		return nil
+	}
+	src := s.source(path)
+	offset := fromLSP(src, pos)
+	var found ast.Expr
+	for _, e := range check.exprsOf(path) {
+		start, end := check.fset.Position(e.Pos()).Offset, check.fset.Position(e.End()).Offset
+		if start > offset || offset >= end {
+			continue
+		}
+		if found == nil || e.End()-e.Pos() < found.End()-found.Pos() {
+			found = e
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	info := check.exprs[found]
+	lines := []string{}
+	if info.Type != "" {
+		lines = append(lines, "```go", fmt.Sprintf("%s %s", types.ExprString(found), GoTypeName(info.Type, info.Info)), "```",
+			fmt.Sprintf("udon type `%s`", info.Type))
+	}
+	if info.Extern != "" {
+		lines = append(lines, "", fmt.Sprintf("extern `%s`", asm.DemangleExtern(info.Extern)), "", "```", string(info.Extern), "```")
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	start, end := check.fset.Position(found.Pos()).Offset, check.fset.Position(found.End()).Offset
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": strings.Join(lines, "\n")},
+		"range":    lspRange{Start: toLSP(src, start), End: toLSP(src, end)},
+	}
+}
+
+// complete lists the members of the value before the dot preceding a position,
+// or the unity types after asm.
+func (s *lspServer) complete(path string, pos lspPosition) []lspCompletionItem {
+	src := s.source(path)
+	offset := fromLSP(src, pos)
+	start := offset
+	for start > 0 && isIdentByte(src[start-1]) {
+		start--
+	}
+	if start == 0 || src[start-1] != '.' {
+		return []lspCompletionItem{}
+	}
+	dot := start - 1
+	recv := dot
+	for recv > 0 && isIdentByte(src[recv-1]) {
+		recv--
+	}
+	if string(src[recv:dot]) == "asm" && (recv == 0 || src[recv-1] != '.') {
+		return unityTypeItems()
+	}
+	// the member being typed is blanked out, so that the value before the dot compiles on its own
+	patched := append([]byte{}, src...)
+	for i := dot; i < offset; i++ {
+		patched[i] = ' '
+	}
+	docs := map[string][]byte{}
+	for p, doc := range s.docs {
+		docs[p] = doc
+	}
+	docs[path] = patched
+	check := s.check(path, docs)
+	var found ast.Expr
+	for _, e := range check.exprsOf(path) {
+		if check.fset.Position(e.End()).Offset != dot || check.exprs[e].Type == "" {
+			continue
+		}
+		if found == nil || e.Pos() < found.Pos() {
+			found = e
+		}
+	}
+	if found == nil {
+		return []lspCompletionItem{}
+	}
+	info := check.exprs[found]
+	return s.members(info.Type, info.Info)
+}
+
+// unityTypeItems lists the udon types the asm package stands for
+func unityTypeItems() []lspCompletionItem {
+	items := []lspCompletionItem{{Label: "Log", Kind: lspFunction, Detail: "func Log(msg interface{})"}}
+	for name, typeName := range asm.UdonTypes {
+		items = append(items, lspCompletionItem{Label: string(name), Kind: lspClass, Detail: string(typeName)})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+// members lists the fields of structs and behaviours, the events of behaviours,
+// and the properties and methods of the method table for the other types
+func (s *lspServer) members(typeName asm.UdonTypeName, info *TypeInfo) []lspCompletionItem {
+	items := []lspCompletionItem{}
+	switch {
+	case info == nil:
+	case info.Struct != nil:
+		for _, field := range info.Struct.Fields {
+			items = append(items, lspCompletionItem{Label: field.Name, Kind: lspField, Detail: GoTypeName(field.TypeName, field.Info)})
+		}
+		return items
+	case info.Behaviour != nil:
+		for _, field := range info.Behaviour.Fields {
+			items = append(items, lspCompletionItem{Label: field.Name, Kind: lspField, Detail: GoTypeName(field.TypeName, field.Info)})
+		}
+		for _, ident := range info.Behaviour.Events {
+			items = append(items, lspCompletionItem{Label: ident.Name, Kind: lspMethod, Detail: "event"})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+		return items
+	case info.Map != nil:
+		return items
+	}
+	// overloads, getters and setters are listed once, described by the first of their externs
+	externs := map[lspCompletionItem][]string{}
+	module := asm.ShortTypeName(typeName)
+	for key, value := range s.methodTable {
+		if key.ModuleName != module || strings.HasPrefix(string(key.MethodName), "op_") {
+			continue
+		}
+		item := lspCompletionItem{Label: string(key.MethodName)}
+		switch {
+		case key.MethodKind == asm.CONSTRUCTOR:
+			continue
+		case strings.HasPrefix(item.Label, "get_") || strings.HasPrefix(item.Label, "set_"):
+			item.Label, item.Kind = item.Label[4:], lspProperty
+		case key.MethodKind == asm.STATIC_FUNC:
+			item.Kind = lspFunction
+		default:
+			item.Kind = lspMethod
+		}
+		externs[item] = append(externs[item], value.ExternStr)
+	}
+	for item, strs := range externs {
+		sort.Strings(strs)
+		item.Detail = asm.DemangleExtern(asm.ExternStr(strs[0]))
+		if len(strs) > 1 {
+			item.Detail += fmt.Sprintf(" (+%d more)", len(strs)-1)
+		}
+		item.Documentation = strings.Join(strs, "\n")
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Label != items[j].Label {
+			return items[i].Label < items[j].Label
+		}
+		return items[i].Kind < items[j].Kind
+	})
+	return items
+}
@@ -1,161 +1,344 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"udon-go/asm"
-
-	"github.com/davecgh/go-spew/spew"
+	"udon-go/debug"
+	"udon-go/vm"
 )
 
 func main() {
 	// src is the input for which we want to print the AST.
 
-	f, err := os.Open("./asm/udon_funcs_data.txt")
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		err := runDisasm(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "debug" {
+		err := runDebug(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		err := runLSP(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && (os.Args[1] == "externs" || os.Args[1] == "doc") {
+		run := runExterns
+		if os.Args[1] == "doc" {
+			run = runDoc
+		}
+		err := run(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		err := runBuild(os.Args[2:])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	funcs := funcsFlag(flag.CommandLine)
+	optLevel := flag.Int("O", 0, "optimization level, 0 to 2")
+	inline := flag.Int("inline", 0, "size of the largest function inlined at its call sites, 0 for the default of the optimization level, -1 to only inline //udon:inline functions")
+	heapReport := flag.Bool("heap-report", false, "print the heap variable counts before and after they are reused at -O 2")
+	deadCode := flag.Bool("dead-code", false, "list the functions and statements left out because they are never reached")
+	backend := flag.String("backend", BackendAST, "backend compiling the functions, ast or ssa")
+	binary := flag.Bool("binary", false, "write the bytecode instead of the assembly")
+	comments := flag.Bool("comments", false, "write every go statement as a comment before its instructions")
+	sourceMap := flag.String("sourcemap", "", "write the source map of the program to this file")
+	flag.Parse()
+	methodTable, err := loadMethodTable(*funcs)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-
-	defer f.Close()
-	uasm, err := asm.NewUdonAssembly(f)
+	uasm, err := asm.NewUdonAssembly(strings.NewReader(""))
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	uasm.MethodTable = methodTable
 	uc := &UdonCompiler{
 		UASM: uasm,
+		Options: Options{Backend: *backend, OptLevel: asm.OptLevel(*optLevel), InlineThreshold: *inline,
+			Comments: *comments, SourceMap: *sourceMap != ""},
+	}
+	if *heapReport {
+		uc.Options.HeapReport = os.Stderr
+	}
+	if *deadCode {
+		uc.Options.DeadCode = os.Stderr
 	}
 
-	srcFile, err := os.Open("./sample/func.go")
+	srcPath := "./sample/func.go"
+	if flag.NArg() > 0 {
+		srcPath = flag.Arg(0)
+	}
+	// a directory is compiled as a package, linked with the helper packages it imports
+	prog, err := LoadProgram(srcPath)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-
-	defer srcFile.Close()
-	result, err := uc.MakeUASMCode(os.Stdout, srcFile)
+	result, err := uc.Link(os.Stdout, prog)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if *sourceMap != "" {
+		err = writeSourceMap(*sourceMap, uc.SourceMap)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *binary {
+		data, err := assembleBinary(result, uc.UASM.LabelDict)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(data)
+		return
+	}
 	fmt.Println(result)
 }
 
-type UdonCompiler struct {
-	UASM                 *asm.UdonAssembly
-	Node                 ast.Node
-	CurrentFuncRetType   []*asm.UdonTypeName
-	CurrentBreakLabel    []asm.LabelName
-	CurrentContinueLabel []asm.LabelName
-}
+// defaultFuncsPath is the method table of the repository, relative to its root
+const defaultFuncsPath = "./asm/udon_funcs_data.txt"
 
-func (uc *UdonCompiler) MakeUASMCode(w io.Writer, rdr io.Reader) (string, error) {
-	fset := token.NewFileSet() // positions are relative to fset
+// funcsFlag adds the -funcs flag, the path of the method table, to flags
+func funcsFlag(flags *flag.FlagSet) *string {
+	return flags.String("funcs", defaultFuncsPath, "method table listing the externs, asm/udon_funcs_data.txt of this repository")
+}
 
-	f, err := parser.ParseFile(fset, "main.go", rdr, 0)
+// loadMethodTable reads the method table at path, the lines which are not records are reported on stderr
+func loadMethodTable(path string) (asm.MethodMap, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return "", nil
+		return nil, fmt.Errorf("method table: %w, set its path with -funcs", err)
 	}
-	collectFuncs(uc.UASM, f)
-	err = uc.UASM.VarTable.AddVar(asm.VarName("ret_addr"), asm.UdonTypeUInt32, "0xFFFFFFFF")
+	defer f.Close()
+	methodTable, warnings, err := asm.ParseExterns(f)
 	if err != nil {
-		return "", fmt.Errorf("add init vars: %w", err)
+		return nil, fmt.Errorf("method table %s: %w", path, err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, w)
 	}
-	err = uc.UASM.VarTable.AddVar(asm.VarName("this_trans"), asm.UdonTypeTransform, "this")
+	err = asm.AddSDKRecords(methodTable)
 	if err != nil {
-		return "", fmt.Errorf("add init vars: %w", err)
+		return nil, err
 	}
-	err = uc.UASM.VarTable.AddVar(asm.VarName("this_gameObj"), asm.UdonTypeGameObject, "this")
+	return methodTable, nil
+}
+
+// runLSP runs udon-go lsp [-funcs file], serving the language server protocol on stdin and stdout
+func runLSP(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	funcs := funcsFlag(flags)
+	err := flags.Parse(args)
 	if err != nil {
-		return "", fmt.Errorf("add init vars: %w", err)
+		return err
 	}
+	methodTable, err := loadMethodTable(*funcs)
+	if err != nil {
+		return err
+	}
+	return ServeLSP(methodTable, os.Stdin, os.Stdout)
+}
 
-	c := &Compiler{}
-	err = c.handleDecls(uc.UASM, w, f)
+// runBuild runs udon-go build [-o dir] [-funcs file] [-backend ast|ssa] [-O level] [-inline size] [-heap-report] [-dead-code] [-binary] [-comments] [-sourcemap] [packages], writing one assembly per behaviour and their manifest
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	funcs := funcsFlag(flags)
+	outDir := flags.String("o", "out", "output directory")
+	optLevel := flags.Int("O", 0, "optimization level, 0 to 2")
+	inline := flags.Int("inline", 0, "size of the largest function inlined at its call sites, 0 for the default of the optimization level, -1 to only inline //udon:inline functions")
+	heapReport := flags.Bool("heap-report", false, "print the heap variable counts of every behaviour before and after they are reused at -O 2")
+	deadCode := flags.Bool("dead-code", false, "list the functions and statements left out because they are never reached")
+	backend := flags.String("backend", BackendAST, "backend compiling the functions, ast or ssa")
+	binary := flags.Bool("binary", false, "also write the bytecode of every behaviour, next to its assembly")
+	comments := flags.Bool("comments", false, "write every go statement as a comment before its instructions")
+	sourceMap := flags.Bool("sourcemap", false, "also write the source map of every behaviour, next to its assembly")
+	err := flags.Parse(args)
 	if err != nil {
-		return "", fmt.Errorf("handle decls: %w", err)
+		return err
 	}
-	spew.Dump(uc.UASM.VarTable.VarDict)
-	retCode := ""
-	dataSegment, err := uc.UASM.VarTable.MakeDataSeg()
+	methodTable, err := loadMethodTable(*funcs)
 	if err != nil {
-		return "", fmt.Errorf("make data seg: %w", err)
+		return err
+	}
+	patterns := flags.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+	opts := Options{Backend: *backend, OptLevel: asm.OptLevel(*optLevel), InlineThreshold: *inline, Binary: *binary,
+		Comments: *comments, SourceMap: *sourceMap}
+	if *heapReport {
+		opts.HeapReport = os.Stderr
+	}
+	if *deadCode {
+		opts.DeadCode = os.Stderr
 	}
-	retCode += dataSegment
-	retCode += uc.UASM.MakeCodeSeg()
-	// retCode = uc.UASM.ReplaceTmpAdrr(retCode)
-	return retCode, nil
+	manifest, err := Build(methodTable, *outDir, patterns, opts)
+	if err != nil {
+		return err
+	}
+	for _, bm := range manifest.Behaviours {
+		fmt.Println(filepath.Join(*outDir, filepath.FromSlash(bm.Output)))
+	}
+	return nil
+}
+
+// Options are the settings of a compilation
+type Options struct {
+	// Backend compiles the functions, BackendAST when empty
+	Backend string
+	// OptLevel selects the optimizations run over the linked code
+	OptLevel asm.OptLevel
+	// InlineThreshold is the size of the largest function body inlined at its call sites, in syntax nodes.
+	// 0 selects DefaultInlineThreshold from -O 1 on, a negative threshold inlines only the //udon:inline functions.
+	InlineThreshold int
+	// DeadCode receives the functions of the behaviour and the statements left out because they are never reached
+	DeadCode io.Writer
+	// HeapReport receives the heap variable counts before and after they are reused, at O2
+	HeapReport io.Writer
+	// Binary also writes every behaviour as bytecode, see asm.Program.Assemble
+	Binary bool
+	// Comments writes every go statement as a comment before its instructions, with its position
+	Comments bool
+	// SourceMap keeps the source positions of the linked code in UdonCompiler.SourceMap
+	SourceMap bool
+	// Exprs receives the udon type and extern of the expressions compiled by the ast backend, see ExprInfo
+	Exprs map[ast.Expr]*ExprInfo
+}
+
+type UdonCompiler struct {
+	UASM                 *asm.UdonAssembly
+	Options              Options
+	Node                 ast.Node
+	CurrentFuncRetType   []*asm.UdonTypeName
+	CurrentBreakLabel    []asm.LabelName
+	CurrentContinueLabel []asm.LabelName
+	// SourceMap maps the linked code back to the go source, when Options.SourceMap is set
+	SourceMap *asm.SourceMap
+}
+
+// MakeUASMCode compiles a behaviour written in a single file, read from rdr
+func (uc *UdonCompiler) MakeUASMCode(w io.Writer, rdr io.Reader) (string, error) {
+	fset := token.NewFileSet() // positions are relative to fset
+
+	f, err := parser.ParseFile(fset, "main.go", rdr, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("parse file: %w", err)
+	}
+	pkg := &Package{Name: f.Name.Name, Files: []*ast.File{f}, Imports: map[string]*Package{}}
+	return uc.Link(w, &Program{Fset: fset, Packages: []*Package{pkg}})
 }
 
 type Visitor struct {
-	UASM *asm.UdonAssembly
+	C         *Compiler
+	UASM      *asm.UdonAssembly
+	Reachable map[*ast.FuncDecl]bool
+	Err       error
 }
 
 func Str(in interface{}) string {
 	return fmt.Sprintf("%s", in)
 }
 
-func collectFuncs(uasm *asm.UdonAssembly, fileNode *ast.File) {
-	v := &Visitor{uasm}
+// collectFuncs registers the events and the reachable functions of the file
+func collectFuncs(c *Compiler, uasm *asm.UdonAssembly, fileNode *ast.File, reachable map[*ast.FuncDecl]bool) error {
+	v := &Visitor{C: c, UASM: uasm, Reachable: reachable}
 	ast.Walk(v, fileNode)
+	return v.Err
 }
 
 // Visit each node for func collection
 func (v *Visitor) Visit(node ast.Node) ast.Visitor {
+	if v.Err != nil {
+		return nil
+	}
 	var err error
 	switch nt := node.(type) {
 	case *ast.FuncDecl:
+		if !v.Reachable[nt] {
+			return nil
+		}
 		argTypes := []asm.UdonTypeName{}
-		retTypes := []asm.UdonTypeName{}
 		argNames := []asm.VarName{}
 		for _, arg := range nt.Type.Params.List {
-			if len(arg.Names) > 1 {
-				fmt.Println("multiple args to type not supported")
-				os.Exit(1)
-				return v
+			typeName, _, err := v.C.ResolveType(arg.Type)
+			if err != nil {
+				v.Err = v.C.at(arg.Type.Pos(), fmt.Errorf("%s: %w", nt.Name.Name, err))
+				return nil
 			}
-			argTypes = append(argTypes, asm.UdonTypeName(fmt.Sprintf("%s", arg.Type)))
-			argNames = append(argNames, asm.VarName(fmt.Sprintf("%s", arg.Names[0])))
-		}
-		if nt.Type.Results != nil && len(nt.Type.Results.List) > 0 {
-			for _, ret := range nt.Type.Results.List {
-				if ret.Tag == nil {
-					break
-				}
-				retTypes = append(retTypes, TokenToUnity(ret.Tag.Kind))
+			for _, name := range arg.Names {
+				argTypes = append(argTypes, typeName)
+				argNames = append(argNames, asm.VarName(name.Name))
 			}
 		}
 
-		if len(retTypes) > 1 {
-			fmt.Println("multiple returns not supported")
-			os.Exit(1)
-			return v
-		}
-
-		if nt.Name.Name == "main" {
-			err = v.UASM.AddEvent(asm.EventName("_start"), argNames, argTypes)
+		if eventName, ok := v.C.eventName(nt); ok {
+			_, _, err = v.C.inlineDirectives(nt)
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				v.Err = err
+				return nil
 			}
-		} else if strings.HasPrefix(nt.Name.Name, "_") {
-			err = v.UASM.AddEvent(asm.EventName(nt.Name.Name), argNames, argTypes)
+			err = v.UASM.AddEvent(eventName, argNames, argTypes)
 			if err != nil {
-				fmt.Println(err)
-				os.Exit(1)
+				v.Err = err
+				return nil
 			}
 		} else {
-			udonReturnType := asm.GoNil
-			if len(retTypes) > 0 {
-				udonReturnType = retTypes[0]
+			udonReturnType, info, err := v.C.funcReturnType(nt)
+			if err != nil {
+				v.Err = v.C.at(nt.Type.Pos(), fmt.Errorf("%s: %w", nt.Name.Name, err))
+				return nil
 			}
-			v.UASM.FuncTable.Put(asm.FuncName(nt.Name.Name), argTypes, udonReturnType, argNames)
+			if info != nil {
+				v.C.FuncRetTypes[asm.FuncName(v.C.qualify(nt.Name.Name))] = info
+			}
+			v.UASM.FuncTable.Put(asm.FuncName(v.C.qualify(nt.Name.Name)), argTypes, udonReturnType, argNames)
+			fn := &FuncDecl{Decl: nt, Pkg: v.C.Pkg}
+			fn.Inline, fn.NoInline, err = v.C.inlineDirectives(nt)
+			if err != nil {
+				v.Err = err
+				return nil
+			}
+			v.C.Funcs[asm.FuncName(v.C.qualify(nt.Name.Name))] = fn
 		}
 
 	}
@@ -179,19 +362,197 @@ func TokenToUnity(kind token.Token) asm.UdonTypeName {
 	panic("bad token")
 }
 
-func IdentToUnity(kind *ast.Ident) asm.UdonTypeName {
+func IdentToUnity(kind *ast.Ident) (asm.UdonTypeName, error) {
 	switch kind.Name {
 	case "int":
-		return asm.GoInt
+		return asm.GoInt, nil
 
-	case "float":
-		return asm.GoFloat32
+	case "int16":
+		return asm.GoInt16, nil
 
-	case "char":
-		return asm.GoRune
+	case "uint16":
+		return asm.GoUint16, nil
+
+	case "int32":
+		return asm.GoInt32, nil
+
+	case "uint", "uint32":
+		return asm.GoUint32, nil
+
+	case "int64":
+		return asm.GoInt64, nil
+
+	case "uint64":
+		return asm.GoUint64, nil
+
+	case "float", "float32":
+		return asm.GoFloat32, nil
+
+	case "float64":
+		return asm.GoFloat64, nil
+
+	case "char", "rune":
+		return asm.GoRune, nil
+
+	case "byte":
+		return asm.GoByte, nil
+
+	case "bool":
+		return asm.GoBool, nil
 
 	case "string":
-		return asm.GoString
+		return asm.GoString, nil
 	}
-	panic("bad token")
+	return "", fmt.Errorf("unsupported type: %s", kind.Name)
+}
+
+// runDisasm runs udon-go disasm file, printing the annotated listing of a text or binary program
+func runDisasm(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: udon-go disasm file")
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	prog, err := asm.ReadProgram(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", flags.Arg(0), err)
+	}
+	prog.Disassemble(os.Stdout)
+	return nil
+}
+
+// runDebug runs udon-go debug [-sourcemap file] program, reading debugger commands from stdin,
+// or udon-go debug -dap, serving the debug adapter protocol on stdin and stdout for an editor launching the program
+func runDebug(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	sourceMap := flags.String("sourcemap", "", "source map of the program, the program path with "+asm.SourceMapExt+" by default")
+	dap := flags.Bool("dap", false, "serve the debug adapter protocol on stdin and stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *dap {
+		return debug.ServeDAP(os.Stdin, os.Stdout)
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: udon-go debug [-sourcemap file] program")
+	}
+	program := flags.Arg(0)
+	data, err := ioutil.ReadFile(program)
+	if err != nil {
+		return err
+	}
+	m, err := vm.Load(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", program, err)
+	}
+	smPath := *sourceMap
+	if smPath == "" {
+		smPath = strings.TrimSuffix(program, filepath.Ext(program)) + asm.SourceMapExt
+		if _, err := os.Stat(smPath); os.IsNotExist(err) {
+			smPath = ""
+		}
+	}
+	if smPath != "" {
+		m.SourceMap, err = debug.ReadSourceMap(smPath)
+		if err != nil {
+			return err
+		}
+	}
+	return debug.New(m, m.SourceMap).Console(os.Stdin, os.Stdout)
+}
+
+// typeArg returns the udon type named on the command line, see asm.LookupTypeName.
+// Names it does not know are taken as the module names of the method table.
+func typeArg(name string) asm.UdonTypeName {
+	if typeName, ok := asm.LookupTypeName(name); ok {
+		return typeName
+	}
+	return asm.FullTypeName(asm.UdonTypeName(name))
+}
+
+// runExterns runs udon-go externs search [-funcs file] [-type type] [-kind kind] [-args types] [[type.]method],
+// printing the go signature and extern of the externs found
+func runExterns(args []string) error {
+	if len(args) == 0 || args[0] != "search" {
+		return errors.New("usage: udon-go externs search [-funcs file] [-type type] [-kind kind] [-args types] [[type.]method]")
+	}
+	flags := flag.NewFlagSet("externs search", flag.ContinueOnError)
+	funcs := funcsFlag(flags)
+	module := flags.String("type", "", "type declaring the externs, e.g. asm.Transform, Transform or UnityEngineTransform")
+	kind := flags.String("kind", "", "kind of the externs, StaticFunc, InstanceFunc or Constructor")
+	argTypes := flags.String("args", "", "comma separated argument types, e.g. int32,string, () for none")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	q := &asm.ExternQuery{}
+	if *module != "" {
+		q.Module = typeArg(*module)
+	}
+	for _, k := range []asm.UdonMethodKind{asm.STATIC_FUNC, asm.INSTANCE_FUNC, asm.CONSTRUCTOR, asm.UNKNOWN} {
+		if strings.EqualFold(*kind, string(k)) {
+			q.Kind = k
+		}
+	}
+	if *kind != "" && q.Kind == "" {
+		return fmt.Errorf("unknown kind %s, want StaticFunc, InstanceFunc or Constructor", *kind)
+	}
+	if *argTypes != "" {
+		q.ArgTypes = []asm.UdonTypeName{}
+		for _, name := range strings.Split(strings.Trim(*argTypes, "()"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				q.ArgTypes = append(q.ArgTypes, typeArg(name))
+			}
+		}
+	}
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		// the type may prefix the method, itself prefixed by asm.
+		q.Method = flags.Arg(0)
+		if i := strings.LastIndex(q.Method, "."); i >= 0 {
+			q.Module, q.Method = typeArg(q.Method[:i]), q.Method[i+1:]
+		}
+	default:
+		return errors.New("usage: udon-go externs search [-funcs file] [-type type] [-kind kind] [-args types] [[type.]method]")
+	}
+	methodTable, err := loadMethodTable(*funcs)
+	if err != nil {
+		return err
+	}
+	for _, m := range methodTable.Search(q) {
+		m.Write(os.Stdout, "")
+	}
+	return nil
+}
+
+// runDoc runs udon-go doc [-funcs file] type, printing the constructors, properties and methods of the method table for a type
+func runDoc(args []string) error {
+	flags := flag.NewFlagSet("doc", flag.ContinueOnError)
+	funcs := funcsFlag(flags)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: udon-go doc [-funcs file] type")
+	}
+	methodTable, err := loadMethodTable(*funcs)
+	if err != nil {
+		return err
+	}
+	doc, err := methodTable.Doc(typeArg(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	doc.Write(os.Stdout)
+	return nil
 }
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"udon-go/asm"
+)
+
+// MapType is a go map.
+// Udon exposes no dictionary externs, so a map is an open addressing hash table with linear probing,
+// stored in a SystemObjectArray header: [keys, values, count, used].
+// keys and values are parallel SystemObjectArrays with a power of two capacity, count is the number
+// of entries and used the number of occupied slots including deleted ones.
+// A deleted slot holds the keys array itself, which can never be a key.
+// The table is managed by the runtime functions below, which are emitted once when a map is used.
+type MapType struct {
+	Key       asm.UdonTypeName
+	Value     asm.UdonTypeName
+	KeyInfo   *TypeInfo
+	ValueInfo *TypeInfo
+}
+
+const mapInitialCapacity = 8
+
+var (
+	mapMakeFunc = RuntimeFunc{
+		Name:    "__map_make",
+		RetType: asm.UdonTypeObjectArray,
+	}
+	mapFindFunc = RuntimeFunc{
+		Name:     "__map_find",
+		ArgNames: []asm.VarName{"m", "key"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray, asm.UdonTypeObject},
+		RetType:  asm.UdonTypeInt32,
+	}
+	mapSetFunc = RuntimeFunc{
+		Name:     "__map_set",
+		ArgNames: []asm.VarName{"m", "key", "value"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray, asm.UdonTypeObject, asm.UdonTypeObject},
+		RetType:  asm.GoNil,
+	}
+	mapGrowFunc = RuntimeFunc{
+		Name:     "__map_grow",
+		ArgNames: []asm.VarName{"m"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray},
+		RetType:  asm.GoNil,
+	}
+	mapDeleteFunc = RuntimeFunc{
+		Name:     "__map_delete",
+		ArgNames: []asm.VarName{"m", "key"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray, asm.UdonTypeObject},
+		RetType:  asm.GoNil,
+	}
+	mapLenFunc = RuntimeFunc{
+		Name:     "__map_len",
+		ArgNames: []asm.VarName{"m"},
+		ArgTypes: []asm.UdonTypeName{asm.UdonTypeObjectArray},
+		RetType:  asm.UdonTypeInt32,
+	}
+	mapRuntimeFuncs = []RuntimeFunc{mapMakeFunc, mapFindFunc, mapSetFunc, mapGrowFunc, mapDeleteFunc, mapLenFunc}
+)
+
+// header slots
+const (
+	mapKeysSlot = iota
+	mapValuesSlot
+	mapCountSlot
+	mapUsedSlot
+	mapHeaderSize
+)
+
+func (c *Compiler) resolveMapType(t *ast.MapType) (*MapType, error) {
+	keyType, keyInfo, err := c.ResolveType(t.Key)
+	if err != nil {
+		return nil, fmt.Errorf("map key: %w", err)
+	}
+	if keyInfo != nil && keyInfo.Map != nil {
+		return nil, errors.New("map key: maps are not comparable")
+	}
+	valueType, valueInfo, err := c.ResolveType(t.Value)
+	if err != nil {
+		return nil, fmt.Errorf("map value: %w", err)
+	}
+	return &MapType{
+		Key:       keyType,
+		Value:     valueType,
+		KeyInfo:   keyInfo,
+		ValueInfo: valueInfo,
+	}, nil
+}
+
+// registerMapRuntime makes the map runtime callable, it is only emitted when c.UsesMaps is set
+func registerMapRuntime(uasm *asm.UdonAssembly) {
+	for _, f := range mapRuntimeFuncs {
+		f.register(uasm)
+	}
+}
+
+// handleMakeExpr compiles make(map[K]V) and make(map[K]V, n), the size hint is ignored
+func (c *Compiler) handleMakeExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr) (asm.VarName, error) {
+	if len(expr.Args) == 0 || len(expr.Args) > 2 {
+		return "", errors.New("make: unsupported # of args")
+	}
+	mapType, ok := expr.Args[0].(*ast.MapType)
+	if !ok {
+		return "", fmt.Errorf("make %T: %w", expr.Args[0], ErrNotImplemented)
+	}
+	mt, err := c.resolveMapType(mapType)
+	if err != nil {
+		return "", err
+	}
+	return c.newMap(uasm, mt)
+}
+
+func (c *Compiler) newMap(uasm *asm.UdonAssembly, mt *MapType) (asm.VarName, error) {
+	c.UsesMaps = true
+	retVarName, err := uasm.CallDefFunc(mapMakeFunc.Name, []asm.VarName{})
+	if err != nil {
+		return "", fmt.Errorf("make map: %w", err)
+	}
+	c.SetTypeInfo(*retVarName, &TypeInfo{Map: mt})
+	return *retVarName, nil
+}
+
+// handleMapLit compiles map[K]V{k: v, ...}
+func (c *Compiler) handleMapLit(uasm *asm.UdonAssembly, out io.Writer, lit *ast.CompositeLit, mapType *ast.MapType) (asm.VarName, error) {
+	mt, err := c.resolveMapType(mapType)
+	if err != nil {
+		return "", err
+	}
+	mapVarName, err := c.newMap(uasm, mt)
+	if err != nil {
+		return "", err
+	}
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return "", errors.New("map literal: missing key")
+		}
+		keyVarName, err := c.handleExpr(uasm, out, kv.Key)
+		if err != nil {
+			return "", fmt.Errorf("map literal key: %w", err)
+		}
+		valueVarName, err := c.handleExpr(uasm, out, kv.Value)
+		if err != nil {
+			return "", fmt.Errorf("map literal value: %w", err)
+		}
+		err = c.handleMapSet(uasm, mapVarName, keyVarName, valueVarName)
+		if err != nil {
+			return "", err
+		}
+	}
+	return mapVarName, nil
+}
+
+// mapKey checks the key type and widens it for the runtime functions
+func (c *Compiler) mapKey(uasm *asm.UdonAssembly, mt *MapType, keyVarName asm.VarName) (asm.VarName, error) {
+	keyType, err := uasm.VarTable.GetVarType(keyVarName)
+	if err != nil {
+		return "", err
+	}
+	if keyType != mt.Key && keyType != asm.UdonTypeObject {
+		return "", fmt.Errorf("cannot use %s as map key of type %s", keyType, mt.Key)
+	}
+	return c.toObject(uasm, keyVarName)
+}
+
+// handleMapIndex compiles m[k], returning the value and a boolean telling whether the key was present
+func (c *Compiler) handleMapIndex(uasm *asm.UdonAssembly, mapVarName asm.VarName, keyVarName asm.VarName) (asm.VarName, asm.VarName, error) {
+	mt, ok := c.MapOf(mapVarName)
+	if !ok {
+		return "", "", fmt.Errorf("%s is not a map", mapVarName)
+	}
+	c.UsesMaps = true
+	objKeyVarName, err := c.mapKey(uasm, mt, keyVarName)
+	if err != nil {
+		return "", "", err
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s[%s]", mapVarName, keyVarName))
+	slotVarName, err := uasm.CallDefFunc(mapFindFunc.Name, []asm.VarName{mapVarName, objKeyVarName})
+	if err != nil {
+		return "", "", fmt.Errorf("map index: %w", err)
+	}
+	r := &routine{c: c, uasm: uasm}
+	okVarName := r.op(token.GEQ, *slotVarName, r.constInt(0))
+	objVarName := uasm.GetNextId("obj")
+	r.fail(uasm.VarTable.AddVar(objVarName, asm.UdonTypeObject, "null"))
+	r.assign(objVarName, r.constNil())
+	missingLabel := r.newLabel("map_missing")
+	r.jumpIfFalse(okVarName, missingLabel)
+	values := r.get(mapVarName, r.constInt(mapValuesSlot), asm.UdonTypeObjectArray)
+	r.assign(objVarName, r.get(values, *slotVarName, asm.UdonTypeObject))
+	r.mark(missingLabel)
+	if r.err != nil {
+		return "", "", r.err
+	}
+	// missing keys cast to the zero value
+	valueVarName, err := c.castFromObject(uasm, objVarName, mt.Value)
+	if err != nil {
+		return "", "", err
+	}
+	c.SetTypeInfo(valueVarName, mt.ValueInfo)
+	return valueVarName, okVarName, nil
+}
+
+// handleMapSet compiles m[k] = v
+func (c *Compiler) handleMapSet(uasm *asm.UdonAssembly, mapVarName asm.VarName, keyVarName asm.VarName, valueVarName asm.VarName) error {
+	mt, ok := c.MapOf(mapVarName)
+	if !ok {
+		return fmt.Errorf("%s is not a map", mapVarName)
+	}
+	c.UsesMaps = true
+	objKeyVarName, err := c.mapKey(uasm, mt, keyVarName)
+	if err != nil {
+		return err
+	}
+	valueType, err := uasm.VarTable.GetVarType(valueVarName)
+	if err != nil {
+		return err
+	}
+	if valueType != mt.Value && valueType != asm.UdonTypeObject {
+		return fmt.Errorf("cannot use %s as map value of type %s", valueType, mt.Value)
+	}
+	objValueVarName, err := c.toObject(uasm, valueVarName)
+	if err != nil {
+		return err
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s[%s] = %s", mapVarName, keyVarName, valueVarName))
+	_, err = uasm.CallDefFunc(mapSetFunc.Name, []asm.VarName{mapVarName, objKeyVarName, objValueVarName})
+	if err != nil {
+		return fmt.Errorf("map set: %w", err)
+	}
+	return nil
+}
+
+// handleDeleteExpr compiles delete(m, k)
+func (c *Compiler) handleDeleteExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr) (asm.VarName, error) {
+	if len(expr.Args) != 2 {
+		return "", errors.New("delete: expected two arguments")
+	}
+	mapVarName, err := c.handleExpr(uasm, out, expr.Args[0])
+	if err != nil {
+		return "", fmt.Errorf("delete: %w", err)
+	}
+	mt, ok := c.MapOf(mapVarName)
+	if !ok {
+		return "", errors.New("delete: first argument is not a map")
+	}
+	c.UsesMaps = true
+	keyVarName, err := c.handleExpr(uasm, out, expr.Args[1])
+	if err != nil {
+		return "", fmt.Errorf("delete: %w", err)
+	}
+	objKeyVarName, err := c.mapKey(uasm, mt, keyVarName)
+	if err != nil {
+		return "", fmt.Errorf("delete: %w", err)
+	}
+	_, err = uasm.CallDefFunc(mapDeleteFunc.Name, []asm.VarName{mapVarName, objKeyVarName})
+	if err != nil {
+		return "", fmt.Errorf("delete: %w", err)
+	}
+	return "", nil
+}
+
+// handleLenExpr compiles len(m)
+func (c *Compiler) handleLenExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr) (asm.VarName, error) {
+	if len(expr.Args) != 1 {
+		return "", errors.New("len: expected one argument")
+	}
+	varName, err := c.handleExpr(uasm, out, expr.Args[0])
+	if err != nil {
+		return "", fmt.Errorf("len: %w", err)
+	}
+	if _, ok := c.MapOf(varName); ok {
+		c.UsesMaps = true
+		retVarName, err := uasm.CallDefFunc(mapLenFunc.Name, []asm.VarName{varName})
+		if err != nil {
+			return "", fmt.Errorf("len: %w", err)
+		}
+		return *retVarName, nil
+	}
+	typeName, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	if typeName == asm.UdonTypeString {
+		return c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeString, "get_Length", varName, []asm.VarName{})
+	}
+	return "", fmt.Errorf("len %s: %w", typeName, ErrNotImplemented)
+}
+
+// handleMapRange compiles for k, v := range m by walking the slots of the table in order
+func (c *Compiler) handleMapRange(uasm *asm.UdonAssembly, out io.Writer, st *ast.RangeStmt, mapVarName asm.VarName, mt *MapType) error {
+	c.UsesMaps = true
+	r := &routine{c: c, uasm: uasm}
+	uasm.AddInstComment(fmt.Sprintf("range %s", mapVarName))
+	loopLabel := r.newLabel("map_range")
+	continueLabel := r.newLabel("map_range_continue")
+	endLabel := r.newLabel("map_range_end")
+
+	index := uasm.GetNextId("map_range_index")
+	r.fail(uasm.VarTable.AddVar(index, asm.UdonTypeInt32, "0"))
+	r.assign(index, r.constInt(0))
+	r.jumpIfFalse(r.op(token.NEQ, mapVarName, r.constNil()), endLabel)
+	keys := r.get(mapVarName, r.constInt(mapKeysSlot), asm.UdonTypeObjectArray)
+	values := r.get(mapVarName, r.constInt(mapValuesSlot), asm.UdonTypeObjectArray)
+	capacity := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", keys)
+
+	r.mark(loopLabel)
+	r.jumpIfFalse(r.op(token.LSS, index, capacity), endLabel)
+	slot := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", keys, index)
+	r.jumpIfFalse(r.op(token.NEQ, slot, r.constNil()), continueLabel)
+	deleted := r.extern(asm.STATIC_FUNC, asm.UdonTypeObject, "ReferenceEquals", "", slot, r.objOf(keys))
+	r.jumpIfFalse(r.extern(asm.STATIC_FUNC, asm.UdonTypeBoolean, "op_UnaryNegation", "", deleted), continueLabel)
+	if r.err != nil {
+		return r.err
+	}
+
+	if st.Key != nil {
+		keyVarName, err := c.castFromObject(uasm, slot, mt.Key)
+		if err != nil {
+			return err
+		}
+		c.SetTypeInfo(keyVarName, mt.KeyInfo)
+		err = c.rangeAssign(uasm, out, st.Tok, st.Key, keyVarName)
+		if err != nil {
+			return err
+		}
+	}
+	if st.Value != nil {
+		valueVarName := r.get(values, index, mt.Value)
+		if r.err != nil {
+			return r.err
+		}
+		c.SetTypeInfo(valueVarName, mt.ValueInfo)
+		err := c.rangeAssign(uasm, out, st.Tok, st.Value, valueVarName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.handleLoopBody(uasm, out, st.Body, endLabel, continueLabel)
+	if err != nil {
+		return err
+	}
+
+	r.mark(continueLabel)
+	r.assign(index, r.op(token.ADD, index, r.constInt(1)))
+	r.jump(loopLabel)
+	r.mark(endLabel)
+	return r.err
+}
+
+// objOf widens varName to a SystemObject
+func (r *routine) objOf(varName asm.VarName) asm.VarName {
+	if r.err != nil {
+		return ""
+	}
+	obj, err := r.c.toObject(r.uasm, varName)
+	r.fail(err)
+	return obj
+}
+
+// emitMapRuntime emits the runtime functions managing map hash tables
+func (c *Compiler) emitMapRuntime(uasm *asm.UdonAssembly) error {
+	uasm.AddComment("map runtime: udon has no dictionary externs, so maps are open addressing hash tables")
+	uasm.AddComment("with linear probing, stored in a SystemObjectArray [keys, values, count, used].")
+	uasm.AddComment("keys and values are parallel SystemObjectArrays, deleted slots hold the keys array.")
+	for _, emit := range []func(*asm.UdonAssembly) error{
+		c.emitMapMake,
+		c.emitMapFind,
+		c.emitMapSet,
+		c.emitMapGrow,
+		c.emitMapDelete,
+		c.emitMapLen,
+	} {
+		err := emit(uasm)
+		if err != nil {
+			return fmt.Errorf("map runtime: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) emitMapMake(uasm *asm.UdonAssembly) error {
+	r, _ := c.beginRoutine(uasm, mapMakeFunc)
+	m := r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", r.constInt(mapHeaderSize))
+	r.set(m, r.constInt(mapKeysSlot), r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", r.constInt(mapInitialCapacity)))
+	r.set(m, r.constInt(mapValuesSlot), r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", r.constInt(mapInitialCapacity)))
+	r.set(m, r.constInt(mapCountSlot), r.constInt(0))
+	r.set(m, r.constInt(mapUsedSlot), r.constInt(0))
+	r.ret(m)
+	return r.end()
+}
+
+// __map_find returns the slot holding key, or -1
+func (c *Compiler) emitMapFind(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, mapFindFunc)
+	m, key := args[0], args[1]
+	result := r.local("result", asm.UdonTypeInt32)
+	h := r.local("h", asm.UdonTypeInt32)
+	loopLabel := r.newLabel("map_find_loop")
+	doneLabel := r.newLabel("map_find_done")
+
+	r.assign(result, r.constInt(-1))
+	r.jumpIfFalse(r.op(token.NEQ, m, r.constNil()), doneLabel)
+	keys := r.get(m, r.constInt(mapKeysSlot), asm.UdonTypeObjectArray)
+	mask := r.op(token.SUB, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", keys), r.constInt(1))
+	r.assign(h, r.op(token.AND, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObject, "GetHashCode", key), mask))
+
+	r.mark(loopLabel)
+	slot := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", keys, h)
+	r.jumpIfFalse(r.op(token.NEQ, slot, r.constNil()), doneLabel)
+	nextLabel := r.newLabel("map_find_next")
+	r.jumpIfFalse(r.extern(asm.STATIC_FUNC, asm.UdonTypeObject, "Equals", "", slot, key), nextLabel)
+	r.assign(result, h)
+	r.jump(doneLabel)
+	r.mark(nextLabel)
+	r.assign(h, r.op(token.AND, r.op(token.ADD, h, r.constInt(1)), mask))
+	r.jump(loopLabel)
+
+	r.mark(doneLabel)
+	r.ret(result)
+	return r.end()
+}
+
+// __map_set updates the slot holding key, or inserts it into the first empty slot
+func (c *Compiler) emitMapSet(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, mapSetFunc)
+	m, key, value := args[0], args[1], args[2]
+	h := r.local("h", asm.UdonTypeInt32)
+	insertLabel := r.newLabel("map_set_insert")
+	probeLabel := r.newLabel("map_set_probe")
+	placeLabel := r.newLabel("map_set_place")
+	noGrowLabel := r.newLabel("map_set_no_grow")
+
+	existing := r.call(mapFindFunc.Name, m, key)
+	r.jumpIfFalse(r.op(token.GEQ, existing, r.constInt(0)), insertLabel)
+	r.set(r.get(m, r.constInt(mapValuesSlot), asm.UdonTypeObjectArray), existing, value)
+	r.ret("")
+
+	r.mark(insertLabel)
+	// keep the load factor under 3/4 so probing always finds an empty slot
+	used := r.get(m, r.constInt(mapUsedSlot), asm.UdonTypeInt32)
+	capacity := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", r.get(m, r.constInt(mapKeysSlot), asm.UdonTypeObjectArray))
+	load := r.op(token.MUL, r.op(token.ADD, used, r.constInt(1)), r.constInt(4))
+	r.jumpIfFalse(r.op(token.GTR, load, r.op(token.MUL, capacity, r.constInt(3))), noGrowLabel)
+	r.call(mapGrowFunc.Name, m)
+	r.mark(noGrowLabel)
+
+	keys := r.get(m, r.constInt(mapKeysSlot), asm.UdonTypeObjectArray)
+	values := r.get(m, r.constInt(mapValuesSlot), asm.UdonTypeObjectArray)
+	mask := r.op(token.SUB, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", keys), r.constInt(1))
+	r.assign(h, r.op(token.AND, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObject, "GetHashCode", key), mask))
+	r.mark(probeLabel)
+	slot := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", keys, h)
+	r.jumpIfFalse(r.op(token.NEQ, slot, r.constNil()), placeLabel)
+	r.assign(h, r.op(token.AND, r.op(token.ADD, h, r.constInt(1)), mask))
+	r.jump(probeLabel)
+
+	r.mark(placeLabel)
+	r.set(keys, h, key)
+	r.set(values, h, value)
+	r.set(m, r.constInt(mapCountSlot), r.op(token.ADD, r.get(m, r.constInt(mapCountSlot), asm.UdonTypeInt32), r.constInt(1)))
+	r.set(m, r.constInt(mapUsedSlot), r.op(token.ADD, r.get(m, r.constInt(mapUsedSlot), asm.UdonTypeInt32), r.constInt(1)))
+	r.ret("")
+	return r.end()
+}
+
+// __map_grow rehashes the live entries into tables of twice the capacity, dropping deleted slots
+func (c *Compiler) emitMapGrow(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, mapGrowFunc)
+	m := args[0]
+	i := r.local("i", asm.UdonTypeInt32)
+	h := r.local("h", asm.UdonTypeInt32)
+	loopLabel := r.newLabel("map_grow_loop")
+	probeLabel := r.newLabel("map_grow_probe")
+	placeLabel := r.newLabel("map_grow_place")
+	nextLabel := r.newLabel("map_grow_next")
+	doneLabel := r.newLabel("map_grow_done")
+
+	oldKeys := r.get(m, r.constInt(mapKeysSlot), asm.UdonTypeObjectArray)
+	oldValues := r.get(m, r.constInt(mapValuesSlot), asm.UdonTypeObjectArray)
+	oldCapacity := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "get_Length", oldKeys)
+	newCapacity := r.op(token.MUL, oldCapacity, r.constInt(2))
+	newKeys := r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", newCapacity)
+	newValues := r.extern(asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", newCapacity)
+	mask := r.op(token.SUB, newCapacity, r.constInt(1))
+	r.assign(i, r.constInt(0))
+
+	r.mark(loopLabel)
+	r.jumpIfFalse(r.op(token.LSS, i, oldCapacity), doneLabel)
+	key := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", oldKeys, i)
+	r.jumpIfFalse(r.op(token.NEQ, key, r.constNil()), nextLabel)
+	deleted := r.extern(asm.STATIC_FUNC, asm.UdonTypeObject, "ReferenceEquals", "", key, r.objOf(oldKeys))
+	r.jumpIfFalse(r.extern(asm.STATIC_FUNC, asm.UdonTypeBoolean, "op_UnaryNegation", "", deleted), nextLabel)
+	r.assign(h, r.op(token.AND, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObject, "GetHashCode", key), mask))
+	r.mark(probeLabel)
+	r.jumpIfFalse(r.op(token.NEQ, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", newKeys, h), r.constNil()), placeLabel)
+	r.assign(h, r.op(token.AND, r.op(token.ADD, h, r.constInt(1)), mask))
+	r.jump(probeLabel)
+	r.mark(placeLabel)
+	r.set(newKeys, h, key)
+	r.set(newValues, h, r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", oldValues, i))
+	r.mark(nextLabel)
+	r.assign(i, r.op(token.ADD, i, r.constInt(1)))
+	r.jump(loopLabel)
+
+	r.mark(doneLabel)
+	r.set(m, r.constInt(mapKeysSlot), newKeys)
+	r.set(m, r.constInt(mapValuesSlot), newValues)
+	r.set(m, r.constInt(mapUsedSlot), r.get(m, r.constInt(mapCountSlot), asm.UdonTypeInt32))
+	r.ret("")
+	return r.end()
+}
+
+// __map_delete marks the slot holding key as deleted
+func (c *Compiler) emitMapDelete(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, mapDeleteFunc)
+	m, key := args[0], args[1]
+	doneLabel := r.newLabel("map_delete_done")
+
+	slot := r.call(mapFindFunc.Name, m, key)
+	r.jumpIfFalse(r.op(token.GEQ, slot, r.constInt(0)), doneLabel)
+	keys := r.get(m, r.constInt(mapKeysSlot), asm.UdonTypeObjectArray)
+	r.set(keys, slot, keys)
+	r.set(r.get(m, r.constInt(mapValuesSlot), asm.UdonTypeObjectArray), slot, r.constNil())
+	r.set(m, r.constInt(mapCountSlot), r.op(token.SUB, r.get(m, r.constInt(mapCountSlot), asm.UdonTypeInt32), r.constInt(1)))
+	r.mark(doneLabel)
+	r.ret("")
+	return r.end()
+}
+
+// __map_len returns the number of entries, 0 for a nil map
+func (c *Compiler) emitMapLen(uasm *asm.UdonAssembly) error {
+	r, args := c.beginRoutine(uasm, mapLenFunc)
+	m := args[0]
+	result := r.local("result", asm.UdonTypeInt32)
+	doneLabel := r.newLabel("map_len_done")
+
+	r.assign(result, r.constInt(0))
+	r.jumpIfFalse(r.op(token.NEQ, m, r.constNil()), doneLabel)
+	r.assign(result, r.get(m, r.constInt(mapCountSlot), asm.UdonTypeInt32))
+	r.mark(doneLabel)
+	r.ret(result)
+	return r.end()
+}
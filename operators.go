@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"strings"
+	"udon-go/asm"
+)
+
+// BinaryOpMethods maps go binary operators to the udon operator methods
+var BinaryOpMethods = map[token.Token]asm.UdonMethodName{
+	token.ADD: "op_Addition",
+	token.SUB: "op_Subtraction",
+	token.MUL: "op_Multiplication",
+	token.QUO: "op_Division",
+	token.EQL: "op_Equality",
+	token.NEQ: "op_Inequality",
+	token.LSS: "op_LessThan",
+	token.GTR: "op_GreaterThan",
+	token.LEQ: "op_LessThanOrEqual",
+	token.GEQ: "op_GreaterThanOrEqual",
+	token.AND: "op_LogicalAnd",
+	token.OR:  "op_LogicalOr",
+	token.XOR: "op_LogicalXor",
+	token.SHL: "op_LeftShift",
+	token.SHR: "op_RightShift",
+}
+
+// AssignOpTokens maps compound assignment operators to their binary operator
+var AssignOpTokens = map[token.Token]token.Token{
+	token.ADD_ASSIGN:     token.ADD,
+	token.SUB_ASSIGN:     token.SUB,
+	token.MUL_ASSIGN:     token.MUL,
+	token.QUO_ASSIGN:     token.QUO,
+	token.REM_ASSIGN:     token.REM,
+	token.AND_ASSIGN:     token.AND,
+	token.OR_ASSIGN:      token.OR,
+	token.XOR_ASSIGN:     token.XOR,
+	token.SHL_ASSIGN:     token.SHL,
+	token.SHR_ASSIGN:     token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
+}
+
+// allOnes are the constants ^x is computed with, as x ^ allOnes
+var allOnes = map[asm.UdonTypeName]string{
+	asm.UdonTypeSByte:  "-1",
+	asm.UdonTypeInt16:  "-1",
+	asm.UdonTypeInt32:  "-1",
+	asm.UdonTypeInt64:  "-1",
+	asm.UdonTypeByte:   "255",
+	asm.UdonTypeUInt16: "65535",
+	asm.UdonTypeUInt32: "4294967295",
+	asm.UdonTypeUInt64: "18446744073709551615",
+}
+
+func (c *Compiler) handleBinaryExpr(uasm *asm.UdonAssembly, out io.Writer, be *ast.BinaryExpr) (asm.VarName, error) {
+	if be.Op == token.LAND || be.Op == token.LOR {
+		return c.handleLogicalExpr(uasm, out, be)
+	}
+	xVarName, err := c.handleExpr(uasm, out, be.X)
+	if err != nil {
+		return "", fmt.Errorf("binaryExpr: left: %w", err)
+	}
+	yVarName, err := c.handleExpr(uasm, out, be.Y)
+	if err != nil {
+		return "", fmt.Errorf("binaryExpr: right: %w", err)
+	}
+	return c.binaryOp(uasm, be.Op, xVarName, yVarName)
+}
+
+// binaryOp emits the operator extern for x op y, resolved by the type of the left operand
+func (c *Compiler) binaryOp(uasm *asm.UdonAssembly, op token.Token, xVarName asm.VarName, yVarName asm.VarName) (asm.VarName, error) {
+	switch op {
+	case token.REM:
+		return c.remainder(uasm, xVarName, yVarName)
+	case token.AND_NOT:
+		notYVarName, err := c.unaryOp(uasm, token.XOR, yVarName)
+		if err != nil {
+			return "", err
+		}
+		return c.binaryOp(uasm, token.AND, xVarName, notYVarName)
+	}
+	methodName, ok := BinaryOpMethods[op]
+	if !ok {
+		return "", fmt.Errorf("binaryExpr %s: %w", op, ErrNotImplemented)
+	}
+	xVarName, yVarName, err := c.matchUntyped(uasm, xVarName, yVarName)
+	if err != nil {
+		return "", err
+	}
+	xType, err := uasm.VarTable.GetVarType(xVarName)
+	if err != nil {
+		return "", err
+	}
+	yType, err := uasm.VarTable.GetVarType(yVarName)
+	if err != nil {
+		return "", err
+	}
+	if (op == token.SHL || op == token.SHR) && yType != asm.UdonTypeInt32 {
+		// most shift externs take an Int32 count
+		_, _, err := uasm.MethodTable.FindExtern(asm.STATIC_FUNC, xType, methodName, []asm.UdonTypeName{xType, yType})
+		if err != nil {
+			yVarName, err = c.convert(uasm, yVarName, asm.UdonTypeInt32)
+			if err != nil {
+				return "", fmt.Errorf("shift count: %w", err)
+			}
+		}
+	}
+	if (op == token.EQL || op == token.NEQ) && (xType == asm.UdonTypeObject || yType == asm.UdonTypeObject || xType == asm.UdonTypeObjectArray) {
+		// nil checks and pointer identity, e.g. p == nil
+		xObjVarName, err := c.toObject(uasm, xVarName)
+		if err != nil {
+			return "", err
+		}
+		yObjVarName, err := c.toObject(uasm, yVarName)
+		if err != nil {
+			return "", err
+		}
+		return c.callExtern(uasm, asm.STATIC_FUNC, asm.UdonTypeObject, methodName, "", []asm.VarName{xObjVarName, yObjVarName})
+	}
+	retVarName, err := c.callExtern(uasm, asm.STATIC_FUNC, xType, methodName, "", []asm.VarName{xVarName, yVarName})
+	if err != nil {
+		return "", fmt.Errorf("binaryExpr %s: %w", op, err)
+	}
+	return retVarName, nil
+}
+
+// remainder computes x % y as x - (x/y)*y, udon has no modulus operator for integers
+func (c *Compiler) remainder(uasm *asm.UdonAssembly, xVarName asm.VarName, yVarName asm.VarName) (asm.VarName, error) {
+	xType, err := uasm.VarTable.GetVarType(xVarName)
+	if err != nil {
+		return "", err
+	}
+	if !isInteger(xType) {
+		return "", fmt.Errorf("operator %% not defined on %s", xType)
+	}
+	quotient, err := c.binaryOp(uasm, token.QUO, xVarName, yVarName)
+	if err != nil {
+		return "", err
+	}
+	product, err := c.binaryOp(uasm, token.MUL, quotient, yVarName)
+	if err != nil {
+		return "", err
+	}
+	return c.binaryOp(uasm, token.SUB, xVarName, product)
+}
+
+// matchUntyped gives an untyped constant operand the type of the other operand, e.g. f * 2 for a float f
+func (c *Compiler) matchUntyped(uasm *asm.UdonAssembly, xVarName asm.VarName, yVarName asm.VarName) (asm.VarName, asm.VarName, error) {
+	xType, err := uasm.VarTable.GetVarType(xVarName)
+	if err != nil {
+		return "", "", err
+	}
+	yType, err := uasm.VarTable.GetVarType(yVarName)
+	if err != nil {
+		return "", "", err
+	}
+	if xType == yType || !numericTypes[xType] || !numericTypes[yType] {
+		return xVarName, yVarName, nil
+	}
+	if value, ok := c.UntypedConsts[yVarName]; ok {
+		yVarName, err = c.typedConst(uasm, value, xType)
+	} else if value, ok := c.UntypedConsts[xVarName]; ok {
+		xVarName, err = c.typedConst(uasm, value, yType)
+	}
+	return xVarName, yVarName, err
+}
+
+// untypedAs gives varName typeName if it is an untyped constant and typeName is numeric,
+// other values are returned as they are for the caller to check
+func (c *Compiler) untypedAs(uasm *asm.UdonAssembly, varName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	value, ok := c.UntypedConsts[varName]
+	if !ok || !numericTypes[typeName] {
+		return varName, nil
+	}
+	varType, err := uasm.VarTable.GetVarType(varName)
+	if err != nil || varType == typeName {
+		return varName, err
+	}
+	return c.typedConst(uasm, value, typeName)
+}
+
+// typedConst declares the untyped constant value with typeName
+func (c *Compiler) typedConst(uasm *asm.UdonAssembly, value string, typeName asm.UdonTypeName) (asm.VarName, error) {
+	if isInteger(typeName) && strings.ContainsAny(value, ".eE") {
+		return "", fmt.Errorf("constant %s truncated to %s", value, typeName)
+	}
+	constNextID := uasm.GetNextId("const")
+	err := uasm.VarTable.AddVar(constNextID, typeName, value)
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	return constNextID, nil
+}
+
+// unaryOp emits the operator extern for op x
+func (c *Compiler) unaryOp(uasm *asm.UdonAssembly, op token.Token, xVarName asm.VarName) (asm.VarName, error) {
+	xType, err := uasm.VarTable.GetVarType(xVarName)
+	if err != nil {
+		return "", err
+	}
+	switch op {
+	case token.ADD:
+		if !numericTypes[xType] {
+			return "", fmt.Errorf("operator + not defined on %s", xType)
+		}
+		return xVarName, nil
+	case token.SUB:
+		return c.callExtern(uasm, asm.STATIC_FUNC, xType, "op_UnaryMinus", "", []asm.VarName{xVarName})
+	case token.NOT:
+		if xType != asm.UdonTypeBoolean {
+			return "", fmt.Errorf("operator ! not defined on %s", xType)
+		}
+		return c.callExtern(uasm, asm.STATIC_FUNC, xType, "op_UnaryNegation", "", []asm.VarName{xVarName})
+	case token.XOR:
+		// udon has no complement operator, ^x is x ^ -1
+		value, ok := allOnes[xType]
+		if !ok {
+			return "", fmt.Errorf("operator ^ not defined on %s", xType)
+		}
+		onesVarName, err := c.typedConst(uasm, value, xType)
+		if err != nil {
+			return "", err
+		}
+		retVarName, err := c.binaryOp(uasm, token.XOR, xVarName, onesVarName)
+		if err != nil {
+			return "", err
+		}
+		// small integers are promoted to SystemInt32 by the extern
+		return c.convert(uasm, retVarName, xType)
+	}
+	return "", fmt.Errorf("unaryExpr %s: %w", op, ErrNotImplemented)
+}
+
+// handleOpAssign compiles lhs op= rhs, evaluating the operands of lhs once.
+// A nil rhs is the constant 1 of the type of lhs, for x++ and x--.
+func (c *Compiler) handleOpAssign(uasm *asm.UdonAssembly, out io.Writer, lhs ast.Expr, op token.Token, rhs ast.Expr) error {
+	var load func() (asm.VarName, error)
+	var store func(asm.VarName) error
+	switch l := lhs.(type) {
+	case *ast.Ident:
+		// x += y does not count as a use of x
+		varName, err := c.lookupVar(l, false)
+		if err != nil {
+			return err
+		}
+		load = func() (asm.VarName, error) { return varName, nil }
+		store = func(src asm.VarName) error { return uasm.Assign(varName, src) }
+	case *ast.SelectorExpr:
+		ptrVarName, err := c.handleExpr(uasm, out, l.X)
+		if err != nil {
+			return err
+		}
+		if bt, ok := c.BehaviourOf(ptrVarName); ok {
+			load = func() (asm.VarName, error) { return c.handleProgramVarGet(uasm, ptrVarName, bt, l.Sel) }
+			store = func(src asm.VarName) error { return c.handleProgramVarSet(uasm, ptrVarName, bt, l.Sel, src) }
+			break
+		}
+		st, ok := c.StructOf(ptrVarName)
+		if !ok {
+			load = func() (asm.VarName, error) { return c.handlePropertyGet(uasm, ptrVarName, l.Sel) }
+			store = func(src asm.VarName) error { return c.handlePropertySet(uasm, ptrVarName, l.Sel, src) }
+			break
+		}
+		load = func() (asm.VarName, error) { return c.handleFieldGet(uasm, out, ptrVarName, st, l.Sel.Name) }
+		store = func(src asm.VarName) error { return c.handleFieldSet(uasm, out, ptrVarName, st, l.Sel.Name, src) }
+	case *ast.IndexExpr:
+		mapVarName, err := c.handleExpr(uasm, out, l.X)
+		if err != nil {
+			return err
+		}
+		keyVarName, err := c.handleExpr(uasm, out, l.Index)
+		if err != nil {
+			return err
+		}
+		load = func() (asm.VarName, error) {
+			valueVarName, _, err := c.handleMapIndex(uasm, mapVarName, keyVarName)
+			return valueVarName, err
+		}
+		store = func(src asm.VarName) error { return c.handleMapSet(uasm, mapVarName, keyVarName, src) }
+	default:
+		return fmt.Errorf("assign: unsupported lhs: %T", lhs)
+	}
+
+	curVarName, err := load()
+	if err != nil {
+		return err
+	}
+	curType, err := uasm.VarTable.GetVarType(curVarName)
+	if err != nil {
+		return err
+	}
+	var rhsVarName asm.VarName
+	if rhs == nil {
+		rhsVarName, err = c.typedConst(uasm, "1", curType)
+	} else {
+		rhsVarName, err = c.handleExpr(uasm, out, rhs)
+	}
+	if err != nil {
+		return err
+	}
+	retVarName, err := c.binaryOp(uasm, op, curVarName, rhsVarName)
+	if err != nil {
+		return err
+	}
+	retType, err := uasm.VarTable.GetVarType(retVarName)
+	if err != nil {
+		return err
+	}
+	if retType != curType {
+		retVarName, err = c.convert(uasm, retVarName, curType)
+		if err != nil {
+			return err
+		}
+	}
+	return store(retVarName)
+}
+
+// handleLogicalExpr compiles a && b and a || b in value position, b is only evaluated when a does not decide the result
+func (c *Compiler) handleLogicalExpr(uasm *asm.UdonAssembly, out io.Writer, be *ast.BinaryExpr) (asm.VarName, error) {
+	resultVarName := uasm.GetNextId("cond")
+	err := uasm.VarTable.AddVar(resultVarName, asm.UdonTypeBoolean, "false")
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	endLabel := asm.LabelName(uasm.GetNextId("cond_end"))
+	xVarName, err := c.boolOperand(uasm, out, be.X)
+	if err != nil {
+		return "", err
+	}
+	uasm.Assign(resultVarName, xVarName)
+	uasm.PushVar(resultVarName)
+	if be.Op == token.LAND {
+		// false && b is false
+		uasm.JumpIfFalseLabel(endLabel)
+	} else {
+		// true || b is true
+		rightLabel := asm.LabelName(uasm.GetNextId("cond_right"))
+		uasm.JumpIfFalseLabel(rightLabel)
+		uasm.JumpLabel(endLabel)
+		uasm.AddLabelCurrentAddr(rightLabel)
+	}
+	yVarName, err := c.boolOperand(uasm, out, be.Y)
+	if err != nil {
+		return "", err
+	}
+	uasm.Assign(resultVarName, yVarName)
+	uasm.AddLabelCurrentAddr(endLabel)
+	return resultVarName, nil
+}
+
+func (c *Compiler) boolOperand(uasm *asm.UdonAssembly, out io.Writer, e ast.Expr) (asm.VarName, error) {
+	varName, err := c.handleExpr(uasm, out, e)
+	if err != nil {
+		return "", err
+	}
+	typeName, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return "", err
+	}
+	if typeName != asm.UdonTypeBoolean {
+		return "", fmt.Errorf("non-boolean condition: %s", typeName)
+	}
+	return varName, nil
+}
+
+// handleCond compiles a condition that jumps to falseLabel when it does not hold.
+// && and || become branches, so the right operand is skipped as soon as the result is known.
+func (c *Compiler) handleCond(uasm *asm.UdonAssembly, out io.Writer, cond ast.Expr, falseLabel asm.LabelName) error {
+	switch e := cond.(type) {
+	case *ast.ParenExpr:
+		return c.handleCond(uasm, out, e.X, falseLabel)
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.LAND:
+			err := c.handleCond(uasm, out, e.X, falseLabel)
+			if err != nil {
+				return err
+			}
+			return c.handleCond(uasm, out, e.Y, falseLabel)
+		case token.LOR:
+			rightLabel := asm.LabelName(uasm.GetNextId("cond_right"))
+			trueLabel := asm.LabelName(uasm.GetNextId("cond_true"))
+			err := c.handleCond(uasm, out, e.X, rightLabel)
+			if err != nil {
+				return err
+			}
+			uasm.JumpLabel(trueLabel)
+			uasm.AddLabelCurrentAddr(rightLabel)
+			err = c.handleCond(uasm, out, e.Y, falseLabel)
+			if err != nil {
+				return err
+			}
+			uasm.AddLabelCurrentAddr(trueLabel)
+			return nil
+		}
+	}
+	condVarName, err := c.boolOperand(uasm, out, cond)
+	if err != nil {
+		return err
+	}
+	uasm.PushVar(condVarName)
+	uasm.JumpIfFalseLabel(falseLabel)
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"io"
+	"udon-go/asm"
+)
+
+// property looks up the property sel of the udon value varName in the method table
+func (c *Compiler) property(uasm *asm.UdonAssembly, varName asm.VarName, sel *ast.Ident) (*asm.Property, error) {
+	typeName, err := uasm.VarTable.GetVarType(varName)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := uasm.MethodTable.Property(typeName, sel.Name)
+	if !ok {
+		return nil, c.errorf(sel.Pos(), "%s has no field or property %s", typeName, sel.Name)
+	}
+	return p, nil
+}
+
+// handlePropertyGet reads a property of the udon value varName with its get_ extern, e.g. transform.position
+func (c *Compiler) handlePropertyGet(uasm *asm.UdonAssembly, varName asm.VarName, sel *ast.Ident) (asm.VarName, error) {
+	p, err := c.property(uasm, varName, sel)
+	if err != nil {
+		return "", err
+	}
+	if p.Get == nil {
+		return "", c.errorf(sel.Pos(), "property %s of %s cannot be read", sel.Name, p.Set.Module)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.%s", varName, sel.Name))
+	return c.emitExtern(uasm, p.Get.Extern, p.Type, []asm.VarName{varName})
+}
+
+// handlePropertySet writes valueVarName to a property of the udon value varName with its set_ extern, e.g. transform.position = v
+func (c *Compiler) handlePropertySet(uasm *asm.UdonAssembly, varName asm.VarName, sel *ast.Ident, valueVarName asm.VarName) error {
+	p, err := c.property(uasm, varName, sel)
+	if err != nil {
+		return err
+	}
+	if p.Set == nil {
+		return c.errorf(sel.Pos(), "property %s of %s cannot be written", sel.Name, p.Get.Module)
+	}
+	valueVarName, err = c.untypedAs(uasm, valueVarName, p.Type)
+	if err != nil {
+		return c.errorf(sel.Pos(), "%v", err)
+	}
+	valueType, err := uasm.VarTable.GetVarType(valueVarName)
+	if err != nil {
+		return err
+	}
+	if valueType != p.Type {
+		return c.errorf(sel.Pos(), "cannot use %s as %s in assignment to property %s", valueType, p.Type, sel.Name)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.%s = %s", varName, sel.Name, valueVarName))
+	_, err = c.emitExtern(uasm, p.Set.Extern, asm.UdonTypeVoid, []asm.VarName{varName, valueVarName})
+	return err
+}
+
+// writeBack stores varName, read from x and then changed by a property setter, back into x.
+// The getters of properties return copies of values such as vectors, so T.position.x = 2.5 sets x on a copy
+// which goes back with set_position. Properties without a setter are left alone, like the transform of a game object
+// they return references which the setter changed in place.
+func (c *Compiler) writeBack(uasm *asm.UdonAssembly, out io.Writer, x ast.Expr, varName asm.VarName) error {
+	sel, ok := x.(*ast.SelectorExpr)
+	if !ok {
+		// variables are changed in place
+		return nil
+	}
+	if _, ok, _ := c.importedVar(sel, false); ok {
+		return nil
+	}
+	ptrVarName, err := c.handleExpr(uasm, out, sel.X)
+	if err != nil {
+		return err
+	}
+	_, isBehaviour := c.BehaviourOf(ptrVarName)
+	_, isStruct := c.StructOf(ptrVarName)
+	if !isBehaviour && !isStruct {
+		p, err := c.property(uasm, ptrVarName, sel.Sel)
+		if err != nil {
+			return err
+		}
+		if p.Set == nil {
+			return nil
+		}
+	}
+	return c.assignSelector(uasm, out, sel, ptrVarName, varName)
+}
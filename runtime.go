@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"strconv"
+	"udon-go/asm"
+)
+
+// routine emits a runtime function written directly against the assembler.
+// Runtime functions follow the CallDefFunc calling convention, so compiled code calls them like user functions.
+// The first error is kept and every later emit becomes a no-op, check it with end.
+type routine struct {
+	c    *Compiler
+	uasm *asm.UdonAssembly
+	err  error
+}
+
+// RuntimeFunc is the signature of a runtime function
+type RuntimeFunc struct {
+	Name     asm.FuncName
+	ArgNames []asm.VarName
+	ArgTypes []asm.UdonTypeName
+	RetType  asm.UdonTypeName
+}
+
+// register adds the runtime function to the function table so compiled code can call it
+func (f RuntimeFunc) register(uasm *asm.UdonAssembly) {
+	uasm.FuncTable.Put(f.Name, f.ArgTypes, f.RetType, f.ArgNames)
+}
+
+// beginRoutine emits the prologue of f and returns the variables holding its arguments
+func (c *Compiler) beginRoutine(uasm *asm.UdonAssembly, f RuntimeFunc) (*routine, []asm.VarName) {
+	r := &routine{c: c, uasm: uasm}
+	funcLabel := uasm.FuncTable.GetFunctionID(f.Name, f.ArgTypes)
+	uasm.VarTable.SetCurrentFuncID(&funcLabel)
+	uasm.AddLabelCurrentAddr(funcLabel)
+	argNames := []asm.VarName{}
+	for i, argName := range f.ArgNames {
+		argNames = append(argNames, r.local(string(argName), f.ArgTypes[i]))
+	}
+	if r.err != nil {
+		return r, argNames
+	}
+	r.fail(uasm.PopVars(argNames))
+	r.fail(uasm.PopVar(asm.VarName("ret_addr")))
+	return r, argNames
+}
+
+// end finishes the routine, returning the first error encountered while emitting it
+func (r *routine) end() error {
+	r.uasm.VarTable.SetCurrentFuncID(nil)
+	return r.err
+}
+
+func (r *routine) fail(err error) {
+	if r.err == nil && err != nil {
+		r.err = err
+	}
+}
+
+// local declares a variable of the routine
+func (r *routine) local(name string, typeName asm.UdonTypeName) asm.VarName {
+	varName := asm.VarName(fmt.Sprintf("%s_%s", *r.uasm.VarTable.CurrentFuncID, name))
+	if _, ok := r.uasm.VarTable.Find(varName); !ok {
+		r.fail(r.uasm.VarTable.AddVar(varName, typeName, "null"))
+	}
+	return varName
+}
+
+func (r *routine) constInt(n int) asm.VarName {
+	constNextID := r.uasm.GetNextId("const")
+	r.fail(r.uasm.VarTable.AddVar(constNextID, asm.UdonTypeInt32, strconv.Itoa(n)))
+	return constNextID
+}
+
+func (r *routine) constNil() asm.VarName {
+	constNextID := r.uasm.GetNextId("const_nil")
+	r.fail(r.uasm.VarTable.AddVar(constNextID, asm.UdonTypeObject, "null"))
+	return constNextID
+}
+
+func (r *routine) extern(methodKind asm.UdonMethodKind, moduleType asm.UdonTypeName, methodName asm.UdonMethodName, instance asm.VarName, args ...asm.VarName) asm.VarName {
+	if r.err != nil {
+		return ""
+	}
+	retVarName, err := r.c.callExtern(r.uasm, methodKind, moduleType, methodName, instance, args)
+	r.fail(err)
+	return retVarName
+}
+
+func (r *routine) op(op token.Token, x asm.VarName, y asm.VarName) asm.VarName {
+	if r.err != nil {
+		return ""
+	}
+	retVarName, err := r.c.binaryOp(r.uasm, op, x, y)
+	r.fail(err)
+	return retVarName
+}
+
+// get loads slot index of an object array and casts it to typeName
+func (r *routine) get(array asm.VarName, index asm.VarName, typeName asm.UdonTypeName) asm.VarName {
+	obj := r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", array, index)
+	if r.err != nil {
+		return ""
+	}
+	value, err := r.c.castFromObject(r.uasm, obj, typeName)
+	r.fail(err)
+	return value
+}
+
+// set stores value in slot index of an object array
+func (r *routine) set(array asm.VarName, index asm.VarName, value asm.VarName) {
+	if r.err != nil {
+		return
+	}
+	obj, err := r.c.toObject(r.uasm, value)
+	r.fail(err)
+	r.extern(asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Set", array, index, obj)
+}
+
+func (r *routine) assign(dst asm.VarName, src asm.VarName) {
+	if r.err != nil {
+		return
+	}
+	r.fail(r.uasm.Assign(dst, src))
+}
+
+func (r *routine) call(funcName asm.FuncName, args ...asm.VarName) asm.VarName {
+	if r.err != nil {
+		return ""
+	}
+	retVarName, err := r.uasm.CallDefFunc(funcName, args)
+	r.fail(err)
+	if retVarName == nil {
+		return ""
+	}
+	return *retVarName
+}
+
+func (r *routine) newLabel(name string) asm.LabelName {
+	return asm.LabelName(r.uasm.GetNextId(name))
+}
+
+func (r *routine) mark(label asm.LabelName) {
+	r.uasm.AddLabelCurrentAddr(label)
+}
+
+func (r *routine) jump(label asm.LabelName) {
+	r.uasm.JumpLabel(label)
+}
+
+// jumpIfFalse jumps to label unless the boolean cond is true
+func (r *routine) jumpIfFalse(cond asm.VarName, label asm.LabelName) {
+	if r.err != nil {
+		return
+	}
+	r.uasm.PushVar(cond)
+	r.uasm.JumpIfFalseLabel(label)
+}
+
+// ret returns from the routine, with value unless it is empty
+func (r *routine) ret(value asm.VarName) {
+	if value != "" {
+		r.uasm.PushVar(value)
+	}
+	r.uasm.JumpRetAddr()
+}
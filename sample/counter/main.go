@@ -0,0 +1,26 @@
+package main
+
+import (
+	"udon-go/asm"
+	"udon-go/sample/lib"
+)
+
+var counter *lib.Counter
+
+// Presses is exported to the inspector and synced to every player
+//
+//udon:sync
+var Presses int
+
+func main() {
+	counter = &lib.Counter{Max: 10}
+	lib.Add(counter, 7)
+	lib.Add(counter, 7)
+	asm.Log(lib.Label("counter", counter.Value))
+	asm.Log(lib.Label("clamped", lib.Calls))
+}
+
+func _interact() {
+	Presses++
+	asm.Log(lib.Label("presses", Presses))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"udon-go/asm"
+	"udon-go/sample/lib"
+)
+
+// Counter is the counter behaviour, as far as the display uses it
+//
+//udon:behaviour udon-go/sample/counter
+type Counter struct {
+	Presses int
+}
+
+func (*Counter) _interact() {}
+
+// Source is set in the inspector to the counter to display
+var Source *Counter
+
+func main() {
+	asm.Log("display ready")
+}
+
+func _interact() {
+	Source._interact()
+	asm.Log(lib.Label("counter presses", Source.Presses))
+}
+
+func _update() {
+	if Source.Presses > 100 {
+		Source.Presses = 0
+	}
+}
@@ -30,12 +30,15 @@
 
 package main
 
-import "udon-go/asm"
+import (
+	"strconv"
+	"udon-go/asm"
+)
 
 func main() {
-	a := func1(100, 1000) // 1200
-	b := func2(a, 10)     // 120
-	asm.Log(string(b))    // output 120
+	a := func1(100, 1000)    // 1200
+	b := func2(a, 10)        // 120
+	asm.Log(strconv.Itoa(b)) // output 120
 }
 
 func func1(x1 int, y1 int) int {
@@ -0,0 +1,35 @@
+// Package lib holds helper functions shared by the sample behaviours
+package lib
+
+import "strconv"
+
+// Calls counts the calls to Clamp
+var Calls int
+
+// Clamp limits n to [lo, hi]
+func Clamp(n int, lo int, hi int) int {
+	Calls++
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
+
+// Label formats a named number
+func Label(name string, n int) string {
+	return name + ": " + strconv.Itoa(n)
+}
+
+// Counter is a bounded counter
+type Counter struct {
+	Value int
+	Max   int
+}
+
+// Add adds n to the counter without going over its maximum
+func Add(c *Counter, n int) {
+	c.Value = Clamp(c.Value+n, 0, c.Max)
+}
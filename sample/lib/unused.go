@@ -0,0 +1,6 @@
+package lib
+
+// Sum is not called by any sample, so it is not linked into them
+func Sum(a int, b int) int {
+	return a + b
+}
@@ -0,0 +1,25 @@
+package main
+
+import "udon-go/asm"
+
+// Node is stored in a SystemObjectArray, one slot per field
+type Node struct {
+	Name string
+	Next *Node
+}
+
+func push(head *Node, name string) *Node {
+	return &Node{Name: name, Next: head}
+}
+
+func main() {
+	var head *Node
+	head = push(head, "first")
+	head = push(head, "second")
+	next := head.Next
+	if next != nil {
+		asm.Log(next.Name) // output first
+	} else {
+		asm.Log("empty")
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "udon-go/asm"
+
+// maps are hash tables managed by the runtime functions emitted with the program
+func count(words map[string]int, word string) {
+	n := words[word]
+	words[word] = n + 1
+}
+
+func main() {
+	words := make(map[string]int)
+	count(words, "udon")
+	count(words, "go")
+	count(words, "udon")
+	delete(words, "go")
+	if n, ok := words["udon"]; ok && n == 2 {
+		asm.Log("udon twice")
+	}
+	for word := range words {
+		asm.Log(word) // output udon
+	}
+	if len(words) == 1 {
+		asm.Log("one word")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strconv"
+	"time"
+	"udon-go/asm"
+	"udon-go/udon"
+)
+
+var ticks int
+
+func tick() {
+	ticks++
+	asm.Log("tick " + strconv.Itoa(ticks))
+	udon.After(time.Second, tick)
+}
+
+func greet(name string) {
+	asm.Log("hello " + name)
+}
+
+func main() {
+	name := "world"
+	go greet(name)
+	delay := 500 * time.Millisecond
+	udon.After(delay, func() {
+		asm.Log("half a second later, " + name)
+		udon.NextFrame(func() {
+			asm.Log("and one more frame, " + name)
+		})
+	})
+	tick()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"udon-go/asm"
+)
+
+// ScopeKind is the kind of declaration a scope belongs to
+type ScopeKind int
+
+const (
+	// PackageScope holds the package variables, stored under their own name
+	PackageScope ScopeKind = iota
+	// FuncScope holds the parameters and top level variables of a function, stored as <func>_<name>
+	FuncScope
+	// BlockScope holds the variables of a nested block, stored as <func>_<name>_<n>
+	BlockScope
+)
+
+// Scope maps the go identifiers declared in a package, function or block to their heap variables.
+// Heap variables are program wide, so every declaration gets a unique heap name
+// and shadowed variables keep their own slot.
+type Scope struct {
+	Parent *Scope
+	Kind   ScopeKind
+	Vars   map[string]*ScopeVar
+}
+
+// ScopeVar is a declared variable
+type ScopeVar struct {
+	Name    string
+	VarName asm.VarName
+	Pos     token.Pos
+	// Used is set once the variable is read, assigning to it does not count
+	Used bool
+	// Captured variables are the copies read by a delayed call, which can not be assigned to
+	Captured bool
+	// Scheduled is where a delayed call first captures the variable, it can not be assigned to afterwards
+	// as the call keeps the value it has when it is scheduled
+	Scheduled token.Pos
+	// Assigned is where the variable was last assigned to
+	Assigned token.Pos
+}
+
+// Lookup finds the variable called name in the innermost scope declaring it
+func (s *Scope) Lookup(name string) (*ScopeVar, bool) {
+	for ; s != nil; s = s.Parent {
+		if v, ok := s.Vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// lookupLocal finds the variable called name if a function or block scope declares it
+func (s *Scope) lookupLocal(name string) (*ScopeVar, bool) {
+	for ; s != nil && s.Kind != PackageScope; s = s.Parent {
+		if v, ok := s.Vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// errorf returns an error prefixed with the source position of pos
+func (c *Compiler) errorf(pos token.Pos, format string, args ...interface{}) error {
+	if c.Fset == nil || !pos.IsValid() {
+		return fmt.Errorf(format, args...)
+	}
+	return &PosError{Pos: c.Fset.Position(pos), Err: fmt.Errorf(format, args...)}
+}
+
+// at gives err the source position of pos, unless it already has one
+func (c *Compiler) at(pos token.Pos, err error) error {
+	var posErr *PosError
+	if err == nil || errors.As(err, &posErr) {
+		return err
+	}
+	return c.errorf(pos, "%w", err)
+}
+
+func (c *Compiler) pushScope(kind ScopeKind) {
+	c.Scope = &Scope{Parent: c.Scope, Kind: kind, Vars: map[string]*ScopeVar{}}
+}
+
+// popScope leaves the current scope, reporting the first of its variables which was never used
+func (c *Compiler) popScope() error {
+	s := c.Scope
+	c.Scope = s.Parent
+	unused := []*ScopeVar{}
+	for _, v := range s.Vars {
+		if !v.Used {
+			unused = append(unused, v)
+		}
+	}
+	if len(unused) == 0 {
+		return nil
+	}
+	sort.Slice(unused, func(i, j int) bool { return unused[i].Pos < unused[j].Pos })
+	return c.errorf(unused[0].Pos, "declared and not used: %s", unused[0].Name)
+}
+
+// declare adds ident to the current scope and returns its heap variable
+func (c *Compiler) declare(uasm *asm.UdonAssembly, ident *ast.Ident) (asm.VarName, error) {
+	if _, ok := c.Scope.Vars[ident.Name]; ok {
+		return "", c.errorf(ident.Pos(), "%s redeclared in this block", ident.Name)
+	}
+	varName := asm.VarName(c.qualify(ident.Name))
+	if c.Scope.Kind != PackageScope {
+		if uasm.VarTable.CurrentFuncID == nil {
+			return "", c.errorf(ident.Pos(), "declare %s: no current function", ident.Name)
+		}
+		varName = asm.VarName(fmt.Sprintf("%s_%s", *uasm.VarTable.CurrentFuncID, ident.Name))
+	}
+	if c.Scope.Kind == BlockScope || c.heapNameTaken(uasm, varName) {
+		// number the variable until its name is free, a shadowed x and a variable called x_1 must not share a slot
+		base := varName
+		for {
+			c.ScopedVarCount++
+			varName = asm.VarName(fmt.Sprintf("%s_%d", base, c.ScopedVarCount))
+			if !c.heapNameTaken(uasm, varName) {
+				break
+			}
+		}
+	}
+	c.HeapNames[varName] = true
+	c.VarIdents[varName] = ident.Name
+	c.Scope.Vars[ident.Name] = &ScopeVar{Name: ident.Name, VarName: varName, Pos: ident.Pos()}
+	return varName, nil
+}
+
+func (c *Compiler) heapNameTaken(uasm *asm.UdonAssembly, varName asm.VarName) bool {
+	if c.HeapNames[varName] {
+		return true
+	}
+	_, ok := uasm.VarTable.Find(varName)
+	return ok
+}
+
+// lookupVar resolves ident to its heap variable, marking it used when the value is read
+func (c *Compiler) lookupVar(ident *ast.Ident, use bool) (asm.VarName, error) {
+	v, ok := c.Scope.Lookup(ident.Name)
+	if !ok {
+		return "", c.errorf(ident.Pos(), "undefined: %s", ident.Name)
+	}
+	if use {
+		v.Used = true
+	} else if v.Captured {
+		return "", c.errorf(ident.Pos(), "cannot assign to %s, functions run later get a copy of the variables they capture", ident.Name)
+	} else if v.Scheduled.IsValid() {
+		return "", c.errorf(ident.Pos(), "cannot assign to %s after a function run later captures it, the function gets a copy of it", ident.Name)
+	} else {
+		v.Assigned = ident.Pos()
+	}
+	return v.VarName, nil
+}
+
+// checkLoopCaptures rejects the assignments in loop to the variables declared outside of it
+// which a function scheduled in loop captures, the function would not see the next iterations assign them
+func (c *Compiler) checkLoopCaptures(loop ast.Node) error {
+	in := func(pos token.Pos) bool {
+		return loop.Pos() <= pos && pos < loop.End()
+	}
+	var first *ScopeVar
+	for s := c.Scope; s != nil && s.Kind != PackageScope; s = s.Parent {
+		for _, v := range s.Vars {
+			if in(v.Scheduled) && in(v.Assigned) && (first == nil || v.Assigned < first.Assigned) {
+				first = v
+			}
+		}
+	}
+	if first != nil {
+		return c.errorf(first.Assigned, "cannot assign to %s in a loop running a function later which captures it, the function gets a copy of it", first.Name)
+	}
+	return nil
+}
@@ -0,0 +1,867 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"udon-go/asm"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// The backends compiling the functions of a program
+const (
+	// BackendAST compiles the syntax tree of every function, it supports the whole language of udon-go
+	BackendAST = "ast"
+	// BackendSSA lowers the go/ssa form of the functions, whose control flow is a graph of basic blocks.
+	// It supports value types, package variables, functions with any number of results and any control flow between them.
+	BackendSSA = "ssa"
+)
+
+// linkSSA compiles prog with the ssa backend
+func (uc *UdonCompiler) linkSSA(prog *Program) (string, *Compiler, error) {
+	c := NewCompiler()
+	c.Fset = prog.Fset
+	c.enterPackage(prog.Main())
+	pkgs, err := buildSSA(prog)
+	if err != nil {
+		return "", nil, err
+	}
+	err = addInitVars(uc.UASM)
+	if err != nil {
+		return "", nil, err
+	}
+	l := &ssaLowerer{
+		c:       c,
+		uasm:    uc.UASM,
+		pkgs:    pkgs,
+		globals: map[*ssa.Global]asm.VarName{},
+		funcs:   map[*ssa.Function]asm.FuncName{},
+		events:  map[*ssa.Function]asm.EventName{},
+		results: map[*ssa.Function][]asm.VarName{},
+		consts:  map[string]asm.VarName{},
+	}
+	err = l.declare(pkgs[prog.Main()])
+	if err != nil {
+		return "", nil, err
+	}
+	err = l.lower()
+	if err != nil {
+		return "", nil, err
+	}
+	code, err := uc.assemble()
+	if err != nil {
+		return "", nil, err
+	}
+	return code, c, nil
+}
+
+// buildSSA type checks the packages of prog and builds their ssa form
+func buildSSA(prog *Program) (map[*Package]*ssa.Package, error) {
+	checked, infos, err := typeCheck(prog, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sprog := ssa.NewProgram(prog.Fset, 0)
+	created := map[*types.Package]bool{}
+	for _, tpkg := range checked {
+		created[tpkg] = true
+	}
+	var createImports func(tpkg *types.Package)
+	createImports = func(tpkg *types.Package) {
+		for _, imported := range tpkg.Imports() {
+			if !created[imported] {
+				created[imported] = true
+				createImports(imported)
+				sprog.CreatePackage(imported, nil, nil, true)
+			}
+		}
+	}
+	pkgs := map[*Package]*ssa.Package{}
+	for _, pkg := range prog.Packages {
+		createImports(checked[pkg])
+		pkgs[pkg] = sprog.CreatePackage(checked[pkg], pkg.Files, infos[pkg], false)
+	}
+	sprog.Build()
+	return pkgs, nil
+}
+
+// typeCheck type checks the packages of prog, other imports are type checked from source without their function bodies.
+// Every error is passed to onError if it is not nil, the first one is returned.
+func typeCheck(prog *Program, onError func(error)) (map[*Package]*types.Package, map[*Package]*types.Info, error) {
+	byPath := map[string]*Package{}
+	for _, pkg := range prog.Packages {
+		byPath[ssaPath(pkg)] = pkg
+	}
+	fallback := importer.ForCompiler(prog.Fset, "source", nil)
+	checked := map[*Package]*types.Package{}
+	infos := map[*Package]*types.Info{}
+	var check func(pkg *Package) (*types.Package, error)
+	conf := &types.Config{Importer: importerFunc(func(path string) (*types.Package, error) {
+		if pkg, ok := byPath[path]; ok {
+			return check(pkg)
+		}
+		return fallback.Import(path)
+	}), Error: onError}
+	check = func(pkg *Package) (*types.Package, error) {
+		if tpkg, ok := checked[pkg]; ok {
+			return tpkg, nil
+		}
+		info := &types.Info{
+			Types:      map[ast.Expr]types.TypeAndValue{},
+			Defs:       map[*ast.Ident]types.Object{},
+			Uses:       map[*ast.Ident]types.Object{},
+			Implicits:  map[ast.Node]types.Object{},
+			Scopes:     map[ast.Node]*types.Scope{},
+			Selections: map[*ast.SelectorExpr]*types.Selection{},
+		}
+		tpkg, err := conf.Check(ssaPath(pkg), prog.Fset, pkg.Files, info)
+		if err != nil {
+			return nil, fmt.Errorf("type check %s: %w", ssaPath(pkg), err)
+		}
+		checked[pkg], infos[pkg] = tpkg, info
+		return tpkg, nil
+	}
+	for _, pkg := range prog.Packages {
+		_, err := check(pkg)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return checked, infos, nil
+}
+
+// ssaPath is the import path pkg is type checked as
+func ssaPath(pkg *Package) string {
+	if pkg.Path == "" {
+		return "main"
+	}
+	return pkg.Path
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
+
+// ssaLowerer emits the udon code of ssa functions
+type ssaLowerer struct {
+	c    *Compiler
+	uasm *asm.UdonAssembly
+	pkgs map[*Package]*ssa.Package
+	// globals are the heap variables of the package variables
+	globals map[*ssa.Global]asm.VarName
+	// funcs are the functions of the program called with CallDefFunc, events are not called
+	funcs  map[*ssa.Function]asm.FuncName
+	events map[*ssa.Function]asm.EventName
+	// results are the heap variables the functions with multiple results return them in
+	results map[*ssa.Function][]asm.VarName
+	// init is the package initializer of the behaviour, run at the start of _start
+	init *ssa.Function
+	// consts are the constants declared so far, by type and value
+	consts map[string]asm.VarName
+
+	// the function being lowered
+	fn     *ssa.Function
+	funcID asm.LabelName
+	values map[ssa.Value]asm.VarName
+	// tuples are the results of the calls returning multiple values, copied out of the results of the callee
+	tuples map[ssa.Value][]asm.VarName
+	// arrays are the backing arrays of variadic arguments, whose elements are only kept track of
+	arrays map[ssa.Value][]ssa.Value
+}
+
+// ssaName turns the name of a package member into a heap variable or label name, init$guard becomes init_guard
+func ssaName(pkg *Package, name string) string {
+	return pkg.qualify(strings.Replace(name, "$", "_", -1))
+}
+
+// udonType returns the udon type of a go type of the ssa backend
+func udonType(t types.Type) (asm.UdonTypeName, error) {
+	basic, ok := t.Underlying().(*types.Basic)
+	if !ok || basic.Info()&types.IsUntyped != 0 {
+		return "", fmt.Errorf("type %s is not supported by the ssa backend", t)
+	}
+	return IdentToUnity(ast.NewIdent(basic.Name()))
+}
+
+// declare registers the package variables, functions and events of the packages
+func (l *ssaLowerer) declare(main *ssa.Package) error {
+	pkgs := []*Package{}
+	for pkg := range l.pkgs {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return ssaPath(pkgs[i]) < ssaPath(pkgs[j]) })
+	for _, pkg := range pkgs {
+		spkg := l.pkgs[pkg]
+		names := []string{}
+		for name := range spkg.Members {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch member := spkg.Members[name].(type) {
+			case *ssa.Global:
+				typeName, err := udonType(member.Type().(*types.Pointer).Elem())
+				if err != nil {
+					return l.c.errorf(member.Pos(), "%s: %v", name, err)
+				}
+				varName := asm.VarName(ssaName(pkg, name))
+				err = l.uasm.VarTable.AddVar(varName, typeName, zeroLiteral(typeName))
+				if err != nil {
+					return fmt.Errorf("add var: %w", err)
+				}
+				if spkg == main && ast.IsExported(name) {
+					l.uasm.VarTable.AddVarGlobal(varName)
+				}
+				l.globals[member] = varName
+			case *ssa.Function:
+				if member.Blocks == nil {
+					continue
+				}
+				if eventName, ok := EventName(&ast.FuncDecl{Name: ast.NewIdent(name)}); ok && spkg == main {
+					if member.Signature.Params().Len() > 0 {
+						return l.c.errorf(member.Pos(), "%s: events with arguments are not supported by the ssa backend", name)
+					}
+					l.events[member] = eventName
+					continue
+				}
+				err := l.declareFunc(pkg, member)
+				if err != nil {
+					return err
+				}
+				if spkg == main && name == "init" {
+					l.init = member
+				}
+			}
+		}
+	}
+	if _, ok := l.eventNamed("_start"); !ok && l.init != nil {
+		// package variables are initialized at the start, even if the behaviour does not handle it
+		l.events[nil] = "_start"
+	}
+	for _, eventName := range l.sortedEvents() {
+		err := l.uasm.AddEvent(eventName, nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventNamed returns the function handling an event
+func (l *ssaLowerer) eventNamed(eventName asm.EventName) (*ssa.Function, bool) {
+	for fn, name := range l.events {
+		if name == eventName {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+// sortedEvents returns the events in the order they are declared, _start first
+func (l *ssaLowerer) sortedEvents() []asm.EventName {
+	eventNames := []asm.EventName{}
+	for _, eventName := range l.events {
+		eventNames = append(eventNames, eventName)
+	}
+	sort.Slice(eventNames, func(i, j int) bool {
+		if (eventNames[i] == "_start") != (eventNames[j] == "_start") {
+			return eventNames[i] == "_start"
+		}
+		return eventNames[i] < eventNames[j]
+	})
+	return eventNames
+}
+
+// declareFunc registers a function called with CallDefFunc
+func (l *ssaLowerer) declareFunc(pkg *Package, fn *ssa.Function) error {
+	funcName := asm.FuncName(ssaName(pkg, fn.Name()))
+	argTypes := []asm.UdonTypeName{}
+	for _, param := range fn.Params {
+		typeName, err := udonType(param.Type())
+		if err != nil {
+			return l.c.errorf(param.Pos(), "%s: %v", fn.Name(), err)
+		}
+		argTypes = append(argTypes, typeName)
+	}
+	funcID := l.uasm.FuncTable.GetFunctionID(funcName, argTypes)
+	retType := asm.GoNil
+	results := fn.Signature.Results()
+	for i := 0; i < results.Len(); i++ {
+		typeName, err := udonType(results.At(i).Type())
+		if err != nil {
+			return l.c.errorf(fn.Pos(), "%s: %v", fn.Name(), err)
+		}
+		if results.Len() == 1 {
+			retType = typeName
+			break
+		}
+		// the stack only holds a single return value, the others are left on the heap
+		varName := asm.VarName(fmt.Sprintf("%s_result%d", funcID, i))
+		err = l.uasm.VarTable.AddVar(varName, typeName, zeroLiteral(typeName))
+		if err != nil {
+			return fmt.Errorf("add var: %w", err)
+		}
+		l.results[fn] = append(l.results[fn], varName)
+	}
+	argNames := []asm.VarName{}
+	for _, param := range fn.Params {
+		argNames = append(argNames, asm.VarName(fmt.Sprintf("%s_%s", funcID, param.Name())))
+	}
+	l.uasm.FuncTable.Put(funcName, argTypes, retType, argNames)
+	l.funcs[fn] = funcName
+	// the ssa function does not keep its syntax, the declaration is looked up to find recursive calls
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			if decl, ok := decl.(*ast.FuncDecl); ok && decl.Name.Pos() == fn.Pos() {
+				l.c.Funcs[funcName] = &FuncDecl{Decl: decl, Pkg: pkg}
+			}
+		}
+	}
+	return nil
+}
+
+// lower emits the events, then the functions they reach
+func (l *ssaLowerer) lower() error {
+	for _, eventName := range l.sortedEvents() {
+		fn, _ := l.eventNamed(eventName)
+		label := asm.LabelName(eventName)
+		l.uasm.VarTable.SetCurrentFuncID(&label)
+		l.uasm.EventHead(eventName)
+		if eventName == "_start" && l.init != nil {
+			_, err := l.uasm.CallDefFunc(l.funcs[l.init], nil)
+			if err != nil {
+				return err
+			}
+		}
+		if fn == nil {
+			l.uasm.End()
+		} else {
+			err := l.lowerFunc(fn, label)
+			if err != nil {
+				return err
+			}
+		}
+		l.uasm.VarTable.SetCurrentFuncID(nil)
+	}
+	for _, fn := range l.reachableFuncs() {
+		funcName := l.funcs[fn]
+		argTypes := []asm.UdonTypeName{}
+		for _, param := range fn.Params {
+			typeName, _ := udonType(param.Type())
+			argTypes = append(argTypes, typeName)
+		}
+		label := l.uasm.FuncTable.GetFunctionID(funcName, argTypes)
+		l.uasm.AddLabelCurrentAddr(label)
+		l.uasm.VarTable.SetCurrentFuncID(&label)
+		err := l.lowerFunc(fn, label)
+		if err != nil {
+			return err
+		}
+		l.uasm.VarTable.SetCurrentFuncID(nil)
+	}
+	return nil
+}
+
+// reachableFuncs returns the functions called from the events, directly or not, in the order they are declared
+func (l *ssaLowerer) reachableFuncs() []*ssa.Function {
+	reached := map[*ssa.Function]bool{}
+	pending := []*ssa.Function{}
+	for fn := range l.events {
+		if fn != nil {
+			pending = append(pending, fn)
+		}
+	}
+	if l.init != nil {
+		reached[l.init] = true
+		pending = append(pending, l.init)
+	}
+	for len(pending) > 0 {
+		fn := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				call, ok := instr.(ssa.CallInstruction)
+				if !ok {
+					continue
+				}
+				callee := call.Common().StaticCallee()
+				if _, ok := l.funcs[callee]; ok && !reached[callee] {
+					reached[callee] = true
+					pending = append(pending, callee)
+				}
+			}
+		}
+	}
+	fns := []*ssa.Function{}
+	for fn := range reached {
+		fns = append(fns, fn)
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		if fns[i].Pos() != fns[j].Pos() {
+			return fns[i].Pos() < fns[j].Pos()
+		}
+		return l.funcs[fns[i]] < l.funcs[fns[j]]
+	})
+	return fns
+}
+
+// lowerFunc emits the body of fn, whose parameters are popped from the stack unless it is an event
+func (l *ssaLowerer) lowerFunc(fn *ssa.Function, funcID asm.LabelName) error {
+	l.fn, l.funcID = fn, funcID
+	l.values = map[ssa.Value]asm.VarName{}
+	l.tuples = map[ssa.Value][]asm.VarName{}
+	l.arrays = map[ssa.Value][]ssa.Value{}
+	if len(fn.AnonFuncs) > 0 {
+		return l.c.errorf(fn.AnonFuncs[0].Pos(), "function literals are not supported by the ssa backend")
+	}
+	if _, ok := l.funcs[fn]; ok {
+		argNames := []asm.VarName{}
+		for _, param := range fn.Params {
+			varName := asm.VarName(fmt.Sprintf("%s_%s", funcID, param.Name()))
+			typeName, _ := udonType(param.Type())
+			err := l.uasm.VarTable.AddVar(varName, typeName, "null")
+			if err != nil {
+				return fmt.Errorf("add var: %w", err)
+			}
+			l.values[param] = varName
+			argNames = append(argNames, varName)
+		}
+		err := l.uasm.PopVars(argNames)
+		if err != nil {
+			return fmt.Errorf("pop vars: %w", err)
+		}
+		err = l.uasm.PopVar(asm.VarName("ret_addr"))
+		if err != nil {
+			return fmt.Errorf("pop vars: %w", err)
+		}
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			phi, ok := instr.(*ssa.Phi)
+			if !ok {
+				continue
+			}
+			typeName, err := udonType(phi.Type())
+			if err != nil {
+				return l.c.errorf(l.pos(phi), "%v", err)
+			}
+			varName := l.uasm.GetNextId("phi")
+			err = l.uasm.VarTable.AddVar(varName, typeName, "null")
+			if err != nil {
+				return fmt.Errorf("add var: %w", err)
+			}
+			l.values[phi] = varName
+		}
+	}
+	// values are lowered before they are used when dominators come first
+	for _, b := range fn.DomPreorder() {
+		l.uasm.AddLabelCurrentAddr(l.blockLabel(b))
+		for _, instr := range b.Instrs {
+			l.sourceComment(instr)
+			err := l.lowerInstr(instr)
+			if err != nil {
+				return fmt.Errorf("%s: %w", fn.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (l *ssaLowerer) blockLabel(b *ssa.BasicBlock) asm.LabelName {
+	return asm.LabelName(fmt.Sprintf("%s_block%d", l.funcID, b.Index))
+}
+
+// pos returns the source position of an instruction, that of its function if it has none
+func (l *ssaLowerer) pos(instr ssa.Instruction) token.Pos {
+	if instr.Pos().IsValid() {
+		return instr.Pos()
+	}
+	return l.fn.Pos()
+}
+
+// sourceComment writes the position of an instruction and the instruction before the code lowered from it,
+// see asm.UdonAssembly.SourceComment
+func (l *ssaLowerer) sourceComment(instr ssa.Instruction) {
+	switch instr.(type) {
+	case *ssa.DebugRef, *ssa.Phi:
+		return
+	}
+	if !l.uasm.Comments || !instr.Pos().IsValid() {
+		return
+	}
+	pos := l.c.Fset.Position(instr.Pos())
+	source := instr.String()
+	if v, ok := instr.(ssa.Value); ok {
+		source = v.Name() + " = " + source
+	}
+	l.uasm.SourceComment(pos.Filename, pos.Line, pos.Column, source)
+}
+
+// unsupported returns the error for an instruction the backend can not lower
+func (l *ssaLowerer) unsupported(instr ssa.Instruction) error {
+	kind := strings.TrimPrefix(fmt.Sprintf("%T", instr), "*ssa.")
+	return l.c.errorf(l.pos(instr), "%s is not supported by the ssa backend: %s", kind, instr)
+}
+
+func (l *ssaLowerer) lowerInstr(instr ssa.Instruction) error {
+	switch i := instr.(type) {
+	case *ssa.DebugRef, *ssa.Phi:
+		// phis are assigned at the end of the blocks before them
+	case *ssa.BinOp:
+		x, err := l.operand(i.X)
+		if err != nil {
+			return err
+		}
+		y, err := l.operand(i.Y)
+		if err != nil {
+			return err
+		}
+		if i.Op == token.SHL || i.Op == token.SHR {
+			// udon shifts by an int32
+			y, err = l.c.convert(l.uasm, y, asm.UdonTypeInt32)
+			if err != nil {
+				return l.c.errorf(l.pos(i), "%v", err)
+			}
+		}
+		result, err := l.c.binaryOp(l.uasm, i.Op, x, y)
+		if err != nil {
+			return l.c.errorf(l.pos(i), "%v", err)
+		}
+		l.values[i] = result
+	case *ssa.UnOp:
+		return l.lowerUnOp(i)
+	case *ssa.Store:
+		global, ok := i.Addr.(*ssa.Global)
+		if !ok {
+			return l.storeElem(i)
+		}
+		val, err := l.operand(i.Val)
+		if err != nil {
+			return err
+		}
+		l.uasm.Assign(l.globals[global], val)
+	case *ssa.Convert:
+		return l.convert(i, i.X)
+	case *ssa.ChangeType:
+		return l.convert(i, i.X)
+	case *ssa.Call:
+		return l.lowerCall(i)
+	case *ssa.Extract:
+		tuple, ok := l.tuples[i.Tuple]
+		if !ok {
+			return l.unsupported(i)
+		}
+		l.values[i] = tuple[i.Index]
+	case *ssa.Alloc:
+		// only the arrays of variadic arguments, which are never stored in variables
+		array, ok := i.Type().(*types.Pointer).Elem().(*types.Array)
+		if !ok {
+			return l.unsupported(i)
+		}
+		l.arrays[i] = make([]ssa.Value, array.Len())
+	case *ssa.IndexAddr:
+		if _, ok := l.arrays[i.X]; !ok {
+			return l.unsupported(i)
+		}
+	case *ssa.Slice:
+		elems, ok := l.arrays[i.X]
+		if !ok || i.Low != nil || i.High != nil || i.Max != nil {
+			return l.unsupported(i)
+		}
+		l.arrays[i] = elems
+	case *ssa.If:
+		cond, err := l.operand(i.Cond)
+		if err != nil {
+			return err
+		}
+		b := i.Block()
+		then, els := b.Succs[0], b.Succs[1]
+		elseLabel := l.blockLabel(els)
+		if hasPhis(els) {
+			elseLabel = asm.LabelName(l.uasm.GetNextId("else_edge"))
+		}
+		l.uasm.PushVar(cond)
+		l.uasm.JumpIfFalseLabel(elseLabel)
+		err = l.jump(b, then)
+		if err != nil {
+			return err
+		}
+		if hasPhis(els) {
+			l.uasm.AddLabelCurrentAddr(elseLabel)
+			return l.jump(b, els)
+		}
+	case *ssa.Jump:
+		return l.jump(i.Block(), i.Block().Succs[0])
+	case *ssa.Return:
+		_, isFunc := l.funcs[l.fn]
+		if !isFunc && len(i.Results) > 0 {
+			return l.c.errorf(l.pos(i), "return: events can not return values")
+		}
+		if want := l.fn.Signature.Results().Len(); len(i.Results) != want {
+			return l.c.errorf(l.pos(i), "return: %d values, %s returns %d", len(i.Results), l.fn.Name(), want)
+		}
+		if !isFunc {
+			l.uasm.End()
+			return nil
+		}
+		if results, ok := l.results[l.fn]; ok {
+			for n, v := range i.Results {
+				result, err := l.operand(v)
+				if err != nil {
+					return err
+				}
+				l.uasm.Assign(results[n], result)
+			}
+		} else if len(i.Results) > 0 {
+			result, err := l.operand(i.Results[0])
+			if err != nil {
+				return err
+			}
+			l.uasm.PushVar(result)
+		}
+		l.uasm.JumpRetAddr()
+	default:
+		return l.unsupported(instr)
+	}
+	return nil
+}
+
+// convert lowers a conversion of x to the type of v
+func (l *ssaLowerer) convert(v ssa.Value, x ssa.Value) error {
+	varName, err := l.operand(x)
+	if err != nil {
+		return err
+	}
+	typeName, err := udonType(v.Type())
+	if err != nil {
+		return l.c.errorf(v.Pos(), "%v", err)
+	}
+	result, err := l.c.convert(l.uasm, varName, typeName)
+	if err != nil {
+		return l.c.errorf(v.Pos(), "%v", err)
+	}
+	l.values[v] = result
+	return nil
+}
+
+// storeElem keeps track of the elements stored in the array of variadic arguments
+func (l *ssaLowerer) storeElem(store *ssa.Store) error {
+	addr, ok := store.Addr.(*ssa.IndexAddr)
+	if !ok {
+		return l.unsupported(store)
+	}
+	elems, ok := l.arrays[addr.X]
+	index, isConst := addr.Index.(*ssa.Const)
+	if !ok || !isConst {
+		return l.unsupported(store)
+	}
+	elems[index.Int64()] = store.Val
+	return nil
+}
+
+func (l *ssaLowerer) lowerUnOp(i *ssa.UnOp) error {
+	if i.Op == token.MUL {
+		global, ok := i.X.(*ssa.Global)
+		if !ok {
+			return l.unsupported(i)
+		}
+		// the loaded value must not change when the variable is stored to
+		typeName, err := udonType(i.Type())
+		if err != nil {
+			return l.c.errorf(l.pos(i), "%v", err)
+		}
+		varName := l.uasm.GetNextId("load")
+		err = l.uasm.VarTable.AddVar(varName, typeName, "null")
+		if err != nil {
+			return fmt.Errorf("add var: %w", err)
+		}
+		l.uasm.Assign(varName, l.globals[global])
+		l.values[i] = varName
+		return nil
+	}
+	x, err := l.operand(i.X)
+	if err != nil {
+		return err
+	}
+	result, err := l.c.unaryOp(l.uasm, i.Op, x)
+	if err != nil {
+		return l.c.errorf(l.pos(i), "%v", err)
+	}
+	l.values[i] = result
+	return nil
+}
+
+// lowerCall calls a function of the program, or the extern a supported library function stands for
+func (l *ssaLowerer) lowerCall(i *ssa.Call) error {
+	callee := i.Call.StaticCallee()
+	if callee == nil || i.Call.IsInvoke() {
+		return l.unsupported(i)
+	}
+	if funcName, ok := l.funcs[callee]; ok {
+		if l.c.recursive(funcName) {
+			return l.c.errorf(l.pos(i), "call %s: recursive functions are not supported", callee.Name())
+		}
+		args := []asm.VarName{}
+		for _, arg := range i.Call.Args {
+			varName, err := l.operand(arg)
+			if err != nil {
+				return err
+			}
+			args = append(args, varName)
+		}
+		result, err := l.uasm.CallDefFunc(funcName, args)
+		if err != nil {
+			return l.c.errorf(l.pos(i), "call %s: %v", callee.Name(), err)
+		}
+		if result != nil {
+			l.values[i] = *result
+		}
+		// the results are copied before another call of the callee overwrites them
+		for _, varName := range l.results[callee] {
+			typeName, _ := l.uasm.VarTable.GetVarType(varName)
+			tmp := l.uasm.GetNextId("result")
+			err = l.uasm.VarTable.AddVar(tmp, typeName, "null")
+			if err != nil {
+				return fmt.Errorf("add var: %w", err)
+			}
+			l.uasm.Assign(tmp, varName)
+			l.tuples[i] = append(l.tuples[i], tmp)
+		}
+		return nil
+	}
+	if callee.Pkg == nil {
+		return l.unsupported(i)
+	}
+	name := callee.Pkg.Pkg.Path() + "." + callee.Name()
+	if callee.Name() == "init" {
+		// library packages need no initialization
+		return nil
+	}
+	switch name {
+	case "udon-go/asm.Log":
+		elems := l.arrays[i.Call.Args[0]]
+		if len(elems) != 1 {
+			return l.c.errorf(l.pos(i), "asm.Log: unsupported # of args: %d", len(elems))
+		}
+		msg, err := l.operand(elems[0])
+		if err != nil {
+			return err
+		}
+		obj, err := l.c.toObject(l.uasm, msg)
+		if err != nil {
+			return err
+		}
+		_, err = l.c.callExtern(l.uasm, asm.STATIC_FUNC, asm.UdonTypeDebug, "Log", "", []asm.VarName{obj})
+		return err
+	case "strconv.Itoa", "strconv.FormatBool":
+		x, err := l.operand(i.Call.Args[0])
+		if err != nil {
+			return err
+		}
+		result, err := l.c.toString(l.uasm, x)
+		if err != nil {
+			return err
+		}
+		l.values[i] = result
+		return nil
+	}
+	return l.unsupported(i)
+}
+
+// jump assigns the phis of the block to, then jumps to it.
+// The incoming values are all read before any phi is written, a phi may be the value of another.
+func (l *ssaLowerer) jump(from *ssa.BasicBlock, to *ssa.BasicBlock) error {
+	pred := -1
+	for i, b := range to.Preds {
+		if b == from {
+			pred = i
+		}
+	}
+	phis := []*ssa.Phi{}
+	for _, instr := range to.Instrs {
+		if phi, ok := instr.(*ssa.Phi); ok {
+			phis = append(phis, phi)
+		}
+	}
+	incoming := []asm.VarName{}
+	for _, phi := range phis {
+		varName, err := l.operand(phi.Edges[pred])
+		if err != nil {
+			return err
+		}
+		if len(phis) > 1 {
+			typeName, _ := l.uasm.VarTable.GetVarType(l.values[phi])
+			tmp := l.uasm.GetNextId("phi_in")
+			err = l.uasm.VarTable.AddVar(tmp, typeName, "null")
+			if err != nil {
+				return fmt.Errorf("add var: %w", err)
+			}
+			l.uasm.Assign(tmp, varName)
+			varName = tmp
+		}
+		incoming = append(incoming, varName)
+	}
+	for i, phi := range phis {
+		l.uasm.Assign(l.values[phi], incoming[i])
+	}
+	l.uasm.JumpLabel(l.blockLabel(to))
+	return nil
+}
+
+func hasPhis(b *ssa.BasicBlock) bool {
+	if len(b.Instrs) == 0 {
+		return false
+	}
+	_, ok := b.Instrs[0].(*ssa.Phi)
+	return ok
+}
+
+// operand returns the heap variable holding the value of v, declaring the constants
+func (l *ssaLowerer) operand(v ssa.Value) (asm.VarName, error) {
+	if varName, ok := l.values[v]; ok {
+		return varName, nil
+	}
+	k, ok := v.(*ssa.Const)
+	if !ok {
+		return "", l.c.errorf(v.Pos(), "%s is not supported by the ssa backend", v)
+	}
+	typeName, err := udonType(k.Type())
+	if err != nil {
+		return "", l.c.errorf(v.Pos(), "%v", err)
+	}
+	value := "null"
+	if k.Value != nil {
+		switch k.Value.Kind() {
+		case constant.Bool:
+			value = strconv.FormatBool(constant.BoolVal(k.Value))
+		case constant.String:
+			value = strconv.Quote(constant.StringVal(k.Value))
+		case constant.Float:
+			f, _ := constant.Float64Val(k.Value)
+			value = strconv.FormatFloat(f, 'g', -1, 64)
+		default:
+			value = k.Value.ExactString()
+		}
+	}
+	key := string(typeName) + " " + value
+	if varName, ok := l.consts[key]; ok {
+		return varName, nil
+	}
+	varName := l.uasm.GetNextId("const")
+	err = l.uasm.VarTable.AddVar(varName, typeName, value)
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	l.consts[key] = varName
+	return varName, nil
+}
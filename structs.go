@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"strconv"
+	"udon-go/asm"
+)
+
+// StructType is a user struct. Udon has no user defined types, so a *T is represented
+// as a SystemObjectArray holding one slot per field, in declaration order.
+// Slots start out null, which the casts in handleFieldGet turn into the zero value of the field.
+type StructType struct {
+	Name   string
+	Fields []*StructField
+}
+
+// StructField is a field stored in slot Index of the backing object array
+type StructField struct {
+	Name     string
+	Index    int
+	TypeName asm.UdonTypeName
+	Info     *TypeInfo
+}
+
+// Field returns the field called name
+func (st *StructType) Field(name string) (*StructField, error) {
+	for _, field := range st.Fields {
+		if field.Name == name {
+			return field, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no field %s", st.Name, name)
+}
+
+// collectStructs registers the struct types of the file, and the behaviour types, see BehaviourType.
+// Names are registered before fields are resolved so that structs can point to each other.
+func (c *Compiler) collectStructs(f *ast.File) error {
+	specs := []*ast.TypeSpec{}
+	behaviourSpecs := []*ast.TypeSpec{}
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, s := range genDecl.Specs {
+			spec, ok := s.(*ast.TypeSpec)
+			if !ok {
+				continue
+			}
+			if _, ok := spec.Type.(*ast.StructType); !ok {
+				return fmt.Errorf("type %s: only struct types are supported", spec.Name.Name)
+			}
+			path, ok, err := c.behaviourDirective(genDecl, spec)
+			if err != nil {
+				return err
+			}
+			if ok {
+				c.Behaviours[c.qualify(spec.Name.Name)] = &BehaviourType{Name: c.qualify(spec.Name.Name), Path: path, Pos: spec.Name.Pos(), Events: map[string]*ast.Ident{}}
+				behaviourSpecs = append(behaviourSpecs, spec)
+				continue
+			}
+			c.Structs[c.qualify(spec.Name.Name)] = &StructType{Name: c.qualify(spec.Name.Name)}
+			specs = append(specs, spec)
+		}
+	}
+	for _, spec := range behaviourSpecs {
+		err := c.resolveBehaviourFields(c.Behaviours[c.qualify(spec.Name.Name)], spec)
+		if err != nil {
+			return err
+		}
+	}
+	for _, spec := range specs {
+		st := c.Structs[c.qualify(spec.Name.Name)]
+		for _, field := range spec.Type.(*ast.StructType).Fields.List {
+			typeName, info, err := c.ResolveType(field.Type)
+			if err != nil {
+				return fmt.Errorf("struct %s: %w", st.Name, err)
+			}
+			if len(field.Names) == 0 {
+				return fmt.Errorf("struct %s: embedded fields are not supported", st.Name)
+			}
+			for _, name := range field.Names {
+				st.Fields = append(st.Fields, &StructField{
+					Name:     name.Name,
+					Index:    len(st.Fields),
+					TypeName: typeName,
+					Info:     info,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// structNamed returns the struct type named by expr, T or pkg.T for the structs of an imported helper package
+func (c *Compiler) structNamed(expr ast.Expr) (*StructType, bool) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		st, ok := c.Structs[c.qualify(t.Name)]
+		return st, ok
+	case *ast.SelectorExpr:
+		pkg, ok := c.importedPackage(t.X)
+		if !ok || !ast.IsExported(t.Sel.Name) {
+			return nil, false
+		}
+		st, ok := c.Structs[pkg.qualify(t.Sel.Name)]
+		return st, ok
+	}
+	return nil, false
+}
+
+// handleNewExpr compiles new(T)
+func (c *Compiler) handleNewExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.CallExpr) (asm.VarName, error) {
+	if len(expr.Args) != 1 {
+		return "", errors.New("new: expected one argument")
+	}
+	st, ok := c.structNamed(expr.Args[0])
+	if !ok {
+		return "", fmt.Errorf("new %s: only struct types are supported", types.ExprString(expr.Args[0]))
+	}
+	return c.newStruct(uasm, st)
+}
+
+// newStruct allocates the object array backing a *T
+func (c *Compiler) newStruct(uasm *asm.UdonAssembly, st *StructType) (asm.VarName, error) {
+	uasm.AddInstComment(fmt.Sprintf("new(%s)", st.Name))
+	sizeVarName := uasm.GetNextId("const_size")
+	err := uasm.VarTable.AddVar(sizeVarName, asm.UdonTypeInt32, strconv.Itoa(len(st.Fields)))
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	ptrVarName, err := c.callExtern(uasm, asm.CONSTRUCTOR, asm.UdonTypeObjectArray, "ctor", "", []asm.VarName{sizeVarName})
+	if err != nil {
+		return "", fmt.Errorf("new %s: %w", st.Name, err)
+	}
+	c.SetTypeInfo(ptrVarName, &TypeInfo{Struct: st})
+	return ptrVarName, nil
+}
+
+// handleStructLit compiles &T{...}
+func (c *Compiler) handleStructLit(uasm *asm.UdonAssembly, out io.Writer, lit *ast.CompositeLit) (asm.VarName, error) {
+	st, ok := c.structNamed(lit.Type)
+	if !ok {
+		return "", fmt.Errorf("&%s{}: not a struct type", types.ExprString(lit.Type))
+	}
+	ptrVarName, err := c.newStruct(uasm, st)
+	if err != nil {
+		return "", err
+	}
+	for i, elt := range lit.Elts {
+		var field *StructField
+		value := elt
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				return "", fmt.Errorf("&%s{}: bad field key %T", st.Name, kv.Key)
+			}
+			field, err = st.Field(key.Name)
+			if err != nil {
+				return "", err
+			}
+			value = kv.Value
+		} else {
+			if i >= len(st.Fields) {
+				return "", fmt.Errorf("&%s{}: too many values", st.Name)
+			}
+			field = st.Fields[i]
+		}
+		valueVarName, err := c.handleExpr(uasm, out, value)
+		if err != nil {
+			return "", fmt.Errorf("&%s{}: field %s: %w", st.Name, field.Name, err)
+		}
+		err = c.handleFieldSet(uasm, out, ptrVarName, st, field.Name, valueVarName)
+		if err != nil {
+			return "", err
+		}
+	}
+	return ptrVarName, nil
+}
+
+func (c *Compiler) handleSelectorExpr(uasm *asm.UdonAssembly, out io.Writer, expr *ast.SelectorExpr) (asm.VarName, error) {
+	if value, ok := c.durationConst(expr); ok {
+		return c.typedConst(uasm, value, asm.UdonTypeInt64)
+	}
+	if varName, ok, err := c.importedVar(expr, true); ok {
+		return varName, err
+	}
+	ptrVarName, err := c.handleExpr(uasm, out, expr.X)
+	if err != nil {
+		return "", err
+	}
+	if bt, ok := c.BehaviourOf(ptrVarName); ok {
+		return c.handleProgramVarGet(uasm, ptrVarName, bt, expr.Sel)
+	}
+	st, ok := c.StructOf(ptrVarName)
+	if !ok {
+		return c.handlePropertyGet(uasm, ptrVarName, expr.Sel)
+	}
+	return c.handleFieldGet(uasm, out, ptrVarName, st, expr.Sel.Name)
+}
+
+// handleFieldGet loads a field of the struct pointed to by ptrVarName, casting it back from SystemObject
+func (c *Compiler) handleFieldGet(uasm *asm.UdonAssembly, out io.Writer, ptrVarName asm.VarName, st *StructType, fieldName string) (asm.VarName, error) {
+	field, err := st.Field(fieldName)
+	if err != nil {
+		return "", err
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.%s", ptrVarName, fieldName))
+	indexVarName, err := c.fieldIndex(uasm, field)
+	if err != nil {
+		return "", err
+	}
+	objVarName, err := c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Get", ptrVarName, []asm.VarName{indexVarName})
+	if err != nil {
+		return "", fmt.Errorf("get field %s.%s: %w", st.Name, fieldName, err)
+	}
+	valueVarName, err := c.castFromObject(uasm, objVarName, field.TypeName)
+	if err != nil {
+		return "", fmt.Errorf("get field %s.%s: %w", st.Name, fieldName, err)
+	}
+	c.SetTypeInfo(valueVarName, field.Info)
+	return valueVarName, nil
+}
+
+// handleFieldSet stores valueVarName in a field of the struct pointed to by ptrVarName
+func (c *Compiler) handleFieldSet(uasm *asm.UdonAssembly, out io.Writer, ptrVarName asm.VarName, st *StructType, fieldName string, valueVarName asm.VarName) error {
+	field, err := st.Field(fieldName)
+	if err != nil {
+		return err
+	}
+	valueVarName, err = c.untypedAs(uasm, valueVarName, field.TypeName)
+	if err != nil {
+		return fmt.Errorf("set field %s.%s: %w", st.Name, fieldName, err)
+	}
+	valueType, err := uasm.VarTable.GetVarType(valueVarName)
+	if err != nil {
+		return err
+	}
+	if valueType != field.TypeName && valueType != asm.UdonTypeObject {
+		return fmt.Errorf("set field %s.%s: cannot use %s as %s", st.Name, fieldName, valueType, field.TypeName)
+	}
+	uasm.AddInstComment(fmt.Sprintf("%s.%s = %s", ptrVarName, fieldName, valueVarName))
+	indexVarName, err := c.fieldIndex(uasm, field)
+	if err != nil {
+		return err
+	}
+	// the extern takes a SystemObject, so widen the value first
+	objVarName, err := c.toObject(uasm, valueVarName)
+	if err != nil {
+		return err
+	}
+	_, err = c.callExtern(uasm, asm.INSTANCE_FUNC, asm.UdonTypeObjectArray, "Set", ptrVarName, []asm.VarName{indexVarName, objVarName})
+	if err != nil {
+		return fmt.Errorf("set field %s.%s: %w", st.Name, fieldName, err)
+	}
+	return nil
+}
+
+func (c *Compiler) fieldIndex(uasm *asm.UdonAssembly, field *StructField) (asm.VarName, error) {
+	indexVarName := uasm.GetNextId("const_field")
+	err := uasm.VarTable.AddVar(indexVarName, asm.UdonTypeInt32, strconv.Itoa(field.Index))
+	if err != nil {
+		return "", fmt.Errorf("add var: %w", err)
+	}
+	return indexVarName, nil
+}
+
+// castFromObject converts a SystemObject to typeName.
+// Value types go through SystemConvert, which also maps null to the zero value,
+// reference types are copied into a variable of the target type.
+func (c *Compiler) castFromObject(uasm *asm.UdonAssembly, objVarName asm.VarName, typeName asm.UdonTypeName) (asm.VarName, error) {
+	if typeName == asm.UdonTypeObject {
+		return objVarName, nil
+	}
+	methodName := asm.UdonMethodName("To" + asm.ShortTypeName(typeName))
+	_, _, err := uasm.MethodTable.FindExtern(asm.STATIC_FUNC, asm.UdonTypeConvert, methodName, []asm.UdonTypeName{asm.UdonTypeObject})
+	if err == nil {
+		return c.callExtern(uasm, asm.STATIC_FUNC, asm.UdonTypeConvert, methodName, "", []asm.VarName{objVarName})
+	}
+	return c.copyAs(uasm, objVarName, typeName)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"udon-go/asm"
+)
+
+// TypeInfo describes the go type behind a heap variable when its udon type is not enough,
+// e.g. which struct a SystemObjectArray points to
+type TypeInfo struct {
+	Struct    *StructType
+	Map       *MapType
+	Behaviour *BehaviourType
+}
+
+// ResolveType returns the udon type of a go type expression.
+// Pointers to user structs and maps are represented as object arrays, see StructType and MapType.
+func (c *Compiler) ResolveType(expr ast.Expr) (asm.UdonTypeName, *TypeInfo, error) {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if bt, ok := c.behaviourNamed(t); ok {
+			return "", nil, fmt.Errorf("behaviour values are not supported, use *%s", bt.Name)
+		}
+		if _, ok := c.structNamed(t); ok {
+			return "", nil, fmt.Errorf("struct values are not supported, use *%s", t.Name)
+		}
+		typeName, err := IdentToUnity(t)
+		if err != nil {
+			return "", nil, err
+		}
+		return typeName, nil, nil
+	case *ast.StarExpr:
+		if bt, ok := c.behaviourNamed(t.X); ok {
+			return asm.UdonTypeIUdonEventReceiver, &TypeInfo{Behaviour: bt}, nil
+		}
+		st, ok := c.structNamed(t.X)
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported pointer type: *%s", types.ExprString(t.X))
+		}
+		return asm.UdonTypeObjectArray, &TypeInfo{Struct: st}, nil
+	case *ast.SelectorExpr:
+		if bt, ok := c.behaviourNamed(t); ok {
+			return "", nil, fmt.Errorf("behaviour values are not supported, use *%s", bt.Name)
+		}
+		if st, ok := c.structNamed(t); ok {
+			return "", nil, fmt.Errorf("struct values are not supported, use *%s", st.Name)
+		}
+		typeName, ok := unityType(t)
+		if !ok {
+			typeName, ok = c.timeType(t)
+		}
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported type: %s", t.Sel.Name)
+		}
+		return typeName, nil, nil
+	case *ast.MapType:
+		mt, err := c.resolveMapType(t)
+		if err != nil {
+			return "", nil, err
+		}
+		return asm.UdonTypeObjectArray, &TypeInfo{Map: mt}, nil
+	}
+	return "", nil, fmt.Errorf("unsupported type: %T", expr)
+}
+
+// SetTypeInfo records the go type behind varName, if there is one
+func (c *Compiler) SetTypeInfo(varName asm.VarName, info *TypeInfo) {
+	if info != nil {
+		c.VarTypes[varName] = info
+	}
+}
+
+// StructOf returns the struct varName points to
+func (c *Compiler) StructOf(varName asm.VarName) (*StructType, bool) {
+	info, ok := c.VarTypes[varName]
+	if !ok || info.Struct == nil {
+		return nil, false
+	}
+	return info.Struct, true
+}
+
+// MapOf returns the map type of varName
+func (c *Compiler) MapOf(varName asm.VarName) (*MapType, bool) {
+	info, ok := c.VarTypes[varName]
+	if !ok || info.Map == nil {
+		return nil, false
+	}
+	return info.Map, true
+}
+
+// BehaviourOf returns the behaviour type varName refers to
+func (c *Compiler) BehaviourOf(varName asm.VarName) (*BehaviourType, bool) {
+	info, ok := c.VarTypes[varName]
+	if !ok || info.Behaviour == nil {
+		return nil, false
+	}
+	return info.Behaviour, true
+}
+
+// GoTypeName returns how go writes the type of a heap variable of type typeName, described by info.
+// Unity types are written as their stub of the asm package.
+func GoTypeName(typeName asm.UdonTypeName, info *TypeInfo) string {
+	switch {
+	case info == nil:
+	case info.Struct != nil:
+		return "*" + info.Struct.Name
+	case info.Behaviour != nil:
+		return "*" + info.Behaviour.Name
+	case info.Map != nil:
+		return fmt.Sprintf("map[%s]%s", GoTypeName(info.Map.Key, info.Map.KeyInfo), GoTypeName(info.Map.Value, info.Map.ValueInfo))
+	}
+	return asm.StubTypeName(typeName)
+}
@@ -0,0 +1,17 @@
+// Package udon declares the scheduling functions of udon behaviours.
+// They only exist so that programs are valid go, the compiler replaces every call.
+package udon
+
+import "time"
+
+// After runs fn once d has passed.
+// fn gets a copy of the variables it captures, taken when After is called.
+func After(d time.Duration, fn func()) {
+
+}
+
+// NextFrame runs fn on the next frame.
+// fn gets a copy of the variables it captures, taken when NextFrame is called.
+func NextFrame(fn func()) {
+
+}
@@ -0,0 +1,469 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"udon-go/asm"
+	"unicode/utf16"
+)
+
+// externFunc implements an extern, this is nil for static externs
+type externFunc func(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error)
+
+// externImpl is the implementation of a method, whatever the types of its arguments
+type externImpl struct {
+	instance bool
+	fn       externFunc
+}
+
+// boundExtern is an extern signature resolved to its implementation
+type boundExtern struct {
+	sig *asm.ExternSig
+	externImpl
+}
+
+// externs are implemented by module and method, with the number of arguments as methods may be overloaded
+var externs map[string]externImpl
+
+// arrayExterns are implemented for every array type
+var arrayExterns = map[string]externImpl{
+	"ctor/1":       {false, newArray},
+	"Get/1":        {true, arrayGet},
+	"Set/2":        {true, arraySet},
+	"get_Length/0": {true, arrayLength},
+}
+
+func init() {
+	// set in init as the events sent by some externs run other externs
+	externs = map[string]externImpl{
+		"SystemObject.Equals/2":            {false, objectEquals},
+		"SystemObject.Equals/1":            {true, objectEquals},
+		"SystemObject.ReferenceEquals/2":   {false, referenceEquals},
+		"SystemObject.op_Equality/2":       {false, referenceEquals},
+		"SystemObject.op_Inequality/2":     {false, not(referenceEquals)},
+		"SystemObject.GetHashCode/0":       {true, hashCode},
+		"SystemObject.ToString/0":          {true, toString},
+		"SystemString.get_Length/0":        {true, stringLength},
+		"SystemString.op_Addition/2":       {false, concat},
+		"SystemString.op_Equality/2":       {false, objectEquals},
+		"SystemString.op_Inequality/2":     {false, not(objectEquals)},
+		"SystemString.Concat/2":            {false, concat},
+		"SystemString.Equals/1":            {true, objectEquals},
+		"SystemString.ToString/0":          {true, toString},
+		"UnityEngineDebug.Log/1":           {false, debugLog},
+		"UnityEngineTime.get_time/0":       {false, timeNow},
+		"UnityEngineTime.get_frameCount/0": {false, frameCount},
+
+		"VRCUdonCommonInterfacesIUdonEventReceiver.SendCustomEvent/1":               {true, sendCustomEvent},
+		"VRCUdonCommonInterfacesIUdonEventReceiver.SendCustomEventDelayedSeconds/3": {true, sendCustomEventDelayed},
+		"VRCUdonCommonInterfacesIUdonEventReceiver.SendCustomEventDelayedFrames/3":  {true, sendCustomEventDelayed},
+		"VRCUdonCommonInterfacesIUdonEventReceiver.GetProgramVariable/1":            {true, getProgramVariable},
+		"VRCUdonCommonInterfacesIUdonEventReceiver.SetProgramVariable/2":            {true, setProgramVariable},
+	}
+}
+
+// bind resolves an extern signature to its implementation
+func (m *VM) bind(externStr asm.ExternStr) (*boundExtern, error) {
+	if b, ok := m.externs[externStr]; ok {
+		return b, nil
+	}
+	sig, err := asm.ParseExternStr(externStr)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%d", sig.Method, len(sig.ArgTypes))
+	impl, ok := externs[fmt.Sprintf("%s.%s", sig.Module, key)]
+	switch {
+	case ok:
+	case strings.HasSuffix(string(sig.Module), "Array"):
+		impl, ok = arrayExterns[key]
+	case sig.Module == asm.UdonTypeConvert && strings.HasPrefix(string(sig.Method), "To") && len(sig.ArgTypes) == 1:
+		impl, ok = externImpl{false, convertTo}, true
+	case strings.HasPrefix(string(sig.Method), "op_") && len(sig.ArgTypes) == 2:
+		impl, ok = externImpl{false, binaryOp}, true
+	case strings.HasPrefix(string(sig.Method), "op_Unary") && len(sig.ArgTypes) == 1:
+		impl, ok = externImpl{false, unaryOp}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("extern %s is not implemented", externStr)
+	}
+	b := &boundExtern{sig: sig, externImpl: impl}
+	m.externs[externStr] = b
+	return b, nil
+}
+
+// callExtern pops the heap addresses of the return value, the arguments and the instance, in this order, and calls the extern
+func (m *VM) callExtern(externStr asm.ExternStr) error {
+	b, err := m.bind(externStr)
+	if err != nil {
+		return err
+	}
+	ret := uint32(0)
+	if b.sig.HasRet() {
+		ret, err = m.pop()
+		if err != nil {
+			return err
+		}
+	}
+	args := make([]interface{}, len(b.sig.ArgTypes))
+	for i := len(args) - 1; i >= 0; i-- {
+		addr, err := m.pop()
+		if err != nil {
+			return err
+		}
+		args[i] = m.Heap[addr]
+		if !assignable(args[i], b.sig.ArgTypes[i]) {
+			return fmt.Errorf("argument %d is %s, not %s", i, typeOf(args[i]), b.sig.ArgTypes[i])
+		}
+	}
+	var this interface{}
+	if b.instance {
+		addr, err := m.pop()
+		if err != nil {
+			return err
+		}
+		this = m.Heap[addr]
+		if this == nil {
+			return errors.New("null reference")
+		}
+	}
+	value, err := b.fn(m, b.sig, this, args)
+	if err != nil {
+		return err
+	}
+	if b.sig.HasRet() {
+		m.Heap[ret] = value
+	}
+	return nil
+}
+
+// assignable reports whether v can be passed as an argument of type typeName
+func assignable(v interface{}, typeName asm.UdonTypeName) bool {
+	switch {
+	case typeName == asm.UdonTypeObject:
+		return true
+	case v == nil:
+		return zero(typeName) == nil
+	}
+	if _, ok := v.(*Object); ok {
+		// unity objects are only told apart by the externs they are passed to
+		return zero(typeName) == nil && typeName != asm.UdonTypeString
+	}
+	return typeOf(v) == typeName
+}
+
+func not(fn externFunc) externFunc {
+	return func(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+		v, err := fn(m, sig, this, args)
+		if err != nil {
+			return nil, err
+		}
+		return !v.(bool), nil
+	}
+}
+
+// operands returns the operands of a static extern, or the instance followed by the arguments
+func operands(this interface{}, args []interface{}) []interface{} {
+	if this == nil {
+		return args
+	}
+	return append([]interface{}{this}, args...)
+}
+
+// objectEquals compares values like Equals: by value for numbers and strings, by reference for objects
+func objectEquals(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	ops := operands(this, args)
+	return ops[0] == ops[1], nil
+}
+
+// referenceEquals compares by reference, numbers are boxed by value in the emulator so they compare by value
+func referenceEquals(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return args[0] == args[1], nil
+}
+
+func hashCode(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	if i, ok := signed(this); ok {
+		return int32(i ^ i>>32), nil
+	}
+	if u, ok := unsigned(this); ok {
+		return int32(u ^ u>>32), nil
+	}
+	if array, ok := this.(*Array); ok {
+		// arrays hash by reference, numbered in the order they are made so runs are reproducible
+		return array.id, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(typeOf(this)))
+	h.Write([]byte(format(this)))
+	return int32(h.Sum32()), nil
+}
+
+func toString(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return format(this), nil
+}
+
+func stringLength(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return int32(len(utf16.Encode([]rune(this.(string))))), nil
+}
+
+func concat(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return format(args[0]) + format(args[1]), nil
+}
+
+func debugLog(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	if args[0] == nil {
+		m.Log = append(m.Log, "Null")
+	} else {
+		m.Log = append(m.Log, format(args[0]))
+	}
+	return nil, nil
+}
+
+func convertTo(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return convert(args[0], sig.RetType)
+}
+
+func newArray(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	n, ok := signed(args[0])
+	if !ok || n < 0 {
+		return nil, fmt.Errorf("bad array length %v", args[0])
+	}
+	m.arrays++
+	array := &Array{Type: sig.RetType, Elems: make([]interface{}, n), id: m.arrays}
+	elem := zero(asm.UdonTypeName(strings.TrimSuffix(string(sig.RetType), "Array")))
+	for i := range array.Elems {
+		array.Elems[i] = elem
+	}
+	return array, nil
+}
+
+// arrayIndex checks the index of an array access
+func arrayIndex(this interface{}, index interface{}) (*Array, int, error) {
+	array, ok := this.(*Array)
+	if !ok {
+		return nil, 0, fmt.Errorf("%s is not an array", typeOf(this))
+	}
+	i, ok := signed(index)
+	if !ok || i < 0 || i >= int64(len(array.Elems)) {
+		return nil, 0, fmt.Errorf("index %v out of range [0, %d)", index, len(array.Elems))
+	}
+	return array, int(i), nil
+}
+
+func arrayGet(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	array, i, err := arrayIndex(this, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return array.Elems[i], nil
+}
+
+func arraySet(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	array, i, err := arrayIndex(this, args[0])
+	if err != nil {
+		return nil, err
+	}
+	array.Elems[i] = args[1]
+	return nil, nil
+}
+
+func arrayLength(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	array, ok := this.(*Array)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an array", typeOf(this))
+	}
+	return int32(len(array.Elems)), nil
+}
+
+// self checks that an UdonBehaviour extern is called on the behaviour being run, the only one the emulator knows
+func (m *VM) self(this interface{}) error {
+	if this != m.Self {
+		return fmt.Errorf("%s is not this behaviour", format(this))
+	}
+	return nil
+}
+
+func sendCustomEvent(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	err := m.self(this)
+	if err != nil {
+		return nil, err
+	}
+	return nil, m.sendEvent(asm.EventName(format(args[0])))
+}
+
+// sendCustomEventDelayed queues an event for a later frame, at least the next one
+func sendCustomEventDelayed(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	err := m.self(this)
+	if err != nil {
+		return nil, err
+	}
+	e := &delayedEvent{event: asm.EventName(format(args[0])), time: m.Time, frame: m.Frame + 1}
+	switch delay := args[1].(type) {
+	case float32:
+		e.time += float64(delay)
+	case int32:
+		if delay > 1 {
+			e.frame = m.Frame + int(delay)
+		}
+	}
+	m.pending = append(m.pending, e)
+	return nil, nil
+}
+
+// timeNow returns the time of the current frame in seconds, Time.time
+func timeNow(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return float32(m.Time), nil
+}
+
+// frameCount returns the number of the current frame, Time.frameCount
+func frameCount(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	return int32(m.Frame), nil
+}
+
+func getProgramVariable(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	err := m.self(this)
+	if err != nil {
+		return nil, err
+	}
+	v, _ := m.Var(asm.VarName(format(args[0])))
+	return v, nil
+}
+
+func setProgramVariable(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	err := m.self(this)
+	if err != nil {
+		return nil, err
+	}
+	// like udon, setting a variable the program does not have does nothing
+	m.SetVar(asm.VarName(format(args[0])), args[1])
+	return nil, nil
+}
+
+// unaryOp implements op_UnaryMinus and op_UnaryNegation
+func unaryOp(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	x := args[0]
+	switch sig.Method {
+	case "op_UnaryNegation":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "op_UnaryMinus":
+		if f, ok := float(x); ok {
+			return convert(-f, sig.RetType)
+		}
+		if i, ok := signed(x); ok {
+			return wrap(uint64(-i), sig.RetType), nil
+		}
+		if u, ok := unsigned(x); ok {
+			return wrap(-u, sig.RetType), nil
+		}
+	}
+	return nil, fmt.Errorf("%s not defined on %s", sig.Method, typeOf(x))
+}
+
+// binaryOp implements the operators of booleans, strings and numbers.
+// Integers are computed on 64 bits and wrapped to the result type, as C# does in unchecked code.
+func binaryOp(m *VM, sig *asm.ExternSig, this interface{}, args []interface{}) (interface{}, error) {
+	x, y := args[0], args[1]
+	switch sig.Method {
+	case "op_Equality":
+		return x == y, nil
+	case "op_Inequality":
+		return x != y, nil
+	}
+	if a, ok := x.(bool); ok {
+		b := y.(bool)
+		switch sig.Method {
+		case "op_LogicalAnd", "op_ConditionalAnd":
+			return a && b, nil
+		case "op_LogicalOr", "op_ConditionalOr":
+			return a || b, nil
+		case "op_LogicalXor":
+			return a != b, nil
+		}
+	}
+	if a, ok := float(x); ok {
+		b, _ := toFloat(y)
+		switch sig.Method {
+		case "op_Addition":
+			return convert(a+b, sig.RetType)
+		case "op_Subtraction":
+			return convert(a-b, sig.RetType)
+		case "op_Multiplication":
+			return convert(a*b, sig.RetType)
+		case "op_Division":
+			return convert(a/b, sig.RetType)
+		}
+		return compare(sig.Method, a < b, a == b)
+	}
+	i, isSigned := signed(x)
+	u, isUnsigned := unsigned(x)
+	if !isSigned && !isUnsigned {
+		return nil, fmt.Errorf("%s not defined on %s", sig.Method, typeOf(x))
+	}
+	if isSigned {
+		u = uint64(i)
+	}
+	j, ySigned := signed(y)
+	v, _ := unsigned(y)
+	if ySigned {
+		v = uint64(j)
+	}
+	switch sig.Method {
+	case "op_Addition":
+		return wrap(u+v, sig.RetType), nil
+	case "op_Subtraction":
+		return wrap(u-v, sig.RetType), nil
+	case "op_Multiplication":
+		return wrap(u*v, sig.RetType), nil
+	case "op_Division":
+		if v == 0 {
+			return nil, errors.New("divide by zero")
+		}
+		if isSigned {
+			return wrap(uint64(i/j), sig.RetType), nil
+		}
+		return wrap(u/v, sig.RetType), nil
+	case "op_LogicalAnd":
+		return wrap(u&v, sig.RetType), nil
+	case "op_LogicalOr":
+		return wrap(u|v, sig.RetType), nil
+	case "op_LogicalXor":
+		return wrap(u^v, sig.RetType), nil
+	case "op_LeftShift", "op_RightShift":
+		// the shift count is masked by the size of the operand, promoted to 32 bits at least
+		bits := uint64(32)
+		if sig.RetType == asm.UdonTypeInt64 || sig.RetType == asm.UdonTypeUInt64 {
+			bits = 64
+		}
+		n := v & (bits - 1)
+		if sig.Method == "op_LeftShift" {
+			return wrap(u<<n, sig.RetType), nil
+		}
+		if isSigned {
+			return wrap(uint64(i>>n), sig.RetType), nil
+		}
+		return wrap(u>>n, sig.RetType), nil
+	}
+	if isSigned {
+		return compare(sig.Method, i < j, i == j)
+	}
+	return compare(sig.Method, u < v, u == v)
+}
+
+// compare implements the comparison operators from less and equal
+func compare(method asm.UdonMethodName, less bool, equal bool) (interface{}, error) {
+	switch method {
+	case "op_LessThan":
+		return less, nil
+	case "op_LessThanOrEqual":
+		return less || equal, nil
+	case "op_GreaterThan":
+		return !less && !equal, nil
+	case "op_GreaterThanOrEqual":
+		return !less, nil
+	}
+	return nil, fmt.Errorf("operator %s is not implemented", method)
+}
@@ -0,0 +1,361 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"udon-go/asm"
+)
+
+// Values of the heap are go values standing for the C# ones:
+// int32 for SystemInt32, float32 for SystemSingle, string for SystemString, bool for SystemBoolean and so on,
+// Char for SystemChar, *Array for arrays and *Object for unity objects. nil is null.
+
+// Char is a SystemChar, an utf-16 code unit
+type Char uint16
+
+// Array is a C# array, shared by reference
+type Array struct {
+	Type  asm.UdonTypeName
+	Elems []interface{}
+	id    int32
+}
+
+// zero returns the default value of a C# type, null for references
+func zero(typeName asm.UdonTypeName) interface{} {
+	switch typeName {
+	case asm.UdonTypeBoolean:
+		return false
+	case asm.UdonTypeChar:
+		return Char(0)
+	case asm.UdonTypeByte:
+		return uint8(0)
+	case asm.UdonTypeSByte:
+		return int8(0)
+	case asm.UdonTypeInt16:
+		return int16(0)
+	case asm.UdonTypeUInt16:
+		return uint16(0)
+	case asm.UdonTypeInt32:
+		return int32(0)
+	case asm.UdonTypeUInt32:
+		return uint32(0)
+	case asm.UdonTypeInt64:
+		return int64(0)
+	case asm.UdonTypeUInt64:
+		return uint64(0)
+	case asm.UdonTypeSingle:
+		return float32(0)
+	case asm.UdonTypeDouble:
+		return float64(0)
+	}
+	return nil
+}
+
+// parseValue parses the initial value of a heap variable, written as a go literal
+func parseValue(s string, typeName asm.UdonTypeName) (interface{}, error) {
+	switch typeName {
+	case asm.UdonTypeBoolean:
+		return strconv.ParseBool(s)
+	case asm.UdonTypeChar:
+		r, _, _, err := strconv.UnquoteChar(strings.Trim(s, "'"), '\'')
+		if err != nil {
+			return nil, err
+		}
+		return Char(r), nil
+	case asm.UdonTypeSingle, asm.UdonTypeDouble:
+		f, err := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64)
+		if err != nil {
+			return nil, err
+		}
+		return convert(f, typeName)
+	}
+	if _, _, ok := integerRange(typeName); !ok {
+		return nil, fmt.Errorf("can not parse %s of type %s", s, typeName)
+	}
+	if strings.HasPrefix(s, "-") {
+		i, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		return convert(i, typeName)
+	}
+	u, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		// untyped constants may be floats given an integer type, e.g. 1e3
+		f, ferr := strconv.ParseFloat(strings.ReplaceAll(s, "_", ""), 64)
+		if ferr != nil {
+			return nil, err
+		}
+		return convert(f, typeName)
+	}
+	return convert(u, typeName)
+}
+
+// signed returns the value of a signed integer
+func signed(v interface{}) (int64, bool) {
+	switch x := v.(type) {
+	case int8:
+		return int64(x), true
+	case int16:
+		return int64(x), true
+	case int32:
+		return int64(x), true
+	case int64:
+		return x, true
+	}
+	return 0, false
+}
+
+// unsigned returns the value of an unsigned integer or a char
+func unsigned(v interface{}) (uint64, bool) {
+	switch x := v.(type) {
+	case uint8:
+		return uint64(x), true
+	case uint16:
+		return uint64(x), true
+	case uint32:
+		return uint64(x), true
+	case uint64:
+		return x, true
+	case Char:
+		return uint64(x), true
+	}
+	return 0, false
+}
+
+// float returns the value of a float
+func float(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+// typeOf returns the udon type of a value
+func typeOf(v interface{}) asm.UdonTypeName {
+	switch x := v.(type) {
+	case bool:
+		return asm.UdonTypeBoolean
+	case Char:
+		return asm.UdonTypeChar
+	case uint8:
+		return asm.UdonTypeByte
+	case int8:
+		return asm.UdonTypeSByte
+	case int16:
+		return asm.UdonTypeInt16
+	case uint16:
+		return asm.UdonTypeUInt16
+	case int32:
+		return asm.UdonTypeInt32
+	case uint32:
+		return asm.UdonTypeUInt32
+	case int64:
+		return asm.UdonTypeInt64
+	case uint64:
+		return asm.UdonTypeUInt64
+	case float32:
+		return asm.UdonTypeSingle
+	case float64:
+		return asm.UdonTypeDouble
+	case string:
+		return asm.UdonTypeString
+	case *Array:
+		return x.Type
+	case *Object:
+		return x.Type
+	}
+	return asm.UdonTypeObject
+}
+
+// wrap converts an integer computed on 64 bits back to typeName, dropping the high bits like C# does in unchecked code
+func wrap(u uint64, typeName asm.UdonTypeName) interface{} {
+	switch typeName {
+	case asm.UdonTypeByte:
+		return uint8(u)
+	case asm.UdonTypeSByte:
+		return int8(u)
+	case asm.UdonTypeInt16:
+		return int16(u)
+	case asm.UdonTypeUInt16:
+		return uint16(u)
+	case asm.UdonTypeChar:
+		return Char(u)
+	case asm.UdonTypeInt32:
+		return int32(u)
+	case asm.UdonTypeUInt32:
+		return uint32(u)
+	case asm.UdonTypeInt64:
+		return int64(u)
+	}
+	return u
+}
+
+// integerRange returns the bounds of an integer type
+func integerRange(typeName asm.UdonTypeName) (float64, float64, bool) {
+	switch typeName {
+	case asm.UdonTypeByte:
+		return 0, math.MaxUint8, true
+	case asm.UdonTypeSByte:
+		return math.MinInt8, math.MaxInt8, true
+	case asm.UdonTypeInt16:
+		return math.MinInt16, math.MaxInt16, true
+	case asm.UdonTypeUInt16, asm.UdonTypeChar:
+		return 0, math.MaxUint16, true
+	case asm.UdonTypeInt32:
+		return math.MinInt32, math.MaxInt32, true
+	case asm.UdonTypeUInt32:
+		return 0, math.MaxUint32, true
+	case asm.UdonTypeInt64:
+		return math.MinInt64, math.MaxInt64, true
+	case asm.UdonTypeUInt64:
+		return 0, math.MaxUint64, true
+	}
+	return 0, 0, false
+}
+
+// convert converts a value like SystemConvert does: floats are rounded to the nearest even integer,
+// and a value out of the range of the integer type is an overflow
+func convert(v interface{}, typeName asm.UdonTypeName) (interface{}, error) {
+	if v == nil && zero(typeName) != nil {
+		// null converts to the default value
+		return zero(typeName), nil
+	}
+	switch typeName {
+	case asm.UdonTypeObject:
+		return v, nil
+	case asm.UdonTypeString:
+		return format(v), nil
+	case asm.UdonTypeBoolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if s, ok := v.(string); ok {
+			b, err := strconv.ParseBool(strings.ToLower(strings.TrimSpace(s)))
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a boolean", s)
+			}
+			return b, nil
+		}
+		if f, ok := toFloat(v); ok {
+			return f != 0, nil
+		}
+	case asm.UdonTypeSingle, asm.UdonTypeDouble:
+		f, ok := toFloat(v)
+		if s, isString := v.(string); isString {
+			var err error
+			f, err = strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", s)
+			}
+			ok = true
+		}
+		if !ok {
+			break
+		}
+		if typeName == asm.UdonTypeSingle {
+			return float32(f), nil
+		}
+		return f, nil
+	}
+	min, max, ok := integerRange(typeName)
+	if !ok {
+		return nil, fmt.Errorf("can not convert %s to %s", typeOf(v), typeName)
+	}
+	if s, ok := v.(string); ok {
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", s)
+		}
+		v = i
+	}
+	if b, ok := v.(bool); ok {
+		if b {
+			return wrap(1, typeName), nil
+		}
+		return wrap(0, typeName), nil
+	}
+	if i, ok := signed(v); ok {
+		if float64(i) < min || float64(i) > max {
+			return nil, fmt.Errorf("%d overflows %s", i, typeName)
+		}
+		return wrap(uint64(i), typeName), nil
+	}
+	if u, ok := unsigned(v); ok {
+		if float64(u) > max {
+			return nil, fmt.Errorf("%d overflows %s", u, typeName)
+		}
+		return wrap(u, typeName), nil
+	}
+	if f, ok := float(v); ok {
+		f = math.RoundToEven(f)
+		if math.IsNaN(f) || f < min || f > max {
+			return nil, fmt.Errorf("%g overflows %s", f, typeName)
+		}
+		if f < 0 {
+			return wrap(uint64(int64(f)), typeName), nil
+		}
+		return wrap(uint64(f), typeName), nil
+	}
+	return nil, fmt.Errorf("can not convert %s to %s", typeOf(v), typeName)
+}
+
+// toFloat returns any number as a float64
+func toFloat(v interface{}) (float64, bool) {
+	if i, ok := signed(v); ok {
+		return float64(i), true
+	}
+	if u, ok := unsigned(v); ok {
+		return float64(u), true
+	}
+	return float(v)
+}
+
+// format returns the value as C# ToString does, for the types the emulator knows
+func format(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case bool:
+		if x {
+			return "True"
+		}
+		return "False"
+	case Char:
+		return string(rune(x))
+	case float32:
+		return formatFloat(float64(x), 32)
+	case float64:
+		return formatFloat(x, 64)
+	case *Array:
+		return string(x.Type)
+	case *Object:
+		return fmt.Sprintf("%s (%s)", x.Name, x.Type)
+	}
+	if i, ok := signed(v); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	if u, ok := unsigned(v); ok {
+		return strconv.FormatUint(u, 10)
+	}
+	return fmt.Sprint(v)
+}
+
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return strconv.FormatFloat(f, 'G', -1, bitSize)
+}
@@ -0,0 +1,343 @@
+// Package vm emulates the udon vm well enough to run compiled programs without unity.
+// Only the externs the compiler emits are implemented, see externs.go.
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"udon-go/asm"
+)
+
+// ErrStepLimit is returned when an event runs more instructions than MaxSteps
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// DefaultMaxSteps is how many instructions an event may run by default
+const DefaultMaxSteps = 1000000
+
+// VM runs the events of one udon program
+type VM struct {
+	Program *asm.Program
+	// Heap holds the value of every heap variable, indexed by heap address
+	Heap  []interface{}
+	Stack []uint32
+	// PC is the address of the next instruction
+	PC uint32
+	// Log collects the messages of UnityEngineDebug.Log
+	Log []string
+	// Time is the time of the frame being run, in seconds, Frame counts the frames
+	Time  float64
+	Frame int
+	// MaxSteps bounds the instructions run by an event, including the events it sends
+	MaxSteps int
+	steps    int
+	// Self is the UdonBehaviour running the program, the value of variables initialized to this
+	Self *Object
+	// SourceMap positions the errors in the go source when it is set
+	SourceMap *asm.SourceMap
+	insts     map[uint32]*asm.Instruction
+	externs   map[asm.ExternStr]*boundExtern
+	pending   []*delayedEvent
+	arrays    int32
+}
+
+// Object is an unity object, only known by its type
+type Object struct {
+	Type asm.UdonTypeName
+	Name string
+}
+
+// delayedEvent is an event sent with SendCustomEventDelayedSeconds or SendCustomEventDelayedFrames
+type delayedEvent struct {
+	event asm.EventName
+	time  float64
+	frame int
+}
+
+// New loads prog, initializing the heap
+func New(prog *asm.Program) (*VM, error) {
+	m := &VM{
+		Program:  prog,
+		MaxSteps: DefaultMaxSteps,
+		Self:     &Object{Type: asm.UdonTypeIUdonEventReceiver, Name: "this"},
+		insts:    map[uint32]*asm.Instruction{},
+		externs:  map[asm.ExternStr]*boundExtern{},
+	}
+	for _, item := range prog.Vars {
+		value, err := m.initialValue(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", item.VarName, err)
+		}
+		m.Heap = append(m.Heap, value)
+	}
+	for _, inst := range prog.Code {
+		m.insts[uint32(inst.Addr)] = inst
+	}
+	return m, nil
+}
+
+// Load parses a text assembly or a binary program and loads it
+func Load(text string) (*VM, error) {
+	prog, err := asm.ReadProgram([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	return New(prog)
+}
+
+// initialValue parses the initial value of a heap variable
+func (m *VM) initialValue(item *asm.VarItem) (interface{}, error) {
+	switch item.InitialValue {
+	case "null":
+		return zero(item.TypeName), nil
+	case "this":
+		if item.TypeName == asm.UdonTypeIUdonEventReceiver {
+			return m.Self, nil
+		}
+		return &Object{Type: item.TypeName, Name: "this"}, nil
+	}
+	if item.TypeName == asm.UdonTypeString {
+		return strconv.Unquote(item.InitialValue)
+	}
+	return parseValue(item.InitialValue, item.TypeName)
+}
+
+// Var returns the value of a heap variable
+func (m *VM) Var(varName asm.VarName) (interface{}, bool) {
+	i, ok := m.Program.VarIndex(varName)
+	if !ok {
+		return nil, false
+	}
+	return m.Heap[i], true
+}
+
+// SetVar sets the value of a heap variable
+func (m *VM) SetVar(varName asm.VarName, value interface{}) error {
+	i, ok := m.Program.VarIndex(varName)
+	if !ok {
+		return fmt.Errorf("variable not defined: %s", varName)
+	}
+	m.Heap[i] = value
+	return nil
+}
+
+// Start makes the event the next code to run, without running it
+func (m *VM) Start(event asm.EventName) error {
+	addr, ok := m.Program.EventAddr(event)
+	if !ok {
+		return fmt.Errorf("event not defined: %s", event)
+	}
+	m.PC = uint32(addr)
+	m.steps = 0
+	return nil
+}
+
+// Running reports whether the current event has not ended yet
+func (m *VM) Running() bool {
+	_, ok := m.insts[m.PC]
+	return ok
+}
+
+// Instruction returns the next instruction, nil once the event has ended
+func (m *VM) Instruction() *asm.Instruction {
+	return m.insts[m.PC]
+}
+
+// Run runs the event until it ends
+func (m *VM) Run(event asm.EventName) error {
+	err := m.Start(event)
+	if err != nil {
+		return err
+	}
+	return m.Continue()
+}
+
+// Continue runs the current event until it ends
+func (m *VM) Continue() error {
+	for m.Running() {
+		err := m.Step()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step runs the next instruction
+func (m *VM) Step() error {
+	inst, ok := m.insts[m.PC]
+	if !ok {
+		return fmt.Errorf("no instruction at %#x", m.PC)
+	}
+	m.steps++
+	if m.MaxSteps > 0 && m.steps > m.MaxSteps {
+		return fmt.Errorf("%s%#x: %w", m.sourcePos(inst.Addr), m.PC, ErrStepLimit)
+	}
+	err := m.exec(inst)
+	if err != nil {
+		return fmt.Errorf("%s%#x %s: %w", m.sourcePos(inst.Addr), inst.Addr, inst, err)
+	}
+	return nil
+}
+
+// sourcePos returns the go position of the instruction at addr followed by ": ", "" without a source map
+func (m *VM) sourcePos(addr asm.Addr) string {
+	if m.SourceMap == nil {
+		return ""
+	}
+	pos, ok := m.SourceMap.Lookup(addr)
+	if !ok {
+		return ""
+	}
+	return pos.String() + ": "
+}
+
+func (m *VM) exec(inst *asm.Instruction) error {
+	next := m.PC + uint32(inst.Op.Size())
+	switch inst.Op {
+	case asm.NOP, asm.ANNOTATION:
+	case asm.PUSH:
+		addr, err := m.operandAddr(inst.Arg)
+		if err != nil {
+			return err
+		}
+		m.Stack = append(m.Stack, addr)
+	case asm.POP:
+		_, err := m.pop()
+		if err != nil {
+			return err
+		}
+	case asm.COPY:
+		dst, err := m.pop()
+		if err != nil {
+			return err
+		}
+		src, err := m.pop()
+		if err != nil {
+			return err
+		}
+		m.Heap[dst] = m.Heap[src]
+	case asm.JUMP:
+		addr, err := parseAddr(inst.Arg)
+		if err != nil {
+			return err
+		}
+		next = addr
+	case asm.JUMP_IF_FALSE:
+		addr, err := parseAddr(inst.Arg)
+		if err != nil {
+			return err
+		}
+		cond, err := m.pop()
+		if err != nil {
+			return err
+		}
+		b, ok := m.Heap[cond].(bool)
+		if !ok {
+			return fmt.Errorf("condition %s is %T, not bool", m.Program.Vars[cond].VarName, m.Heap[cond])
+		}
+		if !b {
+			next = addr
+		}
+	case asm.JUMP_INDIRECT:
+		i, err := m.operandAddr(inst.Arg)
+		if err != nil {
+			return err
+		}
+		addr, ok := m.Heap[i].(uint32)
+		if !ok {
+			return fmt.Errorf("%s is %T, not an address", inst.Arg, m.Heap[i])
+		}
+		next = addr
+	case asm.EXTERN:
+		externStr, _ := inst.Extern()
+		err := m.callExtern(externStr)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown instruction %s", inst.Op)
+	}
+	m.PC = next
+	return nil
+}
+
+func (m *VM) pop() (uint32, error) {
+	if len(m.Stack) == 0 {
+		return 0, errors.New("stack underflow")
+	}
+	addr := m.Stack[len(m.Stack)-1]
+	m.Stack = m.Stack[:len(m.Stack)-1]
+	if int(addr) >= len(m.Heap) {
+		return 0, fmt.Errorf("heap address %#x out of range", addr)
+	}
+	return addr, nil
+}
+
+// operandAddr returns the heap address of a PUSH or JUMP_INDIRECT operand, a variable name or an address
+func (m *VM) operandAddr(arg string) (uint32, error) {
+	if strings.HasPrefix(arg, "0x") {
+		return parseAddr(arg)
+	}
+	i, ok := m.Program.VarIndex(asm.VarName(arg))
+	if !ok {
+		return 0, fmt.Errorf("variable not defined: %s", arg)
+	}
+	return uint32(i), nil
+}
+
+func parseAddr(arg string) (uint32, error) {
+	addr, err := strconv.ParseUint(arg, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %s", arg)
+	}
+	return uint32(addr), nil
+}
+
+// sendEvent runs an event sent to this behaviour while the current one is running, as udon does
+func (m *VM) sendEvent(event asm.EventName) error {
+	pc, stack := m.PC, m.Stack
+	addr, ok := m.Program.EventAddr(event)
+	if !ok {
+		// like udon, sending an event the program does not have does nothing
+		return nil
+	}
+	m.PC, m.Stack = uint32(addr), nil
+	for m.Running() {
+		err := m.Step()
+		if err != nil {
+			return fmt.Errorf("event %s: %w", event, err)
+		}
+	}
+	m.PC, m.Stack = pc, stack
+	return nil
+}
+
+// Pending returns how many delayed events are waiting
+func (m *VM) Pending() int {
+	return len(m.pending)
+}
+
+// NextFrame advances the time by delta seconds and runs the delayed events due in the new frame, in the order they were sent
+func (m *VM) NextFrame(delta float64) error {
+	m.Frame++
+	m.Time += delta
+	due := []*delayedEvent{}
+	pending := []*delayedEvent{}
+	for _, e := range m.pending {
+		if e.frame <= m.Frame && e.time <= m.Time {
+			due = append(due, e)
+		} else {
+			pending = append(pending, e)
+		}
+	}
+	m.pending = pending
+	for _, e := range due {
+		err := m.Run(e.event)
+		if err != nil {
+			return fmt.Errorf("event %s: %w", e.event, err)
+		}
+	}
+	return nil
+}
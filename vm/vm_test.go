@@ -0,0 +1,153 @@
+package vm_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"udon-go/asm"
+	"udon-go/vm"
+)
+
+// sumProgram adds the numbers below Limit into Sum, then logs it
+const sumProgram = `.data_start
+
+    .export Sum
+    Limit: %SystemInt32, 5
+    Sum: %SystemInt32, null
+    i: %SystemInt32, 0
+    one: %SystemInt32, 1
+    cond: %SystemBoolean, null
+    text: %SystemString, ""
+
+.data_end
+
+.code_start
+
+    .export _start
+    _start:
+        PUSH, i
+        PUSH, Limit
+        PUSH, cond
+        EXTERN, "SystemInt32.__op_LessThan__SystemInt32_SystemInt32__SystemBoolean"
+        PUSH, cond
+        JUMP_IF_FALSE, 0x00000078
+        PUSH, Sum
+        PUSH, i
+        PUSH, Sum
+        EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"
+        PUSH, i
+        PUSH, one
+        PUSH, i
+        EXTERN, "SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32"
+        JUMP, 0x00000000
+        PUSH, Sum
+        PUSH, text
+        EXTERN, "SystemConvert.__ToString__SystemInt32__SystemString"
+        PUSH, text
+        EXTERN, "UnityEngineDebug.__Log__SystemObject__SystemVoid"
+        JUMP, 0xFFFFFFFF
+
+.code_end
+`
+
+func TestVM_Run(t *testing.T) {
+	m, err := vm.Load(sumProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Program.Exports; !reflect.DeepEqual(got, []asm.VarName{"Sum"}) {
+		t.Errorf("exports %v", got)
+	}
+	err = m.Run("_start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, _ := m.Var("Sum"); sum != int32(10) {
+		t.Errorf("Sum = %v, want 10", sum)
+	}
+	if !reflect.DeepEqual(m.Log, []string{"10"}) {
+		t.Errorf("log %q", m.Log)
+	}
+	if len(m.Stack) != 0 {
+		t.Errorf("stack left with %d addresses", len(m.Stack))
+	}
+}
+
+func TestVM_RunBinary(t *testing.T) {
+	prog, err := asm.ParseProgram(sumProgram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := prog.Assemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := vm.Load(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Run("_start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Log, []string{"10"}) {
+		t.Errorf("log %q", m.Log)
+	}
+}
+
+func TestVM_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		want string
+	}{
+		{"stack underflow", "POP", "stack underflow"},
+		{"undefined variable", "PUSH, x", "variable not defined: x"},
+		{"condition", "PUSH, Sum\nJUMP_IF_FALSE, 0x00000000", "condition Sum is int32, not bool"},
+		{"argument type", "PUSH, text\nPUSH, Sum\nPUSH, Sum\nEXTERN, \"SystemInt32.__op_Addition__SystemInt32_SystemInt32__SystemInt32\"", "argument 0 is SystemString, not SystemInt32"},
+		{"divide by zero", "PUSH, Sum\nPUSH, Sum\nPUSH, Sum\nEXTERN, \"SystemInt32.__op_Division__SystemInt32_SystemInt32__SystemInt32\"", "divide by zero"},
+		{"not implemented", "PUSH, Sum\nEXTERN, \"UnityEngineTime.__get_deltaTime__SystemSingle\"", "is not implemented"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := strings.Replace(sumProgram, "PUSH, i\n", tt.code+"\n", 1)
+			m, err := vm.Load(code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = m.Run("_start")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+	t.Run("step limit", func(t *testing.T) {
+		m, err := vm.Load(strings.Replace(sumProgram, "JUMP, 0xFFFFFFFF", "JUMP, 0x00000000", 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.MaxSteps = 1000
+		err = m.Run("_start")
+		if !errors.Is(err, vm.ErrStepLimit) {
+			t.Errorf("got error %v, want %v", err, vm.ErrStepLimit)
+		}
+	})
+	t.Run("source map", func(t *testing.T) {
+		m, err := vm.Load(strings.Replace(sumProgram, "PUSH, i\n", "POP\n", 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.SourceMap = &asm.SourceMap{Code: []asm.SourcePos{{Addr: 0, File: "sum.go", Line: 4, Col: 2}, {Addr: 0x78, File: "sum.go", Line: 7, Col: 2}}}
+		err = m.Run("_start")
+		if err == nil || !strings.HasPrefix(err.Error(), "sum.go:4:2: 0x0 POP: ") {
+			t.Errorf("got error %v", err)
+		}
+	})
+	t.Run("bad program", func(t *testing.T) {
+		_, err := vm.Load(strings.Replace(sumProgram, "JUMP, 0x00000000", "MOVE, 0x00000000", 1))
+		if err == nil || !strings.Contains(err.Error(), "unknown instruction MOVE") {
+			t.Errorf("got error %v", err)
+		}
+	})
+}